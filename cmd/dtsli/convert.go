@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/dynatrace"
+	"gopkg.in/yaml.v2"
+)
+
+/**
+ * runConvertDashboard converts a dashboard into the sli.yaml and slo.yaml an evaluation of the dashboard generates - without querying any data
+ * The dashboard is read from a file or fetched from the tenant. Tiles that can't be converted exactly are reported as warnings on stderr
+ * A file is converted without tenant if no tenant and API token are configured - this fails for dashboards with custom charts as their queries depend on the metric definitions
+ */
+func runConvertDashboard(args []string, stdout io.Writer) int {
+	flags := newFlagSet("convert-dashboard")
+	tenant := addTenantFlags(flags)
+	file := flags.String("file", "", "dashboard JSON as exported from Dynatrace")
+	dashboard := flags.String("dashboard", "", "ID of the dashboard to fetch or 'query' to look it up by project, stage and service - instead of -file")
+	project := flags.String("project", "", "project used to look up the dashboard")
+	stage := flags.String("stage", "", "stage used to look up the dashboard")
+	service := flags.String("service", "", "service used to look up the dashboard")
+	outputDir := flags.String("output-dir", "", "directory to write sli.yaml and slo.yaml to (default: print both to stdout)")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	ctx := context.Background()
+
+	if (*file == "") == (*dashboard == "") {
+		fmt.Fprintln(os.Stderr, "either -file or -dashboard is required")
+		return exitUsage
+	}
+
+	keptnEvent := &common.BaseKeptnEvent{
+		Project: *project,
+		Stage:   *stage,
+		Service: *service,
+	}
+	var handler *dynatrace.Handler
+	if *file != "" && !tenant.hasTenant() {
+		tenant.configureLogging()
+		handler = dynatrace.NewDynatraceHandler("", keptnEvent, nil, nil, "", "", nil)
+	} else {
+		var err error
+		handler, err = tenant.newHandler(keptnEvent, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return exitUsage
+		}
+	}
+
+	dashboardJSON, err := loadDashboard(ctx, handler, keptnEvent, *file, *dashboard)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+
+	dashboardSLI, dashboardSLO, warnings, err := handler.ConvertDashboardToSLIAndSLO(ctx, dashboardJSON)
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "warning: "+warning)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v - use -tenant and -token or DT_TENANT and DT_API_TOKEN\n", err)
+		return exitFailed
+	}
+
+	sliContent, err := yaml.Marshal(dashboardSLI)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+	sloContent, err := yaml.Marshal(dashboardSLO)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+
+	if *outputDir == "" {
+		fmt.Fprintln(stdout, "# sli.yaml")
+		fmt.Fprint(stdout, string(sliContent))
+		fmt.Fprintln(stdout, "---")
+		fmt.Fprintln(stdout, "# slo.yaml")
+		fmt.Fprint(stdout, string(sloContent))
+		return exitOK
+	}
+
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+	for filename, content := range map[string][]byte{"sli.yaml": sliContent, "slo.yaml": sloContent} {
+		if err := ioutil.WriteFile(filepath.Join(*outputDir, filename), content, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return exitFailed
+		}
+	}
+	fmt.Fprintf(stdout, "wrote sli.yaml and slo.yaml with %d indicators to %s\n", len(dashboardSLI.Indicators), *outputDir)
+	return exitOK
+}
+
+// loadDashboard reads the dashboard JSON from the file - or fetches the dashboard from the tenant if no file is passed
+func loadDashboard(ctx context.Context, handler *dynatrace.Handler, keptnEvent *common.BaseKeptnEvent, file string, dashboard string) (*dynatrace.DynatraceDashboard, error) {
+	if file == "" {
+		dashboardJSON, err := handler.LoadDynatraceDashboard(ctx, keptnEvent, dashboard)
+		if err != nil {
+			return nil, err
+		}
+		if dashboardJSON == nil {
+			return nil, fmt.Errorf("no dashboard %s found for project=%s, stage=%s, service=%s", dashboard, keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service)
+		}
+		return dashboardJSON, nil
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", file, err)
+	}
+	dashboardJSON := &dynatrace.DynatraceDashboard{}
+	if err := json.Unmarshal(content, dashboardJSON); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", file, err)
+	}
+	return dashboardJSON, nil
+}
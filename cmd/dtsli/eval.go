@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/dynatrace"
+	keptnevents "github.com/keptn/go-utils/pkg/lib"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats of eval
+const outputTable = "table"
+const outputJSON = "json"
+const outputYAML = "yaml"
+
+/**
+ * runEval queries the indicators of an sli.yaml or of a dashboard for a timeframe and prints the SLIResults
+ * Exits with exitFailed if at least one indicator could not be retrieved
+ */
+func runEval(args []string, stdout io.Writer) int {
+	flags := newFlagSet("eval")
+	tenant := addTenantFlags(flags)
+	sliFile := flags.String("sli", "", "sli.yaml with the indicators to query")
+	dashboard := flags.String("dashboard", "", "ID of the dashboard to query or 'query' to look it up by project, stage and service - instead of -sli")
+	confFile := flags.String("conf", "", "dynatrace.conf.yaml with the defaults section to apply")
+	gitRepo := flags.String("git-repo", "", "Git checkout with the Keptn resources of project, stage and service - evaluates its dynatrace.conf.yaml, dashboard and sli.yaml like the service")
+	gitLayout := flags.String("git-layout", common.GitLayoutBranches, "layout of -git-repo: branches or directories")
+	gitDefaultBranch := flags.String("git-default-branch", "", "branch with the project-level resources of the branches layout (default: the default branch of origin or master)")
+	indicators := flags.String("indicators", "", "comma separated indicators to query (default: all indicators of the sli.yaml)")
+	project := flags.String("project", "", "project used for placeholders and to look up the dashboard")
+	stage := flags.String("stage", "", "stage used for placeholders and to look up the dashboard")
+	service := flags.String("service", "", "service used for placeholders and to look up the dashboard")
+	deployment := flags.String("deployment", "", "deployment used for placeholders, e.g: canary")
+	deploymentStrategy := flags.String("deployment-strategy", "", "deployment strategy used for query templates, e.g: blue_green_service")
+	testStrategy := flags.String("test-strategy", "", "test strategy used for query templates, e.g: performance")
+	labels := keyValueFlag{}
+	flags.Var(labels, "label", "label used for $LABEL placeholders as key=value, can be repeated")
+	start := flags.String("start", "", "start of the timeframe as RFC3339 or unix timestamp (default: -end minus -timeframe)")
+	end := flags.String("end", "", "end of the timeframe as RFC3339 or unix timestamp (default: now)")
+	timeframe := flags.Duration("timeframe", 5*time.Minute, "length of the timeframe if -start is not set")
+	output := flags.String("output", outputTable, "output format: table, json or yaml")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	ctx := context.Background()
+
+	if (*sliFile != "" && *dashboard != "") || (*sliFile == "" && *dashboard == "" && *gitRepo == "") {
+		fmt.Fprintln(os.Stderr, "either -sli, -dashboard or -git-repo is required")
+		return exitUsage
+	}
+	if *output != outputTable && *output != outputJSON && *output != outputYAML {
+		fmt.Fprintf(os.Stderr, "invalid output format %s\n", *output)
+		return exitUsage
+	}
+
+	startUnix, endUnix, err := parseTimeframe(*start, *end, *timeframe)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+
+	keptnEvent := &common.BaseKeptnEvent{
+		Project:            *project,
+		Stage:              *stage,
+		Service:            *service,
+		Deployment:         *deployment,
+		DeploymentStrategy: *deploymentStrategy,
+		TestStrategy:       *testStrategy,
+		Labels:             labels,
+	}
+
+	var defaults *common.SLIDefaults
+	var gitStore *common.GitStore
+	if *gitRepo != "" {
+		gitStore, err = common.NewGitStore(*gitRepo, *gitLayout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return exitUsage
+		}
+		gitStore.DefaultBranch = *gitDefaultBranch
+		if *confFile == "" {
+			tenant.configureLogging()
+			dynatraceConfigFile, err := common.GetDynatraceConfig(ctx, gitStore, keptnEvent, common.NewLogger("", "", common.ServiceName))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				return exitUsage
+			}
+			if dynatraceConfigFile != nil {
+				defaults = dynatraceConfigFile.Defaults
+				if *sliFile == "" && *dashboard == "" {
+					*dashboard = dynatraceConfigFile.Dashboard
+				}
+			}
+		}
+	}
+	if *confFile != "" {
+		content, err := ioutil.ReadFile(*confFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not read %s: %v\n", *confFile, err)
+			return exitUsage
+		}
+		dynatraceConfigFile, err := common.ParseDynatraceConfigFile(content)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return exitUsage
+		}
+		defaults = dynatraceConfigFile.Defaults
+	}
+
+	handler, err := tenant.newHandler(keptnEvent, defaults)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+	if gitStore != nil {
+		handler.ResourceStore = gitStore
+	}
+	if handler.Cassette != nil && !handler.Cassette.Replaying() {
+		handler.Cassette.Metadata["start"] = startUnix.Format(time.RFC3339)
+		handler.Cassette.Metadata["end"] = endUnix.Format(time.RFC3339)
+	}
+
+	var sliResults []*keptnevents.SLIResult
+	if *sliFile != "" {
+		sliResults, err = evalSLIFile(ctx, handler, *sliFile, splitIndicators(*indicators), startUnix, endUnix)
+	} else if gitStore != nil {
+		sliResults, err = evalResources(ctx, handler, keptnEvent, *dashboard, splitIndicators(*indicators), startUnix, endUnix)
+	} else {
+		sliResults, err = evalDashboard(ctx, handler, keptnEvent, *dashboard, startUnix, endUnix)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+
+	if err := printSLIResults(stdout, sliResults, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+
+	for _, sliResult := range sliResults {
+		if !sliResult.Success {
+			return exitFailed
+		}
+	}
+	return exitOK
+}
+
+// parseTimeframe returns start and end of the evaluation - without start the timeframe ends at end
+func parseTimeframe(start string, end string, timeframe time.Duration) (time.Time, time.Time, error) {
+	endUnix := time.Now().UTC()
+	if end != "" {
+		var err error
+		endUnix, err = common.ParseUnixTimestamp(end)
+		if err != nil {
+			return endUnix, endUnix, fmt.Errorf("invalid -end %s: %v", end, err)
+		}
+	}
+
+	startUnix := endUnix.Add(-timeframe)
+	if start != "" {
+		var err error
+		startUnix, err = common.ParseUnixTimestamp(start)
+		if err != nil {
+			return startUnix, endUnix, fmt.Errorf("invalid -start %s: %v", start, err)
+		}
+	}
+
+	if !startUnix.Before(endUnix) {
+		return startUnix, endUnix, errors.New("start of the timeframe has to be before its end")
+	}
+	return startUnix, endUnix, nil
+}
+
+func splitIndicators(indicators string) []string {
+	var result []string
+	for _, indicator := range strings.Split(indicators, ",") {
+		if indicator = strings.TrimSpace(indicator); indicator != "" {
+			result = append(result, indicator)
+		}
+	}
+	return result
+}
+
+/**
+ * evalSLIFile queries the passed indicators - or all indicators of the sli.yaml - the same way an sli.yaml is evaluated by the service
+ * Indicators not defined in the sli.yaml are taken from the SLI catalog
+ */
+func evalSLIFile(ctx context.Context, handler *dynatrace.Handler, sliFile string, indicators []string, startUnix time.Time, endUnix time.Time) ([]*keptnevents.SLIResult, error) {
+	content, err := ioutil.ReadFile(sliFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", sliFile, err)
+	}
+	return evalSLIContent(ctx, handler, sliFile, content, indicators, startUnix, endUnix)
+}
+
+// evalSLIContent queries the indicators of the content of an sli.yaml - see evalSLIFile
+func evalSLIContent(ctx context.Context, handler *dynatrace.Handler, sliFile string, content []byte, indicators []string, startUnix time.Time, endUnix time.Time) ([]*keptnevents.SLIResult, error) {
+	sli, err := dynatrace.ParseSLIFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", sliFile, err)
+	}
+
+	sliCatalog, err := dynatrace.LoadSLICatalog(handler.Logger)
+	if err != nil {
+		return nil, err
+	}
+	handler.DefaultQueries = sliCatalog
+	handler.CustomQueries = sli.Indicators
+
+	if len(indicators) == 0 {
+		for indicator := range sli.Indicators {
+			indicators = append(indicators, indicator)
+		}
+		sort.Strings(indicators)
+	}
+
+	// the indicators are queried with the parallelism of dynatrace.conf.yaml like the service does
+	return handler.GetSLIResultsForIndicators(ctx, indicators, startUnix, endUnix), nil
+}
+
+/**
+ * evalResources evaluates the resources of the handler's resource store the same way the service does: the dashboard of
+ * dynatrace.conf.yaml - or the one of an existing dynatrace/dashboard.json - and otherwise the indicators of dynatrace/sli.yaml
+ */
+func evalResources(ctx context.Context, handler *dynatrace.Handler, keptnEvent *common.BaseKeptnEvent, dashboard string, indicators []string, startUnix time.Time, endUnix time.Time) ([]*keptnevents.SLIResult, error) {
+	_, _, _, _, sliResults, err := handler.QueryDynatraceDashboardForSLIs(ctx, keptnEvent, dashboard, startUnix, endUnix)
+	if err != nil {
+		return nil, err
+	}
+	if sliResults != nil {
+		return sliResults, nil
+	}
+
+	content, err := common.GetKeptnResource(ctx, handler.ResourceStore, keptnEvent, common.DynatraceSLIFilename, handler.Logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s: %v", common.DynatraceSLIFilename, err)
+	}
+	if content == "" {
+		return nil, fmt.Errorf("neither a dashboard nor %s found for project=%s, stage=%s, service=%s", common.DynatraceSLIFilename, keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service)
+	}
+	return evalSLIContent(ctx, handler, common.DynatraceSLIFilename, []byte(content), indicators, startUnix, endUnix)
+}
+
+/**
+ * evalDashboard queries the SLIs of a dashboard the same way the service does
+ * The CLI runs in local mode, i.e: an existing dynatrace/dashboard.json is looked up in the working directory instead of the configuration-service (see newHandler)
+ */
+func evalDashboard(ctx context.Context, handler *dynatrace.Handler, keptnEvent *common.BaseKeptnEvent, dashboard string, startUnix time.Time, endUnix time.Time) ([]*keptnevents.SLIResult, error) {
+	dashboardLinkAsLabel, _, _, _, sliResults, err := handler.QueryDynatraceDashboardForSLIs(ctx, keptnEvent, dashboard, startUnix, endUnix)
+	if err != nil {
+		return nil, err
+	}
+	if sliResults == nil {
+		// the dashboard link is only returned if a dashboard was found - its SLIs are not parsed if it didn't change
+		if dashboardLinkAsLabel != "" {
+			return nil, fmt.Errorf("the dashboard of project=%s, stage=%s, service=%s has KQG.QueryBehavior=ParseOnChange and did not change since %s - the service evaluates %s instead, use -sli to evaluate it",
+				keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service, common.DynatraceDashboardFilename, common.DynatraceSLIFilename)
+		}
+		return nil, fmt.Errorf("no dashboard %s found for project=%s, stage=%s, service=%s", dashboard, keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service)
+	}
+	return sliResults, nil
+}
+
+// printSLIResults prints the results as table, json or yaml
+func printSLIResults(w io.Writer, sliResults []*keptnevents.SLIResult, output string) error {
+	switch output {
+	case outputJSON:
+		content, err := json.MarshalIndent(sliResults, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(content))
+	case outputYAML:
+		content, err := yaml.Marshal(sliResults)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(w, string(content))
+	default:
+		table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(table, "INDICATOR\tVALUE\tSUCCESS\tMESSAGE")
+		for _, sliResult := range sliResults {
+			fmt.Fprintf(table, "%s\t%g\t%t\t%s\n", sliResult.Metric, sliResult.Value, sliResult.Success, strings.TrimSpace(sliResult.Message))
+		}
+		return table.Flush()
+	}
+	return nil
+}
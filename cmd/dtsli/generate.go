@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/dynatrace"
+	keptnevents "github.com/keptn/go-utils/pkg/lib"
+	"gopkg.in/yaml.v2"
+)
+
+/**
+ * runGenerateDashboard generates the KQG dashboard of an sli.yaml and slo.yaml and prints it - or uploads it to the tenant with -upload
+ * Indicators that can't be charted are reported as warnings on stderr
+ */
+func runGenerateDashboard(args []string, stdout io.Writer) int {
+	flags := newFlagSet("generate-dashboard")
+	tenant := addTenantFlags(flags)
+	sliFile := flags.String("sli", "", "sli.yaml with the indicators to chart")
+	sloFile := flags.String("slo", "", "slo.yaml with the objectives, total score and comparison (default: all indicators are informational)")
+	project := flags.String("project", "", "project used for placeholders and the dashboard name")
+	stage := flags.String("stage", "", "stage used for placeholders and the dashboard name")
+	service := flags.String("service", "", "service used for placeholders and the dashboard name")
+	output := flags.String("output", "", "file to write the dashboard JSON to (default: stdout)")
+	upload := flags.Bool("upload", false, "create the dashboard on the tenant - an existing KQG dashboard of project, stage and service is replaced")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	ctx := context.Background()
+
+	if *sliFile == "" {
+		fmt.Fprintln(os.Stderr, "-sli is required")
+		return exitUsage
+	}
+
+	content, err := ioutil.ReadFile(*sliFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read %s: %v\n", *sliFile, err)
+		return exitUsage
+	}
+	sli, err := dynatrace.ParseSLIFile(content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not parse %s: %v\n", *sliFile, err)
+		return exitUsage
+	}
+
+	var slo *keptnevents.ServiceLevelObjectives
+	if *sloFile != "" {
+		content, err := ioutil.ReadFile(*sloFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not read %s: %v\n", *sloFile, err)
+			return exitUsage
+		}
+		slo = &keptnevents.ServiceLevelObjectives{}
+		if err := yaml.Unmarshal(content, slo); err != nil {
+			fmt.Fprintf(os.Stderr, "could not parse %s: %v\n", *sloFile, err)
+			return exitUsage
+		}
+	}
+
+	keptnEvent := &common.BaseKeptnEvent{
+		Project: *project,
+		Stage:   *stage,
+		Service: *service,
+	}
+
+	// the tenant is only needed for the upload
+	var handler *dynatrace.Handler
+	if *upload {
+		handler, err = tenant.newHandler(keptnEvent, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return exitUsage
+		}
+	} else {
+		tenant.configureLogging()
+		handler = dynatrace.NewDynatraceHandler("", keptnEvent, nil, nil, "", "", nil)
+	}
+
+	dashboardJSON, warnings := handler.GenerateDashboard(sli, slo)
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, "warning: "+warning)
+	}
+
+	if *upload {
+		dashboardID, err := handler.UploadDashboard(ctx, dashboardJSON)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not upload the dashboard: %v\n", err)
+			return exitFailed
+		}
+		fmt.Fprintf(stdout, "uploaded dashboard %s: %s#dashboard;id=%s\n", dashboardJSON.DashboardMetadata.Name, handler.ApiURL, dashboardID)
+		return exitOK
+	}
+
+	dashboardContent, err := json.MarshalIndent(dashboardJSON, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+	if *output == "" {
+		fmt.Fprintln(stdout, string(dashboardContent))
+		return exitOK
+	}
+	if err := ioutil.WriteFile(*output, dashboardContent, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+	fmt.Fprintf(stdout, "wrote dashboard %s with %d tiles to %s\n", dashboardJSON.DashboardMetadata.Name, len(dashboardJSON.Tiles), *output)
+	return exitOK
+}
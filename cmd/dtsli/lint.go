@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/dynatrace"
+	keptnevents "github.com/keptn/go-utils/pkg/lib"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats of lint
+const outputText = "text"
+
+// lintResult is the json output of lint
+type lintResult struct {
+	Errors   int                      `json:"errors"`
+	Warnings int                      `json:"warnings"`
+	Findings []*dynatrace.LintFinding `json:"findings"`
+}
+
+/**
+ * runLint checks sli.yaml, slo.yaml and dashboards for problems that otherwise only show up during an evaluation
+ * Exits with exitFailed if an error was found - with -strict also for warnings
+ */
+func runLint(args []string, stdout io.Writer) int {
+	flags := newFlagSet("lint")
+	tenant := addTenantFlags(flags)
+	sliFile := flags.String("sli", "", "sli.yaml to check")
+	sloFile := flags.String("slo", "", "slo.yaml to check - its objectives have to reference indicators of -sli or the SLI catalog")
+	dashboardFile := flags.String("dashboard-file", "", "dashboard JSON to check")
+	dashboard := flags.String("dashboard", "", "ID of a dashboard on the tenant to check or 'query' to look it up by project, stage and service")
+	project := flags.String("project", "", "project used to look up the dashboard")
+	stage := flags.String("stage", "", "stage used to look up the dashboard")
+	service := flags.String("service", "", "service used to look up the dashboard")
+	validateMetrics := flags.Bool("validate-metrics", false, "validate the metric IDs of all queries against the tenant")
+	strict := flags.Bool("strict", false, "fail on warnings as well")
+	output := flags.String("output", outputText, "output format: text or json")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	ctx := context.Background()
+
+	if *sliFile == "" && *sloFile == "" && *dashboardFile == "" && *dashboard == "" {
+		fmt.Fprintln(os.Stderr, "at least one of -sli, -slo, -dashboard-file or -dashboard is required")
+		return exitUsage
+	}
+	if *output != outputText && *output != outputJSON {
+		fmt.Fprintf(os.Stderr, "invalid output format %s\n", *output)
+		return exitUsage
+	}
+
+	keptnEvent := &common.BaseKeptnEvent{
+		Project: *project,
+		Stage:   *stage,
+		Service: *service,
+	}
+	var handler *dynatrace.Handler
+	if *validateMetrics || *dashboard != "" {
+		var err error
+		handler, err = tenant.newHandler(keptnEvent, nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return exitUsage
+		}
+	} else {
+		tenant.configureLogging()
+	}
+
+	sliCatalog, err := dynatrace.LoadSLICatalog(common.NewLogger("", "", common.ServiceName))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitUsage
+	}
+	linter := dynatrace.NewLinter(nil, sliCatalog)
+	if *validateMetrics {
+		linter.Handler = handler
+	}
+
+	var sli *dynatrace.SLI
+	if *sliFile != "" {
+		if content, ok := readLintFile(linter, *sliFile); ok {
+			sli, err = dynatrace.ParseSLIFile(content)
+			if err != nil {
+				linter.AddFinding(*sliFile, "", dynatrace.LintRuleInvalidFile, dynatrace.LintSeverityError, "could not parse the sli.yaml: %v", err)
+			} else {
+				linter.LintSLI(ctx, *sliFile, sli)
+			}
+		}
+	}
+
+	if *sloFile != "" {
+		if content, ok := readLintFile(linter, *sloFile); ok {
+			slo := &keptnevents.ServiceLevelObjectives{}
+			if err := yaml.Unmarshal(content, slo); err != nil {
+				linter.AddFinding(*sloFile, "", dynatrace.LintRuleInvalidFile, dynatrace.LintSeverityError, "could not parse the slo.yaml: %v", err)
+			} else {
+				linter.LintSLO(*sloFile, slo, sli)
+			}
+		}
+	}
+
+	if *dashboardFile != "" {
+		if content, ok := readLintFile(linter, *dashboardFile); ok {
+			dashboardJSON := &dynatrace.DynatraceDashboard{}
+			if err := json.Unmarshal(content, dashboardJSON); err != nil {
+				linter.AddFinding(*dashboardFile, "", dynatrace.LintRuleInvalidFile, dynatrace.LintSeverityError, "could not parse the dashboard: %v", err)
+			} else {
+				linter.LintDashboard(ctx, *dashboardFile, dashboardJSON)
+			}
+		}
+	}
+
+	if *dashboard != "" {
+		dashboardJSON, err := loadDashboard(ctx, handler, keptnEvent, "", *dashboard)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return exitFailed
+		}
+		linter.LintDashboard(ctx, "dashboard "+*dashboard, dashboardJSON)
+	}
+
+	findings := linter.Findings()
+	if err := printLintFindings(stdout, findings, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+
+	if linter.HasErrors() || (*strict && len(findings) > 0) {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// readLintFile returns the content of the file - a file that can't be read is reported as finding
+func readLintFile(linter *dynatrace.Linter, file string) ([]byte, bool) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		linter.AddFinding(file, "", dynatrace.LintRuleInvalidFile, dynatrace.LintSeverityError, "could not read the file: %v", err)
+		return nil, false
+	}
+	return content, true
+}
+
+// printLintFindings prints the findings one per line as <file>:<location>: <severity>: <message> [<rule>] - or as json
+func printLintFindings(w io.Writer, findings []*dynatrace.LintFinding, output string) error {
+	result := lintResult{Findings: findings}
+	for _, finding := range findings {
+		if finding.Severity == dynatrace.LintSeverityError {
+			result.Errors++
+		} else {
+			result.Warnings++
+		}
+	}
+
+	if output == outputJSON {
+		content, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(content))
+		return nil
+	}
+
+	for _, finding := range findings {
+		fmt.Fprintf(w, "%s:%s: %s: %s [%s]\n", finding.File, finding.Location, finding.Severity, finding.Message, finding.Rule)
+	}
+	fmt.Fprintf(w, "%d errors, %d warnings\n", result.Errors, result.Warnings)
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/dynatrace"
+)
+
+/**
+ * dtsli runs the logic of the dynatrace-sli-service from the command line - without Keptn, e.g: to debug SLI definitions
+ * Usage: dtsli <command> [flags]
+ */
+
+// Exit codes of all commands
+const exitOK = 0
+const exitFailed = 1
+const exitUsage = 2
+
+type command struct {
+	name        string
+	description string
+	run         func(args []string, stdout io.Writer) int
+}
+
+var commands = []command{
+	{name: "eval", description: "queries the SLIs of an sli.yaml or a dashboard for a timeframe", run: runEval},
+	{name: "convert-dashboard", description: "converts a dashboard into sli.yaml and slo.yaml without querying data", run: runConvertDashboard},
+	{name: "generate-dashboard", description: "generates a dashboard from sli.yaml and slo.yaml and optionally uploads it", run: runGenerateDashboard},
+	{name: "lint", description: "checks sli.yaml, slo.yaml and dashboards for problems before they are evaluated", run: runLint},
+	{name: "migrate", description: "migrates legacy queries of an sli.yaml to the metricSelector=...&entitySelector=... format", run: runMigrate},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+func run(args []string, stdout io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			exitCode := cmd.run(args[1:], stdout)
+			if err := saveRecording(); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				return exitFailed
+			}
+			return exitCode
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %s\n\n", args[0])
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: dtsli <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-20s %s\n", cmd.name, cmd.description)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'dtsli <command> -h' for the flags of a command")
+}
+
+// newFlagSet returns the flag set of a command - flag errors are reported to stderr
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("dtsli "+name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	return flags
+}
+
+// tenantFlags are the flags of every command that calls the Dynatrace API
+type tenantFlags struct {
+	tenant  string
+	token   string
+	verbose bool
+	record  string
+	replay  string
+}
+
+// recording is the cassette of a command run with -record - it is saved once the command is done, even if it failed
+var recording struct {
+	file     string
+	cassette *dynatrace.Cassette
+}
+
+func addTenantFlags(flags *flag.FlagSet) *tenantFlags {
+	tf := &tenantFlags{}
+	flags.StringVar(&tf.tenant, "tenant", os.Getenv("DT_TENANT"), "Dynatrace tenant URL, e.g: https://abc12345.live.dynatrace.com (default $DT_TENANT)")
+	flags.StringVar(&tf.token, "token", "", "Dynatrace API token (default $DT_API_TOKEN)")
+	flags.BoolVar(&tf.verbose, "verbose", false, "log the Dynatrace API calls to stderr")
+	flags.StringVar(&tf.record, "record", "", "record all Dynatrace API calls to a cassette file, e.g: to attach it to a bug report")
+	flags.StringVar(&tf.replay, "replay", "", "replay the Dynatrace API calls of a cassette file instead of calling the tenant - no tenant or token required")
+	return tf
+}
+
+/**
+ * credentials returns the tenant and API token - the token is taken from DT_API_TOKEN unless passed as flag
+ * Proxy and CAs are configured via HTTPS_PROXY, NO_PROXY and HTTP_CA_BUNDLE just like for the service
+ */
+func (tf *tenantFlags) credentials() (*common.DTCredentials, error) {
+	credentials := &common.DTCredentials{Tenant: tf.tenant, ApiToken: tf.token}
+	if credentials.ApiToken == "" {
+		credentials.ApiToken = os.Getenv("DT_API_TOKEN")
+	}
+	if credentials.Tenant == "" || credentials.ApiToken == "" {
+		return nil, fmt.Errorf("tenant and API token are required: use -tenant and -token or DT_TENANT and DT_API_TOKEN")
+	}
+	if !strings.HasPrefix(credentials.Tenant, "https://") && !strings.HasPrefix(credentials.Tenant, "http://") {
+		credentials.Tenant = "https://" + credentials.Tenant
+	}
+	credentials.Tenant = strings.TrimSuffix(credentials.Tenant, "/")
+	return credentials, nil
+}
+
+// hasTenant returns whether the Dynatrace API can be called - with tenant and API token or by replaying a cassette
+func (tf *tenantFlags) hasTenant() bool {
+	if tf.replay != "" {
+		return true
+	}
+	_, err := tf.credentials()
+	return err == nil
+}
+
+// configureLogging writes log messages to stderr so they don't mix with the output of a command - debug messages only with -verbose
+func (tf *tenantFlags) configureLogging() {
+	logLevel := common.LogLevelError
+	if tf.verbose {
+		logLevel = common.LogLevelDebug
+	}
+	common.SetLogOutput(os.Stderr, logLevel, common.LogFormatText)
+}
+
+// newHandler returns a Dynatrace handler for the tenant - or one that replays a cassette with -replay
+func (tf *tenantFlags) newHandler(keptnEvent *common.BaseKeptnEvent, defaults *common.SLIDefaults) (*dynatrace.Handler, error) {
+	if tf.replay != "" {
+		if tf.record != "" {
+			return nil, fmt.Errorf("-record and -replay can't be combined")
+		}
+		cassette, err := dynatrace.LoadCassette(tf.replay)
+		if err != nil {
+			return nil, fmt.Errorf("could not load cassette %s: %v", tf.replay, err)
+		}
+
+		tf.configureLogging()
+		handler := dynatrace.NewDynatraceHandler(replayTenant, keptnEvent, nil, nil, "", "", defaults)
+		handler.Cassette = cassette
+		handler.ResourceStore = workingDirStore
+		return handler, nil
+	}
+
+	credentials, err := tf.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	tf.configureLogging()
+	common.RegisterSecret(credentials.ApiToken)
+
+	transport, err := dynatrace.NewHTTPTransport(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := dynatrace.NewDynatraceHandler(credentials.Tenant, keptnEvent, map[string]string{
+		"Authorization": "Api-Token " + credentials.ApiToken,
+	}, nil, "", "", defaults)
+	handler.HTTPClient.Transport = transport
+	// like the service with ENV=local, resources such as dynatrace/dashboard.json are looked up in the working directory
+	handler.ResourceStore = workingDirStore
+
+	if tf.record != "" {
+		handler.Cassette = dynatrace.NewRecordingCassette(map[string]string{
+			"project":  keptnEvent.Project,
+			"stage":    keptnEvent.Stage,
+			"service":  keptnEvent.Service,
+			"recorded": time.Now().UTC().Format(time.RFC3339),
+		})
+		recording.file = tf.record
+		recording.cassette = handler.Cassette
+	}
+	return handler, nil
+}
+
+// workingDirStore holds the resources of the handlers, e.g: dynatrace/dashboard.json - tests replace it with a MemoryStore
+var workingDirStore common.ResourceStore = common.NewLocalStore(".")
+
+// replayTenant is the tenant URL of a handler that replays a cassette - recorded calls are relative to the tenant
+const replayTenant = "http://replay.invalid"
+
+// saveRecording writes the cassette of a command run with -record
+func saveRecording() error {
+	if recording.cassette == nil {
+		return nil
+	}
+	if err := recording.cassette.Save(recording.file); err != nil {
+		return fmt.Errorf("could not save cassette %s: %v", recording.file, err)
+	}
+	fmt.Fprintf(os.Stderr, "recorded %d Dynatrace API calls to %s\n", len(recording.cassette.Interactions), recording.file)
+	return nil
+}
+
+// keyValueFlag collects repeated key=value flags, e.g: -label buildId=1.2.3 -label owner=me
+type keyValueFlag map[string]string
+
+func (kv keyValueFlag) String() string {
+	pairs := []string{}
+	for key, value := range kv {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (kv keyValueFlag) Set(value string) error {
+	pair := strings.SplitN(value, "=", 2)
+	if len(pair) != 2 || pair[0] == "" {
+		return fmt.Errorf("%s is not in the format key=value", value)
+	}
+	kv[pair[0]] = pair[1]
+	return nil
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/dynatrace"
+)
+
+// testingCassette is the recorded evaluation of the KQG dashboard of qualitygate/qualitystage/evalservice
+const testingCassette = "../../pkg/lib/dynatrace/testfiles/test_cassette_dashboard.json"
+
+// testingTimeframe is the timeframe of testingCassette
+var testingTimeframe = []string{"-start", "2019-10-21T09:11:24Z", "-end", "2019-10-21T09:16:24Z"}
+
+// testingDashboardFlags look up the dashboard of testingCassette by project, stage and service
+var testingDashboardFlags = []string{"-dashboard", "query", "-project", "qualitygate", "-stage", "qualitystage", "-service", "evalservice"}
+
+// testingFiles are written to the fixture directory of a test - the queries of sli.yaml are recorded in testingCassette
+var testingFiles = map[string]string{
+	"sli.yaml": `spec_version: '1.0'
+indicators:
+  response_time_p90: "metricSelector=builtin:service.response.time:merge(0):percentile(90.000000):names&entitySelector=type(SERVICE)"
+  throughput: "metricSelector=builtin:service.requestCount.total:merge(0):value:names&entitySelector=type(SERVICE)"
+`,
+	"sli_unrecorded.yaml": `spec_version: '1.0'
+indicators:
+  error_rate: "metricSelector=builtin:service.errors.total.rate:merge(0):avg:names&entitySelector=type(SERVICE)"
+`,
+	"sli_invalid.yaml": `spec_version: '1.0'
+indicators:
+  throughput: "entitySelector=type(SERVICE)"
+`,
+	"sli_unmerged.yaml": `spec_version: '1.0'
+indicators:
+  throughput: "metricSelector=builtin:service.requestCount.total&entitySelector=type(SERVICE)"
+`,
+	"sli_legacy.yaml": `spec_version: '1.0'
+indicators:
+  response_time_p90: "builtin:service.response.time:merge(0):percentile(90)?scope=tag(keptn_project:$PROJECT)"
+`,
+	"slo.yaml": `spec_version: '0.1.0'
+comparison:
+  compare_with: "single_result"
+  number_of_comparison_results: 1
+objectives:
+  - sli: response_time_p90
+    pass:
+      - criteria:
+          - "<600"
+total_score:
+  pass: "90%"
+  warning: "75%"
+`,
+	"invalid.yaml": "objectives: [\n",
+	"dashboard_usql.json": `{"dashboardMetadata": {"name": "KQG;project=qualitygate;service=evalservice;stage=qualitystage"}, "tiles": [
+	{"name": "User Sessions Query Language", "tileType": "DTAQL", "configured": true, "type": "SINGLE_VALUE", "query": "SELECT count(*) FROM usersession", "customName": "sessions;sli=sessions;pass=>100"}
+]}`,
+	"cassette_nodashboard.json": `{"interactions": [
+	{"method": "GET", "uri": "/api/config/v1/dashboards", "statusCode": 200, "responseBody": {"dashboards": []}}
+]}`,
+	"cassette_empty.json": `{"interactions": []}`,
+}
+
+/**
+ * testingFixtures writes testingFiles to a new directory - together with cassette_parseonchange.json, a copy of testingCassette
+ * with KQG.QueryBehavior=ParseOnChange in the dashboard's markdown, and that dashboard as dashboard.json
+ * Returns the directory and the content of dynatrace/dashboard.json if the ParseOnChange dashboard didn't change
+ */
+func testingFixtures(t *testing.T) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "dtsli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filename, content := range testingFiles {
+		if err := ioutil.WriteFile(filepath.Join(dir, filename), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(testingCassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassette := map[string]interface{}{}
+	if err := json.Unmarshal(content, &cassette); err != nil {
+		t.Fatal(err)
+	}
+	var dashboardContent []byte
+	for _, interaction := range cassette["interactions"].([]interface{}) {
+		interaction := interaction.(map[string]interface{})
+		if strings.HasPrefix(interaction["uri"].(string), "/api/config/v1/dashboards/") {
+			dashboardContent, _ = json.Marshal(interaction["responseBody"])
+			dashboardContent = bytes.Replace(dashboardContent, []byte("KQG.Compare.Function=avg"), []byte("KQG.Compare.Function=avg;KQG.QueryBehavior=ParseOnChange"), 1)
+			interaction["responseBody"] = json.RawMessage(dashboardContent)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dashboard.json"), dashboardContent, 0644); err != nil {
+		t.Fatal(err)
+	}
+	content, err = json.Marshal(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "cassette_parseonchange.json"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the service stores dynatrace/dashboard.json the way HasDashboardChanged compares it
+	dashboardJSON := &dynatrace.DynatraceDashboard{}
+	if err := json.Unmarshal(dashboardContent, dashboardJSON); err != nil {
+		t.Fatal(err)
+	}
+	storedDashboard, err := json.MarshalIndent(dashboardJSON, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, string(storedDashboard), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+/**
+ * testingRun runs dtsli with the resources of the working directory in a MemoryStore
+ * Returns the exit code and what was written to stdout and stderr
+ */
+func testingRun(t *testing.T, resources map[string]string, args []string) (int, string, string) {
+	stderrFile, err := ioutil.TempFile("", "dtsli-stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(stderrFile.Name())
+	defer stderrFile.Close()
+
+	stderr := os.Stderr
+	os.Stderr = stderrFile
+	workingDirStore = common.NewMemoryStore(resources)
+	defer func() {
+		os.Stderr = stderr
+		common.SetLogOutput(os.Stderr, common.LogLevelError, common.LogFormatText)
+		workingDirStore = common.NewLocalStore(".")
+		recording.file = ""
+		recording.cassette = nil
+	}()
+
+	stdout := &bytes.Buffer{}
+	exitCode := run(args, stdout)
+
+	stderrContent, err := ioutil.ReadFile(stderrFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exitCode, stdout.String(), string(stderrContent)
+}
+
+// testingCommandTest is a dtsli run with its expected exit code and output
+type testingCommandTest struct {
+	name      string
+	args      []string
+	resources map[string]string
+	// wantExitCode is checked always, wantStdout and wantStderr are expected to be contained in the output if set
+	wantExitCode int
+	wantStdout   string
+	wantStderr   string
+}
+
+func testingRunCommandTests(t *testing.T, tests []testingCommandTest) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exitCode, stdout, stderr := testingRun(t, tt.resources, tt.args)
+			if exitCode != tt.wantExitCode {
+				t.Errorf("dtsli %s exited with %d, want %d\nstdout: %s\nstderr: %s", strings.Join(tt.args, " "), exitCode, tt.wantExitCode, stdout, stderr)
+			}
+			if !strings.Contains(stdout, tt.wantStdout) {
+				t.Errorf("dtsli %s stdout = %s, expected to contain %s", strings.Join(tt.args, " "), stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("dtsli %s stderr = %s, expected to contain %s", strings.Join(tt.args, " "), stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+// testingArgs concatenates the arguments of a dtsli run
+func testingArgs(args ...[]string) []string {
+	var result []string
+	for _, a := range args {
+		result = append(result, a...)
+	}
+	return result
+}
+
+func TestRun(t *testing.T) {
+	testingRunCommandTests(t, []testingCommandTest{
+		{name: "no command", args: []string{}, wantExitCode: exitUsage, wantStderr: "Usage: dtsli <command> [flags]"},
+		{name: "help", args: []string{"help"}, wantExitCode: exitUsage, wantStderr: "Usage: dtsli <command> [flags]"},
+		{name: "unknown command", args: []string{"evaluate"}, wantExitCode: exitUsage, wantStderr: "unknown command evaluate"},
+	})
+}
+
+func TestRunEval(t *testing.T) {
+	dir, storedDashboard, cleanup := testingFixtures(t)
+	defer cleanup()
+
+	testingRunCommandTests(t, []testingCommandTest{
+		{
+			name:         "neither -sli nor -dashboard",
+			args:         testingArgs([]string{"eval", "-replay", testingCassette}, testingTimeframe),
+			wantExitCode: exitUsage,
+			wantStderr:   "either -sli, -dashboard or -git-repo is required",
+		},
+		{
+			name:         "-sli and -dashboard",
+			args:         testingArgs([]string{"eval", "-replay", testingCassette, "-sli", filepath.Join(dir, "sli.yaml")}, testingDashboardFlags, testingTimeframe),
+			wantExitCode: exitUsage,
+			wantStderr:   "either -sli, -dashboard or -git-repo is required",
+		},
+		{
+			name:         "unknown flag",
+			args:         []string{"eval", "-indicator", "throughput"},
+			wantExitCode: exitUsage,
+		},
+		{
+			name:         "invalid output format",
+			args:         testingArgs([]string{"eval", "-replay", testingCassette, "-sli", filepath.Join(dir, "sli.yaml"), "-output", "xml"}, testingTimeframe),
+			wantExitCode: exitUsage,
+			wantStderr:   "invalid output format xml",
+		},
+		{
+			name:         "start after end",
+			args:         []string{"eval", "-replay", testingCassette, "-sli", filepath.Join(dir, "sli.yaml"), "-start", "2019-10-21T09:16:24Z", "-end", "2019-10-21T09:11:24Z"},
+			wantExitCode: exitUsage,
+			wantStderr:   "start of the timeframe has to be before its end",
+		},
+		{
+			name:         "without tenant",
+			args:         testingArgs([]string{"eval", "-tenant", "", "-sli", filepath.Join(dir, "sli.yaml")}, testingTimeframe),
+			wantExitCode: exitUsage,
+			wantStderr:   "tenant and API token are required",
+		},
+		{
+			name:         "-record and -replay",
+			args:         testingArgs([]string{"eval", "-replay", testingCassette, "-record", filepath.Join(dir, "recorded.json"), "-sli", filepath.Join(dir, "sli.yaml")}, testingTimeframe),
+			wantExitCode: exitUsage,
+			wantStderr:   "-record and -replay can't be combined",
+		},
+		{
+			name:         "missing cassette",
+			args:         testingArgs([]string{"eval", "-replay", filepath.Join(dir, "missing.json"), "-sli", filepath.Join(dir, "sli.yaml")}, testingTimeframe),
+			wantExitCode: exitUsage,
+			wantStderr:   "could not load cassette",
+		},
+		{
+			name:         "sli.yaml",
+			args:         testingArgs([]string{"eval", "-replay", testingCassette, "-sli", filepath.Join(dir, "sli.yaml")}, testingTimeframe),
+			wantExitCode: exitOK,
+			wantStdout:   "throughput         0.04146322688540897     true",
+		},
+		{
+			name:         "sli.yaml with indicators",
+			args:         testingArgs([]string{"eval", "-replay", testingCassette, "-sli", filepath.Join(dir, "sli.yaml"), "-indicators", "throughput", "-output", "json"}, testingTimeframe),
+			wantExitCode: exitOK,
+			wantStdout:   `"metric": "throughput"`,
+		},
+		{
+			name:         "missing sli.yaml",
+			args:         testingArgs([]string{"eval", "-replay", testingCassette, "-sli", filepath.Join(dir, "missing.yaml")}, testingTimeframe),
+			wantExitCode: exitFailed,
+			wantStderr:   "could not read",
+		},
+		{
+			name:         "failed indicator",
+			args:         testingArgs([]string{"eval", "-replay", testingCassette, "-sli", filepath.Join(dir, "sli_unrecorded.yaml"), "-output", "yaml"}, testingTimeframe),
+			wantExitCode: exitFailed,
+			wantStdout:   "success: false",
+		},
+		{
+			name:         "dashboard",
+			args:         testingArgs([]string{"eval", "-replay", testingCassette}, testingDashboardFlags, testingTimeframe),
+			wantExitCode: exitOK,
+			wantStdout:   "svc2svc_calls",
+		},
+		{
+			name:         "dashboard not found",
+			args:         testingArgs([]string{"eval", "-replay", filepath.Join(dir, "cassette_nodashboard.json")}, testingDashboardFlags, testingTimeframe),
+			wantExitCode: exitFailed,
+			wantStderr:   "no dashboard query found for project=qualitygate, stage=qualitystage, service=evalservice",
+		},
+		{
+			name:         "changed ParseOnChange dashboard",
+			args:         testingArgs([]string{"eval", "-replay", filepath.Join(dir, "cassette_parseonchange.json")}, testingDashboardFlags, testingTimeframe),
+			resources:    map[string]string{common.DynatraceDashboardFilename: "{}"},
+			wantExitCode: exitOK,
+			wantStdout:   "svc2svc_calls",
+		},
+		{
+			name:         "unchanged ParseOnChange dashboard",
+			args:         testingArgs([]string{"eval", "-replay", filepath.Join(dir, "cassette_parseonchange.json")}, testingDashboardFlags, testingTimeframe),
+			resources:    map[string]string{common.DynatraceDashboardFilename: storedDashboard},
+			wantExitCode: exitFailed,
+			wantStderr:   "has KQG.QueryBehavior=ParseOnChange and did not change since dynatrace/dashboard.json",
+		},
+	})
+}
+
+func TestRunConvertDashboard(t *testing.T) {
+	dir, _, cleanup := testingFixtures(t)
+	defer cleanup()
+
+	outputDir := filepath.Join(dir, "converted")
+	testingRunCommandTests(t, []testingCommandTest{
+		{
+			name:         "neither -file nor -dashboard",
+			args:         []string{"convert-dashboard"},
+			wantExitCode: exitUsage,
+			wantStderr:   "either -file or -dashboard is required",
+		},
+		{
+			name:         "-file and -dashboard",
+			args:         testingArgs([]string{"convert-dashboard", "-file", filepath.Join(dir, "dashboard.json")}, testingDashboardFlags),
+			wantExitCode: exitUsage,
+			wantStderr:   "either -file or -dashboard is required",
+		},
+		{
+			name:         "-dashboard without tenant",
+			args:         testingArgs([]string{"convert-dashboard", "-tenant", ""}, testingDashboardFlags),
+			wantExitCode: exitUsage,
+			wantStderr:   "tenant and API token are required",
+		},
+		{
+			name:         "missing file",
+			args:         []string{"convert-dashboard", "-replay", testingCassette, "-file", filepath.Join(dir, "missing.json")},
+			wantExitCode: exitFailed,
+			wantStderr:   "could not read",
+		},
+		{
+			name:         "invalid file",
+			args:         []string{"convert-dashboard", "-replay", testingCassette, "-file", filepath.Join(dir, "sli.yaml")},
+			wantExitCode: exitFailed,
+			wantStderr:   "could not parse",
+		},
+		{
+			name:         "file without tenant",
+			args:         []string{"convert-dashboard", "-tenant", "", "-file", filepath.Join(dir, "dashboard_usql.json")},
+			wantExitCode: exitOK,
+			wantStdout:   "sessions: USQL;SINGLE_VALUE;;SELECT count(*) FROM usersession",
+		},
+		{
+			name:         "file with custom charts without tenant",
+			args:         []string{"convert-dashboard", "-tenant", "", "-file", filepath.Join(dir, "dashboard.json"), "-output-dir", filepath.Join(dir, "incomplete")},
+			wantExitCode: exitFailed,
+			wantStderr:   `the custom charts "Worker Process Count (Avg);sli=proc_count;", "Response time (P95);sli=svc_rt_p95;pass=<+10%,<600"`,
+		},
+		{
+			name:         "file",
+			args:         []string{"convert-dashboard", "-replay", testingCassette, "-file", filepath.Join(dir, "dashboard.json")},
+			wantExitCode: exitOK,
+			wantStdout:   "svc2svc_calls",
+		},
+		{
+			name:         "dashboard",
+			args:         testingArgs([]string{"convert-dashboard", "-replay", testingCassette, "-output-dir", outputDir}, testingDashboardFlags),
+			wantExitCode: exitOK,
+			wantStdout:   "wrote sli.yaml and slo.yaml with 12 indicators to " + outputDir,
+		},
+		{
+			name:         "dashboard not found",
+			args:         testingArgs([]string{"convert-dashboard", "-replay", filepath.Join(dir, "cassette_nodashboard.json")}, testingDashboardFlags),
+			wantExitCode: exitFailed,
+			wantStderr:   "no dashboard query found",
+		},
+	})
+
+	if _, err := os.Stat(filepath.Join(outputDir, "slo.yaml")); err != nil {
+		t.Errorf("convert-dashboard -output-dir didn't write slo.yaml: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "incomplete")); !os.IsNotExist(err) {
+		t.Errorf("convert-dashboard -output-dir wrote files although custom charts were skipped: %v", err)
+	}
+}
+
+func TestRunGenerateDashboard(t *testing.T) {
+	dir, _, cleanup := testingFixtures(t)
+	defer cleanup()
+
+	output := filepath.Join(dir, "generated.json")
+	testingRunCommandTests(t, []testingCommandTest{
+		{
+			name:         "without -sli",
+			args:         []string{"generate-dashboard", "-slo", filepath.Join(dir, "slo.yaml")},
+			wantExitCode: exitUsage,
+			wantStderr:   "-sli is required",
+		},
+		{
+			name:         "missing sli.yaml",
+			args:         []string{"generate-dashboard", "-sli", filepath.Join(dir, "missing.yaml")},
+			wantExitCode: exitUsage,
+			wantStderr:   "could not read",
+		},
+		{
+			name:         "invalid slo.yaml",
+			args:         []string{"generate-dashboard", "-sli", filepath.Join(dir, "sli.yaml"), "-slo", filepath.Join(dir, "invalid.yaml")},
+			wantExitCode: exitUsage,
+			wantStderr:   "could not parse",
+		},
+		{
+			name:         "sli.yaml",
+			args:         []string{"generate-dashboard", "-sli", filepath.Join(dir, "sli.yaml"), "-project", "sockshop", "-stage", "staging", "-service", "carts"},
+			wantExitCode: exitOK,
+			wantStdout:   "KQG;project=sockshop;service=carts;stage=staging",
+		},
+		{
+			name:         "sli.yaml and slo.yaml",
+			args:         []string{"generate-dashboard", "-sli", filepath.Join(dir, "sli.yaml"), "-slo", filepath.Join(dir, "slo.yaml"), "-output", output},
+			wantExitCode: exitOK,
+			wantStdout:   "to " + output,
+		},
+		{
+			name:         "upload without tenant",
+			args:         []string{"generate-dashboard", "-tenant", "", "-sli", filepath.Join(dir, "sli.yaml"), "-upload"},
+			wantExitCode: exitUsage,
+			wantStderr:   "tenant and API token are required",
+		},
+		{
+			name:         "upload fails",
+			args:         []string{"generate-dashboard", "-replay", filepath.Join(dir, "cassette_empty.json"), "-sli", filepath.Join(dir, "sli.yaml"), "-upload"},
+			wantExitCode: exitFailed,
+			wantStderr:   "could not upload the dashboard",
+		},
+	})
+}
+
+func TestRunLint(t *testing.T) {
+	dir, _, cleanup := testingFixtures(t)
+	defer cleanup()
+
+	testingRunCommandTests(t, []testingCommandTest{
+		{
+			name:         "nothing to check",
+			args:         []string{"lint"},
+			wantExitCode: exitUsage,
+			wantStderr:   "at least one of -sli, -slo, -dashboard-file or -dashboard is required",
+		},
+		{
+			name:         "invalid output format",
+			args:         []string{"lint", "-sli", filepath.Join(dir, "sli.yaml"), "-output", "yaml"},
+			wantExitCode: exitUsage,
+			wantStderr:   "invalid output format yaml",
+		},
+		{
+			name:         "valid files",
+			args:         []string{"lint", "-sli", filepath.Join(dir, "sli.yaml"), "-slo", filepath.Join(dir, "slo.yaml"), "-dashboard-file", filepath.Join(dir, "dashboard.json")},
+			wantExitCode: exitOK,
+			wantStdout:   "0 errors",
+		},
+		{
+			name:         "error",
+			args:         []string{"lint", "-sli", filepath.Join(dir, "sli_invalid.yaml")},
+			wantExitCode: exitFailed,
+			wantStdout:   "the query has no metricSelector",
+		},
+		{
+			name:         "missing file",
+			args:         []string{"lint", "-slo", filepath.Join(dir, "missing.yaml"), "-output", "json"},
+			wantExitCode: exitFailed,
+			wantStdout:   `"errors": 1`,
+		},
+		{
+			name:         "warning",
+			args:         []string{"lint", "-sli", filepath.Join(dir, "sli_unmerged.yaml")},
+			wantExitCode: exitOK,
+			wantStdout:   "0 errors, 1 warnings",
+		},
+		{
+			name:         "warning with -strict",
+			args:         []string{"lint", "-strict", "-sli", filepath.Join(dir, "sli_unmerged.yaml")},
+			wantExitCode: exitFailed,
+			wantStdout:   "0 errors, 1 warnings",
+		},
+		{
+			name:         "dashboard",
+			args:         testingArgs([]string{"lint", "-replay", testingCassette}, testingDashboardFlags),
+			wantExitCode: exitOK,
+			wantStdout:   "0 errors",
+		},
+		{
+			name:         "dashboard not found",
+			args:         testingArgs([]string{"lint", "-replay", filepath.Join(dir, "cassette_nodashboard.json")}, testingDashboardFlags),
+			wantExitCode: exitFailed,
+			wantStderr:   "no dashboard query found",
+		},
+		{
+			name:         "unknown metric",
+			args:         []string{"lint", "-replay", filepath.Join(dir, "cassette_empty.json"), "-validate-metrics", "-sli", filepath.Join(dir, "sli.yaml")},
+			wantExitCode: exitFailed,
+			wantStdout:   "could not be found",
+		},
+	})
+}
+
+func TestRunMigrate(t *testing.T) {
+	dir, _, cleanup := testingFixtures(t)
+	defer cleanup()
+
+	output := filepath.Join(dir, "migrated.yaml")
+	testingRunCommandTests(t, []testingCommandTest{
+		{
+			name:         "without -sli",
+			args:         []string{"migrate", "-write"},
+			wantExitCode: exitUsage,
+			wantStderr:   "-sli is required",
+		},
+		{
+			name:         "-write and -output",
+			args:         []string{"migrate", "-sli", filepath.Join(dir, "sli_legacy.yaml"), "-write", "-output", output},
+			wantExitCode: exitUsage,
+			wantStderr:   "-write and -output can't be combined",
+		},
+		{
+			name:         "missing sli.yaml",
+			args:         []string{"migrate", "-sli", filepath.Join(dir, "missing.yaml")},
+			wantExitCode: exitUsage,
+			wantStderr:   "could not read",
+		},
+		{
+			name:         "nothing to migrate",
+			args:         []string{"migrate", "-sli", filepath.Join(dir, "sli.yaml"), "-write"},
+			wantExitCode: exitOK,
+			wantStdout:   "0 legacy queries migrated",
+		},
+		{
+			name:         "legacy query",
+			args:         []string{"migrate", "-sli", filepath.Join(dir, "sli_legacy.yaml"), "-output", output},
+			wantExitCode: exitOK,
+			wantStdout:   "1 legacy queries migrated\nwrote migrated sli.yaml to " + output,
+		},
+	})
+
+	content, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "metricSelector=builtin:service.response.time:merge(0):percentile(90)&entitySelector=tag(keptn_project:$PROJECT)") {
+		t.Errorf("migrate -output wrote %s, expected the migrated query", string(content))
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/dynatrace"
+)
+
+/**
+ * runMigrate migrates the legacy queries of an sli.yaml and prints every migrated query
+ * The migrated file is written to -output or back to the sli.yaml with -write
+ */
+func runMigrate(args []string, stdout io.Writer) int {
+	flags := newFlagSet("migrate")
+	sliFile := flags.String("sli", "", "sli.yaml to migrate")
+	output := flags.String("output", "", "file to write the migrated sli.yaml to")
+	write := flags.Bool("write", false, "write the migrated sli.yaml back to -sli")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *sliFile == "" {
+		fmt.Fprintln(os.Stderr, "-sli is required")
+		return exitUsage
+	}
+	if *write && *output != "" {
+		fmt.Fprintln(os.Stderr, "-write and -output can't be combined")
+		return exitUsage
+	}
+
+	content, err := ioutil.ReadFile(*sliFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not read %s: %v\n", *sliFile, err)
+		return exitUsage
+	}
+	migratedContent, migrations, err := dynatrace.MigrateSLIFile(content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not parse %s: %v\n", *sliFile, err)
+		return exitUsage
+	}
+
+	for _, migration := range migrations {
+		fmt.Fprintf(stdout, "%s:\n  - %s\n  + %s\n", migration.Indicator, migration.OldQuery, migration.NewQuery)
+	}
+	fmt.Fprintf(stdout, "%d legacy queries migrated\n", len(migrations))
+
+	target := *output
+	if *write {
+		if len(migrations) == 0 {
+			return exitOK
+		}
+		target = *sliFile
+	}
+	if target == "" {
+		return exitOK
+	}
+	if err := ioutil.WriteFile(target, migratedContent, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return exitFailed
+	}
+	fmt.Fprintf(stdout, "wrote migrated sli.yaml to %s\n", target)
+	return exitOK
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/fakedynatrace"
+)
+
+/**
+ * fakedynatrace serves the fixtures of a directory as Dynatrace API, e.g: to run the dynatrace-sli-service with ENV=local fully offline
+ * Usage: fakedynatrace [-fixtures dir] [-listen address] [-token token] [-latency duration] [-fault spec]...
+ */
+
+// faultFlag collects repeated -fault flags
+type faultFlag []fakedynatrace.Fault
+
+func (f *faultFlag) String() string {
+	specs := []string{}
+	for _, fault := range *f {
+		specs = append(specs, fmt.Sprintf("%+v", fault))
+	}
+	return strings.Join(specs, ";")
+}
+
+func (f *faultFlag) Set(value string) error {
+	fault, err := fakedynatrace.ParseFault(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, fault)
+	return nil
+}
+
+func main() {
+	fixtures := flag.String("fixtures", "pkg/lib/fakedynatrace/fixtures", "directory with the fixtures")
+	listen := flag.String("listen", "localhost:8081", "address to listen on")
+	token := flag.String("token", "", "API token the requests have to send - any token is accepted if empty")
+	latency := flag.Duration("latency", 0, "latency added to every response, e.g: 200ms")
+	faults := faultFlag{}
+	flag.Var(&faults, "fault", "fault to inject as comma separated key=value pairs of path, latency, status, retryAfter and count, can be repeated, e.g: path=/api/v2/metrics/query,status=429,retryAfter=1,count=2")
+	flag.Parse()
+
+	if _, err := os.Stat(*fixtures); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid fixture directory: %v\n", err)
+		os.Exit(2)
+	}
+
+	server := fakedynatrace.New(*fixtures)
+	server.APIToken = *token
+	for _, fault := range faults {
+		server.AddFault(fault)
+	}
+	if *latency > 0 {
+		// added last so the faults with a status code still apply
+		server.AddFault(fakedynatrace.Fault{Latency: *latency})
+	}
+
+	log.Printf("Serving fake Dynatrace API from %s on http://%s", *fixtures, *listen)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.URL.String())
+		server.ServeHTTP(w, r)
+	})
+	if err := http.ListenAndServe(*listen, handler); err != nil {
+		log.Fatal(err)
+	}
+}
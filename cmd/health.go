@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+)
+
+const healthPath = "/health"
+const readinessPath = "/ready"
+
+// readinessCheckTimeout is the timeout of the request to the configuration-service during the readiness check
+const readinessCheckTimeout = 5 * time.Second
+
+// keptnWideSecretNames are the secrets checked during the readiness check - project specific secrets are only known once an event is received
+var keptnWideSecretNames = []string{"dynatrace-credentials", "dynatrace"}
+
+// shuttingDown is set once SIGTERM was received - from then on the service reports not ready so no new events are sent
+var shuttingDown int32
+
+func setShuttingDown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+}
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+/**
+ * Liveness probe: the service is alive as long as it can serve http requests
+ */
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+/**
+ * Readiness probe: the service is not ready while it shuts down
+ * Depending on the configuration it also checks that the configuration-service is reachable and that Keptn-wide Dynatrace credentials can be resolved
+ */
+func readinessHandler(env envConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := checkReadiness(env)
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// checkReadiness returns an error describing why the service is not ready
+func checkReadiness(env envConfig) error {
+	if isShuttingDown() {
+		return errors.New("shutting down")
+	}
+
+	if env.ReadinessCheckConfigurationService && !env.runLocal() && env.ResourceStore == "" {
+		if err := checkConfigurationService(); err != nil {
+			return err
+		}
+	}
+
+	if env.ReadinessCheckCredentials {
+		if err := checkDynatraceCredentials(env); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkConfigurationService returns an error if the configuration-service doesn't respond or responds with a server error
+func checkConfigurationService() error {
+	configurationServiceURL := common.GetConfigurationServiceURL()
+	if !strings.HasPrefix(configurationServiceURL, "http://") && !strings.HasPrefix(configurationServiceURL, "https://") {
+		// CONFIGURATION_SERVICE is usually just host and port, e.g: configuration-service:8080
+		configurationServiceURL = "http://" + configurationServiceURL
+	}
+
+	client := &http.Client{Timeout: readinessCheckTimeout}
+	resp, err := client.Get(configurationServiceURL)
+	if err != nil {
+		return fmt.Errorf("configuration-service not reachable: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("configuration-service returned status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkDynatraceCredentials returns an error if none of the Keptn-wide Dynatrace secrets can be resolved to an API token
+func checkDynatraceCredentials(env envConfig) error {
+	for _, secretName := range keptnWideSecretNames {
+		dtCredentials, err := env.getDTCredentials(secretName)
+		if err == nil && dtCredentials != nil && dtCredentials.ApiToken != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("none of the Dynatrace secrets %v can be resolved", keptnWideSecretNames)
+}
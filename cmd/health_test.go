@@ -0,0 +1,181 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testingReadiness calls the readiness probe and returns status code and body
+func testingReadiness(env envConfig) (int, string) {
+	recorder := httptest.NewRecorder()
+	readinessHandler(env)(recorder, httptest.NewRequest("GET", readinessPath, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+// testingSetEnv sets environment variables - an empty value unsets the variable. The returned function restores them
+func testingSetEnv(values map[string]string) func() {
+	restore := map[string]*string{}
+	for name, value := range values {
+		if previous, ok := os.LookupEnv(name); ok {
+			restore[name] = &previous
+		} else {
+			restore[name] = nil
+		}
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+	return func() {
+		for name, previous := range restore {
+			if previous == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *previous)
+			}
+		}
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	healthHandler(recorder, httptest.NewRequest("GET", healthPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("health probe returned status code %d, want %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestReadinessHandlerChecksConfigurationService(t *testing.T) {
+	configurationService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer configurationService.Close()
+	failingConfigurationService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failingConfigurationService.Close()
+	unreachableConfigurationService := httptest.NewServer(http.NotFoundHandler())
+	unreachableConfigurationService.Close()
+
+	tests := []struct {
+		name                 string
+		configurationService string
+		env                  envConfig
+		wantStatusCode       int
+		wantBody             string
+	}{
+		{
+			name:                 "reachable without scheme",
+			configurationService: strings.TrimPrefix(configurationService.URL, "http://"),
+			env:                  envConfig{ReadinessCheckConfigurationService: true},
+			wantStatusCode:       http.StatusOK,
+		},
+		{
+			name:                 "server error",
+			configurationService: failingConfigurationService.URL,
+			env:                  envConfig{ReadinessCheckConfigurationService: true},
+			wantStatusCode:       http.StatusServiceUnavailable,
+			wantBody:             "configuration-service returned status code 502",
+		},
+		{
+			name:                 "unreachable",
+			configurationService: unreachableConfigurationService.URL,
+			env:                  envConfig{ReadinessCheckConfigurationService: true},
+			wantStatusCode:       http.StatusServiceUnavailable,
+			wantBody:             "configuration-service not reachable",
+		},
+		{
+			name:                 "unreachable but not checked",
+			configurationService: unreachableConfigurationService.URL,
+			env:                  envConfig{},
+			wantStatusCode:       http.StatusOK,
+		},
+		{
+			name:                 "unreachable but not used locally",
+			configurationService: unreachableConfigurationService.URL,
+			env:                  envConfig{ReadinessCheckConfigurationService: true, Env: "local"},
+			wantStatusCode:       http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer testingSetEnv(map[string]string{"CONFIGURATION_SERVICE": tt.configurationService})()
+
+			statusCode, body := testingReadiness(tt.env)
+			if statusCode != tt.wantStatusCode {
+				t.Errorf("readiness probe returned status code %d (%s), want %d", statusCode, body, tt.wantStatusCode)
+			}
+			if !strings.Contains(body, tt.wantBody) {
+				t.Errorf("readiness probe returned %s, want %s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestReadinessHandlerChecksCredentials(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            envConfig
+		envVars        map[string]string
+		wantStatusCode int
+	}{
+		{
+			name:           "no Kubernetes secrets",
+			env:            envConfig{ReadinessCheckCredentials: true},
+			envVars:        map[string]string{"KUBERNETES_SERVICE_HOST": "", "KUBERNETES_SERVICE_PORT": ""},
+			wantStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "no local API token",
+			env:            envConfig{ReadinessCheckCredentials: true, Env: "local"},
+			envVars:        map[string]string{"DT_TENANT": "abc12345.live.dynatrace.com", "DT_API_TOKEN": ""},
+			wantStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "local credentials",
+			env:            envConfig{ReadinessCheckCredentials: true, Env: "local"},
+			envVars:        map[string]string{"DT_TENANT": "abc12345.live.dynatrace.com", "DT_API_TOKEN": "token"},
+			wantStatusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer testingSetEnv(tt.envVars)()
+
+			statusCode, body := testingReadiness(tt.env)
+			if statusCode != tt.wantStatusCode {
+				t.Errorf("readiness probe returned status code %d (%s), want %d", statusCode, body, tt.wantStatusCode)
+			}
+			if statusCode != http.StatusOK && !strings.Contains(body, "none of the Dynatrace secrets") {
+				t.Errorf("readiness probe returned %s, want the unresolved secrets", body)
+			}
+		})
+	}
+}
+
+func TestReadinessHandlerWhileShuttingDown(t *testing.T) {
+	defer atomic.StoreInt32(&shuttingDown, 0)
+
+	if statusCode, body := testingReadiness(envConfig{}); statusCode != http.StatusOK {
+		t.Fatalf("readiness probe returned status code %d (%s) before shutdown, want %d", statusCode, body, http.StatusOK)
+	}
+
+	setShuttingDown()
+
+	statusCode, body := testingReadiness(envConfig{})
+	if statusCode != http.StatusServiceUnavailable || body != "shutting down" {
+		t.Errorf("readiness probe returned status code %d (%s) while shutting down, want %d", statusCode, body, http.StatusServiceUnavailable)
+	}
+
+	// the liveness probe is not affected - in-flight evaluations still finish
+	recorder := httptest.NewRecorder()
+	healthHandler(recorder, httptest.NewRequest("GET", healthPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("health probe returned status code %d while shutting down, want %d", recorder.Code, http.StatusOK)
+	}
+}
@@ -7,9 +7,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
@@ -24,6 +27,7 @@ import (
 	keptnevents "github.com/keptn/go-utils/pkg/lib"
 	"github.com/keptn/go-utils/pkg/lib/keptn"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"go.opentelemetry.io/otel/trace"
 	// configutils "github.com/keptn/go-utils/pkg/configuration-service/utils"
 	// keptnevents "github.com/keptn/go-utils/pkg/events"
 	// keptnutils "github.com/keptn/go-utils/pkg/utils"
@@ -34,10 +38,91 @@ const eventbroker = "EVENTBROKER"
 const configservice = "CONFIGURATION_SERVICE"
 const sliResourceURI = "dynatrace/sli.yaml"
 
+// serviceLogger logs messages that don't belong to an event, e.g: during startup and shutdown
+var serviceLogger = common.NewLogger("", "", common.ServiceName)
+
 type envConfig struct {
 	// Port on which to listen for cloudevents
 	Port int    `envconfig:"RCV_PORT" default:"8080"`
 	Path string `envconfig:"RCV_PATH" default:"/"`
+	// ShutdownTimeout is how long in-flight get-sli requests may take to finish after SIGTERM
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"180s"`
+	// ReadinessCheckConfigurationService makes the readiness probe verify that the configuration-service is reachable
+	ReadinessCheckConfigurationService bool `envconfig:"READINESS_CHECK_CONFIGURATION_SERVICE" default:"false"`
+	// ReadinessCheckCredentials makes the readiness probe verify that Keptn-wide Dynatrace credentials can be resolved
+	ReadinessCheckCredentials bool `envconfig:"READINESS_CHECK_DT_CREDENTIALS" default:"false"`
+	// Env runs the service locally: local loads and stores resources in LocalResourceDir, localtest loads them from the configuration-service and stores them in LocalResourceDir
+	Env string `envconfig:"ENV" default:""`
+	// LocalResourceDir is the directory of the resources when running locally, e.g: <dir>/dynatrace/sli.yaml
+	LocalResourceDir string `envconfig:"LOCAL_RESOURCE_DIR" default:"."`
+	// ResourceStore overrides the resource store picked by Env: git loads and stores resources in the Git checkout GitRepoDir
+	ResourceStore string `envconfig:"RESOURCE_STORE" default:""`
+	// GitRepoDir is the root of the Git checkout for RESOURCE_STORE=git
+	GitRepoDir string `envconfig:"GIT_REPO_DIR" default:"."`
+	// GitLayout is the layout of the Git checkout: branches (like the configuration-service) or directories
+	GitLayout string `envconfig:"GIT_LAYOUT" default:"branches"`
+	// GitDefaultBranch is the branch with the project-level resources of the branches layout - default: the default branch of origin or master
+	GitDefaultBranch string `envconfig:"GIT_DEFAULT_BRANCH" default:""`
+	// GitCommit commits uploaded resources with the directories layout - the branches layout always commits
+	GitCommit bool `envconfig:"GIT_COMMIT" default:"false"`
+}
+
+// runLocal returns true if the service runs locally, i.e: ENV=local or ENV=localtest
+func (env envConfig) runLocal() bool {
+	return env.Env == "local" || env.Env == "localtest"
+}
+
+// newResourceStore returns the store of the Keptn resources - the configuration-service unless the service runs locally or RESOURCE_STORE is set
+func (env envConfig) newResourceStore() (common.ResourceStore, error) {
+	switch env.ResourceStore {
+	case "":
+	case "git":
+		store, err := common.NewGitStore(env.GitRepoDir, env.GitLayout)
+		if err != nil {
+			return nil, err
+		}
+		store.Commit = env.GitCommit
+		store.DefaultBranch = env.GitDefaultBranch
+		return store, nil
+	default:
+		return nil, fmt.Errorf("invalid RESOURCE_STORE %s, expected git", env.ResourceStore)
+	}
+
+	switch env.Env {
+	case "local":
+		return common.NewLocalStore(env.LocalResourceDir), nil
+	case "localtest":
+		return common.NewSplitStore(common.NewConfigurationServiceStore(common.GetConfigurationServiceURL()), common.NewLocalStore(env.LocalResourceDir)), nil
+	default:
+		return common.NewConfigurationServiceStore(common.GetConfigurationServiceURL()), nil
+	}
+}
+
+// getDTCredentials returns the Dynatrace credentials of a secret - locally they are taken from DT_TENANT, DT_API_TOKEN, ...
+func (env envConfig) getDTCredentials(secretName string) (*common.DTCredentials, error) {
+	if env.runLocal() {
+		return common.GetLocalDTCredentials(secretName)
+	}
+	return common.GetDTCredentials(secretName)
+}
+
+// eventHandler handles the events received by the service with the resource store of its environment
+type eventHandler struct {
+	env           envConfig
+	resourceStore common.ResourceStore
+	// shutdownDeadline is closed when in-flight evaluations have to be canceled to still send their results before SHUTDOWN_TIMEOUT
+	shutdownDeadline <-chan struct{}
+}
+
+// shutdownResultsReserve is the part of SHUTDOWN_TIMEOUT that is left for sending the partial results of canceled evaluations
+const shutdownResultsReserve = 15 * time.Second
+
+func newEventHandler(env envConfig) (*eventHandler, error) {
+	resourceStore, err := env.newResourceStore()
+	if err != nil {
+		return nil, err
+	}
+	return &eventHandler{env: env, resourceStore: resourceStore}, nil
 }
 
 func main() {
@@ -46,8 +131,10 @@ func main() {
 		log.Fatalf("Failed to process env var: %s", err)
 	}
 
-	if common.RunLocal || common.RunLocalTest {
-		log.Println("env=runlocal: Running with local filesystem to fetch resources")
+	if env.ResourceStore == "git" {
+		serviceLogger.Infof("resourceStore=git: Running with the Git checkout in %s (layout %s) to fetch resources", env.GitRepoDir, env.GitLayout)
+	} else if env.runLocal() {
+		serviceLogger.Infof("env=%s: Running with local filesystem in %s to fetch resources", env.Env, env.LocalResourceDir)
 	}
 
 	os.Exit(_main(os.Args[1:], env))
@@ -55,29 +142,85 @@ func main() {
 
 func _main(args []string, env envConfig) int {
 
-	ctx := context.Background()
+	shutdownTracing, err := common.InitTracing()
+	if err != nil {
+		log.Fatalf("failed to initialize tracing, %v", err)
+	}
+	defer func() {
+		// flush the spans that weren't exported yet
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelShutdown()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			serviceLogger.Errorf("failed to shut down tracing, %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	ctx = cloudevents.WithEncodingStructured(ctx)
 
-	p, err := cloudevents.NewHTTP(cloudevents.WithPath(env.Path), cloudevents.WithPort(env.Port))
+	// on SIGTERM we stop accepting new events and let in-flight get-sli requests finish (up to SHUTDOWN_TIMEOUT)
+	// evaluations still running shortly before SHUTDOWN_TIMEOUT are canceled and send their partial results
+	shutdownDeadline := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		serviceLogger.Infof("Received %v, waiting for in-flight requests to finish ...", sig)
+		setShuttingDown()
+		cancel()
+		time.AfterFunc(env.ShutdownTimeout-shutdownResultsReserve, func() {
+			serviceLogger.Infof("Shutdown deadline reached, canceling in-flight evaluations ...")
+			close(shutdownDeadline)
+		})
+	}()
+
+	p, err := cloudevents.NewHTTP(cloudevents.WithPath(env.Path), cloudevents.WithPort(env.Port), cloudevents.WithShutdownTimeout(env.ShutdownTimeout))
 	if err != nil {
 		log.Fatalf("failed to create client, %v", err)
 	}
+
+	// expose the Prometheus metrics, liveness and readiness probes next to the cloudevents receiver
+	p.Handler = http.NewServeMux()
+	p.Handler.Handle(common.MetricsPath, common.MetricsHandler())
+	p.Handler.HandleFunc(healthPath, healthHandler)
+	p.Handler.HandleFunc(readinessPath, readinessHandler(env))
+
 	c, err := cloudevents.NewClient(p)
 	if err != nil {
 		log.Fatalf("failed to create client, %v", err)
 	}
-	log.Fatal(c.StartReceiver(ctx, gotEvent))
+	eh, err := newEventHandler(env)
+	if err != nil {
+		log.Fatalf("failed to create resource store, %v", err)
+	}
+	eh.shutdownDeadline = shutdownDeadline
+	if err := c.StartReceiver(ctx, eh.gotEvent); err != nil {
+		log.Fatalf("failed to start receiver, %v", err)
+	}
+	serviceLogger.Info("Shut down gracefully")
 	return 0
 }
 
 /**
  * Handles Events
  */
-func gotEvent(ctx context.Context, event cloudevents.Event) error {
+func (eh *eventHandler) gotEvent(ctx context.Context, event cloudevents.Event) error {
+	common.EventsHandled.WithLabelValues(event.Type()).Inc()
 
 	switch event.Type() {
 	case keptnevents.InternalGetSLIEventType:
-		return retrieveMetrics(event)
+		// continue the trace of the event - if any - see https://github.com/cloudevents/spec/blob/v1.0/extensions/distributed-tracing.md
+		// the receiver's ctx is canceled on SIGTERM - in-flight evaluations have to finish though, they are only bound by the evaluation and shutdown deadline
+		var traceparent, tracestate string
+		event.Context.ExtensionAs("traceparent", &traceparent)
+		event.Context.ExtensionAs("tracestate", &tracestate)
+		ctx = common.ExtractTraceContext(context.Background(), traceparent, tracestate)
+
+		ctx, span := common.StartSpan(ctx, "retrieveMetrics")
+		err := eh.retrieveMetrics(ctx, event)
+		common.EndSpan(span, err)
+		return err
 	default:
 		return errors.New("received unknown event type")
 	}
@@ -89,7 +232,7 @@ func gotEvent(ctx context.Context, event cloudevents.Event) error {
  *              to circumvent this issue I am changing the check to also allow a time difference of up to 2 minutes (120 seconds). This shouldnt be a problem as our SLI Service retries the DYnatrace API anyway
  * Here is the issue: https://github.com/keptn-contrib/dynatrace-sli-service/issues/55
  */
-func ensureRightTimestamps(start string, end string, logger keptn.LoggerInterface) (time.Time, time.Time, error) {
+func ensureRightTimestamps(ctx context.Context, start string, end string, defaults *common.SLIDefaults, logger *common.Logger) (time.Time, time.Time, error) {
 
 	startUnix, err := common.ParseUnixTimestamp(start)
 	if err != nil {
@@ -116,24 +259,27 @@ func ensureRightTimestamps(start string, end string, logger keptn.LoggerInterfac
 	// AG-2020-07-16: Wait so Dynatrace has enough data but dont wait every time to shorten processing time
 	// if we have a very short evaluation window and the end timestampe is now then we need to give Dynatrace some time to make sure we have relevant data
 	// if the evalutaion timeframe is > 2 minutes we dont wait and just live with the fact that we may miss one minute or two at the end
-
-	waitForSeconds := 120.0        // by default lets make sure we are at least 120 seconds away from "now()"
-	if timeframeInSeconds >= 300 { // if our evaluated timeframe however is larger than 5 minutes its ok to continue right away. 5 minutes is the default timeframe for most evaluations
-		waitForSeconds = 0.0
-	} else if timeframeInSeconds >= 120 { // if the evaluation span is between 2 and 5 minutes make sure we at least have the last minute of data
-		waitForSeconds = 60.0
-	}
+	// by default: wait 120 seconds for timeframes < 2 minutes, 60 seconds for timeframes < 5 minutes - this can be configured through defaults.waitForData in dynatrace.conf.yaml
+	waitForSeconds := common.ResolveSLIDefaults(defaults).GetWaitForDataDelay(endUnix.Sub(startUnix)).Seconds()
 
 	// log output while we are waiting
 	if time.Now().Sub(endUnix).Seconds() < waitForSeconds {
-		logger.Debug(fmt.Sprintf("As the end date is too close to Now() we are going to wait to make sure we have all the data for the requested timeframe(start-end)\n"))
+		logger.Debugf("As the end date is too close to Now() we are going to wait to make sure we have all the data for the requested timeframe(start-end)\n")
 	}
 
 	// make sure the end timestamp is at least waitForSeconds seconds in the past such that dynatrace metrics API has processed data
+	_, span := common.StartSpan(ctx, "waitForData")
+	defer span.End()
+	waitStart := time.Now()
+	defer func() { common.WaitForDataSeconds.Observe(time.Since(waitStart).Seconds()) }()
 	for time.Now().Sub(endUnix).Seconds() < waitForSeconds {
 		// ToDo: this should be done in main.go
-		logger.Debug(fmt.Sprintf("Sleeping for %d seconds... (waiting for Dynatrace Metrics API)\n", int(waitForSeconds-time.Now().Sub(endUnix).Seconds())))
-		time.Sleep(10 * time.Second)
+		logger.Debugf("Sleeping for %d seconds... (waiting for Dynatrace Metrics API)\n", int(waitForSeconds-time.Now().Sub(endUnix).Seconds()))
+		select {
+		case <-ctx.Done():
+			return startUnix, endUnix, fmt.Errorf("stopped waiting for data: %v", ctx.Err())
+		case <-time.After(10 * time.Second):
+		}
 	}
 
 	return startUnix, endUnix, nil
@@ -142,13 +288,13 @@ func ensureRightTimestamps(start string, end string, logger keptn.LoggerInterfac
 /**
  * Tries to find a dynatrace dashboard that matches our project. If so - returns the SLI, SLO and SLIResults
  */
-func getDataFromDynatraceDashboard(dynatraceHandler *dynatrace.Handler, keptnEvent *common.BaseKeptnEvent, startUnix time.Time, endUnix time.Time, dashboardConfig string) (string, []*keptnevents.SLIResult, error) {
+func (eh *eventHandler) getDataFromDynatraceDashboard(ctx context.Context, dynatraceHandler *dynatrace.Handler, keptnEvent *common.BaseKeptnEvent, startUnix time.Time, endUnix time.Time, dashboardConfig string) (string, []*keptnevents.SLIResult, error) {
 
 	//
 	// Option 1: We query the data from a dashboard instead of the uploaded SLI.yaml
 	// ==============================================================================
 	// Lets see if we have a Dashboard in Dynatrace that we should parse
-	dashboardLinkAsLabel, dashboardJSON, dashboardSLI, dashboardSLO, sliResults, err := dynatraceHandler.QueryDynatraceDashboardForSLIs(keptnEvent, dashboardConfig, startUnix, endUnix)
+	dashboardLinkAsLabel, dashboardJSON, dashboardSLI, dashboardSLO, sliResults, err := dynatraceHandler.QueryDynatraceDashboardForSLIs(ctx, keptnEvent, dashboardConfig, startUnix, endUnix)
 	if err != nil {
 		return dashboardLinkAsLabel, sliResults, fmt.Errorf("could not query Dynatrace dashboard for SLIs: %v", err)
 	}
@@ -157,7 +303,7 @@ func getDataFromDynatraceDashboard(dynatraceHandler *dynatrace.Handler, keptnEve
 	if dashboardJSON != nil {
 		jsonAsByteArray, _ := json.MarshalIndent(dashboardJSON, "", "  ")
 
-		err := common.UploadKeptnResource(jsonAsByteArray, common.DynatraceDashboardFilename, keptnEvent, dynatraceHandler.Logger)
+		err := common.UploadKeptnResource(ctx, dynatraceHandler.ResourceStore, jsonAsByteArray, common.DynatraceDashboardFilename, keptnEvent, dynatraceHandler.Logger)
 		if err != nil {
 			return dashboardLinkAsLabel, sliResults, fmt.Errorf("could not store %s : %v", common.DynatraceDashboardFilename, err)
 		}
@@ -167,7 +313,7 @@ func getDataFromDynatraceDashboard(dynatraceHandler *dynatrace.Handler, keptnEve
 	if dashboardSLI != nil {
 		yamlAsByteArray, _ := yaml.Marshal(dashboardSLI)
 
-		err := common.UploadKeptnResource(yamlAsByteArray, common.DynatraceSLIFilename, keptnEvent, dynatraceHandler.Logger)
+		err := common.UploadKeptnResource(ctx, dynatraceHandler.ResourceStore, yamlAsByteArray, common.DynatraceSLIFilename, keptnEvent, dynatraceHandler.Logger)
 		if err != nil {
 			return dashboardLinkAsLabel, sliResults, fmt.Errorf("could not store %s : %v", common.DynatraceSLIFilename, err)
 		}
@@ -177,7 +323,7 @@ func getDataFromDynatraceDashboard(dynatraceHandler *dynatrace.Handler, keptnEve
 	if dashboardSLO != nil {
 		yamlAsByteArray, _ := yaml.Marshal(dashboardSLO)
 
-		err := common.UploadKeptnResource(yamlAsByteArray, common.KeptnSLOFilename, keptnEvent, dynatraceHandler.Logger)
+		err := common.UploadKeptnResource(ctx, dynatraceHandler.ResourceStore, yamlAsByteArray, common.KeptnSLOFilename, keptnEvent, dynatraceHandler.Logger)
 		if err != nil {
 			return dashboardLinkAsLabel, sliResults, fmt.Errorf("could not store %s : %v", common.KeptnSLOFilename, err)
 		}
@@ -185,11 +331,11 @@ func getDataFromDynatraceDashboard(dynatraceHandler *dynatrace.Handler, keptnEve
 
 	// lets also write the result to a local file in local test mode
 	if sliResults != nil {
-		if common.RunLocal || common.RunLocalTest {
+		if eh.env.runLocal() {
 			dynatraceHandler.Logger.Info("(RunLocal Output) Write SLIResult to sliresult.json")
 			jsonAsByteArray, _ := json.MarshalIndent(sliResults, "", "  ")
 
-			common.UploadKeptnResource(jsonAsByteArray, "sliresult.json", keptnEvent, dynatraceHandler.Logger)
+			common.UploadKeptnResource(ctx, dynatraceHandler.ResourceStore, jsonAsByteArray, "sliresult.json", keptnEvent, dynatraceHandler.Logger)
 		}
 	}
 
@@ -202,7 +348,7 @@ func getDataFromDynatraceDashboard(dynatraceHandler *dynatrace.Handler, keptnEve
  * First tries to find a Dynatrace dashboard and then parses it for SLIs and SLOs
  * Second will go to parse the SLI.yaml and returns the SLI as passed in by the event
  */
-func retrieveMetrics(event cloudevents.Event) error {
+func (eh *eventHandler) retrieveMetrics(ctx context.Context, event cloudevents.Event) error {
 	var shkeptncontext string
 	event.Context.ExtensionAs("shkeptncontext", &shkeptncontext)
 	eventData := &keptnevents.InternalGetSLIEventData{}
@@ -226,26 +372,37 @@ func retrieveMetrics(event cloudevents.Event) error {
 		return nil
 	}
 
-	//
-	// Lets get a Logger
-	stdLogger := keptn.NewLogger(shkeptncontext, event.Context.GetID(), "dynatrace-sli-service")
-	stdLogger.Info(fmt.Sprintf("Processing sh.keptn.internal.event.get-sli for %s.%s.%s", eventData.Project, eventData.Stage, eventData.Service))
-
-	//
-	// see if there is a dynatrace.conf.yaml
 	keptnEvent := &common.BaseKeptnEvent{}
 	keptnEvent.Project = eventData.Project
 	keptnEvent.Stage = eventData.Stage
 	keptnEvent.Service = eventData.Service
 	keptnEvent.TestStrategy = eventData.TestStrategy
+	keptnEvent.DeploymentStrategy = eventData.DeploymentStrategy
+	keptnEvent.Deployment = eventData.Deployment
 	keptnEvent.Labels = eventData.Labels
 	keptnEvent.Context = shkeptncontext
-	dynatraceConfigFile, _ := common.GetDynatraceConfig(keptnEvent, stdLogger)
+
+	//
+	// Lets get a Logger that adds context, event, project, stage and service to every message
+	stdLogger := common.NewLogger(shkeptncontext, event.Context.GetID(), common.ServiceName).WithEvent(keptnEvent)
+	trace.SpanFromContext(ctx).SetAttributes(common.EventSpanAttributes(keptnEvent)...)
+	stdLogger.Infof("Processing sh.keptn.internal.event.get-sli for %s.%s.%s", eventData.Project, eventData.Stage, eventData.Service)
+
+	//
+	// see if there is a dynatrace.conf.yaml
+	dynatraceConfigFile, err := common.GetDynatraceConfig(ctx, eh.resourceStore, keptnEvent, stdLogger)
+	if err != nil {
+		// an invalid or unavailable dynatrace.conf.yaml must not silently fall back to the defaults
+		stdLogger.Error("Failed to load dynatrace.conf.yaml: " + err.Error())
+		return sendInternalGetSLIDoneEvent(shkeptncontext, eventData.Project, eventData.Service, eventData.Stage,
+			nil, eventData.Start, eventData.End, eventData.TestStrategy, eventData.DeploymentStrategy,
+			eventData.Deployment, eventData.Labels, eventData.Indicators, err)
+	}
 
 	dtCreds := ""
 	if dynatraceConfigFile != nil {
 		// implementing https://github.com/keptn-contrib/dynatrace-sli-service/issues/90
-		dtCreds = common.ReplaceKeptnPlaceholders(dynatraceConfigFile.DtCreds, keptnEvent)
+		dtCreds = common.ReplaceKeptnPlaceholders(dynatraceConfigFile.DtCreds, keptnEvent, stdLogger)
 		stdLogger.Debug("Found dynatrace.conf.yaml with DTCreds: " + dtCreds)
 	} else {
 		stdLogger.Debug("Using default DTCreds: dynatrace as no custom dynatrace.conf.yaml was found!")
@@ -261,7 +418,7 @@ func retrieveMetrics(event cloudevents.Event) error {
 	}
 	eventData.Labels["DtCreds"] = dynatraceConfigFile.DtCreds
 
-	dtCredentials, err := getDynatraceCredentials(dtCreds, eventData.Project, stdLogger)
+	dtCredentials, err := eh.getDynatraceCredentials(dtCreds, eventData.Project, stdLogger)
 
 	if err != nil {
 		stdLogger.Error("Failed to fetch Dynatrace credentials: " + err.Error())
@@ -271,15 +428,67 @@ func retrieveMetrics(event cloudevents.Event) error {
 			eventData.Deployment, eventData.Labels, eventData.Indicators, err)
 	}
 
+	// the API token and the client key must never show up in a log message of this event
+	stdLogger.RegisterSecret(dtCredentials.ApiToken)
+	stdLogger.RegisterSecret(dtCredentials.ClientKey)
+
+	// proxy, CAs and client certificate can be configured per credential set
+	transport, err := dynatrace.NewHTTPTransport(dtCredentials)
+	if err != nil {
+		stdLogger.Error("Invalid connection settings in Dynatrace credentials: " + err.Error())
+		return sendInternalGetSLIDoneEvent(shkeptncontext, eventData.Project, eventData.Service, eventData.Stage,
+			nil, eventData.Start, eventData.End, eventData.TestStrategy, eventData.DeploymentStrategy,
+			eventData.Deployment, eventData.Labels, eventData.Indicators, err)
+	}
+
 	//
 	// creating Dynatrace Handler which allows us to call the Dynatrace API
 	dynatraceHandler := dynatrace.NewDynatraceHandler(dtCredentials.Tenant, keptnEvent, map[string]string{
 		"Authorization": "Api-Token " + dtCredentials.ApiToken,
-	}, eventData.CustomFilters, shkeptncontext, event.ID())
+	}, eventData.CustomFilters, shkeptncontext, event.ID(), dynatraceConfigFile.Defaults)
+	dynatraceHandler.HTTPClient.Transport = transport
+	dynatraceHandler.ResourceStore = eh.resourceStore
+	// the handler logs with the secrets of the event, e.g: $ENV values used in queries are redacted from all messages of the event
+	dynatraceHandler.Logger = stdLogger
+
+	//
+	// load the default SLI catalog including overrides from a file or ConfigMap
+	sliCatalog, err := dynatrace.LoadSLICatalog(stdLogger)
+	if err != nil {
+		// log the error, but continue with the catalog we have
+		stdLogger.Error(err.Error())
+	}
+	dynatraceHandler.DefaultQueries = sliCatalog
+	dynatraceHandler.Timeseries = dynatraceConfigFile.Timeseries
+
+	// record all Dynatrace API calls of the evaluation so it can be replayed, e.g: with dtsli eval -replay
+	if dynatraceConfigFile.RecordAPICalls {
+		dynatraceHandler.Cassette = dynatrace.NewRecordingCassette(map[string]string{
+			"shkeptncontext": shkeptncontext,
+			"project":        eventData.Project,
+			"stage":          eventData.Stage,
+			"service":        eventData.Service,
+			"start":          eventData.Start,
+			"end":            eventData.End,
+			"dashboard":      dynatraceConfigFile.Dashboard,
+		})
+	}
+
+	//
+	// waiting for data and retrieving SLIs is canceled at the shutdown deadline - results are still uploaded and sent with ctx
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	go func() {
+		select {
+		case <-eh.shutdownDeadline:
+			cancelRun()
+		case <-runCtx.Done():
+		}
+	}()
 
 	//
 	// parse start and end (which are datetime strings) and convert them into unix timestamps
-	startUnix, endUnix, err := ensureRightTimestamps(eventData.Start, eventData.End, stdLogger)
+	startUnix, endUnix, err := ensureRightTimestamps(runCtx, eventData.Start, eventData.End, dynatraceHandler.Defaults, stdLogger)
 	if err != nil {
 		stdLogger.Error(err.Error())
 		return sendInternalGetSLIDoneEvent(shkeptncontext, eventData.Project, eventData.Service, eventData.Stage,
@@ -287,6 +496,11 @@ func retrieveMetrics(event cloudevents.Event) error {
 			eventData.Deployment, eventData.Labels, eventData.Indicators, err)
 	}
 
+	//
+	// from now on all SLIs have to be retrieved within the evaluation deadline - indicators not retrieved by then are reported as timed out
+	evaluationCtx, cancelEvaluation := context.WithTimeout(runCtx, dynatraceHandler.Defaults.EvaluationTimeout)
+	defer cancelEvaluation()
+
 	//
 	// THIS IS OUR RETURN OBJECT: sliResult
 	// Whether option 1 or option 2 - this will hold our SLIResults
@@ -294,7 +508,7 @@ func retrieveMetrics(event cloudevents.Event) error {
 
 	//
 	// Option 1 - see if we can get the data from a Dnatrace Dashboard
-	dashboardLinkAsLabel, sliResults, err := getDataFromDynatraceDashboard(dynatraceHandler, keptnEvent, startUnix, endUnix, dynatraceConfigFile.Dashboard)
+	dashboardLinkAsLabel, sliResults, err := eh.getDataFromDynatraceDashboard(evaluationCtx, dynatraceHandler, keptnEvent, startUnix, endUnix, dynatraceConfigFile.Dashboard)
 	if err != nil {
 		// log the error, but continue with loading sli.yaml
 		stdLogger.Error(err.Error())
@@ -310,9 +524,32 @@ func retrieveMetrics(event cloudevents.Event) error {
 
 	//
 	// Option 2: If we have not received any data via a Dynatrace Dashboard lets query the SLIs based on the SLI.yaml definition
+	reportSource := dynatrace.ReportSourceDashboard
 	if sliResults == nil {
-		// get custom metrics for project if they exist
-		projectCustomQueries, _ := getCustomQueries(keptnEvent, keptnHandler, stdLogger)
+		reportSource = dynatrace.ReportSourceSLIFile
+
+		// migrate legacy queries in dynatrace/sli.yaml before they are loaded - the migration is added to labels
+		if dynatraceConfigFile.MigrateLegacyQueries {
+			migrationLabels, err := migrateCustomQueries(ctx, eh.resourceStore, keptnEvent, stdLogger)
+			if err != nil {
+				stdLogger.Error(err.Error())
+				return sendInternalGetSLIDoneEvent(shkeptncontext, eventData.Project, eventData.Service, eventData.Stage,
+					nil, eventData.Start, eventData.End, eventData.TestStrategy, eventData.DeploymentStrategy,
+					eventData.Deployment, eventData.Labels, eventData.Indicators, err)
+			}
+			for label, value := range migrationLabels {
+				eventData.Labels[label] = value
+			}
+		}
+
+		// get custom metrics for project if they exist - falling back to the default queries is only fine if there is no sli.yaml
+		projectCustomQueries, err := getCustomQueries(ctx, eh.resourceStore, keptnEvent, keptnHandler, stdLogger)
+		if err != nil {
+			stdLogger.Error(err.Error())
+			return sendInternalGetSLIDoneEvent(shkeptncontext, eventData.Project, eventData.Service, eventData.Stage,
+				nil, eventData.Start, eventData.End, eventData.TestStrategy, eventData.DeploymentStrategy,
+				eventData.Deployment, eventData.Labels, eventData.Indicators, err)
+		}
 
 		// set our list of queries on the handler
 		if projectCustomQueries != nil {
@@ -320,36 +557,70 @@ func retrieveMetrics(event cloudevents.Event) error {
 		}
 
 		// query all indicators
-		for _, indicator := range eventData.Indicators {
-			stdLogger.Info("Fetching indicator: " + indicator)
-			sliValue, err := dynatraceHandler.GetSLIValue(indicator, startUnix, endUnix)
-			if err != nil {
-				stdLogger.Error(err.Error())
-				// failed to fetch metric
-				sliResults = append(sliResults, &keptnevents.SLIResult{
-					Metric:  indicator,
-					Value:   0,
-					Success: false, // Mark as failure
-					Message: err.Error(),
-				})
-			} else {
-				// successfully fetched metric
-				sliResults = append(sliResults, &keptnevents.SLIResult{
-					Metric:  indicator,
-					Value:   sliValue,
-					Success: true, // mark as success
-				})
-			}
+		sliResults = dynatraceHandler.GetSLIResultsForIndicators(evaluationCtx, eventData.Indicators, startUnix, endUnix)
+	}
+
+	if evaluationCtx.Err() == context.DeadlineExceeded {
+		stdLogger.Errorf("Evaluation deadline of %v exceeded - sending partial results", dynatraceHandler.Defaults.EvaluationTimeout)
+	} else if runCtx.Err() != nil {
+		stdLogger.Errorf("Evaluation canceled at the shutdown deadline - sending partial results")
+	}
+
+	// add deep links into Dynatrace for every indicator to labels, e.g: "throughput Link"
+	for indicator, link := range dynatraceHandler.GetSLILinks() {
+		if eventData.Labels == nil {
+			eventData.Labels = make(map[string]string)
 		}
+		eventData.Labels[indicator+dynatrace.SLILinkLabelSuffix] = link
+	}
 
-		if common.RunLocal || common.RunLocalTest {
-			log.Println("(RunLocal Output) Here are the results:")
-			for _, v := range sliResults {
-				log.Println(fmt.Sprintf("%s:%.2f - Success: %t - Error: %s", v.Metric, v.Value, v.Success, v.Message))
-			}
-			return nil
+	//
+	// upload the raw time series in case they got collected and add the resource URI to labels
+	timeseriesResourceURI, err := uploadTimeseries(ctx, dynatraceHandler, keptnEvent)
+	if err != nil {
+		// log the error, but still send the SLI results
+		stdLogger.Error(err.Error())
+	} else if timeseriesResourceURI != "" {
+		if eventData.Labels == nil {
+			eventData.Labels = make(map[string]string)
 		}
+		eventData.Labels[dynatrace.TimeseriesLabel] = timeseriesResourceURI
+	}
 
+	//
+	// upload the evaluation report with the queries, timings and errors of all indicators and add the resource URI to labels
+	evaluationReport := dynatraceHandler.GetEvaluationReport(reportSource, startUnix, endUnix, sliResults)
+	reportResourceURI, err := uploadEvaluationReport(ctx, evaluationReport, dynatraceHandler, keptnEvent)
+	if err != nil {
+		// log the error, but still send the SLI results
+		stdLogger.Error(err.Error())
+	} else {
+		if eventData.Labels == nil {
+			eventData.Labels = make(map[string]string)
+		}
+		eventData.Labels[dynatrace.EvaluationReportLabel] = reportResourceURI
+	}
+
+	//
+	// upload the recorded Dynatrace API calls in case they got recorded and add the resource URI to labels
+	cassetteResourceURI, err := uploadCassette(ctx, dynatraceHandler, keptnEvent)
+	if err != nil {
+		// log the error, but still send the SLI results
+		stdLogger.Error(err.Error())
+	} else if cassetteResourceURI != "" {
+		if eventData.Labels == nil {
+			eventData.Labels = make(map[string]string)
+		}
+		eventData.Labels[dynatrace.CassetteLabel] = cassetteResourceURI
+	}
+
+	// locally there is no Keptn to send the results to - the resources above are stored in the local store nevertheless
+	if eh.env.runLocal() {
+		stdLogger.Info("(RunLocal Output) Here are the results:")
+		for _, v := range sliResults {
+			stdLogger.Infof("%s:%.2f - Success: %t - Error: %s", v.Metric, v.Value, v.Success, v.Message)
+		}
+		return nil
 	}
 
 	// now - lets see if we have captured any result values - if not - return send an error
@@ -365,10 +636,77 @@ func retrieveMetrics(event cloudevents.Event) error {
 		eventData.Deployment, eventData.Labels, eventData.Indicators, err)
 }
 
+/**
+ * Uploads the time series collected by the Dynatrace Handler to dynatrace/results/<shkeptncontext>/timeseries.json (or .csv)
+ * Returns the resource URI or "" if no time series were collected
+ */
+func uploadTimeseries(ctx context.Context, dynatraceHandler *dynatrace.Handler, keptnEvent *common.BaseKeptnEvent) (string, error) {
+	timeseries := dynatraceHandler.GetTimeseries()
+	if len(timeseries) == 0 {
+		return "", nil
+	}
+
+	format := common.ResolveTimeseriesExport(dynatraceHandler.Timeseries).Format
+	content, err := dynatrace.MarshalTimeseries(timeseries, format)
+	if err != nil {
+		return "", fmt.Errorf("could not serialize time series: %v", err)
+	}
+
+	resourceURI := common.GetResultResourceURI(keptnEvent.Context, dynatrace.TimeseriesFilename+"."+format)
+	err = common.UploadKeptnResource(ctx, dynatraceHandler.ResourceStore, content, resourceURI, keptnEvent, dynatraceHandler.Logger)
+	if err != nil {
+		return "", fmt.Errorf("could not store %s : %v", resourceURI, err)
+	}
+
+	return resourceURI, nil
+}
+
+/**
+ * Uploads the Dynatrace API calls recorded by the Dynatrace Handler to dynatrace/results/<shkeptncontext>/cassette.json
+ * Returns the resource URI or "" if the calls were not recorded
+ */
+func uploadCassette(ctx context.Context, dynatraceHandler *dynatrace.Handler, keptnEvent *common.BaseKeptnEvent) (string, error) {
+	if dynatraceHandler.Cassette == nil {
+		return "", nil
+	}
+
+	content, err := dynatraceHandler.Cassette.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("could not serialize cassette: %v", err)
+	}
+
+	resourceURI := common.GetResultResourceURI(keptnEvent.Context, dynatrace.CassetteFilename)
+	err = common.UploadKeptnResource(ctx, dynatraceHandler.ResourceStore, content, resourceURI, keptnEvent, dynatraceHandler.Logger)
+	if err != nil {
+		return "", fmt.Errorf("could not store %s : %v", resourceURI, err)
+	}
+
+	return resourceURI, nil
+}
+
+/**
+ * Uploads the evaluation report to dynatrace/results/<shkeptncontext>/report.json
+ * Returns the resource URI
+ */
+func uploadEvaluationReport(ctx context.Context, evaluationReport *dynatrace.EvaluationReport, dynatraceHandler *dynatrace.Handler, keptnEvent *common.BaseKeptnEvent) (string, error) {
+	content, err := json.MarshalIndent(evaluationReport, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("could not serialize evaluation report: %v", err)
+	}
+
+	resourceURI := common.GetResultResourceURI(keptnEvent.Context, dynatrace.EvaluationReportFilename)
+	err = common.UploadKeptnResource(ctx, dynatraceHandler.ResourceStore, content, resourceURI, keptnEvent, dynatraceHandler.Logger)
+	if err != nil {
+		return "", fmt.Errorf("could not store %s : %v", resourceURI, err)
+	}
+
+	return resourceURI, nil
+}
+
 /**
  * Loads SLIs from a local file and adds it to the SLI map
  */
-func addResourceContentToSLIMap(SLIs map[string]string, sliFilePath string, sliFileContent string, logger *keptn.Logger) (map[string]string, error) {
+func addResourceContentToSLIMap(SLIs map[string]string, sliFilePath string, sliFileContent string, logger *common.Logger) (map[string]string, error) {
 
 	if sliFilePath != "" {
 		localFileContent, err := ioutil.ReadFile(sliFilePath)
@@ -397,24 +735,69 @@ func addResourceContentToSLIMap(SLIs map[string]string, sliFilePath string, sliF
 	return SLIs, nil
 }
 
+/**
+ * migrateCustomQueries migrates legacy queries in dynatrace/sli.yaml to the metricSelector=...&entitySelector=... format
+ * The original file is kept as dynatrace/results/<shkeptncontext>/sli.backup.yaml before the migrated file is uploaded on service level
+ * Returns the labels that describe the migration - nil if there was nothing to migrate
+ */
+func migrateCustomQueries(ctx context.Context, store common.ResourceStore, keptnEvent *common.BaseKeptnEvent, logger *common.Logger) (map[string]string, error) {
+	sliContent, err := common.GetKeptnResource(ctx, store, keptnEvent, sliResourceURI, logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s: %v", sliResourceURI, err)
+	}
+	if sliContent == "" {
+		return nil, nil
+	}
+
+	migratedContent, migrations, err := dynatrace.MigrateSLIFile([]byte(sliContent))
+	if err != nil {
+		return nil, fmt.Errorf("could not migrate %s: %v", sliResourceURI, err)
+	}
+	if len(migrations) == 0 {
+		return nil, nil
+	}
+
+	backupResourceURI := common.GetResultResourceURI(keptnEvent.Context, dynatrace.SLIBackupFilename)
+	err = common.UploadKeptnResource(ctx, store, []byte(sliContent), backupResourceURI, keptnEvent, logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not store backup %s - %s is not migrated: %v", backupResourceURI, sliResourceURI, err)
+	}
+	err = common.UploadKeptnResource(ctx, store, migratedContent, sliResourceURI, keptnEvent, logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not upload migrated %s: %v", sliResourceURI, err)
+	}
+
+	labels := map[string]string{
+		dynatrace.SLIMigrationLabel: fmt.Sprintf("migrated %d legacy queries in %s, backup: %s", len(migrations), sliResourceURI, backupResourceURI),
+	}
+	for _, migration := range migrations {
+		logger.Infof("Migrated legacy query of %s: %s", migration.Indicator, migration.String())
+		labels[migration.Indicator+dynatrace.SLIMigrationLabelSuffix] = migration.String()
+	}
+	return labels, nil
+}
+
 /**
  * getCustomQueries loads custom SLIs from dynatrace/sli.yaml
- * if there is no sli.yaml it will just return an empty map
+ * if there is no sli.yaml it will just return an empty map - an sli.yaml that can't be loaded or parsed returns an error
  */
-func getCustomQueries(keptnEvent *common.BaseKeptnEvent, keptnHandler *keptnv2.Keptn, logger *keptn.Logger) (map[string]string, error) {
+func getCustomQueries(ctx context.Context, store common.ResourceStore, keptnEvent *common.BaseKeptnEvent, keptnHandler *keptnv2.Keptn, logger *common.Logger) (map[string]string, error) {
 	var sliMap = map[string]string{}
-	if common.RunLocal || common.RunLocalTest {
-		sliMap, _ = addResourceContentToSLIMap(sliMap, "dynatrace/sli.yaml", "", logger)
+
+	// load dynatrace/sli.yaml - if its there we add it to the sliMap
+	sliContent, err := common.GetKeptnResource(ctx, store, keptnEvent, sliResourceURI, logger)
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s: %v", sliResourceURI, err)
+	}
+	if sliContent == "" {
+		logger.Infof("No custom SLI queries for project=%s,stage=%s,service=%s found as no dynatrace/sli.yaml in repo. Going with default!", keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service)
 		return sliMap, nil
 	}
 
-	// load dynatrace/sli.yaml - if its there we add it to the sliMap
-	sliContent, err := common.GetKeptnResource(keptnEvent, sliResourceURI, logger)
+	logger.Infof("Found custom SLI queries in dynatrace/sli.yaml for project=%s,stage=%s,service=%s", keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service)
+	sliMap, err = addResourceContentToSLIMap(sliMap, "", sliContent, logger)
 	if err != nil {
-		logger.Info(fmt.Sprintf("No custom SLI queries for project=%s,stage=%s,service=%s found as no dynatrace/sli.yaml in repo. Going with default!", keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service))
-	} else {
-		logger.Info(fmt.Sprintf("Found custom SLI queries in dynatrace/sli.yaml for project=%s,stage=%s,service=%s", keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service))
-		sliMap, _ = addResourceContentToSLIMap(sliMap, "", sliContent, logger)
+		return nil, fmt.Errorf("could not parse %s: %v", sliResourceURI, err)
 	}
 
 	return sliMap, nil
@@ -424,7 +807,7 @@ func getCustomQueries(keptnEvent *common.BaseKeptnEvent, keptnHandler *keptnv2.K
  * returns the DTCredentials
  * First looks at the passed secretName. If null, validates if there is a dynatrace-credentials-%PROJECT% - if not - defaults to "dynatrace" global secret
  */
-func getDynatraceCredentials(secretName string, project string, logger *keptn.Logger) (*common.DTCredentials, error) {
+func (eh *eventHandler) getDynatraceCredentials(secretName string, project string, logger *common.Logger) (*common.DTCredentials, error) {
 
 	secretNames := []string{secretName, fmt.Sprintf("dynatrace-credentials-%s", project), "dynatrace-credentials", "dynatrace"}
 
@@ -433,11 +816,11 @@ func getDynatraceCredentials(secretName string, project string, logger *keptn.Lo
 			continue
 		}
 
-		dtCredentials, err := common.GetDTCredentials(secret)
+		dtCredentials, err := eh.env.getDTCredentials(secret)
 
 		if err == nil && dtCredentials != nil {
 			// lets validate if the tenant URL is
-			logger.Info(fmt.Sprintf("Secret '%s' with credentials found, returning (%s) ...", secret, dtCredentials.Tenant))
+			logger.Infof("Secret '%s' with credentials found, returning (%s) ...", secret, dtCredentials.Tenant)
 			return dtCredentials, nil
 		}
 	}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/dynatrace"
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/fakedynatrace"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	keptnevents "github.com/keptn/go-utils/pkg/lib"
+)
+
+const testingShkeptncontext = "a1b2c3d4-0000-4000-8000-000000000001"
+
+// testingMetricsQueryFixture returns different response times for the blue and the green deployment
+const testingMetricsQueryFixture = `{"result": [
+	{"metricId": "builtin:service.response.time", "entitySelector": "type(SERVICE),tag(blue)", "data": [{"dimensions": [], "timestamps": [1], "values": [100000]}]},
+	{"metricId": "builtin:service.response.time", "entitySelector": "type(SERVICE),tag(green)", "data": [{"dimensions": [], "timestamps": [1], "values": [200000]}]}
+]}`
+
+// testingFakeDynatrace starts a fake Dynatrace API and points the local credentials DT_TENANT and DT_API_TOKEN to it
+func testingFakeDynatrace(t *testing.T) (*fakedynatrace.Server, func()) {
+	fixtureDir, err := ioutil.TempDir("", "fakedynatrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(fixtureDir, "metrics_query.json"), []byte(testingMetricsQueryFixture), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := fakedynatrace.New(fixtureDir)
+	server := httptest.NewServer(fake)
+
+	restoreEnv := testingSetEnv(map[string]string{"DT_TENANT": server.URL, "DT_API_TOKEN": "test-token"})
+
+	return fake, func() {
+		server.Close()
+		os.RemoveAll(fixtureDir)
+		restoreEnv()
+	}
+}
+
+// testingGetSLIEvent returns a get-sli event for the response_time_p95 indicator
+func testingGetSLIEvent(t *testing.T, deploymentStrategy string) cloudevents.Event {
+	event := cloudevents.NewEvent()
+	event.SetID("e1f2a3b4-0000-4000-8000-000000000002")
+	event.SetType(keptnevents.InternalGetSLIEventType)
+	event.SetSource("lighthouse-service")
+	event.SetExtension("shkeptncontext", testingShkeptncontext)
+	err := event.SetData(cloudevents.ApplicationJSON, keptnevents.InternalGetSLIEventData{
+		SLIProvider:        "dynatrace",
+		Project:            "sockshop",
+		Stage:              "staging",
+		Service:            "carts",
+		Start:              "2019-10-21T09:11:24Z",
+		End:                "2019-10-21T09:16:24Z",
+		Indicators:         []string{"response_time_p95"},
+		TestStrategy:       "performance",
+		DeploymentStrategy: deploymentStrategy,
+		Deployment:         "canary",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestRetrieveMetricsWithDeploymentStrategy(t *testing.T) {
+	fake, cleanup := testingFakeDynatrace(t)
+	defer cleanup()
+
+	// the green deployment only receives traffic with the blue_green_service strategy
+	sliFile := `spec_version: '1.0'
+indicators:
+  response_time_p95: "metricSelector=builtin:service.response.time:merge(0):percentile(95)&entitySelector=type(SERVICE),tag({{ if eq .DeploymentStrategy \"blue_green_service\" }}green{{ else }}blue{{ end }})"
+`
+	tests := []struct {
+		deploymentStrategy string
+		wantTag            string
+	}{
+		{"blue_green_service", "tag(green)"},
+		{"direct", "tag(blue)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.deploymentStrategy, func(t *testing.T) {
+			eh := &eventHandler{
+				env:           envConfig{Env: "local"},
+				resourceStore: common.NewMemoryStore(map[string]string{common.DynatraceSLIFilename: sliFile}),
+			}
+			requestCount := len(fake.Requests())
+
+			if err := eh.retrieveMetrics(context.Background(), testingGetSLIEvent(t, tt.deploymentStrategy)); err != nil {
+				t.Fatalf("retrieveMetrics() returned error %v", err)
+			}
+
+			var entitySelectors []string
+			for _, request := range fake.Requests()[requestCount:] {
+				if strings.HasPrefix(request.Path, fakedynatrace.MetricsQueryPath) {
+					query, _ := url.ParseQuery(request.Query)
+					entitySelectors = append(entitySelectors, query.Get("entitySelector"))
+				}
+			}
+			if len(entitySelectors) != 1 || entitySelectors[0] != "type(SERVICE),"+tt.wantTag {
+				t.Errorf("queried entitySelectors %v, expected a single metrics query with %s", entitySelectors, tt.wantTag)
+			}
+		})
+	}
+}
+
+// Tests that the evaluation report of an sli.yaml evaluation is stored locally as well
+func TestRetrieveMetricsLocallyStoresEvaluationReport(t *testing.T) {
+	_, cleanup := testingFakeDynatrace(t)
+	defer cleanup()
+
+	sliFile := `spec_version: '1.0'
+indicators:
+  response_time_p95: "metricSelector=builtin:service.response.time:merge(0):percentile(95)&entitySelector=type(SERVICE),tag(blue)"
+`
+	store := common.NewMemoryStore(map[string]string{common.DynatraceSLIFilename: sliFile})
+	eh := &eventHandler{env: envConfig{Env: "local"}, resourceStore: store}
+
+	if err := eh.retrieveMetrics(context.Background(), testingGetSLIEvent(t, "direct")); err != nil {
+		t.Fatalf("retrieveMetrics() returned error %v", err)
+	}
+
+	reportResourceURI := common.GetResultResourceURI(testingShkeptncontext, dynatrace.EvaluationReportFilename)
+	content, ok := store.Resources()[reportResourceURI]
+	if !ok {
+		t.Fatalf("expected the evaluation report %s to be stored", reportResourceURI)
+	}
+	report := &dynatrace.EvaluationReport{}
+	if err := json.Unmarshal([]byte(content), report); err != nil {
+		t.Fatalf("invalid evaluation report: %v", err)
+	}
+	if report.Source != dynatrace.ReportSourceSLIFile || len(report.Indicators) != 1 || report.Indicators[0].Value != 100 {
+		t.Errorf("unexpected evaluation report %s", content)
+	}
+}
+
+// Tests that the values of $ENV placeholders are not stored in the evaluation report
+func TestRetrieveMetricsRedactsEvaluationReport(t *testing.T) {
+	_, cleanup := testingFakeDynatrace(t)
+	defer cleanup()
+	defer testingSetEnv(map[string]string{"SLI_TEST_TAG": "my-secret-tag"})()
+
+	sliFile := `spec_version: '1.0'
+indicators:
+  response_time_p95: "metricSelector=builtin:service.response.time:merge(0):percentile(95)&entitySelector=type(SERVICE),tag($ENV.SLI_TEST_TAG)"
+`
+	store := common.NewMemoryStore(map[string]string{common.DynatraceSLIFilename: sliFile})
+	eh := &eventHandler{env: envConfig{Env: "local"}, resourceStore: store}
+
+	if err := eh.retrieveMetrics(context.Background(), testingGetSLIEvent(t, "direct")); err != nil {
+		t.Fatalf("retrieveMetrics() returned error %v", err)
+	}
+
+	content := store.Resources()[common.GetResultResourceURI(testingShkeptncontext, dynatrace.EvaluationReportFilename)]
+	if content == "" {
+		t.Fatalf("expected the evaluation report to be stored")
+	}
+	if strings.Contains(content, "my-secret-tag") {
+		t.Errorf("the evaluation report contains the value of $ENV.SLI_TEST_TAG: %s", content)
+	}
+	if !strings.Contains(content, "tag%28***%29") {
+		t.Errorf("expected the redacted query in the evaluation report: %s", content)
+	}
+}
+
+// Tests that evaluations still running at the shutdown deadline are canceled and report their indicators as timed out
+func TestRetrieveMetricsCanceledAtShutdownDeadline(t *testing.T) {
+	fake, cleanup := testingFakeDynatrace(t)
+	defer cleanup()
+
+	sliFile := `spec_version: '1.0'
+indicators:
+  response_time_p95: "metricSelector=builtin:service.response.time:merge(0):percentile(95)&entitySelector=type(SERVICE),tag(blue)"
+`
+	shutdownDeadline := make(chan struct{})
+	close(shutdownDeadline)
+	store := common.NewMemoryStore(map[string]string{common.DynatraceSLIFilename: sliFile})
+	eh := &eventHandler{env: envConfig{Env: "local"}, resourceStore: store, shutdownDeadline: shutdownDeadline}
+	requestCount := len(fake.Requests())
+
+	if err := eh.retrieveMetrics(context.Background(), testingGetSLIEvent(t, "direct")); err != nil {
+		t.Fatalf("retrieveMetrics() returned error %v", err)
+	}
+
+	for _, request := range fake.Requests()[requestCount:] {
+		if strings.HasPrefix(request.Path, fakedynatrace.MetricsQueryPath) {
+			t.Errorf("expected no metrics query after the shutdown deadline, got %s", request.Query)
+		}
+	}
+	content := store.Resources()[common.GetResultResourceURI(testingShkeptncontext, dynatrace.EvaluationReportFilename)]
+	report := &dynatrace.EvaluationReport{}
+	if err := json.Unmarshal([]byte(content), report); err != nil {
+		t.Fatalf("invalid evaluation report: %v", err)
+	}
+	if len(report.Indicators) != 1 || !strings.HasPrefix(report.Indicators[0].Error, dynatrace.TimedOutMessagePrefix) {
+		t.Errorf("expected response_time_p95 to be reported as timed out: %s", content)
+	}
+}
+
+// testingFailingStore is a resource store that can't be reached, e.g: the configuration-service is down
+type testingFailingStore struct{}
+
+func (s testingFailingStore) GetResource(ctx context.Context, keptnEvent *common.BaseKeptnEvent, resourceURI string, logger *common.Logger) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func (s testingFailingStore) UploadResource(ctx context.Context, keptnEvent *common.BaseKeptnEvent, resourceURI string, content []byte, logger *common.Logger) error {
+	return errors.New("connection refused")
+}
+
+// Tests that only a missing sli.yaml falls back to the default queries
+func TestGetCustomQueries(t *testing.T) {
+	keptnEvent := &common.BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}
+	logger := common.NewLogger("", "", common.ServiceName)
+
+	tests := []struct {
+		name    string
+		store   common.ResourceStore
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:  "no sli.yaml",
+			store: common.NewMemoryStore(nil),
+			want:  map[string]string{},
+		},
+		{
+			name:  "sli.yaml",
+			store: common.NewMemoryStore(map[string]string{common.DynatraceSLIFilename: "spec_version: '1.0'\nindicators:\n  throughput: metricSelector=builtin:service.requestCount.total\n"}),
+			want:  map[string]string{"throughput": "metricSelector=builtin:service.requestCount.total"},
+		},
+		{
+			name:    "store unreachable",
+			store:   testingFailingStore{},
+			wantErr: "could not load dynatrace/sli.yaml: connection refused",
+		},
+		{
+			name:    "invalid sli.yaml",
+			store:   common.NewMemoryStore(map[string]string{common.DynatraceSLIFilename: "indicators: [throughput\n"}),
+			wantErr: "could not parse dynatrace/sli.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getCustomQueries(context.Background(), tt.store, keptnEvent, nil, logger)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("getCustomQueries() error = %v, expected to contain %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getCustomQueries() returned error %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getCustomQueries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateCustomQueriesFailsIfStoreUnreachable(t *testing.T) {
+	keptnEvent := &common.BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}
+
+	_, err := migrateCustomQueries(context.Background(), testingFailingStore{}, keptnEvent, common.NewLogger("", "", common.ServiceName))
+	if err == nil || !strings.Contains(err.Error(), "could not load dynatrace/sli.yaml: connection refused") {
+		t.Errorf("migrateCustomQueries() error = %v, expected the store error", err)
+	}
+}
@@ -8,7 +8,11 @@ require (
 	github.com/googleapis/gnostic v0.3.1 // indirect
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/keptn/go-utils v0.6.3-0.20201104145324-b4fabd54ef88
-	github.com/stretchr/testify v1.5.1
+	github.com/prometheus/client_golang v1.7.1
+	github.com/stretchr/testify v1.6.1
+	go.opentelemetry.io/otel v0.14.0
+	go.opentelemetry.io/otel/exporters/otlp v0.14.0
+	go.opentelemetry.io/otel/sdk v0.14.0
 	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
 	golang.org/x/time v0.0.0-20191023065245-6d3f0bb11be5 // indirect
 	gopkg.in/yaml.v2 v2.3.0
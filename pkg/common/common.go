@@ -1,9 +1,9 @@
 package common
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/url"
 	"os"
 	"strconv"
@@ -13,17 +13,9 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
-	"github.com/ghodss/yaml"
-
-	keptnmodels "github.com/keptn/go-utils/pkg/api/models"
-	keptnapi "github.com/keptn/go-utils/pkg/api/utils"
-	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-var RunLocal = (os.Getenv("ENV") == "local")
-var RunLocalTest = (os.Getenv("ENV") == "localtest")
-
 /**
  * Constants for supporting resource files in keptn repo
  */
@@ -35,19 +27,80 @@ const KeptnSLOFilename = "slo.yaml"
  * Defines the Dynatrace Configuration File structure and supporting Constants
  */
 const DynatraceConfigFilename = "dynatrace/dynatrace.conf.yaml"
+
+// DynatraceResultsFolder holds the artifacts of every evaluation, e.g: dynatrace/results/<shkeptncontext>/timeseries.json
+const DynatraceResultsFolder = "dynatrace/results"
 const DynatraceConfigFilenameLOCAL = "dynatrace/_dynatrace.conf.yaml"
 const DynatraceConfigDashboardQUERY = "query"
 
 type DynatraceConfigFile struct {
-	SpecVersion string `json:"spec_version" yaml:"spec_version"`
-	DtCreds     string `json:"dtCreds",omitempty yaml:"dtCreds",omitempty`
-	Dashboard   string `json:"dashboard",omitempty yaml:"dashboard",omitempty`
+	SpecVersion string            `json:"spec_version" yaml:"spec_version"`
+	DtCreds     string            `json:"dtCreds,omitempty" yaml:"dtCreds,omitempty"`
+	Dashboard   string            `json:"dashboard,omitempty" yaml:"dashboard,omitempty"`
+	Defaults    *SLIDefaults      `json:"defaults,omitempty" yaml:"defaults,omitempty"`
+	Timeseries  *TimeseriesExport `json:"timeseries,omitempty" yaml:"timeseries,omitempty"`
+	// MigrateLegacyQueries rewrites legacy queries in dynatrace/sli.yaml to the new Metrics API format and uploads the migrated file
+	MigrateLegacyQueries bool `json:"migrateLegacyQueries,omitempty" yaml:"migrateLegacyQueries,omitempty"`
+	// RecordAPICalls records all Dynatrace API calls of an evaluation and uploads them as cassette, e.g: to replay a failing evaluation
+	RecordAPICalls bool `json:"recordAPICalls,omitempty" yaml:"recordAPICalls,omitempty"`
+}
+
+// SLIDefaults configures the built-in SLIs as well as how SLIs are retrieved for a project, stage or service
+type SLIDefaults struct {
+	// EntityType defines on which entity type the tags are looked up: SERVICE or PROCESS_GROUP
+	EntityType string `json:"entityType,omitempty" yaml:"entityType,omitempty"`
+	// Tags defines the tag names the built-in SLIs are filtering on
+	Tags *EntityTags `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// TagScheme replaces the tag filters generated from Tags, e.g: tag(app:$SERVICE),tag(environment:$STAGE)
+	TagScheme string `json:"tagScheme,omitempty" yaml:"tagScheme,omitempty"`
+	// ManagementZone is either the name or the ID of a management zone the built-in SLIs are filtered on
+	ManagementZone string `json:"managementZone,omitempty" yaml:"managementZone,omitempty"`
+	// RequestTimeout is the timeout of a single call to the Dynatrace API
+	RequestTimeout time.Duration `json:"requestTimeout,omitempty" yaml:"requestTimeout,omitempty"`
+	// EvaluationTimeout is the deadline for retrieving all SLIs of an evaluation - indicators not retrieved by then are reported as timed out
+	EvaluationTimeout time.Duration `json:"evaluationTimeout,omitempty" yaml:"evaluationTimeout,omitempty"`
+	// WaitForData defines how long to wait for Dynatrace to process data depending on the evaluation timeframe
+	WaitForData []WaitForDataThreshold `json:"waitForData,omitempty" yaml:"waitForData,omitempty"`
+	// Parallelism is the number of indicators that are queried in parallel
+	Parallelism int `json:"parallelism,omitempty" yaml:"parallelism,omitempty"`
+}
+
+// EntityTags holds the tag names for project, stage, service and deployment. An empty name means the tag is not used
+type EntityTags struct {
+	Project    string `json:"project" yaml:"project"`
+	Stage      string `json:"stage" yaml:"stage"`
+	Service    string `json:"service" yaml:"service"`
+	Deployment string `json:"deployment" yaml:"deployment"`
+}
+
+// WaitForDataThreshold makes sure the end of an evaluation timeframe shorter than BelowTimeframe is at least Delay in the past
+type WaitForDataThreshold struct {
+	BelowTimeframe time.Duration `json:"belowTimeframe" yaml:"belowTimeframe"`
+	Delay          time.Duration `json:"delay" yaml:"delay"`
+}
+
+// TimeseriesExport configures whether the raw time series of Metrics indicators are stored in the configuration repo
+type TimeseriesExport struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Resolution is passed to the Metrics API, e.g: 1m, 10m, 1h. Defaults to 1m
+	Resolution string `json:"resolution,omitempty" yaml:"resolution,omitempty"`
+	// Format of the uploaded file: json or csv. Defaults to json
+	Format string `json:"format,omitempty" yaml:"format,omitempty"`
 }
 
 type DTCredentials struct {
 	Tenant    string `json:"DT_TENANT" yaml:"DT_TENANT"`
 	ApiToken  string `json:"DT_API_TOKEN" yaml:"DT_API_TOKEN"`
 	PaaSToken string `json:"DT_PAAS_TOKEN" yaml:"DT_PAAS_TOKEN"`
+	// Proxy is the proxy used for the tenant, e.g: http://proxy.example.com:3128 - if empty HTTPS_PROXY and NO_PROXY apply
+	Proxy string `json:"DT_PROXY,omitempty" yaml:"DT_PROXY,omitempty"`
+	// NoProxy lists the hosts that are not reached via Proxy, same format as NO_PROXY
+	NoProxy string `json:"DT_NO_PROXY,omitempty" yaml:"DT_NO_PROXY,omitempty"`
+	// CACert is a PEM bundle of CAs trusted in addition to the system CAs, e.g: the internal CA of a Dynatrace Managed cluster
+	CACert string `json:"DT_CA_CERT,omitempty" yaml:"DT_CA_CERT,omitempty"`
+	// ClientCert and ClientKey are the PEM encoded client certificate and key presented to the tenant (mTLS)
+	ClientCert string `json:"DT_CLIENT_CERT,omitempty" yaml:"DT_CLIENT_CERT,omitempty"`
+	ClientKey  string `json:"DT_CLIENT_KEY,omitempty" yaml:"DT_CLIENT_KEY,omitempty"`
 }
 
 type BaseKeptnEvent struct {
@@ -80,10 +133,6 @@ func getPodNamespace() string {
 }
 
 func GetKubernetesClient() (*kubernetes.Clientset, error) {
-	if RunLocal || RunLocalTest {
-		return nil, nil
-	}
-
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, err
@@ -91,6 +140,27 @@ func GetKubernetesClient() (*kubernetes.Clientset, error) {
 	return kubernetes.NewForConfig(config)
 }
 
+/**
+ * Returns the value of the passed key in a ConfigMap in the Keptn namespace
+ */
+func GetConfigMapValue(configMapName string, key string) (string, error) {
+	kubeAPI, err := GetKubernetesClient()
+	if kubeAPI == nil || err != nil {
+		return "", fmt.Errorf("could not initialize Kubernetes client: %v", err)
+	}
+
+	configMap, err := kubeAPI.CoreV1().ConfigMaps(namespace).Get(configMapName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("could not retrieve ConfigMap %s: %v", configMapName, err)
+	}
+
+	value, ok := configMap.Data[key]
+	if !ok {
+		return "", fmt.Errorf("ConfigMap %s does not contain %s", configMapName, key)
+	}
+	return value, nil
+}
+
 /**
  * Returns the Keptn Domain stored in the keptn-domainconfigmap
  */
@@ -118,7 +188,9 @@ func GetKeptnDomain() (string, error) {
 // $ENV.XXXX    -> will replace that with an env variable called XXXX
 // $SECRET.YYYY -> will replace that with the k8s secret called YYYY
 //
-func ReplaceKeptnPlaceholders(input string, keptnEvent *BaseKeptnEvent) string {
+// The values of all used environment variables are registered as secrets with the passed logger
+//
+func ReplaceKeptnPlaceholders(input string, keptnEvent *BaseKeptnEvent, logger *Logger) string {
 	result := input
 
 	// FIXING on 27.5.2020: URL Escaping of parameters as described in https://github.com/keptn-contrib/dynatrace-sli-service/issues/54
@@ -141,6 +213,9 @@ func ReplaceKeptnPlaceholders(input string, keptnEvent *BaseKeptnEvent) string {
 	// now we do all environment variables
 	for _, env := range os.Environ() {
 		pair := strings.SplitN(env, "=", 2)
+		if strings.Contains(result, "$ENV."+pair[0]) {
+			logger.registerEnvSecret(pair[1])
+		}
 		result = strings.Replace(result, "$ENV."+pair[0], url.QueryEscape(pair[1]), -1)
 	}
 
@@ -149,6 +224,11 @@ func ReplaceKeptnPlaceholders(input string, keptnEvent *BaseKeptnEvent) string {
 	return result
 }
 
+// GetResultResourceURI returns the resource URI of an evaluation artifact, e.g: dynatrace/results/<shkeptncontext>/timeseries.json
+func GetResultResourceURI(keptnContext string, filename string) string {
+	return fmt.Sprintf("%s/%s/%s", DynatraceResultsFolder, keptnContext, filename)
+}
+
 func GetConfigurationServiceURL() string {
 	if os.Getenv("CONFIGURATION_SERVICE") != "" {
 		return os.Getenv("CONFIGURATION_SERVICE")
@@ -158,159 +238,115 @@ func GetConfigurationServiceURL() string {
 
 //
 // Downloads a resource from the Keptn Configuration Repo
-// In RunLocal mode it gets it from the local disk
-// In normal mode it first tries to find it on service level, then stage and then project level
+// Depending on the store it gets it from the configuration-service, e.g: first on service level, then stage and then project level, or from the local disk
 //
-func GetKeptnResource(keptnEvent *BaseKeptnEvent, resourceURI string, logger *keptn.Logger) (string, error) {
-
-	// if we run in a runlocal mode we are just getting the file from the local disk
-	var fileContent string
-	if RunLocal {
-		localFileContent, err := ioutil.ReadFile(resourceURI)
-		if err != nil {
-			logMessage := fmt.Sprintf("No %s file found LOCALLY for service %s in stage %s in project %s", resourceURI, keptnEvent.Service, keptnEvent.Stage, keptnEvent.Project)
-			logger.Info(logMessage)
-			return "", nil
-		}
-		logger.Info("Loaded LOCAL file " + resourceURI)
-		fileContent = string(localFileContent)
-	} else {
-		resourceHandler := keptnapi.NewResourceHandler(GetConfigurationServiceURL())
-
-		// Lets search on SERVICE-LEVEL
-		keptnResourceContent, err := resourceHandler.GetServiceResource(keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service, resourceURI)
-		if err != nil || keptnResourceContent == nil || keptnResourceContent.ResourceContent == "" {
-			// Lets search on STAGE-LEVEL
-			keptnResourceContent, err = resourceHandler.GetStageResource(keptnEvent.Project, keptnEvent.Stage, resourceURI)
-			if err != nil || keptnResourceContent == nil || keptnResourceContent.ResourceContent == "" {
-				// Lets search on PROJECT-LEVEL
-				keptnResourceContent, err = resourceHandler.GetProjectResource(keptnEvent.Project, resourceURI)
-				if err != nil || keptnResourceContent == nil || keptnResourceContent.ResourceContent == "" {
-					// logger.Debug(fmt.Sprintf("No Keptn Resource found: %s/%s/%s/%s - %s", keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service, resourceURI, err))
-					return "", err
-				}
-
-				logger.Debug("Found " + resourceURI + " on project level")
-			} else {
-				logger.Debug("Found " + resourceURI + " on stage level")
-			}
-		} else {
-			logger.Debug("Found " + resourceURI + " on service level")
-		}
-		fileContent = keptnResourceContent.ResourceContent
-	}
+func GetKeptnResource(ctx context.Context, store ResourceStore, keptnEvent *BaseKeptnEvent, resourceURI string, logger *Logger) (fileContent string, err error) {
+	_, span := StartSpan(ctx, "GetKeptnResource", append(EventSpanAttributes(keptnEvent), SpanAttributeResourceURI.String(resourceURI))...)
+	defer func() { EndSpan(span, err) }()
 
-	return fileContent, nil
+	return store.GetResource(ctx, keptnEvent, resourceURI, logger)
 }
 
 // GetDynatraceConfig loads dynatrace.conf for the current service
-func GetDynatraceConfig(keptnEvent *BaseKeptnEvent, logger *keptn.Logger) (*DynatraceConfigFile, error) {
+// Returns nil if there is no dynatrace.conf.yaml, a DynatraceConfigError if the file is invalid and an error if it couldn't be loaded
+func GetDynatraceConfig(ctx context.Context, store ResourceStore, keptnEvent *BaseKeptnEvent, logger *Logger) (*DynatraceConfigFile, error) {
 
-	dynatraceConfFileContent, err := GetKeptnResource(keptnEvent, DynatraceConfigFilename, logger)
+	dynatraceConfFileContent, err := GetKeptnResource(ctx, store, keptnEvent, DynatraceConfigFilename, logger)
 
 	if err != nil {
-		return nil, err
+		// e.g: the configuration-service is down - running with the default credentials and settings could evaluate against the wrong tenant
+		return nil, fmt.Errorf("could not load %s: %v", DynatraceConfigFilename, err)
 	}
 
 	if dynatraceConfFileContent == "" {
-		// loaded an empty file
-		logger.Debug("Content of dynatrace.conf.yaml is empty!")
+		logger.Debugf("No %s found for service %s in stage %s in project %s", DynatraceConfigFilename, keptnEvent.Service, keptnEvent.Stage, keptnEvent.Project)
 		return nil, nil
 	}
 
 	// unmarshal the file
-	dynatraceConfFile, err := parseDynatraceConfigFile([]byte(dynatraceConfFileContent))
+	dynatraceConfFile, err := ParseDynatraceConfigFile([]byte(dynatraceConfFileContent))
 
 	if err != nil {
 		logMessage := fmt.Sprintf("Couldn't parse %s file found for service %s in stage %s in project %s. Error: %s; Content: %s", DynatraceConfigFilename, keptnEvent.Service, keptnEvent.Stage, keptnEvent.Project, err.Error(), dynatraceConfFileContent)
 		logger.Error(logMessage)
-		return nil, errors.New(logMessage)
+		return nil, err
 	}
 
 	return dynatraceConfFile, nil
 }
 
-// UploadKeptnResource uploads a file to the Keptn Configuration Service
-func UploadKeptnResource(contentToUpload []byte, remoteResourceURI string, keptnEvent *BaseKeptnEvent, logger *keptn.Logger) error {
+// UploadKeptnResource uploads a file to the Keptn Configuration Repo - depending on the store to the configuration-service or the local disk
+func UploadKeptnResource(ctx context.Context, store ResourceStore, contentToUpload []byte, remoteResourceURI string, keptnEvent *BaseKeptnEvent, logger *Logger) (err error) {
+	_, span := StartSpan(ctx, "UploadKeptnResource", append(EventSpanAttributes(keptnEvent), SpanAttributeResourceURI.String(remoteResourceURI))...)
+	defer func() { EndSpan(span, err) }()
 
-	// if we run in a runlocal mode we are just getting the file from the local disk
-	if RunLocal || RunLocalTest {
-		err := ioutil.WriteFile(remoteResourceURI, contentToUpload, 0644)
-		if err != nil {
-			return fmt.Errorf("Couldnt write local file %s: %v", remoteResourceURI, err)
-		}
-		logger.Info("Local file written " + remoteResourceURI)
-	} else {
-		resourceHandler := keptnapi.NewResourceHandler(GetConfigurationServiceURL())
-
-		// lets upload it
-		resources := []*keptnmodels.Resource{{ResourceContent: string(contentToUpload), ResourceURI: &remoteResourceURI}}
-		_, err := resourceHandler.CreateResources(keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service, resources)
-		if err != nil {
-			return fmt.Errorf("Couldnt upload remote resource %s: %s", remoteResourceURI, *err.Message)
-		}
-
-		logger.Info(fmt.Sprintf("Uploaded file %s", remoteResourceURI))
-	}
-
-	return nil
+	return store.UploadResource(ctx, keptnEvent, remoteResourceURI, contentToUpload, logger)
 }
 
 /**
- * parses the dynatrace.conf.yaml file that is passed as parameter
+ * Pulls the Dynatrace Credentials from the passed secret
  */
-func parseDynatraceConfigFile(input []byte) (*DynatraceConfigFile, error) {
-	dynatraceConfFile := &DynatraceConfigFile{}
-	err := yaml.Unmarshal([]byte(input), &dynatraceConfFile)
+func GetDTCredentials(dynatraceSecretName string) (*DTCredentials, error) {
+	if dynatraceSecretName == "" {
+		return nil, nil
+	}
 
+	kubeAPI, err := GetKubernetesClient()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error retrieving Dynatrace credentials: could not initialize Kubernetes client: %v", err)
 	}
+	secret, err := kubeAPI.CoreV1().Secrets(namespace).Get(dynatraceSecretName, metav1.GetOptions{})
 
-	return dynatraceConfFile, nil
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving Dynatrace credentials: could not retrieve secret %s: %v", dynatraceSecretName, err)
+	}
+
+	// grabnerandi: remove check on DT_PAAS_TOKEN as it is not relevant for quality-gate-only use case
+	if string(secret.Data["DT_TENANT"]) == "" || string(secret.Data["DT_API_TOKEN"]) == "" { //|| string(secret.Data["DT_PAAS_TOKEN"]) == "" {
+		return nil, errors.New("invalid or no Dynatrace credentials found. Need DT_TENANT & DT_API_TOKEN stored in secret!")
+	}
+
+	dtCreds := &DTCredentials{}
+	dtCreds.Tenant = string(secret.Data["DT_TENANT"])
+	dtCreds.ApiToken = string(secret.Data["DT_API_TOKEN"])
+
+	// optional connection settings, e.g: for a Dynatrace Managed cluster behind a proxy with an internal CA
+	dtCreds.Proxy = string(secret.Data["DT_PROXY"])
+	dtCreds.NoProxy = string(secret.Data["DT_NO_PROXY"])
+	dtCreds.CACert = string(secret.Data["DT_CA_CERT"])
+	dtCreds.ClientCert = string(secret.Data["DT_CLIENT_CERT"])
+	dtCreds.ClientKey = string(secret.Data["DT_CLIENT_KEY"])
+
+	return ensureTenantScheme(dtCreds), nil
 }
 
 /**
- * Pulls the Dynatrace Credentials from the passed secret
+ * GetLocalDTCredentials takes the Dynatrace Credentials from the env-variables DT_TENANT, DT_API_TOKEN, ... instead of a secret
+ * Used when running locally (ENV=local) - the secret name is only checked for being set
  */
-func GetDTCredentials(dynatraceSecretName string) (*DTCredentials, error) {
+func GetLocalDTCredentials(dynatraceSecretName string) (*DTCredentials, error) {
 	if dynatraceSecretName == "" {
 		return nil, nil
 	}
 
 	dtCreds := &DTCredentials{}
-	if RunLocal || RunLocalTest {
-		// if we RunLocal we take it from the env-variables
-		dtCreds.Tenant = os.Getenv("DT_TENANT")
-		dtCreds.ApiToken = os.Getenv("DT_API_TOKEN")
-	} else {
-		kubeAPI, err := GetKubernetesClient()
-		if err != nil {
-			return nil, fmt.Errorf("error retrieving Dynatrace credentials: could not initialize Kubernetes client: %v", err)
-		}
-		secret, err := kubeAPI.CoreV1().Secrets(namespace).Get(dynatraceSecretName, metav1.GetOptions{})
-
-		if err != nil {
-			return nil, fmt.Errorf("error retrieving Dynatrace credentials: could not retrieve secret %s: %v", dynatraceSecretName, err)
-		}
-
-		// grabnerandi: remove check on DT_PAAS_TOKEN as it is not relevant for quality-gate-only use case
-		if string(secret.Data["DT_TENANT"]) == "" || string(secret.Data["DT_API_TOKEN"]) == "" { //|| string(secret.Data["DT_PAAS_TOKEN"]) == "" {
-			return nil, errors.New("invalid or no Dynatrace credentials found. Need DT_TENANT & DT_API_TOKEN stored in secret!")
-		}
-
-		dtCreds.Tenant = string(secret.Data["DT_TENANT"])
-		dtCreds.ApiToken = string(secret.Data["DT_API_TOKEN"])
-	}
+	dtCreds.Tenant = os.Getenv("DT_TENANT")
+	dtCreds.ApiToken = os.Getenv("DT_API_TOKEN")
+	dtCreds.Proxy = os.Getenv("DT_PROXY")
+	dtCreds.NoProxy = os.Getenv("DT_NO_PROXY")
+	dtCreds.CACert = os.Getenv("DT_CA_CERT")
+	dtCreds.ClientCert = os.Getenv("DT_CLIENT_CERT")
+	dtCreds.ClientKey = os.Getenv("DT_CLIENT_KEY")
+
+	return ensureTenantScheme(dtCreds), nil
+}
 
-	// ensure URL always has http or https in front
-	if strings.HasPrefix(dtCreds.Tenant, "https://") || strings.HasPrefix(dtCreds.Tenant, "http://") {
-		dtCreds.Tenant = dtCreds.Tenant
-	} else {
+// ensureTenantScheme ensures the tenant URL always has http or https in front
+func ensureTenantScheme(dtCreds *DTCredentials) *DTCredentials {
+	if !strings.HasPrefix(dtCreds.Tenant, "https://") && !strings.HasPrefix(dtCreds.Tenant, "http://") {
 		dtCreds.Tenant = "https://" + dtCreds.Tenant
 	}
-
-	return dtCreds, nil
+	return dtCreds
 }
 
 // ParseUnixTimestamp parses a time stamp into Unix foramt
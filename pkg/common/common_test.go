@@ -0,0 +1,704 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestParseDynatraceConfigFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		wantDtCreds   string
+		wantDashboard string
+		wantErr       string
+	}{
+		{
+			name:          "valid config",
+			input:         "spec_version: '0.1.0'\ndtCreds: dynatrace-prod\ndashboard: query\n",
+			wantDtCreds:   "dynatrace-prod",
+			wantDashboard: "query",
+		},
+		{
+			name:          "missing spec_version is treated as legacy config",
+			input:         "dtCreds: dynatrace\ndashboard: 311f4aa7-5257-41d7-abd1-70420500e1c8\n",
+			wantDtCreds:   "dynatrace",
+			wantDashboard: "311f4aa7-5257-41d7-abd1-70420500e1c8",
+		},
+		{
+			name:        "0.1.0 config with an optional flag is migrated",
+			input:       "spec_version: '0.1.0'\ndtCreds: dynatrace-prod\nrecordAPICalls: true\n",
+			wantDtCreds: "dynatrace-prod",
+		},
+		{
+			name:    "typo in key",
+			input:   "spec_version: '0.1.0'\ndtCreds: dynatrace\ndashbaord: query\n",
+			wantErr: "field dashbaord not found",
+		},
+		{
+			name:    "unsupported spec_version",
+			input:   "spec_version: '9.9.9'\ndtCreds: dynatrace\n",
+			wantErr: "unsupported spec_version '9.9.9'",
+		},
+		{
+			name:    "invalid timeseries format",
+			input:   "spec_version: '0.2.0'\ntimeseries:\n  enabled: true\n  format: xml\n",
+			wantErr: "timeseries.format 'xml' must either be json or csv",
+		},
+		{
+			name:    "invalid dashboard value",
+			input:   "spec_version: '0.1.0'\ndashboard: my-dashboard\n",
+			wantErr: "dashboard 'my-dashboard' must either be empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDynatraceConfigFile([]byte(tt.input))
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("ParseDynatraceConfigFile() expected error containing %s", tt.wantErr)
+				}
+				if _, ok := err.(*DynatraceConfigError); !ok {
+					t.Errorf("ParseDynatraceConfigFile() returned %T, expected *DynatraceConfigError", err)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseDynatraceConfigFile() error = %s, expected to contain %s", err.Error(), tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDynatraceConfigFile() returned error %v", err)
+			}
+			if got.DtCreds != tt.wantDtCreds {
+				t.Errorf("DtCreds = %s, want %s", got.DtCreds, tt.wantDtCreds)
+			}
+			if got.Dashboard != tt.wantDashboard {
+				t.Errorf("Dashboard = %s, want %s", got.Dashboard, tt.wantDashboard)
+			}
+			if got.SpecVersion != DynatraceConfigSpecVersion {
+				t.Errorf("SpecVersion = %s, want %s", got.SpecVersion, DynatraceConfigSpecVersion)
+			}
+		})
+	}
+}
+
+func TestParseDynatraceConfigFileWithMigrateLegacyQueries(t *testing.T) {
+	got, err := ParseDynatraceConfigFile([]byte("spec_version: '0.2.0'\nmigrateLegacyQueries: true\n"))
+	if err != nil {
+		t.Fatalf("ParseDynatraceConfigFile() returned error %v", err)
+	}
+	if !got.MigrateLegacyQueries {
+		t.Errorf("MigrateLegacyQueries = false, want true")
+	}
+	if got.SpecVersion != DynatraceConfigSpecVersion {
+		t.Errorf("SpecVersion = %s, want %s", got.SpecVersion, DynatraceConfigSpecVersion)
+	}
+}
+
+func TestParseDynatraceConfigFileWithRecordAPICalls(t *testing.T) {
+	got, err := ParseDynatraceConfigFile([]byte("spec_version: '0.2.0'\nrecordAPICalls: true\n"))
+	if err != nil {
+		t.Fatalf("ParseDynatraceConfigFile() returned error %v", err)
+	}
+	if !got.RecordAPICalls {
+		t.Errorf("RecordAPICalls = false, want true")
+	}
+	if got.SpecVersion != DynatraceConfigSpecVersion {
+		t.Errorf("SpecVersion = %s, want %s", got.SpecVersion, DynatraceConfigSpecVersion)
+	}
+}
+
+func TestParseDynatraceConfigFileWithDefaults(t *testing.T) {
+	input := `spec_version: '0.2.0'
+dtCreds: dynatrace
+defaults:
+  entityType: PROCESS_GROUP
+  tags:
+    project: app
+    stage: env
+    service: svc
+    deployment: ""
+  requestTimeout: 30s
+  parallelism: 4
+  waitForData:
+    - belowTimeframe: 10m
+      delay: 2m
+`
+	got, err := ParseDynatraceConfigFile([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseDynatraceConfigFile() returned error %v", err)
+	}
+
+	defaults := ResolveSLIDefaults(got.Defaults)
+	if defaults.EntityType != SLIEntityTypeProcessGroup {
+		t.Errorf("EntityType = %s, want %s", defaults.EntityType, SLIEntityTypeProcessGroup)
+	}
+	if defaults.Tags.Project != "app" || defaults.Tags.Deployment != "" {
+		t.Errorf("Tags = %+v, want project=app and no deployment tag", defaults.Tags)
+	}
+	if defaults.RequestTimeout != 30*time.Second {
+		t.Errorf("RequestTimeout = %v, want 30s", defaults.RequestTimeout)
+	}
+	if defaults.EvaluationTimeout != DefaultEvaluationTimeout {
+		t.Errorf("EvaluationTimeout = %v, want %v", defaults.EvaluationTimeout, DefaultEvaluationTimeout)
+	}
+	if defaults.Parallelism != 4 {
+		t.Errorf("Parallelism = %d, want 4", defaults.Parallelism)
+	}
+	if delay := defaults.GetWaitForDataDelay(5 * time.Minute); delay != 2*time.Minute {
+		t.Errorf("GetWaitForDataDelay(5m) = %v, want 2m", delay)
+	}
+	if delay := defaults.GetWaitForDataDelay(15 * time.Minute); delay != 0 {
+		t.Errorf("GetWaitForDataDelay(15m) = %v, want 0", delay)
+	}
+}
+
+func TestResolveSLIDefaultsWaitForData(t *testing.T) {
+	defaults := ResolveSLIDefaults(nil)
+
+	tests := []struct {
+		timeframe time.Duration
+		want      time.Duration
+	}{
+		{timeframe: time.Minute, want: 120 * time.Second},
+		{timeframe: 3 * time.Minute, want: 60 * time.Second},
+		{timeframe: 5 * time.Minute, want: 0},
+	}
+	for _, tt := range tests {
+		if got := defaults.GetWaitForDataDelay(tt.timeframe); got != tt.want {
+			t.Errorf("GetWaitForDataDelay(%v) = %v, want %v", tt.timeframe, got, tt.want)
+		}
+	}
+}
+
+func TestGetDynatraceAPIEndpoint(t *testing.T) {
+	tests := []struct {
+		requestURL string
+		want       string
+	}{
+		{requestURL: "https://abc.live.dynatrace.com/api/v2/metrics/query/?metricSelector=builtin:service.response.time", want: "/api/v2/metrics/query"},
+		{requestURL: "https://abc.live.dynatrace.com/api/v2/metrics/builtin:service.response.time", want: "/api/v2/metrics"},
+		{requestURL: "https://abc.live.dynatrace.com/api/config/v1/dashboards/ddb6a571-4bda-4e8b-a9c0-4a3e02c2e14a", want: "/api/config/v1/dashboards"},
+		{requestURL: "https://abc.live.dynatrace.com/api/v1/entity/services/SERVICE-1/baseline", want: "/api/v1/entity/services"},
+		{requestURL: "https://abc.live.dynatrace.com/api/v2/metricsfoo", want: "other"},
+	}
+	for _, tt := range tests {
+		requestURL, _ := url.Parse(tt.requestURL)
+		if got := GetDynatraceAPIEndpoint(requestURL); got != tt.want {
+			t.Errorf("GetDynatraceAPIEndpoint(%s) = %s, want %s", tt.requestURL, got, tt.want)
+		}
+	}
+}
+
+func TestLoggerAddsFieldsAndRedactsSecrets(t *testing.T) {
+	var output bytes.Buffer
+	SetLogOutput(&output, LogLevelInfo, LogFormatJSON)
+	defer SetLogOutput(os.Stdout, LogLevelDebug, LogFormatJSON)
+
+	RegisterSecret("my-process-secret")
+	keptnEvent := &BaseKeptnEvent{Project: "sockshop", Stage: "dev", Service: "carts"}
+	eventLogger := NewLogger("my-context", "my-event", ServiceName).WithEvent(keptnEvent)
+	logger := eventLogger.WithIndicator("throughput")
+	// secrets registered with the event's logger apply to the loggers derived from it
+	eventLogger.RegisterSecret("my-secret-value")
+
+	logger.Debug("not written as the level is info")
+	logger.Infof("calling with Authorization: Api-Token %s, %s and %s", "abcdefg1234", "my-secret-value", "my-process-secret")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 log message, got %d: %s", len(lines), output.String())
+	}
+
+	message := map[string]string{}
+	if err := json.Unmarshal([]byte(lines[0]), &message); err != nil {
+		t.Fatalf("log message is no valid json: %v", err)
+	}
+	want := map[string]string{
+		"logLevel":       "INFO",
+		"message":        "calling with Authorization: Api-Token ***, *** and ***",
+		"keptnService":   ServiceName,
+		"shkeptncontext": "my-context",
+		"eventId":        "my-event",
+		"project":        "sockshop",
+		"stage":          "dev",
+		"service":        "carts",
+		"indicator":      "throughput",
+	}
+	for field, value := range want {
+		if message[field] != value {
+			t.Errorf("%s = %q, want %q", field, message[field], value)
+		}
+	}
+}
+
+func TestLoggerSecretsAreScopedToTheEvent(t *testing.T) {
+	logger := NewLogger("my-context", "my-event", ServiceName)
+	otherLogger := NewLogger("other-context", "other-event", ServiceName)
+	logger.RegisterSecret("my-secret-value")
+
+	if got := logger.WithIndicator("throughput").Redact("tag(my-secret-value)"); got != "tag(***)" {
+		t.Errorf("Redact() = %s, want tag(***)", got)
+	}
+	if got := otherLogger.Redact("tag(my-secret-value)"); got != "tag(my-secret-value)" {
+		t.Errorf("Redact() of another event = %s, want tag(my-secret-value)", got)
+	}
+}
+
+// Tests that the values of all environment variables used in a query are redacted - whatever their name is
+func TestEnvPlaceholderValuesAreRedacted(t *testing.T) {
+	os.Setenv("SLI_TEST_DT_KEY", "my key/value")
+	defer os.Unsetenv("SLI_TEST_DT_KEY")
+	keptnEvent := &BaseKeptnEvent{}
+
+	logger := NewLogger("my-context", "my-event", ServiceName)
+	query := ReplaceKeptnPlaceholders("entitySelector=tag($ENV.SLI_TEST_DT_KEY)", keptnEvent, logger)
+	if got := logger.Redact(query); got != "entitySelector=tag(***)" {
+		t.Errorf("Redact(%s) = %s, want entitySelector=tag(***)", query, got)
+	}
+
+	templateLogger := NewLogger("my-context", "my-event", ServiceName)
+	query, err := ExpandQueryTemplate(`key={{ env "SLI_TEST_DT_KEY" }}`, keptnEvent, nil, templateLogger)
+	if err != nil {
+		t.Fatalf("ExpandQueryTemplate() returned error: %v", err)
+	}
+	if got := templateLogger.Redact(query); got != "key=***" {
+		t.Errorf("Redact(%s) = %s, want key=***", query, got)
+	}
+}
+
+func TestExtractTraceContext(t *testing.T) {
+	shutdownTracing, err := InitTracing()
+	if err != nil {
+		t.Fatalf("InitTracing() returned error: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	ctx := ExtractTraceContext(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "")
+	spanContext := trace.RemoteSpanContextFromContext(ctx)
+	if spanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceID = %s, want 4bf92f3577b34da6a3ce929d0e0e4736", spanContext.TraceID)
+	}
+	if spanContext.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("SpanID = %s, want 00f067aa0ba902b7", spanContext.SpanID)
+	}
+
+	ctx = ExtractTraceContext(context.Background(), "", "")
+	if trace.RemoteSpanContextFromContext(ctx).IsValid() {
+		t.Errorf("expected no span context without traceparent")
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keptnEvent := &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}
+	logger := NewLogger("", "", ServiceName)
+	store := NewLocalStore(dir)
+
+	content, err := GetKeptnResource(context.Background(), store, keptnEvent, DynatraceSLIFilename, logger)
+	if err != nil || content != "" {
+		t.Errorf("GetKeptnResource() of a missing resource returned %q, %v. Expected an empty content", content, err)
+	}
+
+	err = UploadKeptnResource(context.Background(), store, []byte("indicators: {}"), DynatraceSLIFilename, keptnEvent, logger)
+	if err != nil {
+		t.Fatalf("UploadKeptnResource() returned error %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dynatrace", "sli.yaml")); err != nil {
+		t.Errorf("expected %s to be written to %s: %v", DynatraceSLIFilename, dir, err)
+	}
+
+	content, err = GetKeptnResource(context.Background(), store, keptnEvent, DynatraceSLIFilename, logger)
+	if err != nil || content != "indicators: {}" {
+		t.Errorf("GetKeptnResource() returned %q, %v. Expected the uploaded content", content, err)
+	}
+}
+
+func TestGetDynatraceConfigFromMemoryStore(t *testing.T) {
+	keptnEvent := &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}
+	logger := NewLogger("", "", ServiceName)
+
+	got, err := GetDynatraceConfig(context.Background(), NewMemoryStore(nil), keptnEvent, logger)
+	if got != nil || err != nil {
+		t.Errorf("GetDynatraceConfig() without dynatrace.conf.yaml returned %v, %v. Expected nil, nil", got, err)
+	}
+
+	store := NewMemoryStore(map[string]string{DynatraceConfigFilename: "spec_version: '0.2.0'\ndtCreds: dynatrace-prod\n"})
+	got, err = GetDynatraceConfig(context.Background(), store, keptnEvent, logger)
+	if err != nil {
+		t.Fatalf("GetDynatraceConfig() returned error %v", err)
+	}
+	if got.DtCreds != "dynatrace-prod" {
+		t.Errorf("DtCreds = %s, want dynatrace-prod", got.DtCreds)
+	}
+
+	// the split store reads from the memory store but only writes to the other one
+	writeStore := NewMemoryStore(nil)
+	splitStore := NewSplitStore(store, writeStore)
+	if err := UploadKeptnResource(context.Background(), splitStore, []byte("{}"), "sliresult.json", keptnEvent, logger); err != nil {
+		t.Fatalf("UploadKeptnResource() returned error %v", err)
+	}
+	if _, ok := store.Resources()["sliresult.json"]; ok {
+		t.Errorf("expected sliresult.json not to be written to the read store")
+	}
+	if writeStore.Resources()["sliresult.json"] != "{}" {
+		t.Errorf("expected sliresult.json to be written to the write store")
+	}
+}
+
+// failingStore is a resource store that can't be reached, e.g: the configuration-service is down
+type failingStore struct{}
+
+func (s failingStore) GetResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, logger *Logger) (string, error) {
+	return "", fmt.Errorf("connection refused")
+}
+
+func (s failingStore) UploadResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, content []byte, logger *Logger) error {
+	return fmt.Errorf("connection refused")
+}
+
+func TestGetDynatraceConfigErrors(t *testing.T) {
+	keptnEvent := &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}
+	logger := NewLogger("", "", ServiceName)
+
+	tests := []struct {
+		name    string
+		store   ResourceStore
+		wantErr string
+	}{
+		{
+			name:  "not found",
+			store: NewMemoryStore(nil),
+		},
+		{
+			name:    "store unreachable",
+			store:   failingStore{},
+			wantErr: "could not load dynatrace/dynatrace.conf.yaml: connection refused",
+		},
+		{
+			name:    "unsupported spec_version",
+			store:   NewMemoryStore(map[string]string{DynatraceConfigFilename: "spec_version: '9.9.9'\ndtCreds: dynatrace-prod\n"}),
+			wantErr: "unsupported spec_version '9.9.9'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetDynatraceConfig(context.Background(), tt.store, keptnEvent, logger)
+			if got != nil {
+				t.Errorf("GetDynatraceConfig() = %v, want nil", got)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("GetDynatraceConfig() returned error %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("GetDynatraceConfig() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// initGitRepo creates a repository with a master branch holding a project-level resource and a staging branch holding a service-level resource
+func initGitRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "gitstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v %s", args, err, output)
+		}
+	}
+	write := func(file string, content string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, file)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init", "--quiet")
+	run("checkout", "--quiet", "-b", "master")
+	write("slo.yaml", "project slo")
+	write(DynatraceConfigFilename, "project conf")
+	run("add", ".")
+	run("commit", "--quiet", "-m", "project")
+	run("checkout", "--quiet", "-b", "staging")
+	write("carts/"+DynatraceConfigFilename, "service conf")
+	run("add", ".")
+	run("commit", "--quiet", "-m", "stage")
+	run("checkout", "--quiet", "master")
+	return dir
+}
+
+func TestGitStoreBranches(t *testing.T) {
+	dir := initGitRepo(t)
+	defer os.RemoveAll(dir)
+
+	keptnEvent := &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}
+	logger := NewLogger("", "", ServiceName)
+	store, err := NewGitStore(dir, GitLayoutBranches)
+	if err != nil {
+		t.Fatalf("NewGitStore() returned error %v", err)
+	}
+
+	tests := []struct {
+		resourceURI string
+		want        string
+	}{
+		{DynatraceConfigFilename, "service conf"},
+		{"slo.yaml", "project slo"},
+		{DynatraceSLIFilename, ""},
+	}
+	for _, tt := range tests {
+		got, err := GetKeptnResource(context.Background(), store, keptnEvent, tt.resourceURI, logger)
+		if err != nil || got != tt.want {
+			t.Errorf("GetKeptnResource(%s) = %q, %v, want %q", tt.resourceURI, got, err, tt.want)
+		}
+	}
+
+	// staging is not checked out: the resource is committed to it without touching the working tree
+	if err := UploadKeptnResource(context.Background(), store, []byte("indicators: {}"), DynatraceSLIFilename, keptnEvent, logger); err != nil {
+		t.Fatalf("UploadKeptnResource() returned error %v", err)
+	}
+	if got, _ := GetKeptnResource(context.Background(), store, keptnEvent, DynatraceSLIFilename, logger); got != "indicators: {}" {
+		t.Errorf("GetKeptnResource() after upload = %q, want the uploaded content", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "carts")); !os.IsNotExist(err) {
+		t.Errorf("expected the working tree of master not to be touched")
+	}
+
+	// staging is checked out: the resource is written to the working tree and committed
+	cmd := exec.Command("git", "checkout", "--quiet", "staging")
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git checkout failed: %v %s", err, output)
+	}
+	if err := UploadKeptnResource(context.Background(), store, []byte("objectives: []"), KeptnSLOFilename, keptnEvent, logger); err != nil {
+		t.Fatalf("UploadKeptnResource() returned error %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "carts", KeptnSLOFilename))
+	if err != nil || string(content) != "objectives: []" {
+		t.Errorf("expected carts/slo.yaml in the working tree, got %q, %v", content, err)
+	}
+	cmd = exec.Command("git", "status", "--porcelain")
+	cmd.Dir = dir
+	if output, err := cmd.Output(); err != nil || len(output) != 0 {
+		t.Errorf("expected a clean working tree after the upload, got %q, %v", output, err)
+	}
+}
+
+// Tests that only a missing file counts as not found - a broken repository is reported
+func TestGitStoreBranchesReportsGitErrors(t *testing.T) {
+	dir := initGitRepo(t)
+	defer os.RemoveAll(dir)
+
+	// remove the tree of the carts directory in the staging branch
+	cmd := exec.Command("git", "rev-parse", "staging:carts")
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git rev-parse failed: %v", err)
+	}
+	tree := strings.TrimSpace(string(output))
+	if err := os.Remove(filepath.Join(dir, ".git", "objects", tree[:2], tree[2:])); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewGitStore(dir, GitLayoutBranches)
+	if err != nil {
+		t.Fatalf("NewGitStore() returned error %v", err)
+	}
+	keptnEvent := &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}
+	got, err := GetKeptnResource(context.Background(), store, keptnEvent, DynatraceConfigFilename, NewLogger("", "", ServiceName))
+	if err == nil {
+		t.Errorf("GetKeptnResource() = %q, expected an error instead of falling back to the project level", got)
+	}
+}
+
+func TestGitStoreDirectories(t *testing.T) {
+	dir := initGitRepo(t)
+	defer os.RemoveAll(dir)
+
+	keptnEvent := &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}
+	logger := NewLogger("", "", ServiceName)
+	store, err := NewGitStore(dir, GitLayoutDirectories)
+	if err != nil {
+		t.Fatalf("NewGitStore() returned error %v", err)
+	}
+	store.Commit = true
+
+	os.MkdirAll(filepath.Join(dir, "sockshop", "staging"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "sockshop", "staging", KeptnSLOFilename), []byte("stage slo"), 0644)
+	if got, err := GetKeptnResource(context.Background(), store, keptnEvent, KeptnSLOFilename, logger); err != nil || got != "stage slo" {
+		t.Errorf("GetKeptnResource() = %q, %v, want the stage-level slo.yaml", got, err)
+	}
+
+	if err := UploadKeptnResource(context.Background(), store, []byte("indicators: {}"), DynatraceSLIFilename, keptnEvent, logger); err != nil {
+		t.Fatalf("UploadKeptnResource() returned error %v", err)
+	}
+	cmd := exec.Command("git", "log", "--format=%s", "-1", "--", "sockshop/staging/carts/dynatrace/sli.yaml")
+	cmd.Dir = dir
+	if output, err := cmd.Output(); err != nil || !strings.Contains(string(output), DynatraceSLIFilename) {
+		t.Errorf("expected the uploaded sli.yaml to be committed, got %q, %v", output, err)
+	}
+
+	if _, err := NewGitStore(dir, "flat"); err == nil {
+		t.Errorf("expected an error for an invalid layout")
+	}
+}
+
+// Tests that the project-level resources are taken from the configured default branch or the default branch of origin
+func TestGitStoreDefaultBranch(t *testing.T) {
+	dir := initGitRepo(t)
+	defer os.RemoveAll(dir)
+
+	run := func(dir string, args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v %s", args, err, output)
+		}
+	}
+	// staging is based on master - a resource committed afterwards only exists on project level
+	run(dir, "branch", "--quiet", "-m", "master", "main")
+	if err := ioutil.WriteFile(filepath.Join(dir, "project.yaml"), []byte("project only"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run(dir, "add", "project.yaml")
+	run(dir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "project only")
+
+	keptnEvent := &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}
+	logger := NewLogger("", "", ServiceName)
+
+	store, err := NewGitStore(dir, GitLayoutBranches)
+	if err != nil {
+		t.Fatalf("NewGitStore() returned error %v", err)
+	}
+	if got, err := store.GetResource(context.Background(), keptnEvent, "project.yaml", logger); err != nil || got != "" {
+		t.Errorf("GetResource() without master = %q, %v, want no resource", got, err)
+	}
+	store.DefaultBranch = "main"
+	if got, err := store.GetResource(context.Background(), keptnEvent, "project.yaml", logger); err != nil || got != "project only" {
+		t.Errorf("GetResource() with DefaultBranch main = %q, %v, want the project-level resource", got, err)
+	}
+
+	// a clone knows the default branch of origin
+	cloneDir, err := ioutil.TempDir("", "gitstore-clone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cloneDir)
+	run(cloneDir, "clone", "--quiet", dir, ".")
+
+	cloneStore, err := NewGitStore(cloneDir, GitLayoutBranches)
+	if err != nil {
+		t.Fatalf("NewGitStore() returned error %v", err)
+	}
+	if got, err := cloneStore.GetResource(context.Background(), keptnEvent, "project.yaml", logger); err != nil || got != "project only" {
+		t.Errorf("GetResource() in a clone = %q, %v, want the project-level resource of origin's default branch", got, err)
+	}
+}
+
+func TestGitStoreRejectsPathsOutsideTheProject(t *testing.T) {
+	dir := initGitRepo(t)
+	defer os.RemoveAll(dir)
+
+	logger := NewLogger("", "", ServiceName)
+	tests := []struct {
+		name        string
+		keptnEvent  *BaseKeptnEvent
+		resourceURI string
+	}{
+		{"stage ..", &BaseKeptnEvent{Project: "sockshop", Stage: "..", Service: "carts"}, KeptnSLOFilename},
+		{"stage with slash", &BaseKeptnEvent{Project: "sockshop", Stage: "../../tmp", Service: "carts"}, KeptnSLOFilename},
+		{"service with backslash", &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: `..\carts`}, KeptnSLOFilename},
+		{"resource outside", &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}, "../../../etc/passwd"},
+		{"absolute resource", &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}, "/etc/passwd"},
+	}
+	for _, layout := range []string{GitLayoutBranches, GitLayoutDirectories} {
+		store, err := NewGitStore(dir, layout)
+		if err != nil {
+			t.Fatalf("NewGitStore() returned error %v", err)
+		}
+		for _, tt := range tests {
+			if _, err := store.GetResource(context.Background(), tt.keptnEvent, tt.resourceURI, logger); err == nil {
+				t.Errorf("%s: GetResource() with %s returned no error", layout, tt.name)
+			}
+			if err := store.UploadResource(context.Background(), tt.keptnEvent, tt.resourceURI, []byte("content"), logger); err == nil {
+				t.Errorf("%s: UploadResource() with %s returned no error", layout, tt.name)
+			}
+		}
+
+		if err := store.UploadResource(context.Background(), &BaseKeptnEvent{Project: "sockshop", Service: "carts"}, KeptnSLOFilename, []byte("content"), logger); err == nil {
+			t.Errorf("%s: UploadResource() without stage returned no error", layout)
+		}
+	}
+}
+
+func TestConfigurationServiceStore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/project/sockshop/stage/staging/service/carts/resource/dynatrace/sli.yaml":
+			w.WriteHeader(http.StatusNotFound)
+		case "/v1/project/sockshop/stage/staging/resource/dynatrace/sli.yaml":
+			w.Write([]byte(`{"resourceURI": "dynatrace/sli.yaml", "resourceContent": "aW5kaWNhdG9yczoge30="}`))
+		case "/v1/project/broken/stage/staging/service/carts/resource/dynatrace/sli.yaml":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"code": 500, "message": "repository unavailable"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	logger := NewLogger("", "", ServiceName)
+	store := NewConfigurationServiceStore(server.URL)
+
+	got, err := store.GetResource(context.Background(), &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}, DynatraceSLIFilename, logger)
+	if err != nil || got != "indicators: {}" {
+		t.Errorf("GetResource() = %q, %v, want the stage-level sli.yaml", got, err)
+	}
+
+	got, err = store.GetResource(context.Background(), &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}, KeptnSLOFilename, logger)
+	if err != nil || got != "" {
+		t.Errorf("GetResource() of a missing resource = %q, %v, want an empty content without error", got, err)
+	}
+
+	got, err = store.GetResource(context.Background(), &BaseKeptnEvent{Project: "broken", Stage: "staging", Service: "carts"}, DynatraceSLIFilename, logger)
+	if err == nil || !strings.Contains(err.Error(), "repository unavailable") {
+		t.Errorf("GetResource() with a failing configuration-service = %q, %v, want an error", got, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.GetResource(ctx, &BaseKeptnEvent{Project: "sockshop", Stage: "staging", Service: "carts"}, DynatraceSLIFilename, logger); err == nil {
+		t.Errorf("GetResource() with a canceled context returned no error")
+	}
+}
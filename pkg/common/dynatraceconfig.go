@@ -0,0 +1,294 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+/**
+ * Schema handling for dynatrace.conf.yaml
+ *
+ * Every dynatrace.conf.yaml carries a spec_version. Optional sections and flags are added to the current spec_version. Files with an
+ * older spec_version are migrated step by step to DynatraceConfigSpecVersion before they are strictly parsed (unknown keys are reported)
+ * and validated.
+ */
+
+// DynatraceConfigSpecVersion is the current spec_version of dynatrace.conf.yaml
+const DynatraceConfigSpecVersion = "0.2.0"
+
+// dynatraceConfigLegacySpecVersion is assumed for files that do not specify any spec_version
+const dynatraceConfigLegacySpecVersion = "0.1.0"
+
+// dynatraceConfigMigration upgrades the raw content of a dynatrace.conf.yaml by one spec_version and returns the new spec_version
+type dynatraceConfigMigration func(content map[string]interface{}) string
+
+// dynatraceConfigMigrations holds a migration for every outdated spec_version, keyed by the spec_version it migrates from
+var dynatraceConfigMigrations = map[string]dynatraceConfigMigration{
+	// 0.2.0 added the optional defaults section, 0.1.0 files are valid 0.2.0 files that just don't use it
+	"0.1.0": func(content map[string]interface{}) string { return "0.2.0" },
+}
+
+/**
+ * Default values of the defaults section in dynatrace.conf.yaml
+ */
+const SLIEntityTypeService = "SERVICE"
+const SLIEntityTypeProcessGroup = "PROCESS_GROUP"
+const DefaultSLIParallelism = 1
+const DefaultRequestTimeout = 60 * time.Second
+const DefaultEvaluationTimeout = 5 * time.Minute
+
+/**
+ * Default values of the timeseries section in dynatrace.conf.yaml
+ */
+const TimeseriesFormatJSON = "json"
+const TimeseriesFormatCSV = "csv"
+const DefaultTimeseriesResolution = "1m"
+
+var timeseriesResolutionRegex = regexp.MustCompile(`^\d+[smhdwMqy]?$`)
+
+// ResolveTimeseriesExport returns a copy of the passed timeseries configuration with resolution and format set to their defaults if not specified
+func ResolveTimeseriesExport(timeseries *TimeseriesExport) *TimeseriesExport {
+	resolved := &TimeseriesExport{}
+	if timeseries != nil {
+		*resolved = *timeseries
+	}
+	if resolved.Resolution == "" {
+		resolved.Resolution = DefaultTimeseriesResolution
+	}
+	if resolved.Format == "" {
+		resolved.Format = TimeseriesFormatJSON
+	}
+	return resolved
+}
+
+// DefaultEntityTags are the tags Keptn's helm-service puts on every deployed service
+var DefaultEntityTags = EntityTags{
+	Project:    "keptn_project",
+	Stage:      "keptn_stage",
+	Service:    "keptn_service",
+	Deployment: "keptn_deployment",
+}
+
+// DefaultWaitForData waits 120s for timeframes below 2 minutes and 60s for timeframes below 5 minutes. Longer timeframes are queried right away
+var DefaultWaitForData = []WaitForDataThreshold{
+	{BelowTimeframe: 2 * time.Minute, Delay: 120 * time.Second},
+	{BelowTimeframe: 5 * time.Minute, Delay: 60 * time.Second},
+}
+
+// ResolveSLIDefaults returns a copy of the passed defaults where every value that is not specified is set to its default value
+func ResolveSLIDefaults(defaults *SLIDefaults) *SLIDefaults {
+	resolved := &SLIDefaults{}
+	if defaults != nil {
+		*resolved = *defaults
+	}
+
+	if resolved.EntityType == "" {
+		resolved.EntityType = SLIEntityTypeService
+	}
+	if resolved.Tags == nil {
+		tags := DefaultEntityTags
+		resolved.Tags = &tags
+	}
+	if resolved.WaitForData == nil {
+		resolved.WaitForData = DefaultWaitForData
+	}
+	if resolved.Parallelism <= 0 {
+		resolved.Parallelism = DefaultSLIParallelism
+	}
+	if resolved.RequestTimeout <= 0 {
+		resolved.RequestTimeout = DefaultRequestTimeout
+	}
+	if resolved.EvaluationTimeout <= 0 {
+		resolved.EvaluationTimeout = DefaultEvaluationTimeout
+	}
+
+	return resolved
+}
+
+// GetWaitForDataDelay returns how far the end of an evaluation with the passed timeframe has to be in the past
+func (d *SLIDefaults) GetWaitForDataDelay(timeframe time.Duration) time.Duration {
+	thresholds := make([]WaitForDataThreshold, len(d.WaitForData))
+	copy(thresholds, d.WaitForData)
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i].BelowTimeframe < thresholds[j].BelowTimeframe })
+
+	for _, threshold := range thresholds {
+		if timeframe < threshold.BelowTimeframe {
+			return threshold.Delay
+		}
+	}
+	return 0
+}
+
+var uuidRegex = regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-4[a-fA-F0-9]{3}-[8|9|aA|bB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$")
+
+// IsValidUUID validates whether the passed string is a valid UUID, e.g: a Dynatrace dashboard ID
+func IsValidUUID(uuid string) bool {
+	return uuidRegex.MatchString(uuid)
+}
+
+// DynatraceConfigError is returned if a dynatrace.conf.yaml could not be parsed or did not pass validation
+type DynatraceConfigError struct {
+	Problems []string
+}
+
+func (e *DynatraceConfigError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", DynatraceConfigFilename, strings.Join(e.Problems, "; "))
+}
+
+// SupportedDynatraceConfigSpecVersions returns all spec_versions of dynatrace.conf.yaml that can be parsed
+func SupportedDynatraceConfigSpecVersions() []string {
+	versions := []string{DynatraceConfigSpecVersion}
+	for version := range dynatraceConfigMigrations {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+/**
+ * migrateDynatraceConfig brings the passed dynatrace.conf.yaml content to the current spec_version
+ * Returns the content to parse and whether a migration took place
+ */
+func migrateDynatraceConfig(input []byte) ([]byte, bool, error) {
+	content := map[string]interface{}{}
+	err := yaml.Unmarshal(input, &content)
+	if err != nil {
+		return nil, false, &DynatraceConfigError{Problems: []string{err.Error()}}
+	}
+
+	specVersion := dynatraceConfigLegacySpecVersion
+	if value, ok := content["spec_version"]; ok && value != nil {
+		specVersion = fmt.Sprintf("%v", value)
+	}
+
+	migrated := false
+	for specVersion != DynatraceConfigSpecVersion {
+		migration, ok := dynatraceConfigMigrations[specVersion]
+		if !ok {
+			return nil, false, &DynatraceConfigError{Problems: []string{
+				fmt.Sprintf("unsupported spec_version '%s' (supported: %s)", specVersion, strings.Join(SupportedDynatraceConfigSpecVersions(), ", ")),
+			}}
+		}
+		specVersion = migration(content)
+		content["spec_version"] = specVersion
+		migrated = true
+	}
+
+	if !migrated {
+		return input, false, nil
+	}
+
+	output, err := yaml.Marshal(content)
+	if err != nil {
+		return nil, false, err
+	}
+	return output, true, nil
+}
+
+/**
+ * ParseDynatraceConfigFile parses the dynatrace.conf.yaml file that is passed as parameter
+ * Unknown keys, unsupported spec_versions and invalid values result in a DynatraceConfigError
+ */
+func ParseDynatraceConfigFile(input []byte) (*DynatraceConfigFile, error) {
+	content, _, err := migrateDynatraceConfig(input)
+	if err != nil {
+		return nil, err
+	}
+
+	dynatraceConfFile := &DynatraceConfigFile{}
+	err = yaml.UnmarshalStrict(content, dynatraceConfFile)
+	if err != nil {
+		return nil, &DynatraceConfigError{Problems: yamlErrorProblems(err)}
+	}
+
+	// migrated files always end up with the current spec_version
+	dynatraceConfFile.SpecVersion = DynatraceConfigSpecVersion
+
+	problems := validateDynatraceConfigFile(dynatraceConfFile)
+	if len(problems) > 0 {
+		return nil, &DynatraceConfigError{Problems: problems}
+	}
+
+	return dynatraceConfFile, nil
+}
+
+/**
+ * validateDynatraceConfigFile checks the values of a parsed dynatrace.conf.yaml and returns a list of problems
+ */
+func validateDynatraceConfigFile(config *DynatraceConfigFile) []string {
+	var problems []string
+
+	if strings.ContainsAny(config.DtCreds, " \t\r\n") {
+		problems = append(problems, fmt.Sprintf("dtCreds '%s' must not contain whitespaces", config.DtCreds))
+	}
+
+	if config.Dashboard != "" && config.Dashboard != DynatraceConfigDashboardQUERY && !IsValidUUID(config.Dashboard) {
+		problems = append(problems, fmt.Sprintf("dashboard '%s' must either be empty, '%s' or a valid dashboard UUID", config.Dashboard, DynatraceConfigDashboardQUERY))
+	}
+
+	if config.Defaults != nil {
+		problems = append(problems, validateSLIDefaults(config.Defaults)...)
+	}
+
+	if config.Timeseries != nil {
+		if config.Timeseries.Resolution != "" && !timeseriesResolutionRegex.MatchString(config.Timeseries.Resolution) {
+			problems = append(problems, fmt.Sprintf("timeseries.resolution '%s' must be a Metrics API resolution, e.g: 1m, 10m or 1h", config.Timeseries.Resolution))
+		}
+		if config.Timeseries.Format != "" && config.Timeseries.Format != TimeseriesFormatJSON && config.Timeseries.Format != TimeseriesFormatCSV {
+			problems = append(problems, fmt.Sprintf("timeseries.format '%s' must either be %s or %s", config.Timeseries.Format, TimeseriesFormatJSON, TimeseriesFormatCSV))
+		}
+	}
+
+	return problems
+}
+
+/**
+ * validateSLIDefaults checks the values of the defaults section of a dynatrace.conf.yaml
+ */
+func validateSLIDefaults(defaults *SLIDefaults) []string {
+	var problems []string
+
+	if defaults.EntityType != "" && defaults.EntityType != SLIEntityTypeService && defaults.EntityType != SLIEntityTypeProcessGroup {
+		problems = append(problems, fmt.Sprintf("defaults.entityType '%s' must either be %s or %s", defaults.EntityType, SLIEntityTypeService, SLIEntityTypeProcessGroup))
+	}
+
+	if defaults.Tags != nil && defaults.TagScheme != "" {
+		problems = append(problems, "defaults.tags and defaults.tagScheme must not be used together")
+	}
+
+	if defaults.RequestTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("defaults.requestTimeout %v must not be negative", defaults.RequestTimeout))
+	}
+
+	if defaults.EvaluationTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("defaults.evaluationTimeout %v must not be negative", defaults.EvaluationTimeout))
+	}
+
+	if defaults.Parallelism < 0 {
+		problems = append(problems, fmt.Sprintf("defaults.parallelism %d must not be negative", defaults.Parallelism))
+	}
+
+	for i, threshold := range defaults.WaitForData {
+		if threshold.BelowTimeframe <= 0 {
+			problems = append(problems, fmt.Sprintf("defaults.waitForData[%d].belowTimeframe must be greater than 0", i))
+		}
+		if threshold.Delay < 0 {
+			problems = append(problems, fmt.Sprintf("defaults.waitForData[%d].delay must not be negative", i))
+		}
+	}
+
+	return problems
+}
+
+// yamlErrorProblems splits a yaml.TypeError into one problem per line, e.g: "line 3: field dashbaord not found in type common.DynatraceConfigFile"
+func yamlErrorProblems(err error) []string {
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		return typeErr.Errors
+	}
+	return []string{err.Error()}
+}
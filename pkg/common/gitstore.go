@@ -0,0 +1,341 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/**
+ * Layouts of the resources in a Git repository
+ *   branches:    the layout of the Keptn configuration-service - the repository holds a single project, project-level resources are on
+ *                the default branch, every stage has a branch with the stage-level resources in its root and a directory per service
+ *   directories: <project>/<stage>/<service>/ in the checked out branch, stage- and project-level resources in the parent directories
+ */
+const GitLayoutBranches = "branches"
+const GitLayoutDirectories = "directories"
+
+// DefaultGitBranch holds the project-level resources of the branches layout if origin has no default branch
+const DefaultGitBranch = "master"
+
+// gitCommitIdentity is used for commits if the repository has no user configured, e.g: in a CI checkout
+var gitCommitIdentity = []string{"-c", "user.name=" + ServiceName, "-c", "user.email=" + ServiceName + "@keptn.sh"}
+
+/**
+ * GitStore loads and stores resources in a local Git checkout, e.g: a repository of a team that runs quality gates in CI without Keptn
+ * Resources are looked up on service, stage and project level just like on the configuration-service
+ * Uploaded resources are committed with the branches layout - with the directories layout only if Commit is set. Nothing is pushed
+ */
+type GitStore struct {
+	// Dir is the root of the Git checkout
+	Dir string
+	// Layout is GitLayoutBranches or GitLayoutDirectories
+	Layout string
+	// DefaultBranch holds the project-level resources of the branches layout - if empty the default branch of origin or DefaultGitBranch
+	DefaultBranch string
+	// Commit commits resources uploaded with the directories layout to the checked out branch
+	Commit bool
+
+	mutex sync.Mutex
+}
+
+// NewGitStore returns a resource store for the Git checkout in dir - the layout is GitLayoutBranches or GitLayoutDirectories
+func NewGitStore(dir string, layout string) (*GitStore, error) {
+	if layout != GitLayoutBranches && layout != GitLayoutDirectories {
+		return nil, fmt.Errorf("invalid Git layout %s, expected %s or %s", layout, GitLayoutBranches, GitLayoutDirectories)
+	}
+	store := &GitStore{Dir: dir, Layout: layout}
+	if _, err := store.git(nil, "rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("%s is not a Git checkout: %v", dir, err)
+	}
+	return store, nil
+}
+
+// GetResource looks up the resource on service level, then on stage and then on project level
+func (s *GitStore) GetResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, logger *Logger) (string, error) {
+	if err := validateGitResourcePath(keptnEvent, resourceURI); err != nil {
+		return "", err
+	}
+
+	levels := []string{"service", "stage", "project"}
+	if s.Layout == GitLayoutDirectories {
+		for i, dir := range []string{path.Join(keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service), path.Join(keptnEvent.Project, keptnEvent.Stage), keptnEvent.Project} {
+			content, err := ioutil.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(path.Join(dir, resourceURI))))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return "", err
+			}
+			logger.Debugf("Found %s on %s level in %s", resourceURI, levels[i], s.Dir)
+			return string(content), nil
+		}
+		return "", nil
+	}
+
+	locations := []struct {
+		branch string
+		path   string
+	}{
+		{keptnEvent.Stage, path.Join(keptnEvent.Service, resourceURI)},
+		{keptnEvent.Stage, resourceURI},
+		{s.getDefaultBranch(), resourceURI},
+	}
+	for i, location := range locations {
+		ref, err := s.resolveBranch(location.branch)
+		if err != nil {
+			return "", err
+		}
+		if ref == "" {
+			continue
+		}
+		found, err := s.hasFile(ref, location.path)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			continue
+		}
+		content, err := s.git(nil, "cat-file", "-p", ref+":"+location.path)
+		if err != nil {
+			return "", err
+		}
+		logger.Debugf("Found %s on %s level in branch %s", resourceURI, levels[i], ref)
+		return string(content), nil
+	}
+	return "", nil
+}
+
+// UploadResource stores the resource on service level - in the stage branch with the branches layout
+func (s *GitStore) UploadResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, content []byte, logger *Logger) error {
+	if err := validateGitResourcePath(keptnEvent, resourceURI); err != nil {
+		return err
+	}
+	if keptnEvent.Stage == "" || keptnEvent.Service == "" {
+		return fmt.Errorf("could not store %s: stage and service are required", resourceURI)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	message := fmt.Sprintf("Upload %s for service %s in stage %s", resourceURI, keptnEvent.Service, keptnEvent.Stage)
+	if s.Layout == GitLayoutDirectories {
+		resourcePath := path.Join(keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service, resourceURI)
+		if err := s.writeFile(resourcePath, content); err != nil {
+			return err
+		}
+		if s.Commit {
+			if err := s.commitFile(resourcePath, message); err != nil {
+				return err
+			}
+		}
+		logger.Infof("Stored %s in %s", resourcePath, s.Dir)
+		return nil
+	}
+
+	resourcePath := path.Join(keptnEvent.Service, resourceURI)
+	if currentBranch, _ := s.git(nil, "symbolic-ref", "--quiet", "--short", "HEAD"); strings.TrimSpace(string(currentBranch)) == keptnEvent.Stage {
+		// the stage branch is checked out: keep working tree and index in sync
+		if err := s.writeFile(resourcePath, content); err != nil {
+			return err
+		}
+		if err := s.commitFile(resourcePath, message); err != nil {
+			return err
+		}
+	} else if err := s.commitToBranch(keptnEvent.Stage, resourcePath, content, message); err != nil {
+		return err
+	}
+	logger.Infof("Committed %s to branch %s in %s", resourcePath, keptnEvent.Stage, s.Dir)
+	return nil
+}
+
+// getDefaultBranch returns DefaultBranch - or the default branch of origin, e.g: main, and DefaultGitBranch if origin has none
+func (s *GitStore) getDefaultBranch() string {
+	if s.DefaultBranch != "" {
+		return s.DefaultBranch
+	}
+	if originHead, err := s.git(nil, "symbolic-ref", "--quiet", "--short", "refs/remotes/origin/HEAD"); err == nil {
+		if branch := strings.TrimPrefix(strings.TrimSpace(string(originHead)), "origin/"); branch != "" {
+			return branch
+		}
+	}
+	return DefaultGitBranch
+}
+
+/**
+ * validateGitResourcePath rejects project, stage, service and resource URIs that would leave the directories of the project
+ * or select a different level, e.g: a stage ../other or a resource URI /etc/passwd
+ */
+func validateGitResourcePath(keptnEvent *BaseKeptnEvent, resourceURI string) error {
+	for _, segment := range []struct {
+		name  string
+		value string
+	}{
+		{"project", keptnEvent.Project},
+		{"stage", keptnEvent.Stage},
+		{"service", keptnEvent.Service},
+	} {
+		if segment.value == "." || segment.value == ".." || strings.ContainsAny(segment.value, `/\`) {
+			return fmt.Errorf("invalid %s %s", segment.name, segment.value)
+		}
+	}
+
+	if resourceURI == "" || strings.Contains(resourceURI, `\`) || path.IsAbs(resourceURI) || filepath.IsAbs(resourceURI) {
+		return fmt.Errorf("invalid resource URI %s", resourceURI)
+	}
+	for _, segment := range strings.Split(resourceURI, "/") {
+		if segment == ".." {
+			return fmt.Errorf("invalid resource URI %s", resourceURI)
+		}
+	}
+	return nil
+}
+
+// resolveBranch returns the local branch or - e.g: in a CI checkout - the branch of origin. Returns "" if neither exists
+func (s *GitStore) resolveBranch(branch string) (string, error) {
+	if branch == "" {
+		return "", nil
+	}
+	candidates := []string{"refs/heads/" + branch, "refs/remotes/origin/" + branch}
+	// for-each-ref lists the existing refs - unlike rev-parse it only fails if git itself fails
+	output, err := s.git(nil, append([]string{"for-each-ref", "--format=%(refname)"}, candidates...)...)
+	if err != nil {
+		return "", err
+	}
+	refs := strings.Fields(string(output))
+	for _, candidate := range candidates {
+		for _, ref := range refs {
+			if ref == candidate {
+				return ref, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// hasFile returns whether the file exists in the ref - an error is only returned if the ref could not be read
+func (s *GitStore) hasFile(ref string, filePath string) (bool, error) {
+	// ls-tree prints nothing for a missing path - unlike cat-file -e it only fails for an unreadable ref or repository
+	output, err := s.git(nil, "ls-tree", ref, "--", filePath)
+	if err != nil {
+		return false, err
+	}
+	fields := strings.Fields(string(output))
+	return len(fields) >= 2 && fields[1] == "blob", nil
+}
+
+// writeFile writes a file relative to the root of the checkout
+func (s *GitStore) writeFile(resourcePath string, content []byte) error {
+	file := filepath.Join(s.Dir, filepath.FromSlash(resourcePath))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("could not create directory for %s: %v", file, err)
+	}
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		return fmt.Errorf("could not write %s: %v", file, err)
+	}
+	return nil
+}
+
+// commitFile commits a file of the working tree to the checked out branch - unchanged files are not committed
+func (s *GitStore) commitFile(resourcePath string, message string) error {
+	if _, err := s.git(nil, "add", "--", resourcePath); err != nil {
+		return err
+	}
+	if _, err := s.git(nil, "diff", "--cached", "--quiet", "--", resourcePath); err == nil {
+		return nil
+	}
+	_, err := s.git(nil, s.withCommitIdentity("commit", "--quiet", "-m", message, "--", resourcePath)...)
+	return err
+}
+
+/**
+ * commitToBranch commits a file to a branch that is not checked out - without touching working tree and index
+ * A missing local branch is created from origin's branch. The stage branch has to exist, it is not created from scratch
+ */
+func (s *GitStore) commitToBranch(branch string, resourcePath string, content []byte, message string) error {
+	parent, err := s.resolveBranch(branch)
+	if err != nil {
+		return err
+	}
+	if parent == "" {
+		return fmt.Errorf("branch %s does not exist in %s", branch, s.Dir)
+	}
+	parentCommit, err := s.git(nil, "rev-parse", parent)
+	if err != nil {
+		return err
+	}
+
+	indexFile, err := ioutil.TempFile("", "gitstore-index")
+	if err != nil {
+		return err
+	}
+	indexFile.Close()
+	defer os.Remove(indexFile.Name())
+	indexEnv := []string{"GIT_INDEX_FILE=" + indexFile.Name()}
+
+	blob, err := s.gitWithInput(nil, content, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+	if _, err := s.git(indexEnv, "read-tree", parent); err != nil {
+		return err
+	}
+	if _, err := s.git(indexEnv, "update-index", "--add", "--cacheinfo", "100644,"+strings.TrimSpace(string(blob))+","+resourcePath); err != nil {
+		return err
+	}
+	tree, err := s.git(indexEnv, "write-tree")
+	if err != nil {
+		return err
+	}
+	parentTree, err := s.git(nil, "rev-parse", parent+"^{tree}")
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(tree, parentTree) {
+		return nil
+	}
+
+	commit, err := s.git(nil, s.withCommitIdentity("commit-tree", strings.TrimSpace(string(tree)), "-p", strings.TrimSpace(string(parentCommit)), "-m", message)...)
+	if err != nil {
+		return err
+	}
+	_, err = s.git(nil, "update-ref", "refs/heads/"+branch, strings.TrimSpace(string(commit)))
+	return err
+}
+
+// withCommitIdentity prepends a user name and email to the git arguments if the repository has none configured
+func (s *GitStore) withCommitIdentity(args ...string) []string {
+	if email, err := s.git(nil, "config", "user.email"); err == nil && len(bytes.TrimSpace(email)) > 0 {
+		return args
+	}
+	return append(append([]string{}, gitCommitIdentity...), args...)
+}
+
+// git runs a git command in the checkout and returns its output
+func (s *GitStore) git(env []string, args ...string) ([]byte, error) {
+	return s.gitWithInput(env, nil, args...)
+}
+
+// gitWithInput runs a git command in the checkout with the passed stdin and returns its output
+func (s *GitStore) gitWithInput(env []string, input []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.Dir
+	cmd.Env = append(os.Environ(), env...)
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("git %s failed: %v %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,289 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServiceName is used as keptnService of every log message
+const ServiceName = "dynatrace-sli-service"
+
+// Log levels - LOG_LEVEL defines the lowest level that is written. Default: debug
+const LogLevelDebug = "debug"
+const LogLevelInfo = "info"
+const LogLevelError = "error"
+
+// Log formats - LOG_FORMAT defines whether log messages are written as json (default) or as plain text
+const LogFormatJSON = "json"
+const LogFormatText = "text"
+
+// Fields every log message contains. indicator is only added to messages about a single indicator
+const LogFieldContext = "shkeptncontext"
+const LogFieldEventID = "eventId"
+const LogFieldProject = "project"
+const LogFieldStage = "stage"
+const LogFieldService = "service"
+const LogFieldIndicator = "indicator"
+
+// RedactedValue replaces API tokens and secret values in log messages
+const RedactedValue = "***"
+
+var logLevels = map[string]int{LogLevelDebug: 0, LogLevelInfo: 1, LogLevelError: 2}
+
+var logFields = []string{LogFieldContext, LogFieldEventID, LogFieldProject, LogFieldStage, LogFieldService}
+
+// apiTokenRegex matches the value of an Authorization: Api-Token header and of an Api-Token query parameter
+var apiTokenRegex = regexp.MustCompile(`(?i)(Api-Token[\s=:]+)[^\s"',&]+`)
+
+// minSecretLength avoids that very short values, e.g: "1", are redacted everywhere
+const minSecretLength = 4
+
+var logOutput = struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	level  int
+	format string
+}{
+	writer: os.Stdout,
+	level:  parseLogLevel(os.Getenv("LOG_LEVEL")),
+	format: parseLogFormat(os.Getenv("LOG_FORMAT")),
+}
+
+// secretSet holds values that are replaced with RedactedValue
+type secretSet struct {
+	mutex  sync.Mutex
+	values map[string]bool
+}
+
+func newSecretSet() *secretSet {
+	return &secretSet{values: map[string]bool{}}
+}
+
+func (s *secretSet) add(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.values[secret] = true
+}
+
+func (s *secretSet) redact(message string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for secret := range s.values {
+		message = strings.Replace(message, secret, RedactedValue, -1)
+	}
+	return message
+}
+
+// processSecrets are redacted from every message - secrets of an event are registered with its Logger instead
+var processSecrets = newSecretSet()
+
+func parseLogLevel(level string) int {
+	if value, ok := logLevels[strings.ToLower(level)]; ok {
+		return value
+	}
+	return logLevels[LogLevelDebug]
+}
+
+func parseLogFormat(format string) string {
+	if strings.ToLower(format) == LogFormatText {
+		return LogFormatText
+	}
+	return LogFormatJSON
+}
+
+// SetLogOutput changes where log messages are written to and their level and format, e.g: for tests
+func SetLogOutput(writer io.Writer, level string, format string) {
+	logOutput.mutex.Lock()
+	defer logOutput.mutex.Unlock()
+	logOutput.writer = writer
+	logOutput.level = parseLogLevel(level)
+	logOutput.format = parseLogFormat(format)
+}
+
+// RegisterSecret makes sure the passed value never shows up in any log message, e.g: the API token of dtsli - see Logger.RegisterSecret for the secrets of an event
+func RegisterSecret(secret string) {
+	processSecrets.add(secret)
+}
+
+// Redact removes API tokens and the secrets registered with RegisterSecret from the passed message
+func Redact(message string) string {
+	message = apiTokenRegex.ReplaceAllString(message, "${1}"+RedactedValue)
+	return processSecrets.redact(message)
+}
+
+/**
+ * Logger writes structured log messages that always contain shkeptncontext, eventId, project, stage and service
+ * It implements keptn.LoggerInterface, messages are redacted before they are written
+ * Secrets registered with a logger are shared with the loggers derived from it and are forgotten together with the logger of the event
+ */
+type Logger struct {
+	serviceName string
+	fields      map[string]string
+	secrets     *secretSet
+}
+
+// NewLogger returns a logger for the passed Keptn context and event
+func NewLogger(keptnContext string, eventID string, serviceName string) *Logger {
+	return &Logger{
+		serviceName: serviceName,
+		fields:      map[string]string{LogFieldContext: keptnContext, LogFieldEventID: eventID},
+		secrets:     newSecretSet(),
+	}
+}
+
+// RegisterSecret makes sure the passed value, e.g: the API token of the event's credentials, never shows up in a message of this logger
+func (l *Logger) RegisterSecret(secret string) {
+	if l == nil {
+		return
+	}
+	l.secrets.add(secret)
+}
+
+// registerEnvSecret registers the value of an environment variable used for a $ENV placeholder - as is and url encoded like in queries
+func (l *Logger) registerEnvSecret(value string) {
+	l.RegisterSecret(value)
+	l.RegisterSecret(url.QueryEscape(value))
+}
+
+// Redact removes API tokens, the secrets registered with this logger and those registered with RegisterSecret from the passed message
+func (l *Logger) Redact(message string) string {
+	message = Redact(message)
+	if l == nil {
+		return message
+	}
+	return l.secrets.redact(message)
+}
+
+// WithField returns a copy of the logger that adds the passed field to every message
+func (l *Logger) WithField(name string, value string) *Logger {
+	fields := make(map[string]string, len(l.fields)+1)
+	for fieldName, fieldValue := range l.fields {
+		fields[fieldName] = fieldValue
+	}
+	fields[name] = value
+	return &Logger{serviceName: l.serviceName, fields: fields, secrets: l.secrets}
+}
+
+// WithEvent returns a copy of the logger that adds project, stage and service of the event to every message
+func (l *Logger) WithEvent(keptnEvent *BaseKeptnEvent) *Logger {
+	if keptnEvent == nil {
+		return l
+	}
+	return l.WithField(LogFieldProject, keptnEvent.Project).WithField(LogFieldStage, keptnEvent.Stage).WithField(LogFieldService, keptnEvent.Service)
+}
+
+// WithIndicator returns a copy of the logger that adds the indicator name to every message
+func (l *Logger) WithIndicator(indicator string) *Logger {
+	return l.WithField(LogFieldIndicator, indicator)
+}
+
+// Debug logs a debug message
+func (l *Logger) Debug(message string) {
+	l.log(LogLevelDebug, message)
+}
+
+// Debugf logs a formatted debug message
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(LogLevelDebug, fmt.Sprintf(format, args...))
+}
+
+// Info logs an info message
+func (l *Logger) Info(message string) {
+	l.log(LogLevelInfo, message)
+}
+
+// Infof logs a formatted info message
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(LogLevelInfo, fmt.Sprintf(format, args...))
+}
+
+// Error logs an error message
+func (l *Logger) Error(message string) {
+	l.log(LogLevelError, message)
+}
+
+// Errorf logs a formatted error message
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(LogLevelError, fmt.Sprintf(format, args...))
+}
+
+// Terminate logs an info message
+func (l *Logger) Terminate(message string) {
+	l.log(LogLevelInfo, message)
+}
+
+func (l *Logger) log(level string, message string) {
+	message = l.Redact(strings.TrimSpace(message))
+
+	logOutput.mutex.Lock()
+	defer logOutput.mutex.Unlock()
+	if logLevels[level] < logOutput.level {
+		return
+	}
+
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	if logOutput.format == LogFormatText {
+		fmt.Fprintf(logOutput.writer, "%s %-5s %s%s\n", timestamp, strings.ToUpper(level), message, l.formatFields())
+		return
+	}
+
+	logMessage := map[string]string{
+		"timestamp":    timestamp,
+		"logLevel":     strings.ToUpper(level),
+		"message":      message,
+		"keptnService": l.serviceName,
+	}
+	for _, field := range logFields {
+		logMessage[field] = l.fields[field]
+	}
+	for field, value := range l.fields {
+		logMessage[field] = value
+	}
+
+	logString, err := json.Marshal(logMessage)
+	if err != nil {
+		fmt.Fprintln(logOutput.writer, "Could not log message")
+		return
+	}
+	fmt.Fprintln(logOutput.writer, string(logString))
+}
+
+// formatFields returns the fields of the logger as " name=value" pairs - standard fields first
+func (l *Logger) formatFields() string {
+	var builder strings.Builder
+	for _, field := range logFields {
+		builder.WriteString(fmt.Sprintf(" %s=%s", field, l.fields[field]))
+	}
+
+	additionalFields := []string{}
+	for field := range l.fields {
+		if !isStandardLogField(field) {
+			additionalFields = append(additionalFields, field)
+		}
+	}
+	sort.Strings(additionalFields)
+	for _, field := range additionalFields {
+		builder.WriteString(fmt.Sprintf(" %s=%s", field, l.fields[field]))
+	}
+	return builder.String()
+}
+
+func isStandardLogField(field string) bool {
+	for _, standardField := range logFields {
+		if field == standardField {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsPath is the path the Prometheus metrics are exposed on - next to the cloudevents receiver
+const MetricsPath = "/metrics"
+
+const metricsNamespace = "dynatrace_sli_service"
+
+// Reasons an indicator could not be retrieved, used as label of IndicatorFailures
+const IndicatorFailureDefinition = "definition"
+const IndicatorFailureQuery = "query"
+const IndicatorFailureBaseline = "baseline"
+const IndicatorFailureTimeout = "timeout"
+
+// Results of a dashboard parse, used as label of DashboardParses
+const DashboardParseSuccess = "success"
+const DashboardParseError = "error"
+
+// dynatraceAPIEndpoints are the Dynatrace API endpoints used as endpoint label - longest prefix first, IDs in the path are dropped
+var dynatraceAPIEndpoints = []string{
+	"/api/v2/metrics/query",
+	"/api/v2/metrics",
+	"/api/v2/entities",
+	"/api/v1/userSessionQueryLanguage/table",
+	"/api/v1/entity/services",
+	"/api/config/v1/dashboards",
+}
+
+var (
+	// EventsHandled counts the received events by event type
+	EventsHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_handled_total",
+		Help:      "Number of received events by event type",
+	}, []string{"type"})
+
+	// DynatraceAPIRequests counts the Dynatrace API calls by endpoint and status code. Calls without response have status code 0
+	DynatraceAPIRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dynatrace_api_requests_total",
+		Help:      "Number of Dynatrace API calls by endpoint and status code",
+	}, []string{"endpoint", "code"})
+
+	// DynatraceAPIRequestDuration observes the duration of Dynatrace API calls by endpoint
+	DynatraceAPIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "dynatrace_api_request_duration_seconds",
+		Help:      "Duration of Dynatrace API calls by endpoint",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	// DynatraceAPIRetries counts the retries of throttled or failed Dynatrace API calls by endpoint
+	DynatraceAPIRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dynatrace_api_retries_total",
+		Help:      "Number of retried Dynatrace API calls by endpoint",
+	}, []string{"endpoint"})
+
+	// IndicatorFailures counts the indicators that could not be retrieved by reason
+	IndicatorFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "indicator_failures_total",
+		Help:      "Number of indicators that could not be retrieved by reason",
+	}, []string{"reason"})
+
+	// DashboardParses counts the parsed SLI/SLO dashboards by result
+	DashboardParses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dashboard_parses_total",
+		Help:      "Number of parsed SLI/SLO dashboards by result",
+	}, []string{"result"})
+
+	// WaitForDataSeconds observes how long an evaluation waited for Dynatrace to process the data of the evaluation timeframe
+	WaitForDataSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "wait_for_data_seconds",
+		Help:      "Time an evaluation waited for Dynatrace to process the data of the evaluation timeframe",
+		Buckets:   []float64{0, 10, 30, 60, 90, 120, 180, 300},
+	})
+)
+
+func init() {
+	prometheus.MustRegister(EventsHandled, DynatraceAPIRequests, DynatraceAPIRequestDuration, DynatraceAPIRetries,
+		IndicatorFailures, DashboardParses, WaitForDataSeconds)
+}
+
+// MetricsHandler returns the http handler that exposes the Prometheus metrics
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// GetDynatraceAPIEndpoint returns the endpoint label of a Dynatrace API url, e.g: /api/config/v1/dashboards for /api/config/v1/dashboards/<id>
+func GetDynatraceAPIEndpoint(requestURL *url.URL) string {
+	if requestURL == nil {
+		return "unknown"
+	}
+	path := strings.TrimSuffix(requestURL.Path, "/")
+	for _, endpoint := range dynatraceAPIEndpoints {
+		if path == endpoint || strings.HasPrefix(path, endpoint+"/") {
+			return endpoint
+		}
+	}
+	return "other"
+}
+
+// ObserveDynatraceAPIRequest records a Dynatrace API call - resp is nil if the call didn't return a response
+func ObserveDynatraceAPIRequest(endpoint string, resp *http.Response, duration time.Duration) {
+	code := 0
+	if resp != nil {
+		code = resp.StatusCode
+	}
+	DynatraceAPIRequests.WithLabelValues(endpoint, strconv.Itoa(code)).Inc()
+	DynatraceAPIRequestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+}
@@ -0,0 +1,213 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	keptnmodels "github.com/keptn/go-utils/pkg/api/models"
+	keptnapi "github.com/keptn/go-utils/pkg/api/utils"
+)
+
+/**
+ * ResourceStore loads and stores the resources of a project, stage and service, e.g: dynatrace/sli.yaml or slo.yaml
+ * The service uses the Keptn configuration-service, ENV=local a local directory and tests an in-memory store
+ */
+type ResourceStore interface {
+	// GetResource returns the content of a resource - "" if it doesn't exist. An error means the resource couldn't be loaded
+	GetResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, logger *Logger) (string, error)
+	// UploadResource creates or updates a resource on service level
+	UploadResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, content []byte, logger *Logger) error
+}
+
+// ConfigurationServiceStore loads and stores resources with the Keptn configuration-service
+type ConfigurationServiceStore struct {
+	// URL of the configuration-service, e.g: configuration-service:8080
+	URL string
+}
+
+// NewConfigurationServiceStore returns a resource store for the configuration-service at the passed URL - see GetConfigurationServiceURL
+func NewConfigurationServiceStore(url string) *ConfigurationServiceStore {
+	return &ConfigurationServiceStore{URL: url}
+}
+
+// GetResource looks up the resource on service level, then on stage and then on project level
+func (s *ConfigurationServiceStore) GetResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, logger *Logger) (string, error) {
+	resourceHandler := s.newResourceHandler(ctx)
+
+	levels := []struct {
+		name        string
+		getResource func() (*keptnmodels.Resource, error)
+	}{
+		{"service", func() (*keptnmodels.Resource, error) {
+			return resourceHandler.GetServiceResource(keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service, resourceURI)
+		}},
+		{"stage", func() (*keptnmodels.Resource, error) {
+			return resourceHandler.GetStageResource(keptnEvent.Project, keptnEvent.Stage, resourceURI)
+		}},
+		{"project", func() (*keptnmodels.Resource, error) {
+			return resourceHandler.GetProjectResource(keptnEvent.Project, resourceURI)
+		}},
+	}
+	for _, level := range levels {
+		keptnResourceContent, err := level.getResource()
+		if err == keptnapi.ResourceNotFoundError {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not load %s on %s level from the configuration-service: %v", resourceURI, level.name, err)
+		}
+		if keptnResourceContent == nil || keptnResourceContent.ResourceContent == "" {
+			continue
+		}
+		logger.Debug("Found " + resourceURI + " on " + level.name + " level")
+		return keptnResourceContent.ResourceContent, nil
+	}
+	return "", nil
+}
+
+// UploadResource uploads the resource on service level
+func (s *ConfigurationServiceStore) UploadResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, content []byte, logger *Logger) error {
+	resourceHandler := s.newResourceHandler(ctx)
+
+	resources := []*keptnmodels.Resource{{ResourceContent: string(content), ResourceURI: &resourceURI}}
+	_, err := resourceHandler.CreateResources(keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service, resources)
+	if err != nil {
+		return fmt.Errorf("Couldnt upload remote resource %s: %s", resourceURI, *err.Message)
+	}
+
+	logger.Infof("Uploaded file %s", resourceURI)
+	return nil
+}
+
+// newResourceHandler returns a resource handler whose requests are canceled with ctx - the keptn api utils don't take a context
+func (s *ConfigurationServiceStore) newResourceHandler(ctx context.Context) *keptnapi.ResourceHandler {
+	resourceHandler := keptnapi.NewResourceHandler(s.URL)
+	if ctx != nil {
+		resourceHandler.HTTPClient.Transport = &contextTransport{ctx: ctx, transport: resourceHandler.HTTPClient.Transport}
+	}
+	return resourceHandler
+}
+
+// contextTransport adds a context to every request
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(req.WithContext(t.ctx))
+}
+
+// LocalStore loads and stores resources in a local directory, e.g: <dir>/dynatrace/sli.yaml - project, stage and service are ignored
+type LocalStore struct {
+	Dir string
+}
+
+// NewLocalStore returns a resource store for the passed directory
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{Dir: dir}
+}
+
+// GetResource reads the resource from the directory
+func (s *LocalStore) GetResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, logger *Logger) (string, error) {
+	file := filepath.Join(s.Dir, filepath.FromSlash(resourceURI))
+	localFileContent, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		logger.Infof("No %s file found LOCALLY for service %s in stage %s in project %s", file, keptnEvent.Service, keptnEvent.Stage, keptnEvent.Project)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	logger.Info("Loaded LOCAL file " + file)
+	return string(localFileContent), nil
+}
+
+// UploadResource writes the resource to the directory
+func (s *LocalStore) UploadResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, content []byte, logger *Logger) error {
+	file := filepath.Join(s.Dir, filepath.FromSlash(resourceURI))
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return fmt.Errorf("Couldnt create local directory for %s: %v", file, err)
+	}
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		return fmt.Errorf("Couldnt write local file %s: %v", file, err)
+	}
+	logger.Info("Local file written " + file)
+	return nil
+}
+
+// MemoryStore keeps resources in memory, e.g: for tests - project, stage and service are ignored
+type MemoryStore struct {
+	mutex     sync.Mutex
+	resources map[string]string
+}
+
+// NewMemoryStore returns an in-memory resource store with the passed resources keyed by resource URI - resources may be nil
+func NewMemoryStore(resources map[string]string) *MemoryStore {
+	store := &MemoryStore{resources: map[string]string{}}
+	for resourceURI, content := range resources {
+		store.resources[resourceURI] = content
+	}
+	return store
+}
+
+// GetResource returns the resource
+func (s *MemoryStore) GetResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, logger *Logger) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.resources[resourceURI], nil
+}
+
+// UploadResource stores the resource
+func (s *MemoryStore) UploadResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, content []byte, logger *Logger) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.resources[resourceURI] = string(content)
+	return nil
+}
+
+// Resources returns a copy of all resources keyed by resource URI
+func (s *MemoryStore) Resources() map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	resources := make(map[string]string, len(s.resources))
+	for resourceURI, content := range s.resources {
+		resources[resourceURI] = content
+	}
+	return resources
+}
+
+/**
+ * SplitStore loads resources from one store and stores them in another one
+ * ENV=localtest uses it to evaluate with the resources of the configuration-service without changing them
+ */
+type SplitStore struct {
+	Reader ResourceStore
+	Writer ResourceStore
+}
+
+// NewSplitStore returns a resource store that loads resources from reader and stores them in writer
+func NewSplitStore(reader ResourceStore, writer ResourceStore) *SplitStore {
+	return &SplitStore{Reader: reader, Writer: writer}
+}
+
+// GetResource loads the resource from the reader
+func (s *SplitStore) GetResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, logger *Logger) (string, error) {
+	return s.Reader.GetResource(ctx, keptnEvent, resourceURI, logger)
+}
+
+// UploadResource stores the resource in the writer
+func (s *SplitStore) UploadResource(ctx context.Context, keptnEvent *BaseKeptnEvent, resourceURI string, content []byte, logger *Logger) error {
+	return s.Writer.UploadResource(ctx, keptnEvent, resourceURI, content, logger)
+}
@@ -0,0 +1,129 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+/**
+ * Templating of SLI queries
+ *
+ * Besides the $ placeholders (see ReplaceKeptnPlaceholders) a query can use Go templates, e.g:
+ *   entitySelector=type(SERVICE),tag({{ label "dttag" | default "prod" }})
+ *   {{ if eq .DeploymentStrategy "blue_green_service" }}tag(keptn_deployment:primary){{ end }}
+ * Any query containing {{ is treated as a template
+ */
+
+// unresolvedPlaceholderRegex matches placeholders that could not be replaced, e.g: $LABEL.foo
+var unresolvedPlaceholderRegex = regexp.MustCompile(`\$(LABEL|ENV|FILTER)\.[A-Za-z0-9_\-]+`)
+
+// QueryTemplateData is the data that is available within an SLI query template, e.g: {{ .Project }}
+type QueryTemplateData struct {
+	Context            string
+	Event              string
+	Source             string
+	Project            string
+	Stage              string
+	Service            string
+	Deployment         string
+	TestStrategy       string
+	DeploymentStrategy string
+	Labels             map[string]string
+	Filters            map[string]string
+}
+
+// IsQueryTemplate returns whether the passed query uses the template syntax
+func IsQueryTemplate(query string) bool {
+	return strings.Contains(query, "{{")
+}
+
+/**
+ * ExpandQueryTemplate executes a query template. Values are inserted as they are - use urlencode to escape them
+ * Available functions:
+ *   label "name"    -> value of the label or unresolved $LABEL.name
+ *   env "name"      -> value of the environment variable or unresolved $ENV.name
+ *   filter "name"   -> value of the custom filter or unresolved $FILTER.name
+ *   default "value" -> replaces an empty or unresolved value in a pipeline, e.g: {{ label "dttag" | default "prod" }}
+ *   urlencode, pathescape, lower, upper
+ */
+func ExpandQueryTemplate(query string, keptnEvent *BaseKeptnEvent, filters map[string]string, logger *Logger) (string, error) {
+	data := QueryTemplateData{
+		Context:            keptnEvent.Context,
+		Event:              keptnEvent.Event,
+		Source:             keptnEvent.Source,
+		Project:            keptnEvent.Project,
+		Stage:              keptnEvent.Stage,
+		Service:            keptnEvent.Service,
+		Deployment:         keptnEvent.Deployment,
+		TestStrategy:       keptnEvent.TestStrategy,
+		DeploymentStrategy: keptnEvent.DeploymentStrategy,
+		Labels:             keptnEvent.Labels,
+		Filters:            filters,
+	}
+
+	funcs := template.FuncMap{
+		"label": func(name string) string {
+			if value, ok := keptnEvent.Labels[name]; ok {
+				return value
+			}
+			return "$LABEL." + name
+		},
+		"env": func(name string) string {
+			if value, ok := os.LookupEnv(name); ok {
+				logger.registerEnvSecret(value)
+				return value
+			}
+			return "$ENV." + name
+		},
+		"filter": func(name string) string {
+			if value, ok := filters[name]; ok {
+				return value
+			}
+			return "$FILTER." + name
+		},
+		"default": func(defaultValue string, value string) string {
+			if value == "" || unresolvedPlaceholderRegex.MatchString(value) {
+				return defaultValue
+			}
+			return value
+		},
+		"urlencode":  url.QueryEscape,
+		"pathescape": url.PathEscape,
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+	}
+
+	tmpl, err := template.New("query").Funcs(funcs).Option("missingkey=zero").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("could not parse query template: %v", err)
+	}
+
+	var result bytes.Buffer
+	err = tmpl.Execute(&result, data)
+	if err != nil {
+		return "", fmt.Errorf("could not execute query template: %v", err)
+	}
+
+	return result.String(), nil
+}
+
+// FindUnresolvedPlaceholders returns all placeholders such as $LABEL.foo that are still part of the passed query
+func FindUnresolvedPlaceholders(query string) []string {
+	found := map[string]bool{}
+	for _, placeholder := range unresolvedPlaceholderRegex.FindAllString(query, -1) {
+		found[placeholder] = true
+	}
+
+	placeholders := []string{}
+	for placeholder := range found {
+		placeholders = append(placeholders, placeholder)
+	}
+	sort.Strings(placeholders)
+	return placeholders
+}
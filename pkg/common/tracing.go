@@ -0,0 +1,122 @@
+package common
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/label"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer all spans of the dynatrace-sli-service are created with
+const TracerName = "github.com/keptn-contrib/dynatrace-sli-service"
+
+// Environment variables that configure the OTLP exporter - spans are only exported if an endpoint is configured
+const otlpEndpointEnv = "OTEL_EXPORTER_OTLP_ENDPOINT"
+const otlpInsecureEnv = "OTEL_EXPORTER_OTLP_INSECURE"
+
+// Span attributes
+const SpanAttributeContext = label.Key("keptn.context")
+const SpanAttributeProject = label.Key("keptn.project")
+const SpanAttributeStage = label.Key("keptn.stage")
+const SpanAttributeService = label.Key("keptn.service")
+const SpanAttributeIndicator = label.Key("keptn.indicator")
+const SpanAttributeResourceURI = label.Key("keptn.resource_uri")
+const SpanAttributeEndpoint = label.Key("dynatrace.endpoint")
+const SpanAttributeMetricID = label.Key("dynatrace.metric_id")
+
+/**
+ * InitTracing sets up the W3C trace context propagation and - if OTEL_EXPORTER_OTLP_ENDPOINT is set - the export of all spans via OTLP
+ * Returns a function that flushes and stops the exporter
+ */
+func InitTracing() (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	endpoint := os.Getenv(otlpEndpointEnv)
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options := []otlp.ExporterOption{otlp.WithAddress(endpoint)}
+	if insecure, _ := strconv.ParseBool(os.Getenv(otlpInsecureEnv)); insecure {
+		options = append(options, otlp.WithInsecure())
+	}
+	exporter, err := otlp.NewExporter(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(ServiceName))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+
+	return func(ctx context.Context) error {
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			return err
+		}
+		return exporter.Shutdown(ctx)
+	}, nil
+}
+
+// ExtractTraceContext returns a context that continues the trace of the passed traceparent and tracestate, e.g: of a CloudEvent
+func ExtractTraceContext(ctx context.Context, traceparent string, tracestate string) context.Context {
+	carrier := mapCarrier{}
+	if traceparent != "" {
+		carrier["traceparent"] = traceparent
+	}
+	if tracestate != "" {
+		carrier["tracestate"] = tracestate
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// mapCarrier adapts a map to propagation.TextMapCarrier
+type mapCarrier map[string]string
+
+func (c mapCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c mapCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+// StartSpan starts a span as child of the span in ctx
+func StartSpan(ctx context.Context, name string, attributes ...label.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// EndSpan records the error - if any - and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// EventSpanAttributes returns the span attributes of a Keptn event
+func EventSpanAttributes(keptnEvent *BaseKeptnEvent) []label.KeyValue {
+	if keptnEvent == nil {
+		return nil
+	}
+	return []label.KeyValue{
+		SpanAttributeContext.String(keptnEvent.Context),
+		SpanAttributeProject.String(keptnEvent.Project),
+		SpanAttributeStage.String(keptnEvent.Stage),
+		SpanAttributeService.String(keptnEvent.Service),
+	}
+}
@@ -0,0 +1,201 @@
+package dynatrace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+)
+
+/**
+ * Indicators that compare a service against the baseline Dynatrace automatically learned for it
+ * Format: BASELINE;<METRIC>;<MODE>[;<entitySelector>]
+ *   METRIC: RESPONSE_TIME_P50, RESPONSE_TIME_P90 or FAILURE_RATE
+ *   MODE: ratio (measured / baseline) or deviation (measured - baseline, in ms respectively percentage points)
+ *   entitySelector: optional - has to match exactly one service. Defaults to the entitySelector built from dynatrace.conf.yaml
+ * Example: BASELINE;RESPONSE_TIME_P90;ratio;type(SERVICE),tag(keptn_service:$SERVICE)
+ */
+const AutoBaselinePrefix = "BASELINE;"
+
+const AutoBaselineResponseTimeP50 = "RESPONSE_TIME_P50"
+const AutoBaselineResponseTimeP90 = "RESPONSE_TIME_P90"
+const AutoBaselineFailureRate = "FAILURE_RATE"
+
+const AutoBaselineModeRatio = "ratio"
+const AutoBaselineModeDeviation = "deviation"
+
+// autoBaselineMetricSelectors are the metrics that are compared against the baseline of the same name
+var autoBaselineMetricSelectors = map[string]string{
+	AutoBaselineResponseTimeP50: "builtin:service.response.time:merge(0):percentile(50)",
+	AutoBaselineResponseTimeP90: "builtin:service.response.time:merge(0):percentile(90)",
+	AutoBaselineFailureRate:     "builtin:service.errors.total.rate:merge(0):avg",
+}
+
+// DTEntitiesResult is struct for /api/v2/entities
+type DTEntitiesResult struct {
+	TotalCount  int    `json:"totalCount"`
+	NextPageKey string `json:"nextPageKey"`
+	Entities    []struct {
+		EntityID    string `json:"entityId"`
+		DisplayName string `json:"displayName"`
+	} `json:"entities"`
+}
+
+/**
+ * DTServiceBaseline is struct for /api/v1/entity/services/<id>/baseline
+ * Response times are in microseconds like builtin:service.response.time, the failure rate in percent (0-100) like
+ * builtin:service.errors.total.rate - so a failure rate deviation is in percentage points
+ */
+type DTServiceBaseline struct {
+	EntityID                string  `json:"entityId"`
+	DisplayName             string  `json:"displayName"`
+	ResponseTimeP50Baseline float64 `json:"responseTimeMedianBaseline"`
+	ResponseTimeP90Baseline float64 `json:"responseTimeP90Baseline"`
+	FailureRateBaseline     float64 `json:"failureRateBaseline"`
+}
+
+/**
+ * ExecuteEntitiesQuery
+ * Calls the /api/v2/entities API call and returns all entities that match the entitySelector in the timeframe
+ */
+func (ph *Handler) ExecuteEntitiesQuery(ctx context.Context, entitySelector string, startUnix time.Time, endUnix time.Time) (*DTEntitiesResult, error) {
+	queryParams := url.Values{}
+	queryParams.Add("entitySelector", entitySelector)
+	queryParams.Add("from", common.TimestampToString(startUnix))
+	queryParams.Add("to", common.TimestampToString(endUnix))
+	targetURL := ph.ApiURL + "/api/v2/entities?" + queryParams.Encode()
+
+	resp, body, err := ph.executeDynatraceREST(ctx, "GET", targetURL, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.StatusCode != 200 {
+		return nil, ph.dynatraceAPIError(resp, body)
+	}
+
+	// parse response json
+	var result DTEntitiesResult
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+/**
+ * ExecuteServiceBaselineQuery
+ * Calls the /api/v1/entity/services/<id>/baseline API call to retrieve the baselines Dynatrace learned for the service in the timeframe
+ */
+func (ph *Handler) ExecuteServiceBaselineQuery(ctx context.Context, serviceID string, startUnix time.Time, endUnix time.Time) (*DTServiceBaseline, error) {
+	queryParams := url.Values{}
+	queryParams.Add("startTimestamp", common.TimestampToString(startUnix))
+	queryParams.Add("endTimestamp", common.TimestampToString(endUnix))
+	targetURL := ph.ApiURL + fmt.Sprintf("/api/v1/entity/services/%s/baseline?", url.PathEscape(serviceID)) + queryParams.Encode()
+
+	resp, body, err := ph.executeDynatraceREST(ctx, "GET", targetURL, map[string]string{"Content-Type": "application/json"})
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.StatusCode != 200 {
+		return nil, ph.dynatraceAPIError(resp, body)
+	}
+
+	// parse response json
+	var result DTServiceBaseline
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// dynatraceAPIError returns the error message of a failed Dynatrace API call
+func (ph *Handler) dynatraceAPIError(resp *http.Response, body []byte) error {
+	if resp == nil {
+		return fmt.Errorf("No valid response from Dynatrace API!")
+	}
+	dtMetricsErr := &DtMetricsAPIError{}
+	err := json.Unmarshal(body, dtMetricsErr)
+	if err == nil && dtMetricsErr.Error.Message != "" {
+		return fmt.Errorf("Dynatrace API returned status code %d: %s", dtMetricsErr.Error.Code, dtMetricsErr.Error.Message)
+	}
+	return fmt.Errorf("Dynatrace API returned status code %d", resp.StatusCode)
+}
+
+/**
+ * queryAutoBaselineValue returns the ratio or deviation of a service metric compared to its Dynatrace baseline
+ */
+func (ph *Handler) queryAutoBaselineValue(ctx context.Context, metric string, query string, startUnix time.Time, endUnix time.Time) (float64, error) {
+	querySplits := strings.SplitN(strings.TrimPrefix(query, AutoBaselinePrefix), ";", 3)
+	if len(querySplits) < 2 {
+		return 0, fmt.Errorf("BASELINE Query incorrect format: %s", query)
+	}
+
+	baselineMetric := querySplits[0]
+	mode := querySplits[1]
+	metricSelector, ok := autoBaselineMetricSelectors[baselineMetric]
+	if !ok {
+		return 0, fmt.Errorf("unsupported baseline metric %s, expected %s, %s or %s", baselineMetric, AutoBaselineResponseTimeP50, AutoBaselineResponseTimeP90, AutoBaselineFailureRate)
+	}
+	if mode != AutoBaselineModeRatio && mode != AutoBaselineModeDeviation {
+		return 0, fmt.Errorf("unsupported baseline mode %s, expected %s or %s", mode, AutoBaselineModeRatio, AutoBaselineModeDeviation)
+	}
+
+	entitySelector := ph.getDefaultEntitySelector()
+	if len(querySplits) == 3 && querySplits[2] != "" {
+		entitySelector = querySplits[2]
+	}
+	entitySelector, err := ph.replaceQueryParameters(entitySelector)
+	if err != nil {
+		return 0, err
+	}
+
+	// the baseline is learned per service - so the entitySelector has to match exactly one service
+	entities, err := ph.ExecuteEntitiesQuery(ctx, entitySelector, startUnix, endUnix)
+	if err != nil {
+		return 0, fmt.Errorf("Error querying services for %s: %v", entitySelector, err)
+	}
+	if len(entities.Entities) != 1 {
+		return 0, fmt.Errorf("entitySelector %s matches %d services, expected exactly 1 to compare against its baseline", entitySelector, len(entities.Entities))
+	}
+
+	// the baseline is queried for the evaluation timeframe - not the current one
+	baseline, err := ph.ExecuteServiceBaselineQuery(ctx, entities.Entities[0].EntityID, startUnix, endUnix)
+	if err != nil {
+		return 0, fmt.Errorf("Error querying baseline of %s: %v", entities.Entities[0].EntityID, err)
+	}
+
+	var baselineValue float64
+	switch baselineMetric {
+	case AutoBaselineResponseTimeP50:
+		baselineValue = scaleData("", "MicroSecond", baseline.ResponseTimeP50Baseline)
+	case AutoBaselineResponseTimeP90:
+		baselineValue = scaleData("", "MicroSecond", baseline.ResponseTimeP90Baseline)
+	case AutoBaselineFailureRate:
+		baselineValue = baseline.FailureRateBaseline
+	}
+
+	// the measured value is queried for the same entity the baseline belongs to
+	metricsQuery := fmt.Sprintf("metricSelector=%s&entitySelector=entityId(%s)", metricSelector, entities.Entities[0].EntityID)
+	measuredValue, err := ph.querySLIValue(ctx, metric, metricsQuery, startUnix, endUnix)
+	if err != nil {
+		return 0, err
+	}
+
+	ph.Logger.Debugf("%s of %s: measured %f, baseline %f", baselineMetric, entities.Entities[0].EntityID, measuredValue, baselineValue)
+
+	if mode == AutoBaselineModeDeviation {
+		return measuredValue - baselineValue, nil
+	}
+	if baselineValue == 0 {
+		return 0, fmt.Errorf("baseline %s of %s is 0, can't calculate the ratio", baselineMetric, entities.Entities[0].EntityID)
+	}
+	return measuredValue / baselineValue, nil
+}
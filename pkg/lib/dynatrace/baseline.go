@@ -0,0 +1,154 @@
+package dynatrace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	keptnevents "github.com/keptn/go-utils/pkg/lib"
+)
+
+/**
+ * Parameters that additionally query an indicator for a reference timeframe, e.g:
+ *   response_time_p95: "metricSelector=builtin:service.response.time:merge(0):percentile(95)&entitySelector=type(SERVICE)&baseline=-1w&baselineMode=change"
+ * baseline: either a shift of the evaluation timeframe (e.g: -1d, -1w) or a fixed range of unix timestamps in ms (e.g: 1592210000000:1592210600000)
+ * baselineMode: value (default) adds <sli>_baseline, change adds <sli>_change with the relative change in percent, both adds both
+ */
+const baselineParameter = "baseline"
+const baselineModeParameter = "baselineMode"
+
+const BaselineModeValue = "value"
+const BaselineModeChange = "change"
+const BaselineModeBoth = "both"
+
+const BaselineIndicatorSuffix = "_baseline"
+const ChangeIndicatorSuffix = "_change"
+
+// TimedOutMessagePrefix starts the message of every indicator that could not be retrieved before the evaluation deadline
+const TimedOutMessagePrefix = "timed out: "
+
+/**
+ * parseBaselineTimeframe returns the reference timeframe for the value of the baseline parameter
+ */
+func parseBaselineTimeframe(baseline string, startUnix time.Time, endUnix time.Time) (time.Time, time.Time, error) {
+	if rangeSplit := strings.SplitN(baseline, ":", 2); len(rangeSplit) == 2 {
+		baselineStart, err := parseTimeExpression(rangeSplit[0], startUnix, endUnix)
+		if err != nil {
+			return startUnix, endUnix, fmt.Errorf("invalid baseline %s: %v", baseline, err)
+		}
+		baselineEnd, err := parseTimeExpression(rangeSplit[1], startUnix, endUnix)
+		if err != nil {
+			return startUnix, endUnix, fmt.Errorf("invalid baseline %s: %v", baseline, err)
+		}
+		if !baselineStart.Before(baselineEnd) {
+			return startUnix, endUnix, fmt.Errorf("invalid baseline %s: start is not before end", baseline)
+		}
+		return baselineStart, baselineEnd, nil
+	}
+
+	shift, err := ParseRelativeDuration(baseline)
+	if err != nil {
+		return startUnix, endUnix, fmt.Errorf("invalid baseline %s: %v", baseline, err)
+	}
+	if shift >= 0 {
+		return startUnix, endUnix, fmt.Errorf("invalid baseline %s: the baseline has to be in the past, e.g: -1d", baseline)
+	}
+	return startUnix.Add(shift), endUnix.Add(shift), nil
+}
+
+/**
+ * GetSLIResults queries a single indicator and returns its SLIResult
+ * If the query of the indicator contains a baseline parameter the indicator is also queried for the reference timeframe
+ * and <sli>_baseline and/or <sli>_change are added to the returned results
+ */
+func (ph *Handler) GetSLIResults(ctx context.Context, metric string, startUnix time.Time, endUnix time.Time) []*keptnevents.SLIResult {
+	logger := ph.Logger.WithIndicator(metric)
+	metricsQuery, err := ph.getTimeseriesConfig(metric)
+	if err != nil {
+		err = fmt.Errorf("Error when fetching SLI config for %s %s\n", metric, err.Error())
+		logger.Error(err.Error())
+		return []*keptnevents.SLIResult{ph.newFailedSLIResult(ctx, metric, common.IndicatorFailureDefinition, err)}
+	}
+	logger.Debugf("Retrieved SLI config for %s: %s", metric, metricsQuery)
+
+	metricsQuery, parameters := extractQueryParameters(metricsQuery, baselineParameter, baselineModeParameter)
+
+	value, err := ph.querySLIValue(ctx, metric, metricsQuery, startUnix, endUnix)
+	if err != nil {
+		logger.Error(err.Error())
+		return []*keptnevents.SLIResult{ph.newFailedSLIResult(ctx, metric, common.IndicatorFailureQuery, err)}
+	}
+	sliResults := []*keptnevents.SLIResult{{Metric: metric, Value: value, Success: true}}
+
+	baseline, ok := parameters[baselineParameter]
+	if !ok {
+		return sliResults
+	}
+
+	mode := parameters[baselineModeParameter]
+	if mode == "" {
+		mode = BaselineModeValue
+	}
+	if mode != BaselineModeValue && mode != BaselineModeChange && mode != BaselineModeBoth {
+		err = fmt.Errorf("invalid baselineMode %s, expected %s, %s or %s", mode, BaselineModeValue, BaselineModeChange, BaselineModeBoth)
+		return append(sliResults, ph.newFailedSLIResult(ctx, metric+BaselineIndicatorSuffix, common.IndicatorFailureBaseline, err))
+	}
+
+	baselineValue := 0.0
+	baselineStart, baselineEnd, err := parseBaselineTimeframe(baseline, startUnix, endUnix)
+	if err == nil {
+		logger.Debugf("Querying baseline of %s from %s to %s", metric, baselineStart.UTC().Format(time.RFC3339), baselineEnd.UTC().Format(time.RFC3339))
+		baselineValue, err = ph.querySLIValue(ctx, metric+BaselineIndicatorSuffix, metricsQuery, baselineStart, baselineEnd)
+	}
+	if err != nil {
+		logger.Error(err.Error())
+	}
+
+	if mode == BaselineModeValue || mode == BaselineModeBoth {
+		if err != nil {
+			sliResults = append(sliResults, ph.newFailedSLIResult(ctx, metric+BaselineIndicatorSuffix, common.IndicatorFailureBaseline, err))
+		} else {
+			sliResults = append(sliResults, &keptnevents.SLIResult{Metric: metric + BaselineIndicatorSuffix, Value: baselineValue, Success: true})
+		}
+	}
+
+	if mode == BaselineModeChange || mode == BaselineModeBoth {
+		if err == nil && baselineValue == 0 {
+			err = fmt.Errorf("baseline of %s is 0, can't calculate the relative change", metric)
+		}
+		change := 0.0
+		if err != nil {
+			sliResults = append(sliResults, ph.newFailedSLIResult(ctx, metric+ChangeIndicatorSuffix, common.IndicatorFailureBaseline, err))
+		} else {
+			change = (value - baselineValue) / baselineValue * 100
+			sliResults = append(sliResults, &keptnevents.SLIResult{Metric: metric + ChangeIndicatorSuffix, Value: change, Success: true})
+		}
+		changeReport := &IndicatorReport{Indicator: metric + ChangeIndicatorSuffix, Type: IndicatorTypeChange, Query: metricsQuery, Unit: "Percent"}
+		changeReport.setResult(change, err)
+		ph.addIndicatorReport(changeReport)
+	}
+
+	return sliResults
+}
+
+/**
+ * newFailedSLIResult returns an SLIResult for an indicator that could not be queried and counts the failure by reason
+ * If the evaluation deadline was exceeded a failed query is marked as timed out - a missing definition is reported as is
+ */
+func (ph *Handler) newFailedSLIResult(ctx context.Context, metric string, reason string, err error) *keptnevents.SLIResult {
+	// the message is sent with the event - it must not contain secrets, e.g: of a query in an error
+	message := ph.Logger.Redact(err.Error())
+	if reason != common.IndicatorFailureDefinition && timedOut(ctx) {
+		reason = common.IndicatorFailureTimeout
+		message = TimedOutMessagePrefix + message
+	}
+	common.IndicatorFailures.WithLabelValues(reason).Inc()
+	return &keptnevents.SLIResult{
+		Metric:  metric,
+		Value:   0,
+		Success: false, // Mark as failure
+		Message: message,
+	}
+}
@@ -0,0 +1,231 @@
+package dynatrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// CassetteFilename is the name of the uploaded cassette, e.g: dynatrace/results/<shkeptncontext>/cassette.json
+const CassetteFilename = "cassette.json"
+
+// CassetteLabel is the label that holds the resource URI of the uploaded cassette
+const CassetteLabel = "API Cassette"
+
+// timeframeParameters are ignored if no interaction matches a replayed request exactly, e.g: for a relative timeframe
+var timeframeParameters = []string{"from", "to", "startTimestamp", "endTimestamp"}
+
+// responseHeaders are the response headers that are recorded
+var responseHeaders = []string{"Content-Type", "Retry-After"}
+
+/**
+ * Cassette records the calls of a Handler to the Dynatrace API (see Handler.Cassette) or replays them
+ * Recorded URIs are relative to the tenant. The API token is never recorded - neither the Authorization header
+ * nor an Api-Token query parameter - and registered secrets are redacted from URIs and bodies
+ */
+type Cassette struct {
+	// Metadata describes the recorded evaluation, e.g: project, stage, service, start and end
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Interactions []*Interaction    `json:"interactions"`
+
+	replaying bool
+	mutex     sync.Mutex
+}
+
+// Interaction is a single recorded call to the Dynatrace API - retries are recorded as separate interactions
+type Interaction struct {
+	Method      string `json:"method"`
+	URI         string `json:"uri"`
+	RequestBody string `json:"requestBody,omitempty"`
+	// Error is set if the call didn't return a response, e.g: a timeout
+	Error      string            `json:"error,omitempty"`
+	StatusCode int               `json:"statusCode,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	// ResponseBody holds JSON responses as they are, ResponseText all other responses
+	ResponseBody json.RawMessage `json:"responseBody,omitempty"`
+	ResponseText string          `json:"responseText,omitempty"`
+
+	replayed bool
+}
+
+// NewRecordingCassette returns an empty cassette that records all calls of the handler it is set on
+func NewRecordingCassette(metadata map[string]string) *Cassette {
+	return &Cassette{Metadata: metadata, Interactions: []*Interaction{}}
+}
+
+// LoadCassette loads a recorded cassette from a file - the handler it is set on replays the recorded calls instead of calling Dynatrace
+func LoadCassette(file string) (*Cassette, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCassette(content)
+}
+
+// ParseCassette parses a recorded cassette for replay
+func ParseCassette(content []byte) (*Cassette, error) {
+	cassette := &Cassette{}
+	if err := json.Unmarshal(content, cassette); err != nil {
+		return nil, fmt.Errorf("invalid cassette: %v", err)
+	}
+	cassette.replaying = true
+	return cassette, nil
+}
+
+// Replaying returns true if the cassette replays recorded calls
+func (c *Cassette) Replaying() bool {
+	return c.replaying
+}
+
+// Marshal returns the cassette as indented JSON
+func (c *Cassette) Marshal() ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return json.MarshalIndent(c, "", "  ")
+}
+
+// Save writes the cassette to a file
+func (c *Cassette) Save(file string) error {
+	content, err := c.Marshal()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, content, 0644)
+}
+
+// record adds a call to the cassette - bodies and errors are redacted with the passed function
+func (c *Cassette) record(method string, uri string, requestBody []byte, resp *http.Response, body []byte, err error, redact func(string) string) {
+	interaction := &Interaction{
+		Method:      method,
+		URI:         uri,
+		RequestBody: redact(string(requestBody)),
+	}
+	if err != nil {
+		interaction.Error = redact(err.Error())
+	} else {
+		interaction.StatusCode = resp.StatusCode
+		for _, header := range responseHeaders {
+			if value := resp.Header.Get(header); value != "" {
+				if interaction.Headers == nil {
+					interaction.Headers = map[string]string{}
+				}
+				interaction.Headers[header] = value
+			}
+		}
+		redactedBody := redact(string(body))
+		if json.Valid([]byte(redactedBody)) {
+			interaction.ResponseBody = json.RawMessage(redactedBody)
+		} else {
+			interaction.ResponseText = redactedBody
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Interactions = append(c.Interactions, interaction)
+}
+
+/**
+ * replay returns the recorded response of a call. Recorded interactions are replayed in order, i.e: a retried call gets the
+ * response of the retry the second time. If no interaction matches exactly the timeframe parameters are ignored
+ * Calls that were not recorded get a 404
+ */
+func (c *Cassette) replay(method string, uri string, requestBody []byte, redact func(string) string) (*http.Response, []byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	redactedBody := redact(string(requestBody))
+	interaction := c.findInteraction(method, redactedBody, func(recordedURI string) bool { return recordedURI == uri })
+	if interaction == nil {
+		withoutTimeframe := removeTimeframeParameters(uri)
+		interaction = c.findInteraction(method, redactedBody, func(recordedURI string) bool {
+			return removeTimeframeParameters(recordedURI) == withoutTimeframe
+		})
+	}
+	if interaction == nil {
+		body := []byte(fmt.Sprintf(`{"error":{"code":404,"message":%q}}`, "no recorded interaction for "+method+" "+uri))
+		return newReplayedResponse(http.StatusNotFound, map[string]string{"Content-Type": "application/json"}, body), body, nil
+	}
+
+	interaction.replayed = true
+	if interaction.Error != "" {
+		return nil, nil, fmt.Errorf("%s", interaction.Error)
+	}
+	body := []byte(interaction.ResponseText)
+	if len(interaction.ResponseBody) > 0 {
+		body = interaction.ResponseBody
+	}
+	return newReplayedResponse(interaction.StatusCode, interaction.Headers, body), body, nil
+}
+
+// findInteraction returns the first matching interaction that wasn't replayed yet - or the last matching one if all were replayed
+func (c *Cassette) findInteraction(method string, requestBody string, matchesURI func(string) bool) *Interaction {
+	var lastMatch *Interaction
+	for _, interaction := range c.Interactions {
+		if interaction.Method != method || interaction.RequestBody != requestBody || !matchesURI(interaction.URI) {
+			continue
+		}
+		if !interaction.replayed {
+			return interaction
+		}
+		lastMatch = interaction
+	}
+	return lastMatch
+}
+
+func newReplayedResponse(statusCode int, headers map[string]string, body []byte) *http.Response {
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}
+	for name, value := range headers {
+		resp.Header.Set(name, value)
+	}
+	return resp
+}
+
+// removeTimeframeParameters removes from, to, startTimestamp and endTimestamp from a recorded URI
+func removeTimeframeParameters(uri string) string {
+	uriSplit := strings.SplitN(uri, "?", 2)
+	if len(uriSplit) == 1 {
+		return uri
+	}
+	query, err := url.ParseQuery(uriSplit[1])
+	if err != nil {
+		return uri
+	}
+	for _, parameter := range timeframeParameters {
+		query.Del(parameter)
+	}
+	return uriSplit[0] + "?" + query.Encode()
+}
+
+/**
+ * getCassetteURI returns the URI of a call relative to the tenant, e.g: /api/v2/metrics/query?metricSelector=...
+ * Query parameters are sorted, an Api-Token parameter in any case is removed and registered secrets are redacted
+ */
+func (ph *Handler) getCassetteURI(requestURL *url.URL) string {
+	path := requestURL.EscapedPath()
+	if apiURL, err := url.Parse(ph.ApiURL); err == nil && apiURL.Path != "" && apiURL.Path != "/" {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(apiURL.EscapedPath(), "/"))
+	}
+
+	query := requestURL.Query()
+	// Dynatrace accepts the token parameter in any case, e.g: api-token
+	for key := range query {
+		if strings.EqualFold(key, "Api-Token") {
+			query.Del(key)
+		}
+	}
+	if len(query) == 0 {
+		return ph.Logger.Redact(path)
+	}
+	return ph.Logger.Redact(path + "?" + query.Encode())
+}
@@ -1,8 +1,18 @@
 package dynatrace
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
 	"strconv"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"golang.org/x/net/http/httpproxy"
 )
 
 // IsHttpSSLVerificationEnabled returns whether the SSL verification is enabled or disabled
@@ -10,9 +20,77 @@ func IsHttpSSLVerificationEnabled() bool {
 	return readEnvAsBool("HTTP_SSL_VERIFY", true)
 }
 
+// GetHttpCABundleFile returns the path of a PEM file with CAs that are trusted for every tenant, e.g: a mounted ConfigMap
+func GetHttpCABundleFile() string {
+	return os.Getenv("HTTP_CA_BUNDLE")
+}
+
 func readEnvAsBool(env string, fallbackValue bool) bool {
 	if b, err := strconv.ParseBool(os.Getenv(env)); err == nil {
 		return b
 	}
 	return fallbackValue
 }
+
+/**
+ * NewHTTPTransport returns the transport used to call the tenant of the passed credentials
+ * Proxy: DT_PROXY and DT_NO_PROXY of the credentials - otherwise HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+ * CAs: the system CAs, the CAs in HTTP_CA_BUNDLE and DT_CA_CERT of the credentials
+ * Client certificate (mTLS): DT_CLIENT_CERT and DT_CLIENT_KEY of the credentials
+ */
+func NewHTTPTransport(credentials *common.DTCredentials) (*http.Transport, error) {
+	if credentials == nil {
+		credentials = &common.DTCredentials{}
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: !IsHttpSSLVerificationEnabled()}
+
+	caBundleFile := GetHttpCABundleFile()
+	if caBundleFile != "" || credentials.CACert != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if caBundleFile != "" {
+			caBundle, err := ioutil.ReadFile(caBundleFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read CA bundle %s: %v", caBundleFile, err)
+			}
+			if !rootCAs.AppendCertsFromPEM(caBundle) {
+				return nil, fmt.Errorf("CA bundle %s doesn't contain any PEM encoded certificate", caBundleFile)
+			}
+		}
+		if credentials.CACert != "" && !rootCAs.AppendCertsFromPEM([]byte(credentials.CACert)) {
+			return nil, errors.New("DT_CA_CERT doesn't contain any PEM encoded certificate")
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if credentials.ClientCert != "" || credentials.ClientKey != "" {
+		if credentials.ClientCert == "" || credentials.ClientKey == "" {
+			return nil, errors.New("DT_CLIENT_CERT and DT_CLIENT_KEY have to be specified together")
+		}
+		clientCert, err := tls.X509KeyPair([]byte(credentials.ClientCert), []byte(credentials.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	if credentials.Proxy != "" {
+		proxyFunc := (&httpproxy.Config{
+			HTTPProxy:  credentials.Proxy,
+			HTTPSProxy: credentials.Proxy,
+			NoProxy:    credentials.NoProxy,
+		}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+
+	return transport, nil
+}
@@ -0,0 +1,109 @@
+package dynatrace
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	keptnevents "github.com/keptn/go-utils/pkg/lib"
+)
+
+// newDashboardSLIAndSLO returns the sli.yaml and slo.yaml a dashboard is converted into - tiles add indicators and objectives, markdowns change the defaults
+func newDashboardSLIAndSLO() (*SLI, *keptnevents.ServiceLevelObjectives) {
+	dashboardSLI := &SLI{
+		SpecVersion: "0.1.4",
+		Indicators:  map[string]string{},
+	}
+	dashboardSLO := &keptnevents.ServiceLevelObjectives{
+		Objectives: []*keptnevents.SLO{},
+		TotalScore: &keptnevents.SLOScore{Pass: "90%", Warning: "75%"},
+		Comparison: &keptnevents.SLOComparison{CompareWith: "single_result", IncludeResultWithScore: "pass", NumberOfComparisonResults: 1, AggregateFunction: "avg"},
+	}
+	return dashboardSLI, dashboardSLO
+}
+
+// LoadDynatraceDashboard returns the dashboard with the passed ID - or for 'query' the KQG dashboard of project, stage and service. Returns nil if no dashboard was found
+func (ph *Handler) LoadDynatraceDashboard(ctx context.Context, keptnEvent *common.BaseKeptnEvent, dashboard string) (*DynatraceDashboard, error) {
+	dashboardJSON, _, err := ph.loadDynatraceDashboard(ctx, keptnEvent, dashboard)
+	return dashboardJSON, err
+}
+
+/**
+ * ConvertDashboardToSLIAndSLO generates sli.yaml and slo.yaml of a dashboard the same way QueryDynatraceDashboardForSLIs does - without querying any data
+ * Only the metric definitions of custom chart series are retrieved as the generated metricSelector depends on the dimensions of the metric
+ * A handler without tenant (empty ApiURL) converts offline - an error naming all custom charts is returned then as the sli.yaml would lack their indicators
+ * Tiles whose indicators depend on the queried data are reported as warnings:
+ * -- custom charts split by a dimension: an evaluation generates one indicator per dimension value, the conversion only the base indicator
+ * -- USQL tiles other than SINGLE_VALUE: an evaluation generates one indicator per dimension value, the conversion none
+ */
+func (ph *Handler) ConvertDashboardToSLIAndSLO(ctx context.Context, dashboardJSON *DynatraceDashboard) (*SLI, *keptnevents.ServiceLevelObjectives, []string, error) {
+	dashboardSLI, dashboardSLO := newDashboardSLIAndSLO()
+	var warnings []string
+	var offlineCustomCharts []string
+
+	dashboardManagementZoneFilter := ""
+	if dashboardJSON.DashboardMetadata.DashboardFilter != nil && dashboardJSON.DashboardMetadata.DashboardFilter.ManagementZone != nil {
+		dashboardManagementZoneFilter = fmt.Sprintf(",mzId(%s)", dashboardJSON.DashboardMetadata.DashboardFilter.ManagementZone.ID)
+	}
+
+	for _, tile := range dashboardJSON.Tiles {
+		if tile.TileType == "MARKDOWN" {
+			if strings.Contains(tile.Markdown, "KQG.") {
+				ParseMarkdownConfiguration(tile.Markdown, dashboardSLO)
+			}
+			continue
+		}
+
+		tileTitle := tile.FilterConfig.CustomName
+		if tileTitle == "" {
+			tileTitle = tile.CustomName
+		}
+		baseIndicatorName, passSLOs, warningSLOs, weight, keySli := ParsePassAndWarningFromString(tileTitle, []string{}, []string{})
+		if baseIndicatorName == "" {
+			continue
+		}
+		sloDefinition := &keptnevents.SLO{
+			SLI:     baseIndicatorName,
+			Weight:  weight,
+			KeySLI:  keySli,
+			Pass:    passSLOs,
+			Warning: warningSLOs,
+		}
+
+		switch tile.TileType {
+		case "CUSTOM_CHARTING":
+			if ph.ApiURL == "" {
+				offlineCustomCharts = append(offlineCustomCharts, fmt.Sprintf("%q", tileTitle))
+				continue
+			}
+			for _, series := range tile.FilterConfig.ChartConfig.Series {
+				metricDefinition, err := ph.ExecuteMetricAPIDescribe(ctx, series.Metric)
+				if err != nil {
+					warnings = append(warnings, fmt.Sprintf("tile %s: skipped as the definition of metric %s could not be retrieved: %v", tileTitle, series.Metric, err))
+					continue
+				}
+
+				chartQuery := ph.buildCustomChartQuery(tile, series, metricDefinition, dashboardManagementZoneFilter)
+				if chartQuery.filterSLIDefinitionAggregator != "" || chartQuery.entitySelectorSLIDefinition != "" {
+					warnings = append(warnings, fmt.Sprintf("tile %s: the chart is split by a dimension - an evaluation generates one indicator %s_<dimension value> per value instead of %s", tileTitle, baseIndicatorName, baseIndicatorName))
+				}
+
+				dashboardSLI.Indicators[baseIndicatorName] = fmt.Sprintf("MV2;%s;%s", metricDefinition.Unit, chartQuery.metricQuery)
+				dashboardSLO.Objectives = append(dashboardSLO.Objectives, sloDefinition)
+			}
+		case "DTAQL":
+			if tile.Type != "SINGLE_VALUE" {
+				warnings = append(warnings, fmt.Sprintf("tile %s: skipped as an evaluation generates one indicator %s_<dimension value> per value of the %s", tileTitle, baseIndicatorName, tile.Type))
+				continue
+			}
+			dashboardSLI.Indicators[baseIndicatorName] = fmt.Sprintf("USQL;%s;;%s", tile.Type, tile.Query)
+			dashboardSLO.Objectives = append(dashboardSLO.Objectives, sloDefinition)
+		}
+	}
+
+	if len(offlineCustomCharts) > 0 {
+		return nil, nil, warnings, fmt.Errorf("the custom charts %s can only be converted with a tenant as their queries depend on the metric definitions", strings.Join(offlineCustomCharts, ", "))
+	}
+	return dashboardSLI, dashboardSLO, warnings, nil
+}
@@ -0,0 +1,423 @@
+package dynatrace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	keptnevents "github.com/keptn/go-utils/pkg/lib"
+)
+
+// Layout of generated dashboards - the KQG markdown spans the first row, SLI tiles follow in rows of generatedTilesPerRow
+const generatedTileWidth = 380
+const generatedTileHeight = 228
+const generatedMarkdownHeight = 38
+const generatedTilesPerRow = 3
+
+// metricSelectorAggregations maps the aggregation transformations of a metricSelector to the aggregation of a chart series
+var metricSelectorAggregations = map[string]string{
+	"avg":   "AVG",
+	"min":   "MIN",
+	"max":   "MAX",
+	"sum":   "SUM",
+	"count": "COUNT",
+	"value": "VALUE",
+}
+
+// eventPlaceholderRegex matches placeholders whose values differ per evaluation - a dashboard is used for every evaluation of project, stage and service
+var eventPlaceholderRegex = regexp.MustCompile(`\$(LABEL|ENV|FILTER|SECRET)\.[A-Za-z0-9_\-]+|\$(DEPLOYMENT|TESTSTRATEGY|CONTEXT|EVENT|SOURCE)`)
+
+// GetKQGDashboardName returns the name under which the dashboard of project, stage and service is found with dashboard: query
+func GetKQGDashboardName(keptnEvent *common.BaseKeptnEvent) string {
+	return fmt.Sprintf("KQG;project=%s;service=%s;stage=%s", keptnEvent.Project, keptnEvent.Service, keptnEvent.Stage)
+}
+
+/**
+ * GenerateDashboard generates the KQG dashboard of an sli.yaml and slo.yaml - the reverse of ConvertDashboardToSLIAndSLO
+ * -- a markdown tile holds total score and comparison of the slo.yaml
+ * -- every objective becomes a tile titled <sli>;sli=<sli>;pass=..;warning=..;weight=..;key=.. - indicators without objective become informational tiles
+ * -- MV2 and metricSelector queries become custom charts, USQL SINGLE_VALUE queries become USQL tiles
+ * $PROJECT, $STAGE and $SERVICE are resolved for the handler's event. Queries that depend on the evaluation, e.g: on labels,
+ * environment variables or templates, are skipped - the dashboard is stored on the tenant and used for every evaluation
+ * Charts always merge all dimensions of a metric
+ * Indicators that can't be charted, e.g: metricSelectors with filter transformations or legacy timeseries queries, are skipped and reported as warnings
+ */
+func (ph *Handler) GenerateDashboard(sli *SLI, slo *keptnevents.ServiceLevelObjectives) (*DynatraceDashboard, []string) {
+	var warnings []string
+	dashboardJSON := &DynatraceDashboard{}
+	dashboardJSON.DashboardMetadata.Name = GetKQGDashboardName(ph.KeptnEvent)
+	dashboardJSON.DashboardMetadata.Shared = true
+	dashboardJSON.DashboardMetadata.Tags = []string{}
+	dashboardJSON.Tiles = []Tile{newMarkdownTile(buildKQGMarkdown(slo))}
+
+	objectives := []*keptnevents.SLO{}
+	definedObjectives := map[string]bool{}
+	if slo != nil {
+		for _, objective := range slo.Objectives {
+			if _, ok := sli.Indicators[objective.SLI]; !ok {
+				warnings = append(warnings, fmt.Sprintf("objective %s: skipped as the sli.yaml doesn't define its query", objective.SLI))
+				continue
+			}
+			objectives = append(objectives, objective)
+			definedObjectives[objective.SLI] = true
+		}
+	}
+
+	// indicators without objective are only informational
+	informational := []string{}
+	for indicator := range sli.Indicators {
+		if !definedObjectives[indicator] {
+			informational = append(informational, indicator)
+		}
+	}
+	sort.Strings(informational)
+	for _, indicator := range informational {
+		objectives = append(objectives, &keptnevents.SLO{SLI: indicator, Weight: 1})
+	}
+
+	for _, objective := range objectives {
+		tile, err := ph.buildSLITile(objective, sli.Indicators[objective.SLI])
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("indicator %s: skipped as %v", objective.SLI, err))
+			continue
+		}
+
+		tileIndex := len(dashboardJSON.Tiles) - 1
+		tile.Bounds.Top = generatedMarkdownHeight + (tileIndex/generatedTilesPerRow)*generatedTileHeight
+		tile.Bounds.Left = (tileIndex % generatedTilesPerRow) * generatedTileWidth
+		tile.Bounds.Width = generatedTileWidth
+		tile.Bounds.Height = generatedTileHeight
+		dashboardJSON.Tiles = append(dashboardJSON.Tiles, tile)
+	}
+
+	return dashboardJSON, warnings
+}
+
+// buildKQGMarkdown returns the markdown that ParseMarkdownConfiguration parses into total score and comparison of the slo.yaml
+func buildKQGMarkdown(slo *keptnevents.ServiceLevelObjectives) string {
+	_, defaultSLO := newDashboardSLIAndSLO()
+	totalScore := defaultSLO.TotalScore
+	comparison := defaultSLO.Comparison
+	if slo != nil && slo.TotalScore != nil {
+		if slo.TotalScore.Pass != "" {
+			totalScore.Pass = slo.TotalScore.Pass
+		}
+		if slo.TotalScore.Warning != "" {
+			totalScore.Warning = slo.TotalScore.Warning
+		}
+	}
+	if slo != nil && slo.Comparison != nil {
+		if slo.Comparison.IncludeResultWithScore != "" {
+			comparison.IncludeResultWithScore = slo.Comparison.IncludeResultWithScore
+		}
+		if slo.Comparison.NumberOfComparisonResults > 0 {
+			comparison.NumberOfComparisonResults = slo.Comparison.NumberOfComparisonResults
+		}
+		if slo.Comparison.AggregateFunction != "" {
+			comparison.AggregateFunction = slo.Comparison.AggregateFunction
+		}
+	}
+
+	return fmt.Sprintf("KQG.Total.Pass=%s;KQG.Total.Warning=%s;KQG.Compare.WithScore=%s;KQG.Compare.Results=%d;KQG.Compare.Function=%s",
+		totalScore.Pass, totalScore.Warning, comparison.IncludeResultWithScore, comparison.NumberOfComparisonResults, comparison.AggregateFunction)
+}
+
+func newMarkdownTile(markdown string) Tile {
+	tile := Tile{Name: "Markdown", TileType: "MARKDOWN", Configured: true, Markdown: markdown}
+	tile.Bounds.Width = generatedTilesPerRow * generatedTileWidth
+	tile.Bounds.Height = generatedMarkdownHeight
+	return tile
+}
+
+// buildTileTitle returns the tile title ParsePassAndWarningFromString parses into the objective, e.g: svc_rt_p95;sli=svc_rt_p95;pass=<+10%,<600;weight=1;key=false
+func buildTileTitle(objective *keptnevents.SLO) string {
+	title := fmt.Sprintf("%s;sli=%s", objective.SLI, objective.SLI)
+	for _, criteria := range objective.Pass {
+		title = title + ";pass=" + strings.Join(criteria.Criteria, ",")
+	}
+	for _, criteria := range objective.Warning {
+		title = title + ";warning=" + strings.Join(criteria.Criteria, ",")
+	}
+
+	weight := objective.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	return title + fmt.Sprintf(";weight=%d;key=%t", weight, objective.KeySLI)
+}
+
+// buildSLITile returns the custom chart or USQL tile that evaluates the query of the objective
+func (ph *Handler) buildSLITile(objective *keptnevents.SLO, query string) (Tile, error) {
+	query, err := ph.resolveDashboardQuery(query)
+	if err != nil {
+		return Tile{}, err
+	}
+
+	title := buildTileTitle(objective)
+	if strings.HasPrefix(query, "USQL;") {
+		querySplits := strings.SplitN(query, ";", 4)
+		if len(querySplits) != 4 {
+			return Tile{}, errors.New("the USQL query is not in the format USQL;<type>;<dimension>;<query>")
+		}
+		if querySplits[1] != "SINGLE_VALUE" {
+			return Tile{}, fmt.Errorf("an evaluation of a %s tile generates one indicator per dimension value", querySplits[1])
+		}
+		return Tile{
+			Name:       "User Sessions Query Language",
+			TileType:   "DTAQL",
+			Configured: true,
+			Query:      querySplits[3],
+			Type:       querySplits[1],
+			CustomName: title,
+		}, nil
+	}
+
+	// MV2;<unit>;<query> - the unit is taken from the metric definition when the dashboard is evaluated
+	if strings.HasPrefix(query, "MV2;") {
+		querySplits := strings.SplitN(query, ";", 3)
+		if len(querySplits) != 3 {
+			return Tile{}, errors.New("the query is not in the format MV2;<unit>;<query>")
+		}
+		query = querySplits[2]
+	}
+	if !strings.HasPrefix(query, "metricSelector=") {
+		return Tile{}, errors.New("only metricSelector and USQL queries can be charted")
+	}
+
+	tile := Tile{Name: "Custom chart", TileType: "CUSTOM_CHARTING", Configured: true}
+	tile.FilterConfig.Type = "MIXED"
+	tile.FilterConfig.CustomName = title
+	tile.FilterConfig.DefaultName = "Custom chart"
+	tile.FilterConfig.ChartConfig.LegendShown = true
+	tile.FilterConfig.ChartConfig.Type = "SINGLE_VALUE"
+	tile.FilterConfig.FiltersPerEntityType = map[string]map[string][]string{}
+
+	series := ChartSeries{Aggregation: "NONE", Type: "LINE", Dimensions: []SeriesDimension{}, SortColumn: true, AggregationRate: "TOTAL"}
+	entityFilter := map[string][]string{}
+	for _, parameter := range strings.Split(query, "&") {
+		nameValue := strings.SplitN(parameter, "=", 2)
+		if len(nameValue) != 2 {
+			return Tile{}, fmt.Errorf("the query parameter %s is invalid", parameter)
+		}
+		switch nameValue[0] {
+		case "metricSelector":
+			if err := parseMetricSelectorIntoSeries(nameValue[1], &series); err != nil {
+				return Tile{}, err
+			}
+		case "entitySelector":
+			if err := parseEntitySelectorIntoTile(nameValue[1], &series, entityFilter, &tile); err != nil {
+				return Tile{}, err
+			}
+		default:
+			return Tile{}, fmt.Errorf("the query parameter %s can't be charted", nameValue[0])
+		}
+	}
+	if series.EntityType == "" {
+		return Tile{}, errors.New("charts require an entitySelector with type()")
+	}
+	if len(entityFilter) > 0 {
+		tile.FilterConfig.FiltersPerEntityType[series.EntityType] = entityFilter
+	}
+
+	tile.FilterConfig.ChartConfig.Series = []ChartSeries{series}
+	return tile, nil
+}
+
+// resolveDashboardQuery resolves $PROJECT, $STAGE and $SERVICE - placeholders and templates that are resolved per evaluation are rejected
+func (ph *Handler) resolveDashboardQuery(query string) (string, error) {
+	if common.IsQueryTemplate(query) {
+		return "", errors.New("query templates are resolved per evaluation")
+	}
+	if placeholders := eventPlaceholderRegex.FindAllString(query, -1); len(placeholders) > 0 {
+		return "", fmt.Errorf("%s is resolved per evaluation", strings.Join(placeholders, ", "))
+	}
+
+	dashboardEvent := &common.BaseKeptnEvent{Project: ph.KeptnEvent.Project, Stage: ph.KeptnEvent.Stage, Service: ph.KeptnEvent.Service}
+	return common.ReplaceKeptnPlaceholders(query, dashboardEvent, ph.Logger), nil
+}
+
+/**
+ * parseMetricSelectorIntoSeries sets metric and aggregation of the series, e.g: builtin:service.response.time:merge(0):percentile(95)
+ * merge and names are dropped as the evaluation of a chart merges all dimensions itself, other transformations can't be charted
+ */
+func parseMetricSelectorIntoSeries(metricSelector string, series *ChartSeries) error {
+	metricIDParts := []string{}
+	for _, part := range splitOutsideParentheses(metricSelector, ':') {
+		transformation := part
+		argument := ""
+		if openIndex := strings.Index(part, "("); openIndex > 0 && strings.HasSuffix(part, ")") {
+			transformation = part[:openIndex]
+			argument = part[openIndex+1 : len(part)-1]
+		}
+
+		// everything before the first transformation is the metric ID, e.g: builtin:service.response.time
+		isTransformation := argument != "" || transformation == "names" || transformation == "median" || metricSelectorAggregations[transformation] != ""
+		if !isTransformation {
+			if series.Metric != "" {
+				return fmt.Errorf("the transformation %s can't be charted", part)
+			}
+			metricIDParts = append(metricIDParts, part)
+			continue
+		}
+		series.Metric = strings.Join(metricIDParts, ":")
+
+		switch transformation {
+		case "merge", "names":
+		case "percentile":
+			percentile, err := strconv.ParseFloat(argument, 64)
+			if err != nil {
+				return fmt.Errorf("the percentile %s is invalid", argument)
+			}
+			series.Aggregation = "PERCENTILE"
+			series.Percentile = percentile
+		case "median":
+			series.Aggregation = "PERCENTILE"
+			series.Percentile = 50.0
+		default:
+			aggregation, ok := metricSelectorAggregations[transformation]
+			if !ok || argument != "" {
+				return fmt.Errorf("the transformation %s can't be charted", part)
+			}
+			series.Aggregation = aggregation
+		}
+	}
+
+	if series.Metric == "" {
+		series.Metric = strings.Join(metricIDParts, ":")
+	}
+	return nil
+}
+
+/**
+ * parseEntitySelectorIntoTile sets the entity type of the series and the entity filters and management zone of the tile
+ * Supported are the criteria GetEntitySelectorFromEntityFilter generates: type, entityId, tag and mzId
+ */
+func parseEntitySelectorIntoTile(entitySelector string, series *ChartSeries, entityFilter map[string][]string, tile *Tile) error {
+	for _, criterion := range splitOutsideParentheses(entitySelector, ',') {
+		openIndex := strings.Index(criterion, "(")
+		if openIndex <= 0 || !strings.HasSuffix(criterion, ")") {
+			return fmt.Errorf("the entitySelector criterion %s can't be charted", criterion)
+		}
+		name := criterion[:openIndex]
+		values := []string{}
+		for _, value := range splitOutsideParentheses(criterion[openIndex+1:len(criterion)-1], ',') {
+			values = append(values, strings.Trim(value, "\""))
+		}
+
+		switch name {
+		case "type":
+			series.EntityType = values[0]
+		case "entityId":
+			entityFilter["SPECIFIC_ENTITIES"] = append(entityFilter["SPECIFIC_ENTITIES"], values...)
+		case "tag":
+			entityFilter["AUTO_TAGS"] = append(entityFilter["AUTO_TAGS"], values...)
+		case "mzId":
+			tile.TileFilter.ManagementZone = &ManagementZone{ID: values[0]}
+		default:
+			return fmt.Errorf("the entitySelector criterion %s can't be charted", criterion)
+		}
+	}
+	return nil
+}
+
+// splitOutsideParentheses splits at every separator that is neither within parentheses nor within quotes
+func splitOutsideParentheses(value string, separator rune) []string {
+	parts := []string{}
+	depth := 0
+	quoted := false
+	start := 0
+	for i, char := range value {
+		switch {
+		case char == '"':
+			quoted = !quoted
+		case quoted:
+		case char == '(':
+			depth++
+		case char == ')':
+			depth--
+		case char == separator && depth == 0:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+/**
+ * UploadDashboard creates the dashboard on the tenant - or replaces the dashboard with the same ID
+ * A dashboard without ID replaces the KQG dashboard of project, stage and service of the handler if one exists
+ * The POST that creates a dashboard is never retried. If it fails the dashboard is looked up again as Dynatrace might have
+ * created it anyway, e.g: on a timeout - this way an upload never creates the KQG dashboard twice
+ * Returns the ID of the uploaded dashboard
+ */
+func (ph *Handler) UploadDashboard(ctx context.Context, dashboardJSON *DynatraceDashboard) (string, error) {
+	dashboardID := dashboardJSON.ID
+	if dashboardID == "" && ph.KeptnEvent != nil {
+		existingID, err := ph.findDynatraceDashboard(ctx, ph.KeptnEvent)
+		if err != nil {
+			return "", fmt.Errorf("could not look up the existing dashboard: %v", err)
+		}
+		dashboardID = existingID
+	}
+
+	// the metadata is maintained by Dynatrace, the ID must only be sent to update a dashboard
+	payload := map[string]interface{}{}
+	content, err := json.Marshal(dashboardJSON)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(content, &payload); err != nil {
+		return "", err
+	}
+	delete(payload, "metadata")
+	delete(payload, "id")
+	if dashboardID != "" {
+		payload["id"] = dashboardID
+	}
+	content, err = json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	if dashboardID != "" {
+		dashboardAPIUrl := ph.ApiURL + fmt.Sprintf("/api/config/v1/dashboards/%s", dashboardID)
+		resp, body, err := ph.executeDynatraceRESTWithBody(ctx, "PUT", dashboardAPIUrl, content, nil)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusCreated {
+			return "", fmt.Errorf("Dynatrace API returned status code %d: %s", resp.StatusCode, string(body))
+		}
+		return dashboardID, nil
+	}
+
+	dashboardAPIUrl := ph.ApiURL + "/api/config/v1/dashboards"
+	resp, body, err := ph.executeDynatraceRESTWithBody(ctx, "POST", dashboardAPIUrl, content, nil)
+	if err == nil && resp.StatusCode != http.StatusCreated {
+		err = fmt.Errorf("Dynatrace API returned status code %d: %s", resp.StatusCode, string(body))
+	}
+	if err != nil {
+		if ph.KeptnEvent != nil {
+			if createdID, findErr := ph.findDynatraceDashboard(ctx, ph.KeptnEvent); findErr == nil && createdID != "" {
+				ph.Logger.Infof("Creating the dashboard failed but it was created with ID %s anyway: %v", createdID, err)
+				return createdID, nil
+			}
+		}
+		return "", err
+	}
+
+	createdDashboard := struct {
+		ID string `json:"id"`
+	}{}
+	if err := json.Unmarshal(body, &createdDashboard); err != nil {
+		return "", err
+	}
+	return createdDashboard.ID, nil
+}
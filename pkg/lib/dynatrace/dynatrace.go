@@ -1,25 +1,27 @@
 package dynatrace
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	keptnevents "github.com/keptn/go-utils/pkg/lib"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"go.opentelemetry.io/otel/semconv"
 
 	// keptnevents "github.com/keptn/go-utils/pkg/events"
 	// keptnutils "github.com/keptn/go-utils/pkg/utils"
-
-	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
 )
 
 const Throughput = "throughput"
@@ -80,106 +82,77 @@ type DynatraceDashboard struct {
 			Published  bool `json:"published"`
 		} `json:"sharingDetails"`
 		DashboardFilter *struct {
-			Timeframe      string `json:"timeframe"`
-			ManagementZone *struct {
-				ID   string `json:"id"`
-				Name string `json:"name"`
-			} `json:"managementZone,omitempty"`
+			Timeframe      string          `json:"timeframe"`
+			ManagementZone *ManagementZone `json:"managementZone,omitempty"`
 		} `json:"dashboardFilter,omitempty"`
 		Tags []string `json:"tags"`
 	} `json:"dashboardMetadata"`
-	Tiles []struct {
-		Name       string `json:"name"`
-		TileType   string `json:"tileType"`
-		Configured bool   `json:"configured"`
-		Query      string `json:"query"`
-		Type       string `json:"type"`
-		CustomName string `json:"customName`
-		Markdown   string `json:"markdown`
-		Bounds     struct {
-			Top    int `json:"top"`
-			Left   int `json:"left"`
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		} `json:"bounds"`
-		TileFilter struct {
-			Timeframe      string `json:"timeframe"`
-			ManagementZone *struct {
-				ID   string `json:"id"`
-				Name string `json:"name"`
-			} `json:"managementZone,omitempty"`
-		} `json:"tileFilter"`
-		AssignedEntities []string `json:"assignedEntities"`
-		FilterConfig     struct {
-			Type        string `json:"type"`
-			CustomName  string `json:"customName"`
-			DefaultName string `json:"defaultName"`
-			ChartConfig struct {
-				LegendShown bool   `json:"legendShown"`
-				Type        string `json:"type"`
-				Series      []struct {
-					Metric      string      `json:"metric"`
-					Aggregation string      `json:"aggregation"`
-					Percentile  interface{} `json:"percentile"`
-					Type        string      `json:"type"`
-					EntityType  string      `json:"entityType"`
-					Dimensions  []struct {
-						ID              string   `json:"id"`
-						Name            string   `json:"name"`
-						Values          []string `json:"values"`
-						EntityDimension bool     `json:"entitiyDimension"`
-					} `json:"dimensions"`
-					SortAscending   bool   `json:"sortAscending"`
-					SortColumn      bool   `json:"sortColumn"`
-					AggregationRate string `json:"aggregationRate"`
-				} `json:"series"`
-				ResultMetadata struct {
-				} `json:"resultMetadata"`
-			} `json:"chartConfig"`
-			FiltersPerEntityType map[string]map[string][]string `json:"filtersPerEntityType"`
-			/* FiltersPerEntityType struct {
-				HOST struct {
-					SPECIFIC_ENTITIES    []string `json:"SPECIFIC_ENTITIES"`
-					HOST_DATACENTERS     []string `json:"HOST_DATACENTERS"`
-					AUTO_TAGS            []string `json:"AUTO_TAGS"`
-					HOST_SOFTWARE_TECH   []string `json:"HOST_SOFTWARE_TECH"`
-					HOST_VIRTUALIZATION  []string `json:"HOST_VIRTUALIZATION"`
-					HOST_MONITORING_MODE []string `json:"HOST_MONITORING_MODE"`
-					HOST_STATE           []string `json:"HOST_STATE"`
-					HOST_HOST_GROUPS     []string `json:"HOST_HOST_GROUPS"`
-				} `json:"HOST"`
-				PROCESS_GROUP struct {
-					SPECIFIC_ENTITIES     []string `json:"SPECIFIC_ENTITIES"`
-					HOST_TAG_OF_PROCESS   []string `json:"HOST_TAG_OF_PROCESS"`
-					AUTO_TAGS             []string `json:"AUTO_TAGS"`
-					PROCESS_SOFTWARE_TECH []string `json:"PROCESS_SOFTWARE_TECH"`
-				} `json:"PROCESS_GROUP"`
-				PROCESS_GROUP_INSTANCE struct {
-					SPECIFIC_ENTITIES     []string `json:"SPECIFIC_ENTITIES"`
-					HOST_TAG_OF_PROCESS   []string `json:"HOST_TAG_OF_PROCESS"`
-					AUTO_TAGS             []string `json:"AUTO_TAGS"`
-					PROCESS_SOFTWARE_TECH []string `json:"PROCESS_SOFTWARE_TECH"`
-				} `json:"PROCESS_GROUP_INSTANCE"`
-				SERVICE struct {
-					SPECIFIC_ENTITIES     []string `json:"SPECIFIC_ENTITIES"`
-					SERVICE_SOFTWARE_TECH []string `json:"SERVICE_SOFTWARE_TECH"`
-					AUTO_TAGS             []string `json:"AUTO_TAGS"`
-					SERVICE_TYPE          []string `json:"SERVICE_TYPE"`
-					SERVICE_TO_PG         []string `json:"SERVICE_TO_PG"`
-				} `json:"SERVICE"`
-				APPLICATION struct {
-					SPECIFIC_ENTITIES          []string `json:"SPECIFIC_ENTITIES"`
-					APPLICATION_TYPE           []string `json:"APPLICATION_TYPE"`
-					AUTO_TAGS                  []string `json:"AUTO_TAGS"`
-					APPLICATION_INJECTION_TYPE []string `json:"PROCESS_SOFTWARE_TECH"`
-					APPLICATION_STATUS         []string `json:"APPLICATION_STATUS"`
-				} `json:"APPLICATION"`
-				APPLICATION_METHOD struct {
-					SPECIFIC_ENTITIES []string `json:"SPECIFIC_ENTITIES"`
-				} `json:"APPLICATION_METHOD"`
-			} `json:"filtersPerEntityType"`*/
-		} `json:"filterConfig"`
-	} `json:"tiles"`
+	Tiles []Tile `json:"tiles"`
+}
+
+// ManagementZone references a management zone that filters a dashboard or a tile
+type ManagementZone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Tile is a tile of a dashboard, e.g: a custom chart, a USQL query or a markdown
+type Tile struct {
+	Name       string `json:"name"`
+	TileType   string `json:"tileType"`
+	Configured bool   `json:"configured"`
+	Query      string `json:"query"`
+	Type       string `json:"type"`
+	CustomName string `json:"customName"`
+	Markdown   string `json:"markdown"`
+	Bounds     struct {
+		Top    int `json:"top"`
+		Left   int `json:"left"`
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"bounds"`
+	TileFilter struct {
+		Timeframe      string          `json:"timeframe"`
+		ManagementZone *ManagementZone `json:"managementZone,omitempty"`
+	} `json:"tileFilter"`
+	AssignedEntities []string         `json:"assignedEntities"`
+	FilterConfig     TileFilterConfig `json:"filterConfig"`
+}
+
+// TileFilterConfig holds the chart configuration and the entity filters of a custom chart tile
+type TileFilterConfig struct {
+	Type        string `json:"type"`
+	CustomName  string `json:"customName"`
+	DefaultName string `json:"defaultName"`
+	ChartConfig struct {
+		LegendShown    bool          `json:"legendShown"`
+		Type           string        `json:"type"`
+		Series         []ChartSeries `json:"series"`
+		ResultMetadata struct {
+		} `json:"resultMetadata"`
+	} `json:"chartConfig"`
+	FiltersPerEntityType map[string]map[string][]string `json:"filtersPerEntityType"`
+}
+
+// ChartSeries is a metric charted by a custom chart tile
+type ChartSeries struct {
+	Metric          string            `json:"metric"`
+	Aggregation     string            `json:"aggregation"`
+	Percentile      interface{}       `json:"percentile"`
+	Type            string            `json:"type"`
+	EntityType      string            `json:"entityType"`
+	Dimensions      []SeriesDimension `json:"dimensions"`
+	SortAscending   bool              `json:"sortAscending"`
+	SortColumn      bool              `json:"sortColumn"`
+	AggregationRate string            `json:"aggregationRate"`
+}
+
+// SeriesDimension is a dimension a chart series is split by - with values the series is filtered on these dimension values
+type SeriesDimension struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	Values          []string `json:"values"`
+	EntityDimension bool     `json:"entitiyDimension"`
 }
 
 // MetricDefinition defines the output of /metrics/<metricID>
@@ -246,37 +219,100 @@ type Handler struct {
 	HTTPClient    *http.Client
 	Headers       map[string]string
 	CustomQueries map[string]string
-	CustomFilters []*keptnevents.SLIFilter
-	Logger        *keptn.Logger
+	// DefaultQueries is the SLI catalog that is used for indicators that are not defined in CustomQueries
+	DefaultQueries map[string]string
+	CustomFilters  []*keptnevents.SLIFilter
+	Defaults       *common.SLIDefaults
+	Logger         *common.Logger
+	// Timeseries configures whether the data points of Metrics indicators are collected - nil means disabled
+	Timeseries *common.TimeseriesExport
+	// Cassette records all Dynatrace API calls or replays recorded ones instead of calling Dynatrace - nil calls Dynatrace
+	Cassette *Cassette
+	// ResourceStore holds the resources of the Keptn configuration repo, e.g: an existing dynatrace/dashboard.json
+	ResourceStore common.ResourceStore
+
+	sliLinks     *sliLinks
+	timeseries   *timeseriesCollector
+	reports      *reportCollector
+	requestStats *requestStats
 }
 
 // NewDynatraceHandler returns a new dynatrace handler that interacts with the Dynatrace REST API
-func NewDynatraceHandler(apiURL string, keptnEvent *common.BaseKeptnEvent, headers map[string]string, customFilters []*keptnevents.SLIFilter, keptnContext string, eventID string) *Handler {
+// defaults are taken from dynatrace.conf.yaml - if nil the built-in defaults are used
+func NewDynatraceHandler(apiURL string, keptnEvent *common.BaseKeptnEvent, headers map[string]string, customFilters []*keptnevents.SLIFilter, keptnContext string, eventID string, defaults *common.SLIDefaults) *Handler {
+	defaults = common.ResolveSLIDefaults(defaults)
 	tr := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: !IsHttpSSLVerificationEnabled()},
 	}
 	ph := &Handler{
-		ApiURL:        apiURL,
-		KeptnEvent:    keptnEvent,
-		HTTPClient:    &http.Client{Transport: tr},
-		Headers:       headers,
-		CustomFilters: customFilters,
-		Logger:        keptn.NewLogger(keptnContext, eventID, "dynatrace-sli-service"),
+		ApiURL:         apiURL,
+		KeptnEvent:     keptnEvent,
+		HTTPClient:     &http.Client{Transport: tr, Timeout: defaults.RequestTimeout},
+		Headers:        headers,
+		CustomFilters:  customFilters,
+		DefaultQueries: GetBuiltInSLICatalog(),
+		Defaults:       defaults,
+		Logger:         common.NewLogger(keptnContext, eventID, common.ServiceName).WithEvent(keptnEvent),
+		ResourceStore:  common.NewConfigurationServiceStore(common.GetConfigurationServiceURL()),
+		sliLinks:       &sliLinks{links: map[string]string{}},
+		timeseries:     &timeseriesCollector{timeseries: map[string]*IndicatorTimeseries{}},
+		reports:        &reportCollector{indicators: map[string]*IndicatorReport{}},
 	}
 
 	return ph
 }
 
+// timedOut returns true if the deadline of ctx, e.g: the evaluation deadline, was exceeded or it was canceled at shutdown
+func timedOut(ctx context.Context) bool {
+	return ctx.Err() != nil
+}
+
+// maxDynatraceAPIRetries is the number of times an idempotent Dynatrace API call is retried if it was throttled (429), failed with 5xx or did not return at all
+const maxDynatraceAPIRetries = 2
+
+// idempotentMethods are retried - a retried POST could e.g: create a dashboard twice if only the response of the first call got lost
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// dynatraceAPIRetryDelay is the delay before the first retry - it doubles with every further retry
+var dynatraceAPIRetryDelay = 1 * time.Second
+
+// maxDynatraceAPIRetryDelay caps the delay requested by the Retry-After header of a throttled call
+const maxDynatraceAPIRetryDelay = 30 * time.Second
+
 /**
  * exeucteDynatraceREST
  * Executes a call to the Dynatrace REST API Endpoint - taking care of setting all required headers
  * addHeaders allows you to pass additional HTTP Headers
+ * Throttled calls (429), server errors (5xx) and network errors of idempotent methods are retried up to maxDynatraceAPIRetries times
+ * The call and its retries are traced as children of the span in ctx and are canceled once ctx is done, e.g: when the evaluation deadline is hit
  * Returns the Response Object, the body byte array, error
  */
-func (ph *Handler) executeDynatraceREST(httpMethod string, requestUrl string, addHeaders map[string]string) (*http.Response, []byte, error) {
+func (ph *Handler) executeDynatraceREST(ctx context.Context, httpMethod string, requestUrl string, addHeaders map[string]string) (*http.Response, []byte, error) {
+	return ph.executeDynatraceRESTWithBody(ctx, httpMethod, requestUrl, nil, addHeaders)
+}
+
+// executeDynatraceRESTWithBody executes a call to the Dynatrace REST API Endpoint that sends a JSON body, e.g: to create a dashboard
+func (ph *Handler) executeDynatraceRESTWithBody(ctx context.Context, httpMethod string, requestUrl string, requestBody []byte, addHeaders map[string]string) (*http.Response, []byte, error) {
 
 	// new request to our URL
-	req, err := http.NewRequest(httpMethod, requestUrl, nil)
+	var bodyReader io.Reader
+	if requestBody != nil {
+		bodyReader = bytes.NewReader(requestBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, httpMethod, requestUrl, bodyReader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if requestBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	// add our default headers, e.g: authentication
 	for headerName, headerValue := range ph.Headers {
@@ -290,14 +326,89 @@ func (ph *Handler) executeDynatraceREST(httpMethod string, requestUrl string, ad
 		}
 	}
 
+	endpoint := common.GetDynatraceAPIEndpoint(req.URL)
+	_, span := common.StartSpan(ctx, "executeDynatraceREST", common.SpanAttributeEndpoint.String(endpoint), semconv.HTTPMethodKey.String(httpMethod))
+	delay := dynatraceAPIRetryDelay
+	for retry := 0; ; retry++ {
+		requestStart := time.Now()
+		resp, body, err := ph.doDynatraceRequest(req, requestBody)
+		common.ObserveDynatraceAPIRequest(endpoint, resp, time.Since(requestStart))
+		if retry >= maxDynatraceAPIRetries || !isRetryableRequest(httpMethod, resp, err) || ctx.Err() != nil {
+			if resp != nil {
+				span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(resp.StatusCode)...)
+			}
+			common.EndSpan(span, err)
+			return resp, body, err
+		}
+		span.AddEvent("retry")
+
+		retryDelay := delay
+		if err != nil {
+			ph.Logger.Debugf("Retrying %s: %v", requestUrl, err)
+		} else {
+			ph.Logger.Debugf("Retrying %s: status code %d", requestUrl, resp.StatusCode)
+			if retryAfter, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && retryAfter >= 0 {
+				retryDelay = time.Duration(retryAfter) * time.Second
+			}
+		}
+		if retryDelay > maxDynatraceAPIRetryDelay {
+			retryDelay = maxDynatraceAPIRetryDelay
+		}
+		if ph.Cassette != nil && ph.Cassette.Replaying() {
+			retryDelay = 0
+		}
+
+		ph.requestStats.addRetry()
+		common.DynatraceAPIRetries.WithLabelValues(endpoint).Inc()
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			common.EndSpan(span, ctx.Err())
+			return nil, nil, ctx.Err()
+		}
+		delay = delay * 2
+
+		// the body of the previous attempt was consumed
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				common.EndSpan(span, err)
+				return nil, nil, err
+			}
+		}
+	}
+}
+
+// isRetryableRequest returns true if a Dynatrace API call failed temporarily and can safely be sent again
+func isRetryableRequest(httpMethod string, resp *http.Response, err error) bool {
+	if !idempotentMethods[httpMethod] {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// doDynatraceRequest performs a single call to the Dynatrace REST API Endpoint and reads the body - the call is recorded or replayed if the handler has a cassette
+func (ph *Handler) doDynatraceRequest(req *http.Request, requestBody []byte) (*http.Response, []byte, error) {
+	if ph.Cassette != nil && ph.Cassette.Replaying() {
+		return ph.Cassette.replay(req.Method, ph.getCassetteURI(req.URL), requestBody, ph.Logger.Redact)
+	}
+
 	// perform the request
 	resp, err := ph.HTTPClient.Do(req)
 	if err != nil {
+		if ph.Cassette != nil {
+			ph.Cassette.record(req.Method, ph.getCassetteURI(req.URL), requestBody, nil, nil, err, ph.Logger.Redact)
+		}
 		return resp, nil, err
 	}
 	defer resp.Body.Close()
 
 	body, _ := ioutil.ReadAll(resp.Body)
+	if ph.Cassette != nil {
+		ph.Cassette.record(req.Method, ph.getCassetteURI(req.URL), requestBody, resp, body, nil, ph.Logger.Redact)
+	}
 
 	return resp, body, nil
 }
@@ -306,27 +417,28 @@ func (ph *Handler) executeDynatraceREST(httpMethod string, requestUrl string, ad
  * Helper function to validate whether string is a valid UUID
  */
 func IsValidUUID(uuid string) bool {
-	r := regexp.MustCompile("^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-4[a-fA-F0-9]{3}-[8|9|aA|bB][a-fA-F0-9]{3}-[a-fA-F0-9]{12}$")
-	return r.MatchString(uuid)
+	return common.IsValidUUID(uuid)
 }
 
 /**
  * findDynatraceDashboard
  * Queries all Dynatrace Dashboards and returns the dashboard ID that matches the following name patter: KQG;project=%project%;service=%service%;stage=%stage;xxx
  *
- * Returns the UUID of the dashboard that was found. If no dashboard was found it returns ""
+ * Returns the UUID of the dashboard that was found. If no dashboard was found it returns "" - and an error if the dashboards could not be listed
  */
-func (ph *Handler) findDynatraceDashboard(keptnEvent *common.BaseKeptnEvent) (string, error) {
+func (ph *Handler) findDynatraceDashboard(ctx context.Context, keptnEvent *common.BaseKeptnEvent) (string, error) {
 	// Lets query the list of all Dashboards and find the one that matches project, stage, service based on the title (in the future - we can do it via tags)
 	// create dashboard query URL and set additional headers
-	// ph.Logger.Debug(fmt.Sprintf("Query all dashboards\n"))
+	// ph.Logger.Debugf("Query all dashboards\n")
 
 	dashboardAPIUrl := ph.ApiURL + fmt.Sprintf("/api/config/v1/dashboards")
-	resp, body, err := ph.executeDynatraceREST("GET", dashboardAPIUrl, nil)
-
-	if resp == nil || resp.StatusCode != 200 {
+	resp, body, err := ph.executeDynatraceREST(ctx, "GET", dashboardAPIUrl, nil)
+	if err != nil {
 		return "", err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Dynatrace API returned status code %d: %s", resp.StatusCode, string(body))
+	}
 
 	// parse json
 	dashboardsJSON := &DynatraceDashboards{}
@@ -377,15 +489,19 @@ func (ph *Handler) findDynatraceDashboard(keptnEvent *common.BaseKeptnEvent) (st
 
  * Returns: parsed Dynatrace Dashboard and actual dashboard ID in case we queried a dashboard
  */
-func (ph *Handler) loadDynatraceDashboard(keptnEvent *common.BaseKeptnEvent, dashboard string) (*DynatraceDashboard, string, error) {
+func (ph *Handler) loadDynatraceDashboard(ctx context.Context, keptnEvent *common.BaseKeptnEvent, dashboard string) (*DynatraceDashboard, string, error) {
 
 	// Option 1: Query dashboards
 	if dashboard == common.DynatraceConfigDashboardQUERY {
-		dashboard, _ = ph.findDynatraceDashboard(keptnEvent)
+		var err error
+		dashboard, err = ph.findDynatraceDashboard(ctx, keptnEvent)
+		if err != nil {
+			ph.Logger.Errorf("Could not look up the KQG dashboard: %v", err)
+		}
 		if dashboard == "" {
-			ph.Logger.Debug(fmt.Sprintf("dashboard option query but couldnt find KQG dashboard for %s.%s.%s", keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service))
+			ph.Logger.Debugf("dashboard option query but couldnt find KQG dashboard for %s.%s.%s", keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service)
 		} else {
-			ph.Logger.Debug(fmt.Sprintf("dashboard option query for %s.%s.%s found dashboard=%s", keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service, dashboard))
+			ph.Logger.Debugf("dashboard option query for %s.%s.%s found dashboard=%s", keptnEvent.Project, keptnEvent.Stage, keptnEvent.Service, dashboard)
 		}
 	}
 
@@ -401,9 +517,9 @@ func (ph *Handler) loadDynatraceDashboard(keptnEvent *common.BaseKeptnEvent, das
 	}
 
 	// We have a valid Dashboard UUID - now lets query it!
-	ph.Logger.Debug(fmt.Sprintf("Query dashboard with ID: %s", dashboard))
+	ph.Logger.Debugf("Query dashboard with ID: %s", dashboard)
 	dashboardAPIUrl := ph.ApiURL + fmt.Sprintf("/api/config/v1/dashboards/%s", dashboard)
-	resp, body, err := ph.executeDynatraceREST("GET", dashboardAPIUrl, nil)
+	resp, body, err := ph.executeDynatraceREST(ctx, "GET", dashboardAPIUrl, nil)
 
 	if err != nil {
 		return nil, dashboard, err
@@ -418,8 +534,10 @@ func (ph *Handler) loadDynatraceDashboard(keptnEvent *common.BaseKeptnEvent, das
 	err = json.Unmarshal(body, &dashboardJSON)
 
 	if err != nil {
+		common.DashboardParses.WithLabelValues(common.DashboardParseError).Inc()
 		return nil, dashboard, fmt.Errorf("could not decode response payload: %v", err)
 	}
+	common.DashboardParses.WithLabelValues(common.DashboardParseSuccess).Inc()
 
 	return dashboardJSON, dashboard, nil
 }
@@ -428,9 +546,12 @@ func (ph *Handler) loadDynatraceDashboard(keptnEvent *common.BaseKeptnEvent, das
  * ExecuteMetricAPIDescribe
  * Calls the /metrics/<metricID> API call to retrieve Metric Definition Details
  */
-func (ph *Handler) ExecuteMetricAPIDescribe(metricID string) (*MetricDefinition, error) {
+func (ph *Handler) ExecuteMetricAPIDescribe(ctx context.Context, metricID string) (*MetricDefinition, error) {
+	ctx, span := common.StartSpan(ctx, "ExecuteMetricAPIDescribe", common.SpanAttributeMetricID.String(metricID))
+	defer span.End()
+
 	targetURL := ph.ApiURL + fmt.Sprintf("/api/v2/metrics/%s", metricID)
-	resp, body, err := ph.executeDynatraceREST("GET", targetURL, nil)
+	resp, body, err := ph.executeDynatraceREST(ctx, "GET", targetURL, nil)
 
 	if err != nil {
 		return nil, err
@@ -460,9 +581,9 @@ func (ph *Handler) ExecuteMetricAPIDescribe(metricID string) (*MetricDefinition,
 }
 
 // ExecuteMetricsAPIQuery executes the passed Metrics API Call, validates that the call returns data and returns the data set
-func (ph *Handler) ExecuteMetricsAPIQuery(metricsQuery string) (*DynatraceResult, error) {
+func (ph *Handler) ExecuteMetricsAPIQuery(ctx context.Context, metricsQuery string) (*DynatraceResult, error) {
 	// now we execute the query against the Dynatrace API
-	resp, body, err := ph.executeDynatraceREST("GET", metricsQuery, map[string]string{"Content-Type": "application/json"})
+	resp, body, err := ph.executeDynatraceREST(ctx, "GET", metricsQuery, map[string]string{"Content-Type": "application/json"})
 
 	if err != nil {
 		return nil, err
@@ -498,9 +619,9 @@ func (ph *Handler) ExecuteMetricsAPIQuery(metricsQuery string) (*DynatraceResult
 }
 
 // ExecuteUSQLQuery executes the passed Metrics API Call, validates that the call returns data and returns the data set
-func (ph *Handler) ExecuteUSQLQuery(usql string) (*DTUSQLResult, error) {
+func (ph *Handler) ExecuteUSQLQuery(ctx context.Context, usql string) (*DTUSQLResult, error) {
 	// now we execute the query against the Dynatrace API
-	resp, body, err := ph.executeDynatraceREST("GET", usql, map[string]string{"Content-Type": "application/json"})
+	resp, body, err := ph.executeDynatraceREST(ctx, "GET", usql, map[string]string{"Content-Type": "application/json"})
 
 	if resp == nil || err != nil || resp.StatusCode != 200 {
 		return nil, err
@@ -533,11 +654,20 @@ func (ph *Handler) ExecuteUSQLQuery(usql string) (*DTUSQLResult, error) {
 }
 
 // BuildDynatraceUSQLQuery builds a USQL query based on the incoming values
-func (ph *Handler) BuildDynatraceUSQLQuery(query string, startUnix time.Time, endUnix time.Time) string {
-	ph.Logger.Debug(fmt.Sprintf("Finalize USQL query for %s\n", query))
+func (ph *Handler) BuildDynatraceUSQLQuery(query string, startUnix time.Time, endUnix time.Time) (string, error) {
+	ph.Logger.Debugf("Finalize USQL query for %s\n", query)
 
 	// replace query params (e.g., $PROJECT, $STAGE, $SERVICE ...)
-	usql := ph.replaceQueryParameters(query)
+	usql, err := ph.replaceQueryParameters(query)
+	if err != nil {
+		return "", err
+	}
+
+	// apply timeshift, from and to and replace $START, $END and $DURATION
+	usql, startUnix, endUnix, err = applyQueryTimeframe(usql, startUnix, endUnix)
+	if err != nil {
+		return "", err
+	}
 
 	// default query params that are required: resolution, from and to
 	queryParams := map[string]string{
@@ -559,9 +689,9 @@ func (ph *Handler) BuildDynatraceUSQLQuery(query string, startUnix time.Time, en
 	}
 
 	u.RawQuery = q.Encode()
-	ph.Logger.Debug(fmt.Sprintf("Final USQL Query=%s", u.String()))
+	ph.Logger.Debugf("Final USQL Query=%s", u.String())
 
-	return u.String()
+	return u.String(), nil
 }
 
 // BuildDynatraceMetricsQuery builds the complete query string based on start, end and filters
@@ -570,12 +700,27 @@ func (ph *Handler) BuildDynatraceUSQLQuery(query string, startUnix time.Time, en
 //  #1: Finalized Dynatrace API Query
 //  #2: MetricID that this query will return, e.g: builtin:host.cpu
 //  #3: error
-func (ph *Handler) BuildDynatraceMetricsQuery(metricquery string, startUnix time.Time, endUnix time.Time) (string, string) {
+func (ph *Handler) BuildDynatraceMetricsQuery(metricquery string, startUnix time.Time, endUnix time.Time) (string, string, error) {
+	// resolution=Inf means that we only get 1 datapoint (per service)
+	return ph.BuildDynatraceMetricsQueryWithResolution(metricquery, startUnix, endUnix, "Inf")
+}
+
+// BuildDynatraceMetricsQueryWithResolution builds the same query as BuildDynatraceMetricsQuery but with the passed resolution, e.g: 1m
+func (ph *Handler) BuildDynatraceMetricsQueryWithResolution(metricquery string, startUnix time.Time, endUnix time.Time, resolution string) (string, string, error) {
 	// replace query params (e.g., $PROJECT, $STAGE, $SERVICE ...)
-	metricquery = ph.replaceQueryParameters(metricquery)
+	metricquery, err := ph.replaceQueryParameters(metricquery)
+	if err != nil {
+		return "", "", err
+	}
+
+	// apply timeshift, from and to and replace $START, $END and $DURATION
+	metricquery, startUnix, endUnix, err = applyQueryTimeframe(metricquery, startUnix, endUnix)
+	if err != nil {
+		return "", "", err
+	}
 
 	if strings.HasPrefix(metricquery, "?metricSelector=") {
-		ph.Logger.Debug(fmt.Sprintf("COMPATIBILITY WARNING: Provided query string %s is not compatible. Auto-removing the ? in front (see %s for details).\n", metricquery, MetricsAPIOldFormatNewFormatDoc))
+		ph.Logger.Debugf("COMPATIBILITY WARNING: Provided query string %s is not compatible. Auto-removing the ? in front (see %s for details).\n", metricquery, MetricsAPIOldFormatNewFormatDoc)
 		metricquery = strings.Replace(metricquery, "?metricSelector=", "metricSelector=", 1)
 	}
 
@@ -590,7 +735,7 @@ func (ph *Handler) BuildDynatraceMetricsQuery(metricquery string, startUnix time
 		// new format without "?" -> everything within the query string are query parameters
 		metricQueryParams = querySplit[0]
 	} else {
-		ph.Logger.Debug(fmt.Sprintf("COMPATIBILITY WARNING: Your query %s still uses the old format (see %s for details).\n", metricQueryParams, MetricsAPIOldFormatNewFormatDoc))
+		ph.Logger.Debugf("COMPATIBILITY WARNING: Your query %s still uses the old format (see %s for details).\n", metricQueryParams, MetricsAPIOldFormatNewFormatDoc)
 		// old format with "?" - everything left of the ? is the identifier, everything right are query params
 		metricSelector = querySplit[0]
 
@@ -602,7 +747,7 @@ func (ph *Handler) BuildDynatraceMetricsQuery(metricquery string, startUnix time
 
 	// default query params that are required: resolution, from and to
 	queryParams := map[string]string{
-		"resolution": "Inf", // resolution=Inf means that we only get 1 datapoint (per service)
+		"resolution": resolution,
 		"from":       common.TimestampToString(startUnix),
 		"to":         common.TimestampToString(endUnix),
 	}
@@ -619,10 +764,10 @@ func (ph *Handler) BuildDynatraceMetricsQuery(metricquery string, startUnix time
 
 	// compatibility with old scope=... custom queries
 	if scopeData != "" {
-		ph.Logger.Debug(fmt.Sprintf("COMPATIBILITY WARNING: You are still using scope=... - querying the new metrics API requires use of entitySelector=... instead (see %s for details).", MetricsAPIOldFormatNewFormatDoc))
+		ph.Logger.Debugf("COMPATIBILITY WARNING: You are still using scope=... - querying the new metrics API requires use of entitySelector=... instead (see %s for details).", MetricsAPIOldFormatNewFormatDoc)
 		// scope is no longer supported in the new API, it needs to be called "entitySelector" and contain type(SERVICE)
 		if !strings.Contains(scopeData, "type(SERVICE)") {
-			ph.Logger.Debug(fmt.Sprintf("COMPATIBILITY WARNING: Automatically adding type(SERVICE) to entitySelector=... for compatibility with the new Metrics API (see %s for details).", MetricsAPIOldFormatNewFormatDoc))
+			ph.Logger.Debugf("COMPATIBILITY WARNING: Automatically adding type(SERVICE) to entitySelector=... for compatibility with the new Metrics API (see %s for details).", MetricsAPIOldFormatNewFormatDoc)
 			scopeData = fmt.Sprintf("%s,type(SERVICE)", scopeData)
 		}
 		// add scope as entitySelector
@@ -635,9 +780,9 @@ func (ph *Handler) BuildDynatraceMetricsQuery(metricquery string, startUnix time
 	}
 
 	u.RawQuery = q.Encode()
-	ph.Logger.Debug(fmt.Sprintf("Final Query=%s", u.String()))
+	ph.Logger.Debugf("Final Query=%s", u.String())
 
-	return u.String(), metricSelector
+	return u.String(), metricSelector, nil
 }
 
 // ParsePassAndWarningFromString takes a value such as
@@ -785,17 +930,17 @@ func (ph *Handler) isMatchingMetricID(singleResultMetricID string, queryMetricID
 
 	// lets do some basic fuzzy matching
 	if strings.Contains(singleResultMetricID, "~") {
-		ph.Logger.Debug(fmt.Sprintf("Need Fuzzy Matching between %s and %s\n", singleResultMetricID, queryMetricID))
+		ph.Logger.Debugf("Need Fuzzy Matching between %s and %s\n", singleResultMetricID, queryMetricID)
 
 		//
 		// lets just see whether everything until the first : matches
 		if strings.Contains(singleResultMetricID, ":") && strings.Contains(singleResultMetricID, ":") {
-			ph.Logger.Debug(fmt.Sprintf("Just compare before first :\n"))
+			ph.Logger.Debugf("Just compare before first :\n")
 
 			fuzzyResultMetricID := strings.Split(singleResultMetricID, ":")[0]
 			fuzzyQueryMetricID := strings.Split(queryMetricID, ":")[0]
 			if strings.Compare(fuzzyResultMetricID, fuzzyQueryMetricID) == 0 {
-				ph.Logger.Debug(fmt.Sprintf("FUZZY MATCH!!\n"))
+				ph.Logger.Debugf("FUZZY MATCH!!\n")
 				return true
 			}
 		}
@@ -861,19 +1006,28 @@ func (ph *Handler) GetEntitySelectorFromEntityFilter(filtersPerEntityType map[st
 //  #3: ServiceLevelObjectives
 //  #4: SLIResult
 //  #5: Error
-func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEvent, dashboard string, startUnix time.Time, endUnix time.Time) (string, *DynatraceDashboard, *SLI, *keptnevents.ServiceLevelObjectives, []*keptnevents.SLIResult, error) {
+func (ph *Handler) QueryDynatraceDashboardForSLIs(ctx context.Context, keptnEvent *common.BaseKeptnEvent, dashboard string, startUnix time.Time, endUnix time.Time) (string, *DynatraceDashboard, *SLI, *keptnevents.ServiceLevelObjectives, []*keptnevents.SLIResult, error) {
+	ctx, span := common.StartSpan(ctx, "QueryDynatraceDashboardForSLIs", common.EventSpanAttributes(keptnEvent)...)
+	dashboardLinkAsLabel, dashboardJSON, dashboardSLI, dashboardSLO, sliResults, err := ph.queryDynatraceDashboardForSLIs(ctx, keptnEvent, dashboard, startUnix, endUnix)
+	common.EndSpan(span, err)
+
+	return dashboardLinkAsLabel, dashboardJSON, dashboardSLI, dashboardSLO, sliResults, err
+}
+
+// queryDynatraceDashboardForSLIs implements QueryDynatraceDashboardForSLIs
+func (ph *Handler) queryDynatraceDashboardForSLIs(ctx context.Context, keptnEvent *common.BaseKeptnEvent, dashboard string, startUnix time.Time, endUnix time.Time) (string, *DynatraceDashboard, *SLI, *keptnevents.ServiceLevelObjectives, []*keptnevents.SLIResult, error) {
 
 	// Lets see if there is a dashboard.json already in the configuration repo - if so its an indicator that we should query the dashboard
 	// This check is espcially important for backward compatibilty as the new dynatrace.conf.yaml:dashboard property is changing the default behavior
 	// If a dashboard.json exists and dashboard property is empty we default to QUERY - which is the old default behavior
-	existingDashboardContent, err := common.GetKeptnResource(keptnEvent, common.DynatraceDashboardFilename, ph.Logger)
+	existingDashboardContent, err := common.GetKeptnResource(ctx, ph.ResourceStore, keptnEvent, common.DynatraceDashboardFilename, ph.Logger)
 	if err == nil && existingDashboardContent != "" && dashboard == "" {
 		ph.Logger.Debug("Set dashboard=query for backward compatibility as dashboard.json was present!")
 		dashboard = common.DynatraceConfigDashboardQUERY
 	}
 
 	// lets load the dashboard if needed
-	dashboardJSON, dashboard, err := ph.loadDynatraceDashboard(keptnEvent, dashboard)
+	dashboardJSON, dashboard, err := ph.loadDynatraceDashboard(ctx, keptnEvent, dashboard)
 	if err != nil {
 		return "", nil, nil, nil, nil, fmt.Errorf("Error while processing dashboard config '%s' - %v", dashboard, err)
 	}
@@ -884,14 +1038,7 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 
 	// generate our own SLIResult array based on the dashboard configuration
 	var sliResults []*keptnevents.SLIResult
-	dashboardSLI := &SLI{}
-	dashboardSLI.SpecVersion = "0.1.4"
-	dashboardSLI.Indicators = make(map[string]string)
-	dashboardSLO := &keptnevents.ServiceLevelObjectives{
-		Objectives: []*keptnevents.SLO{},
-		TotalScore: &keptnevents.SLOScore{Pass: "90%", Warning: "75%"},
-		Comparison: &keptnevents.SLOComparison{CompareWith: "single_result", IncludeResultWithScore: "pass", NumberOfComparisonResults: 1, AggregateFunction: "avg"},
-	}
+	dashboardSLI, dashboardSLO := newDashboardSLIAndSLO()
 
 	// if there is a dashboard management zone filter get them for both the queries as well as for the dashboard link
 	dashboardManagementZoneFilter := ""
@@ -940,139 +1087,78 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 		// first - lets figure out if this tile should be included in SLI validation or not - we parse the title and look for "sli=sliname"
 		baseIndicatorName, passSLOs, warningSLOs, weight, keySli := ParsePassAndWarningFromString(tileTitle, []string{}, []string{})
 		if baseIndicatorName == "" {
-			ph.Logger.Debug(fmt.Sprintf("Chart Tile %s - NOT included as name doesnt include sli=SLINAME\n", tileTitle))
+			ph.Logger.Debugf("Chart Tile %s - NOT included as name doesnt include sli=SLINAME\n", tileTitle)
 			continue
 		}
 
 		// only interested in custom charts
 		if tile.TileType == "CUSTOM_CHARTING" {
-			ph.Logger.Debug(fmt.Sprintf("Processing custom chart tile %s, sli=%s", tileTitle, baseIndicatorName))
+			ph.Logger.Debugf("Processing custom chart tile %s, sli=%s", tileTitle, baseIndicatorName)
 
 			// we can potentially have multiple series on that chart
 			for _, series := range tile.FilterConfig.ChartConfig.Series {
 
 				// Lets query the metric definition as we need to know how many dimension the metric has
-				metricDefinition, err := ph.ExecuteMetricAPIDescribe(series.Metric)
+				metricDefinition, err := ph.ExecuteMetricAPIDescribe(ctx, series.Metric)
 				if err != nil {
-					ph.Logger.Debug(fmt.Sprintf("Error retrieving Metric Description for %s: %s\n", series.Metric, err.Error()))
+					ph.Logger.Debugf("Error retrieving Metric Description for %s: %s\n", series.Metric, err.Error())
+					if timedOut(ctx) {
+						// report the indicator as timed out instead of silently skipping it
+						sliResults = append(sliResults, ph.newFailedSLIResult(ctx, baseIndicatorName, common.IndicatorFailureQuery, err))
+					}
 					continue
 				}
 
-				// building the merge aggregator string, e.g: merge(1):merge(0) - or merge(0)
-				metricDimensionCount := len(metricDefinition.DimensionDefinitions)
-				metricAggregation := metricDefinition.DefaultAggregation.Type
-				mergeAggregator := ""
-				filterAggregator := ""
-				filterSLIDefinitionAggregator := ""
-				entitySelectorSLIDefinition := ""
-
-				// now we need to merge all the dimensions that are not part of the series.dimensions, e.g: if the metric has two dimensions but only one dimension is used in the chart we need to merge the others
-				// as multiple-merges are possible but as they are executed in sequence we have to use the right index
-				for metricDimIx := metricDimensionCount - 1; metricDimIx >= 0; metricDimIx-- {
-					doMergeDimension := true
-					metricDimIxAsString := strconv.Itoa(metricDimIx)
-					// lets check if this dimension is in the chart
-					for _, seriesDim := range series.Dimensions {
-						ph.Logger.Debug(fmt.Sprintf("seriesDim.id: %s; metricDimIx: %s\n", seriesDim.ID, metricDimIxAsString))
-						if strings.Compare(seriesDim.ID, metricDimIxAsString) == 0 {
-							// this is a dimension we want to keep and not merge
-							ph.Logger.Debug(fmt.Sprintf("not merging dimension %s\n", metricDefinition.DimensionDefinitions[metricDimIx].Name))
-							doMergeDimension = false
-
-							// lets check if we need to apply a dimension filter
-							// TODO: support multiple filters - right now we only support 1
-							if len(seriesDim.Values) > 0 {
-								filterAggregator = fmt.Sprintf(":filter(eq(%s,%s))", seriesDim.Name, seriesDim.Values[0])
-							} else {
-								// we need this for the generation of the SLI for each individual dimension value
-								// if the dimension is a dt.entity we have to add an addiotnal entityId to the entitySelector - otherwise we add a filter for the dimension
-								if strings.HasPrefix(seriesDim.Name, "dt.entity.") {
-									entitySelectorSLIDefinition = fmt.Sprintf(",entityId(FILTERDIMENSIONVALUE)")
-								} else {
-									filterSLIDefinitionAggregator = fmt.Sprintf(":filter(eq(%s,FILTERDIMENSIONVALUE))", seriesDim.Name)
-								}
-							}
-						}
-					}
+				// lets create the metricSelector and entitySelector based on the metric definition and the series configuration
+				chartQuery := ph.buildCustomChartQuery(tile, series, metricDefinition, dashboardManagementZoneFilter)
+				metricQuery := chartQuery.metricQuery
+				filterSLIDefinitionAggregator := chartQuery.filterSLIDefinitionAggregator
+				entitySelectorSLIDefinition := chartQuery.entitySelectorSLIDefinition
 
-					if doMergeDimension {
-						// this is a dimension we want to merge as it is not split by in the chart
-						ph.Logger.Debug(fmt.Sprintf("merging dimension %s\n", metricDefinition.DimensionDefinitions[metricDimIx].Name))
-						mergeAggregator = mergeAggregator + fmt.Sprintf(":merge(%d)", metricDimIx)
-					}
-				}
+				// lets build the Dynatrace API Metric query for the proposed timeframe and additonal filters!
+				fullMetricQuery, metricID, err := ph.BuildDynatraceMetricsQuery(metricQuery, startUnix, endUnix)
 
-				// handle aggregation. If "NONE" is specified we go to the defaultAggregration
-				if series.Aggregation != "NONE" {
-					metricAggregation = series.Aggregation
-				}
-				// for percentile we need to specify the percentile itself
-				if metricAggregation == "PERCENTILE" {
-					metricAggregation = fmt.Sprintf("%s(%f)", metricAggregation, series.Percentile)
-				}
-				// for rate measures such as failure rate we take average if it is "OF_INTEREST_RATIO"
-				if metricAggregation == "OF_INTEREST_RATIO" {
-					metricAggregation = "avg"
-				}
-				// for rate measures charting also provides the "OTHER_RATIO" option which is the inverse
-				// TODO: not supported via API - so we default to avg
-				if metricAggregation == "OTHER_RATIO" {
-					metricAggregation = "avg"
+				// Lets run the Query and iterate through all data per dimension. Each Dimension will become its own indicator
+				var queryResult *DynatraceResult
+				queryHandler := ph.forIndicator(baseIndicatorName)
+				queryStart := time.Now()
+				if err == nil {
+					queryResult, err = queryHandler.ExecuteMetricsAPIQuery(ctx, fullMetricQuery)
 				}
-
-				// TODO - handle aggregation rates -> probably doesnt make sense as we always evalute a short timeframe
-				// if series.AggregationRate
-
-				// lets get the true entity type as the one in the dashboard might not be accurate, e.g: IOT might be used instead of CUSTOM_DEVICE
-				// so - if the metric definition has EntityTypes defined we take the first one
-				entityType := series.EntityType
-				if len(metricDefinition.EntityType) > 0 {
-					entityType = metricDefinition.EntityType[0]
+				queryReport := IndicatorReport{
+					Type:       IndicatorTypeMetrics,
+					Query:      fullMetricQuery,
+					Unit:       metricDefinition.Unit,
+					DurationMs: time.Since(queryStart).Milliseconds(),
+					Retries:    queryHandler.requestStats.getRetries(),
 				}
-
-				// Need to implement chart filters per entity type, e.g: its possible that a chart has a filter on entites or tags
-				// lets see if we have a FiltersPerEntityType for the tiles EntityType
-				entityTileFilter := ph.GetEntitySelectorFromEntityFilter(tile.FilterConfig.FiltersPerEntityType, entityType)
-
-				// Check for tile management zone filter - this would overwrite the dashboardManagementZoneFilter
-				tileManagementZoneFilter := dashboardManagementZoneFilter
-				if tile.TileFilter.ManagementZone != nil {
-					tileManagementZoneFilter = fmt.Sprintf(",mzId(%s)", tile.TileFilter.ManagementZone.ID)
+				dataExplorerLink := ""
+				if fullMetricQuery != "" {
+					dataExplorerLink = ph.buildDataExplorerLink(fullMetricQuery)
 				}
-
-				// lets create the metricSelector and entitySelector
-				// ATTENTION: adding :names so we also get the names of the dimensions and not just the entities. This means we get two values for each dimension
-				metricQuery := fmt.Sprintf("metricSelector=%s%s%s:%s:names&entitySelector=type(%s)%s%s",
-					series.Metric, mergeAggregator, filterAggregator, strings.ToLower(metricAggregation),
-					entityType, entityTileFilter, tileManagementZoneFilter)
-
-				// lets build the Dynatrace API Metric query for the proposed timeframe and additonal filters!
-				fullMetricQuery, metricID := ph.BuildDynatraceMetricsQuery(metricQuery, startUnix, endUnix)
-
-				// Lets run the Query and iterate through all data per dimension. Each Dimension will become its own indicator
-				queryResult, err := ph.ExecuteMetricsAPIQuery(fullMetricQuery)
+				ph.collectTimeseries(ctx, baseIndicatorName, metricQuery, metricDefinition.Unit, startUnix, endUnix)
 				if err != nil {
-					ph.Logger.Debug(fmt.Sprintf("No result for query: %v", err))
+					ph.Logger.Debugf("No result for query: %v", err)
 
 					// ERROR-CASE: Metric API return no values or an error
 					// we couldnt query data - so - we return the error back as part of our SLIResults
-					sliResults = append(sliResults, &keptnevents.SLIResult{
-						Metric:  baseIndicatorName,
-						Value:   0,
-						Success: false, // Mark as failure
-						Message: err.Error(),
-					})
+					sliResults = append(sliResults, ph.newFailedSLIResult(ctx, baseIndicatorName, common.IndicatorFailureQuery, err))
+					ph.addSLILink(baseIndicatorName, dataExplorerLink)
+					indicatorReport := queryReport
+					indicatorReport.Indicator = baseIndicatorName
+					indicatorReport.setResult(0, err)
+					ph.addIndicatorReport(&indicatorReport)
 
 					// add this to our SLI Indicator JSON in case we need to generate an SLI.yaml
 					dashboardSLI.Indicators[baseIndicatorName] = metricQuery
 				} else {
 					// SUCCESS-CASE: we retrieved values - now we interate through the results and create an indicator result for every dimension
 					for _, singleResult := range queryResult.Result {
-						ph.Logger.Debug(fmt.Sprintf("Processing result for %s\n", singleResult.MetricID))
+						ph.Logger.Debugf("Processing result for %s\n", singleResult.MetricID)
 						if ph.isMatchingMetricID(singleResult.MetricID, metricID) {
 							dataResultCount := len(singleResult.Data)
 							if dataResultCount == 0 {
-								ph.Logger.Debug(fmt.Sprintf("No data for this metric!\n"))
+								ph.Logger.Debugf("No data for this metric!\n")
 							}
 							for _, singleDataEntry := range singleResult.Data {
 								//
@@ -1092,7 +1178,7 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 									dimensionCount := len(singleDataEntry.Dimensions)
 									dimensionIncrement := 2
 									if dimensionCount != (len(series.Dimensions) * 2) {
-										ph.Logger.Debug(fmt.Sprintf("DIDNT RECEIVE ID and Names. Lets assume we just received the dimension IDs"))
+										ph.Logger.Debugf("DIDNT RECEIVE ID and Names. Lets assume we just received the dimension IDs")
 										dimensionIncrement = 1
 									}
 
@@ -1119,13 +1205,14 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 									value = value + singleValue
 								}
 								value = value / float64(len(singleDataEntry.Values))
+								rawValue := value
 
 								// lets scale the metric
 								value = scaleData(metricDefinition.MetricID, metricDefinition.Unit, value)
 
 								// we got our metric, slos and the value
 
-								ph.Logger.Debug(fmt.Sprintf("%s: %0.2f\n", indicatorName, value))
+								ph.Logger.Debugf("%s: %0.2f\n", indicatorName, value)
 
 								// lets add the value to our SLIResult array
 								sliResults = append(sliResults, &keptnevents.SLIResult{
@@ -1133,6 +1220,12 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 									Value:   value,
 									Success: true,
 								})
+								ph.addSLILink(indicatorName, dataExplorerLink)
+								indicatorReport := queryReport
+								indicatorReport.Indicator = indicatorName
+								indicatorReport.RawValue = rawValue
+								indicatorReport.setResult(value, nil)
+								ph.addIndicatorReport(&indicatorReport)
 
 								// add this to our SLI Indicator JSON in case we need to generate an SLI.yaml
 								// we use ":names" to find the right spot to add our custom dimension filter
@@ -1150,7 +1243,7 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 								dashboardSLO.Objectives = append(dashboardSLO.Objectives, sloDefinition)
 							}
 						} else {
-							ph.Logger.Debug(fmt.Sprintf("Retrieving unintened metric %s while expecting %s\n", singleResult.MetricID, metricID))
+							ph.Logger.Debugf("Retrieving unintened metric %s while expecting %s\n", singleResult.MetricID, metricID)
 						}
 					}
 				}
@@ -1165,11 +1258,26 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 			// PIE_CHART, COLUMN_CHART: we assume the first column is the dimension and the second column is the value column
 			// TABLE: we assume the first column is the dimension and the last is the value
 
-			usql := ph.BuildDynatraceUSQLQuery(tile.Query, startUnix, endUnix)
-			usqlResult, err := ph.ExecuteUSQLQuery(usql)
+			var usqlResult *DTUSQLResult
+			usqlLink := ""
+			usql, err := ph.BuildDynatraceUSQLQuery(tile.Query, startUnix, endUnix)
+			queryHandler := ph.forIndicator(baseIndicatorName)
+			queryStart := time.Now()
+			if err == nil {
+				usqlLink = ph.buildUSQLLink(usql)
+				usqlResult, err = queryHandler.ExecuteUSQLQuery(ctx, usql)
+			}
+			queryReport := IndicatorReport{
+				Type:       IndicatorTypeUSQL,
+				Query:      usql,
+				DurationMs: time.Since(queryStart).Milliseconds(),
+				Retries:    queryHandler.requestStats.getRetries(),
+			}
 
 			if err != nil {
-
+				if timedOut(ctx) {
+					sliResults = append(sliResults, ph.newFailedSLIResult(ctx, baseIndicatorName, common.IndicatorFailureQuery, err))
+				}
 			} else {
 
 				for _, rowValue := range usqlResult.Values {
@@ -1188,7 +1296,7 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 						dimensionName = rowValue[0].(string)
 						dimensionValue = rowValue[len(rowValue)-1].(float64)
 					} else {
-						ph.Logger.Debug(fmt.Sprintf("USQL Tile Type %s currently not supported!", tile.Type))
+						ph.Logger.Debugf("USQL Tile Type %s currently not supported!", tile.Type)
 						continue
 					}
 
@@ -1201,7 +1309,7 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 						indicatorName = indicatorName + "_" + dimensionName
 					}
 
-					ph.Logger.Debug(fmt.Sprintf("%s: %0.2f\n", indicatorName, dimensionValue))
+					ph.Logger.Debugf("%s: %0.2f\n", indicatorName, dimensionValue)
 
 					// lets add the value to our SLIResult array
 					sliResults = append(sliResults, &keptnevents.SLIResult{
@@ -1209,6 +1317,12 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 						Value:   dimensionValue,
 						Success: true,
 					})
+					ph.addSLILink(indicatorName, usqlLink)
+					indicatorReport := queryReport
+					indicatorReport.Indicator = indicatorName
+					indicatorReport.RawValue = dimensionValue
+					indicatorReport.setResult(dimensionValue, nil)
+					ph.addIndicatorReport(&indicatorReport)
 
 					// add this to our SLI Indicator JSON in case we need to generate an SLI.yaml
 					// in that case we also need to mask it with USQL, TITLE_TYPE, DIMENSIONNAME
@@ -1231,18 +1345,181 @@ func (ph *Handler) QueryDynatraceDashboardForSLIs(keptnEvent *common.BaseKeptnEv
 	return dashboardLinkAsLabel, dashboardJSON, dashboardSLI, dashboardSLO, sliResults, nil
 }
 
+// customChartQuery is the metric query generated for a series of a custom chart tile
+type customChartQuery struct {
+	// metricQuery uses :names so the names of the dimensions are returned as well
+	metricQuery string
+	// for a series split by a dimension one SLI is generated per dimension value - FILTERDIMENSIONVALUE is replaced by that value
+	filterSLIDefinitionAggregator string
+	entitySelectorSLIDefinition   string
+}
+
+/**
+ * buildCustomChartQuery generates the metricSelector and entitySelector of a custom chart series
+ * Dimensions of the metric the chart isn't split by are merged, aggregation and entity type are taken from the metric definition if not specified by the series
+ */
+func (ph *Handler) buildCustomChartQuery(tile Tile, series ChartSeries, metricDefinition *MetricDefinition, dashboardManagementZoneFilter string) customChartQuery {
+	// building the merge aggregator string, e.g: merge(1):merge(0) - or merge(0)
+	metricDimensionCount := len(metricDefinition.DimensionDefinitions)
+	metricAggregation := metricDefinition.DefaultAggregation.Type
+	mergeAggregator := ""
+	filterAggregator := ""
+	filterSLIDefinitionAggregator := ""
+	entitySelectorSLIDefinition := ""
+
+	// now we need to merge all the dimensions that are not part of the series.dimensions, e.g: if the metric has two dimensions but only one dimension is used in the chart we need to merge the others
+	// as multiple-merges are possible but as they are executed in sequence we have to use the right index
+	for metricDimIx := metricDimensionCount - 1; metricDimIx >= 0; metricDimIx-- {
+		doMergeDimension := true
+		metricDimIxAsString := strconv.Itoa(metricDimIx)
+		// lets check if this dimension is in the chart
+		for _, seriesDim := range series.Dimensions {
+			ph.Logger.Debugf("seriesDim.id: %s; metricDimIx: %s\n", seriesDim.ID, metricDimIxAsString)
+			if strings.Compare(seriesDim.ID, metricDimIxAsString) == 0 {
+				// this is a dimension we want to keep and not merge
+				ph.Logger.Debugf("not merging dimension %s\n", metricDefinition.DimensionDefinitions[metricDimIx].Name)
+				doMergeDimension = false
+
+				// lets check if we need to apply a dimension filter
+				// TODO: support multiple filters - right now we only support 1
+				if len(seriesDim.Values) > 0 {
+					filterAggregator = fmt.Sprintf(":filter(eq(%s,%s))", seriesDim.Name, seriesDim.Values[0])
+				} else {
+					// we need this for the generation of the SLI for each individual dimension value
+					// if the dimension is a dt.entity we have to add an addiotnal entityId to the entitySelector - otherwise we add a filter for the dimension
+					if strings.HasPrefix(seriesDim.Name, "dt.entity.") {
+						entitySelectorSLIDefinition = fmt.Sprintf(",entityId(FILTERDIMENSIONVALUE)")
+					} else {
+						filterSLIDefinitionAggregator = fmt.Sprintf(":filter(eq(%s,FILTERDIMENSIONVALUE))", seriesDim.Name)
+					}
+				}
+			}
+		}
+
+		if doMergeDimension {
+			// this is a dimension we want to merge as it is not split by in the chart
+			ph.Logger.Debugf("merging dimension %s\n", metricDefinition.DimensionDefinitions[metricDimIx].Name)
+			mergeAggregator = mergeAggregator + fmt.Sprintf(":merge(%d)", metricDimIx)
+		}
+	}
+
+	// handle aggregation. If "NONE" is specified we go to the defaultAggregration
+	if series.Aggregation != "NONE" {
+		metricAggregation = series.Aggregation
+	}
+	// for percentile we need to specify the percentile itself
+	if metricAggregation == "PERCENTILE" {
+		metricAggregation = fmt.Sprintf("%s(%f)", metricAggregation, series.Percentile)
+	}
+	// for rate measures such as failure rate we take average if it is "OF_INTEREST_RATIO"
+	if metricAggregation == "OF_INTEREST_RATIO" {
+		metricAggregation = "avg"
+	}
+	// for rate measures charting also provides the "OTHER_RATIO" option which is the inverse
+	// TODO: not supported via API - so we default to avg
+	if metricAggregation == "OTHER_RATIO" {
+		metricAggregation = "avg"
+	}
+
+	// TODO - handle aggregation rates -> probably doesnt make sense as we always evalute a short timeframe
+	// if series.AggregationRate
+
+	// lets get the true entity type as the one in the dashboard might not be accurate, e.g: IOT might be used instead of CUSTOM_DEVICE
+	// so - if the metric definition has EntityTypes defined we take the first one
+	entityType := series.EntityType
+	if len(metricDefinition.EntityType) > 0 {
+		entityType = metricDefinition.EntityType[0]
+	}
+
+	// Need to implement chart filters per entity type, e.g: its possible that a chart has a filter on entites or tags
+	// lets see if we have a FiltersPerEntityType for the tiles EntityType
+	entityTileFilter := ph.GetEntitySelectorFromEntityFilter(tile.FilterConfig.FiltersPerEntityType, entityType)
+
+	// Check for tile management zone filter - this would overwrite the dashboardManagementZoneFilter
+	tileManagementZoneFilter := dashboardManagementZoneFilter
+	if tile.TileFilter.ManagementZone != nil {
+		tileManagementZoneFilter = fmt.Sprintf(",mzId(%s)", tile.TileFilter.ManagementZone.ID)
+	}
+
+	// lets create the metricSelector and entitySelector
+	// ATTENTION: adding :names so we also get the names of the dimensions and not just the entities. This means we get two values for each dimension
+	metricQuery := fmt.Sprintf("metricSelector=%s%s%s:%s:names&entitySelector=type(%s)%s%s",
+		series.Metric, mergeAggregator, filterAggregator, strings.ToLower(metricAggregation),
+		entityType, entityTileFilter, tileManagementZoneFilter)
+
+	return customChartQuery{
+		metricQuery:                   metricQuery,
+		filterSLIDefinitionAggregator: filterSLIDefinitionAggregator,
+		entitySelectorSLIDefinition:   entitySelectorSLIDefinition,
+	}
+}
+
+/**
+ * GetSLIResultsForIndicators queries all indicators and returns their SLIResults in the same order as the indicators were passed
+ * Baseline results of an indicator (<sli>_baseline, <sli>_change) directly follow the indicator
+ * Up to defaults.parallelism (dynatrace.conf.yaml) indicators are queried in parallel
+ */
+func (ph *Handler) GetSLIResultsForIndicators(ctx context.Context, indicators []string, startUnix time.Time, endUnix time.Time) []*keptnevents.SLIResult {
+	if len(indicators) == 0 {
+		return nil
+	}
+
+	resultsPerIndicator := make([][]*keptnevents.SLIResult, len(indicators))
+
+	parallelism := common.ResolveSLIDefaults(ph.Defaults).Parallelism
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+
+	for i, indicator := range indicators {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, indicator string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			ph.Logger.WithIndicator(indicator).Info("Fetching indicator: " + indicator)
+			resultsPerIndicator[i] = ph.GetSLIResults(ctx, indicator, startUnix, endUnix)
+		}(i, indicator)
+	}
+	wg.Wait()
+
+	sliResults := []*keptnevents.SLIResult{}
+	for _, results := range resultsPerIndicator {
+		sliResults = append(sliResults, results...)
+	}
+
+	return sliResults
+}
+
 /**
  * GetSLIValue queries a single metric value from Dynatrace API
  * Can handle both Metric Queries as well as USQL
  */
-func (ph *Handler) GetSLIValue(metric string, startUnix time.Time, endUnix time.Time) (float64, error) {
+func (ph *Handler) GetSLIValue(ctx context.Context, metric string, startUnix time.Time, endUnix time.Time) (float64, error) {
 
 	// first we get the query from the SLI configuration based on its logical name
 	metricsQuery, err := ph.getTimeseriesConfig(metric)
 	if err != nil {
 		return 0, fmt.Errorf("Error when fetching SLI config for %s %s\n", metric, err.Error())
 	}
-	ph.Logger.Debug(fmt.Sprintf("Retrieved SLI config for %s: %s", metric, metricsQuery))
+	ph.Logger.Debugf("Retrieved SLI config for %s: %s", metric, metricsQuery)
+
+	// baseline parameters are only relevant for GetSLIResults
+	metricsQuery, _ = extractQueryParameters(metricsQuery, baselineParameter, baselineModeParameter)
+
+	return ph.querySLIValue(ctx, metric, metricsQuery, startUnix, endUnix)
+}
+
+/**
+ * executeSLIQuery executes the passed Metric or USQL query for the timeframe and returns its single value
+ * The resolved query, unit and unscaled value are stored in the passed report
+ */
+func (ph *Handler) executeSLIQuery(ctx context.Context, metric string, metricsQuery string, startUnix time.Time, endUnix time.Time, report *IndicatorReport) (float64, error) {
+	// BASELINE: compares a service against its Dynatrace baseline
+	if strings.HasPrefix(metricsQuery, AutoBaselinePrefix) {
+		report.Query = metricsQuery
+		return ph.queryAutoBaselineValue(ctx, metric, metricsQuery, startUnix, endUnix)
+	}
 
 	var (
 		metricIDExists    = false
@@ -1262,8 +1539,13 @@ func (ph *Handler) GetSLIValue(metric string, startUnix time.Time, endUnix time.
 		requestedDimensionName := querySplits[2]
 		usqlRawQuery := querySplits[3]
 
-		usql := ph.BuildDynatraceUSQLQuery(usqlRawQuery, startUnix, endUnix)
-		usqlResult, err := ph.ExecuteUSQLQuery(usql)
+		usql, err := ph.BuildDynatraceUSQLQuery(usqlRawQuery, startUnix, endUnix)
+		if err != nil {
+			return 0, err
+		}
+		report.Query = usql
+		ph.addSLILink(metric, ph.buildUSQLLink(usql))
+		usqlResult, err := ph.ExecuteUSQLQuery(ctx, usql)
 
 		if err != nil {
 			return 0, fmt.Errorf("Error executing USQL Query %v", err)
@@ -1285,7 +1567,7 @@ func (ph *Handler) GetSLIValue(metric string, startUnix time.Time, endUnix time.
 				dimensionName = rowValue[0].(string)
 				dimensionValue = rowValue[len(rowValue)-1].(float64)
 			} else {
-				ph.Logger.Debug(fmt.Sprintf("USQL Tile Type %s currently not supported!", tileName))
+				ph.Logger.Debugf("USQL Tile Type %s currently not supported!", tileName)
 				continue
 			}
 
@@ -1295,6 +1577,7 @@ func (ph *Handler) GetSLIValue(metric string, startUnix time.Time, endUnix time.
 				actualMetricValue = dimensionValue
 			}
 		}
+		report.RawValue = actualMetricValue
 	} else {
 		metricUnit := ""
 
@@ -1311,8 +1594,16 @@ func (ph *Handler) GetSLIValue(metric string, startUnix time.Time, endUnix time.
 		//
 		// In this case we are querying regular MEtrics
 		// now we are enriching it with all the additonal parameters, e.g: time, filters ...
-		metricsQuery, metricID := ph.BuildDynatraceMetricsQuery(metricsQuery, startUnix, endUnix)
-		result, err := ph.ExecuteMetricsAPIQuery(metricsQuery)
+		rawMetricsQuery := metricsQuery
+		metricsQuery, metricID, err := ph.BuildDynatraceMetricsQuery(metricsQuery, startUnix, endUnix)
+		if err != nil {
+			return 0, err
+		}
+		report.Query = metricsQuery
+		report.Unit = metricUnit
+		ph.addSLILink(metric, ph.buildDataExplorerLink(metricsQuery))
+		ph.collectTimeseries(ctx, metric, rawMetricsQuery, metricUnit, startUnix, endUnix)
+		result, err := ph.ExecuteMetricsAPIQuery(ctx, metricsQuery)
 
 		if err != nil {
 			return 0, fmt.Errorf("error from Execute Metrics API Query: %s\n", err.Error())
@@ -1335,6 +1626,7 @@ func (ph *Handler) GetSLIValue(metric string, startUnix time.Time, endUnix time.
 			}
 		}
 
+		report.RawValue = actualMetricValue
 		actualMetricValue = scaleData(metricID, metricUnit, actualMetricValue)
 	}
 
@@ -1369,15 +1661,34 @@ func scaleData(metricID string, unit string, value float64) float64 {
 	return value
 }
 
-func (ph *Handler) replaceQueryParameters(query string) string {
+/**
+ * replaceQueryParameters resolves the placeholders of an SLI query
+ * Queries containing {{ are executed as template first (see common.ExpandQueryTemplate)
+ * Returns an error listing all placeholders that could not be resolved, e.g: $LABEL.foo
+ */
+func (ph *Handler) replaceQueryParameters(query string) (string, error) {
+	if common.IsQueryTemplate(query) {
+		filters := map[string]string{}
+		for _, filter := range ph.CustomFilters {
+			filters[filter.Key] = filter.Value
+		}
+
+		expandedQuery, err := common.ExpandQueryTemplate(query, ph.KeptnEvent, filters, ph.Logger)
+		if err != nil {
+			return "", err
+		}
+		query = expandedQuery
+	}
+
 	// apply customfilters
+	// the filter itself is not modified as indicators might be queried in parallel
 	for _, filter := range ph.CustomFilters {
-		filter.Value = strings.Replace(filter.Value, "'", "", -1)
-		filter.Value = strings.Replace(filter.Value, "\"", "", -1)
+		filterValue := strings.Replace(filter.Value, "'", "", -1)
+		filterValue = strings.Replace(filterValue, "\"", "", -1)
 
 		// replace the key in both variants, "normal" and uppercased
-		query = strings.Replace(query, "$"+filter.Key, filter.Value, -1)
-		query = strings.Replace(query, "$"+strings.ToUpper(filter.Key), filter.Value, -1)
+		query = strings.Replace(query, "$"+filter.Key, filterValue, -1)
+		query = strings.Replace(query, "$"+strings.ToUpper(filter.Key), filterValue, -1)
 	}
 
 	// apply default values
@@ -1386,12 +1697,18 @@ func (ph *Handler) replaceQueryParameters(query string) string {
 	query = strings.Replace(query, "$SERVICE", ph.Service, -1)
 	query = strings.Replace(query, "$DEPLOYMENT", ph.Deployment, -1)*/
 
-	query = common.ReplaceKeptnPlaceholders(query, ph.KeptnEvent)
+	query = common.ReplaceKeptnPlaceholders(query, ph.KeptnEvent, ph.Logger)
+
+	if unresolved := common.FindUnresolvedPlaceholders(query); len(unresolved) > 0 {
+		// the query is not part of the error as it might contain the values of resolved $ENV placeholders
+		return "", fmt.Errorf("unresolved placeholders: %s", strings.Join(unresolved, ", "))
+	}
 
-	return query
+	return query, nil
 }
 
 // based on the requested metric a dynatrace timeseries with its aggregation type is returned
+// custom queries (sli.yaml or dashboard) take precedence over the default SLI catalog
 func (ph *Handler) getTimeseriesConfig(metric string) (string, error) {
 	if val, ok := ph.CustomQueries[metric]; ok {
 		return val, nil
@@ -1399,18 +1716,39 @@ func (ph *Handler) getTimeseriesConfig(metric string) (string, error) {
 
 	// default SLI configs
 	// Switched to new metric v2 query langugae as discussed here: https://github.com/keptn-contrib/dynatrace-sli-service/issues/91
-	switch metric {
-	case Throughput:
-		return "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),tag(keptn_service:$SERVICE),tag(keptn_deployment:$DEPLOYMENT)", nil
-	case ErrorRate:
-		return "metricSelector=builtin:service.errors.total.count:merge(0):avg&entitySelector=type(SERVICE),tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),tag(keptn_service:$SERVICE),tag(keptn_deployment:$DEPLOYMENT)", nil
-	case ResponseTimeP50:
-		return "metricSelector=builtin:service.response.time:merge(0):percentile(50)&entitySelector=type(SERVICE),tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),tag(keptn_service:$SERVICE),tag(keptn_deployment:$DEPLOYMENT)", nil
-	case ResponseTimeP90:
-		return "metricSelector=builtin:service.response.time:merge(0):percentile(90)&entitySelector=type(SERVICE),tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),tag(keptn_service:$SERVICE),tag(keptn_deployment:$DEPLOYMENT)", nil
-	case ResponseTimeP95:
-		return "metricSelector=builtin:service.response.time:merge(0):percentile(95)&entitySelector=type(SERVICE),tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),tag(keptn_service:$SERVICE),tag(keptn_deployment:$DEPLOYMENT)", nil
-	default:
-		return "", fmt.Errorf("unsupported SLI metric %s", metric)
+	// the entitySelector is built based on the defaults in dynatrace.conf.yaml, e.g: tag names, entity type and management zone
+	if val, ok := ph.DefaultQueries[metric]; ok {
+		return ph.resolveCatalogQuery(val), nil
+	}
+
+	return "", fmt.Errorf("unsupported SLI metric %s", metric)
+}
+
+/**
+ * getDefaultEntitySelector returns the entitySelector used by the built-in SLIs, e.g:
+ * type(SERVICE),tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),tag(keptn_service:$SERVICE),tag(keptn_deployment:$DEPLOYMENT)
+ * For entityType PROCESS_GROUP the tags are expected on the process group the service runs on
+ */
+func (ph *Handler) getDefaultEntitySelector() string {
+	defaults := common.ResolveSLIDefaults(ph.Defaults)
+	tagFilter := ph.getTagFilter()
+
+	entitySelector := "type(SERVICE)"
+	if tagFilter != "" {
+		if defaults.EntityType == common.SLIEntityTypeProcessGroup {
+			entitySelector = entitySelector + fmt.Sprintf(",fromRelationships.runsOn(type(PROCESS_GROUP),%s)", tagFilter)
+		} else {
+			entitySelector = entitySelector + "," + tagFilter
+		}
 	}
+
+	if defaults.ManagementZone != "" {
+		if _, err := strconv.ParseInt(defaults.ManagementZone, 10, 64); err == nil {
+			entitySelector = entitySelector + fmt.Sprintf(",mzId(%s)", defaults.ManagementZone)
+		} else {
+			entitySelector = entitySelector + fmt.Sprintf(",mzName(\"%s\")", defaults.ManagementZone)
+		}
+	}
+
+	return entitySelector
 }
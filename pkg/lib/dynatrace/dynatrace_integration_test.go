@@ -1,15 +1,19 @@
 package dynatrace
 
 import (
+	"encoding/json"
 	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/lib/fakedynatrace"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 )
@@ -67,12 +71,12 @@ func TestGetSLIValue(t *testing.T) {
 	keptnEvent.DeploymentStrategy = ""
 
 	// dh := NewDynatraceHandler("http://dynatrace", "sockshop", "dev", "carts", nil, nil, "")
-	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "")
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
 	dh.HTTPClient = httpClient
 
 	start := time.Unix(1571649084, 0).UTC()
 	end := time.Unix(1571649085, 0).UTC()
-	value, err := dh.GetSLIValue(ResponseTimeP50, start, end)
+	value, err := dh.GetSLIValue(context.Background(), ResponseTimeP50, start, end)
 
 	assert.NoError(t, err)
 
@@ -116,7 +120,7 @@ func TestGetSLIValueWithOldandNewCustomQueryFormat(t *testing.T) {
 	keptnEvent.Service = "carts"
 	keptnEvent.DeploymentStrategy = ""
 
-	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "")
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
 	dh.HTTPClient = httpClient
 
 	// overwrite custom queries with the new format (starting with metricSelector=)
@@ -125,7 +129,7 @@ func TestGetSLIValueWithOldandNewCustomQueryFormat(t *testing.T) {
 
 	start := time.Unix(1571649084, 0).UTC()
 	end := time.Unix(1571649085, 0).UTC()
-	value, err := dh.GetSLIValue(ResponseTimeP50, start, end)
+	value, err := dh.GetSLIValue(context.Background(), ResponseTimeP50, start, end)
 
 	assert.EqualValues(t, nil, err)
 	assert.InDelta(t, 8.43340, value, 0.001)
@@ -136,7 +140,7 @@ func TestGetSLIValueWithOldandNewCustomQueryFormat(t *testing.T) {
 
 	start = time.Unix(1571649084, 0).UTC()
 	end = time.Unix(1571649085, 0).UTC()
-	value, err = dh.GetSLIValue(ResponseTimeP50, start, end)
+	value, err = dh.GetSLIValue(context.Background(), ResponseTimeP50, start, end)
 
 	assert.EqualValues(t, nil, err)
 	assert.InDelta(t, 8.43340, value, 0.001)
@@ -147,7 +151,7 @@ func TestGetSLIValueWithOldandNewCustomQueryFormat(t *testing.T) {
 
 	start = time.Unix(1571649084, 0).UTC()
 	end = time.Unix(1571649085, 0).UTC()
-	value, err = dh.GetSLIValue(ResponseTimeP50, start, end)
+	value, err = dh.GetSLIValue(context.Background(), ResponseTimeP50, start, end)
 
 	assert.EqualValues(t, nil, err)
 	assert.InDelta(t, 8.43340, value, 0.001)
@@ -181,12 +185,12 @@ func TestGetSLIValueWithEmptyResult(t *testing.T) {
 	keptnEvent.Service = "carts"
 	keptnEvent.DeploymentStrategy = ""
 
-	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "")
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
 	dh.HTTPClient = httpClient
 
 	start := time.Unix(1571649084, 0).UTC()
 	end := time.Unix(1571649085, 0).UTC()
-	value, err := dh.GetSLIValue(ResponseTimeP50, start, end)
+	value, err := dh.GetSLIValue(context.Background(), ResponseTimeP50, start, end)
 
 	assert.Error(t, err)
 
@@ -230,12 +234,12 @@ func TestGetSLIValueWithoutExpectedMetric(t *testing.T) {
 	keptnEvent.Service = "carts"
 	keptnEvent.DeploymentStrategy = ""
 
-	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "")
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
 	dh.HTTPClient = httpClient
 
 	start := time.Unix(1571649084, 0).UTC()
 	end := time.Unix(1571649085, 0).UTC()
-	value, err := dh.GetSLIValue(ResponseTimeP50, start, end)
+	value, err := dh.GetSLIValue(context.Background(), ResponseTimeP50, start, end)
 
 	assert.EqualValues(t, errors.New("Not able to query identifier response_time_p50 from Dynatrace"), err)
 
@@ -280,7 +284,7 @@ func TestGetSLIEndTimeFuture(t *testing.T) {
 	start := time.Now()
 	// artificially increase end time to be in the future
 	end := time.Now().Add(3 * time.Minute)
-	value, err := dh.GetSLIValue(Throughput, start, end, []*events.SLIFilter{})
+	value, err := dh.GetSLIValue(context.Background(), Throughput, start, end, []*events.SLIFilter{})
 
 	assert.EqualValues(t, 0.0, value)
 	assert.NotNil(t, err, nil)
@@ -300,7 +304,7 @@ func TestGetSLIStartTimeAfterEndTime(t *testing.T) {
 	start := time.Now()
 	// artificially increase end time to be in the future
 	end := time.Now().Add(-1 * time.Minute)
-	value, err := dh.GetSLIValue(Throughput, start, end, []*events.SLIFilter{})
+	value, err := dh.GetSLIValue(context.Background(), Throughput, start, end, []*events.SLIFilter{})
 
 	assert.EqualValues(t, 0.0, value)
 	assert.NotNil(t, err, nil)
@@ -344,13 +348,13 @@ func TestGetSLISleep(t *testing.T) {
 	keptnEvent.Service = "carts"
 	keptnEvent.DeploymentStrategy = ""
 
-	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "")
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
 	dh.HTTPClient = httpClient
 
 	start := time.Now().Add(-5 * time.Minute)
 	// artificially increase end time to be in the future
 	end := time.Now().Add(-80 * time.Second)
-	value, err := dh.GetSLIValue(ResponseTimeP50, start, end)
+	value, err := dh.GetSLIValue(context.Background(), ResponseTimeP50, start, end)
 
 	assert.InDelta(t, 8.43340, value, 0.001)
 	assert.Nil(t, err)
@@ -372,13 +376,718 @@ func TestGetSLIValueWithErrorResponse(t *testing.T) {
 	keptnEvent.Service = "carts"
 	keptnEvent.DeploymentStrategy = ""
 
-	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "")
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
 	dh.HTTPClient = httpClient
 
 	start := time.Unix(1571649084, 0).UTC()
 	end := time.Unix(1571649085, 0).UTC()
-	value, err := dh.GetSLIValue(Throughput, start, end)
+	value, err := dh.GetSLIValue(context.Background(), Throughput, start, end)
 
 	assert.EqualValues(t, 0.0, value)
 	assert.NotNil(t, err, nil)
 }
+
+// Tests that GetSLIResults queries the reference timeframe of an indicator with a baseline
+func TestGetSLIResultsWithBaseline(t *testing.T) {
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649384, 0).UTC()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the baseline one week before returns 4000 microseconds, the evaluation timeframe 5000
+		value := "5000"
+		if r.URL.Query().Get("from") == common.TimestampToString(start.Add(-7*24*time.Hour)) {
+			value = "4000"
+		}
+		w.Write([]byte(`{"totalCount": 1, "nextPageKey": null, "result": [{"metricId": "builtin:service.response.time:merge(0):avg", "data": [{"dimensions": [], "timestamps": [1579097520000], "values": [` + value + `]}]}]}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	keptnEvent := &common.BaseKeptnEvent{}
+	keptnEvent.Project = "sockshop"
+	keptnEvent.Stage = "dev"
+	keptnEvent.Service = "carts"
+
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+	dh.CustomQueries = map[string]string{
+		"rt":         "metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)&baseline=-1w&baselineMode=both",
+		"rt_value":   "metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)&baseline=-1w",
+		"rt_invalid": "metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)&baseline=+1d&baselineMode=change",
+	}
+
+	results := dh.GetSLIResults(context.Background(), "rt", start, end)
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, "rt", results[0].Metric)
+		assert.InDelta(t, 5.0, results[0].Value, 0.001)
+		assert.Equal(t, "rt_baseline", results[1].Metric)
+		assert.InDelta(t, 4.0, results[1].Value, 0.001)
+		assert.Equal(t, "rt_change", results[2].Metric)
+		assert.InDelta(t, 25.0, results[2].Value, 0.001)
+		assert.True(t, results[2].Success)
+	}
+
+	results = dh.GetSLIResults(context.Background(), "rt_value", start, end)
+	if assert.Len(t, results, 2) {
+		assert.Equal(t, "rt_value_baseline", results[1].Metric)
+		assert.InDelta(t, 4.0, results[1].Value, 0.001)
+	}
+
+	results = dh.GetSLIResults(context.Background(), "rt_invalid", start, end)
+	if assert.Len(t, results, 2) {
+		assert.True(t, results[0].Success)
+		assert.Equal(t, "rt_invalid_change", results[1].Metric)
+		assert.False(t, results[1].Success)
+		assert.Contains(t, results[1].Message, "the baseline has to be in the past")
+	}
+
+	// GetSLIValue ignores the baseline
+	value, err := dh.GetSLIValue(context.Background(), "rt", start, end)
+	assert.NoError(t, err)
+	assert.InDelta(t, 5.0, value, 0.001)
+}
+
+// Tests that BASELINE indicators compare the measured value of a service with its Dynatrace baseline
+func TestGetSLIValueWithAutoBaseline(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimSuffix(r.URL.Path, "/") {
+		case "/api/v2/entities":
+			if r.URL.Query().Get("entitySelector") != "type(SERVICE),tag(keptn_service:carts)" {
+				w.Write([]byte(`{"totalCount": 0, "entities": []}`))
+				return
+			}
+			w.Write([]byte(`{"totalCount": 1, "entities": [{"entityId": "SERVICE-1234", "displayName": "carts"}]}`))
+		case "/api/v1/entity/services/SERVICE-1234/baseline":
+			// the baseline has to be the one of the evaluation timeframe
+			if r.URL.Query().Get("startTimestamp") != "1571649084000" || r.URL.Query().Get("endTimestamp") != "1571649384000" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// response times in microseconds, the failure rate in percent
+			w.Write([]byte(`{"entityId": "SERVICE-1234", "displayName": "carts", "responseTimeMedianBaseline": 4000, "responseTimeP90Baseline": 10000, "failureRateBaseline": 2}`))
+		case "/api/v2/metrics/query":
+			if !strings.Contains(r.URL.Query().Get("entitySelector"), "entityId(SERVICE-1234)") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// builtin:service.errors.total.rate is in percent as well
+			value := "5000"
+			if strings.HasPrefix(r.URL.Query().Get("metricSelector"), "builtin:service.errors.total.rate") {
+				value = "5"
+			}
+			w.Write([]byte(`{"totalCount": 1, "nextPageKey": null, "result": [{"metricId": "` + r.URL.Query().Get("metricSelector") + `", "data": [{"dimensions": [], "timestamps": [1579097520000], "values": [` + value + `]}]}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	keptnEvent := &common.BaseKeptnEvent{}
+	keptnEvent.Project = "sockshop"
+	keptnEvent.Stage = "dev"
+	keptnEvent.Service = "carts"
+
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+	dh.CustomQueries = map[string]string{
+		"rt_p50_ratio":     "BASELINE;RESPONSE_TIME_P50;ratio;type(SERVICE),tag(keptn_service:$SERVICE)",
+		"rt_p90_deviation": "BASELINE;RESPONSE_TIME_P90;deviation;type(SERVICE),tag(keptn_service:$SERVICE)",
+		"failure_rate":     "BASELINE;FAILURE_RATE;ratio;type(SERVICE),tag(keptn_service:$SERVICE)",
+		"failure_rate_pp":  "BASELINE;FAILURE_RATE;deviation;type(SERVICE),tag(keptn_service:$SERVICE)",
+		"no_service":       "BASELINE;RESPONSE_TIME_P50;ratio;type(SERVICE),tag(keptn_service:unknown)",
+		"invalid_mode":     "BASELINE;RESPONSE_TIME_P50;percent",
+	}
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649384, 0).UTC()
+
+	value, err := dh.GetSLIValue(context.Background(), "rt_p50_ratio", start, end)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.25, value, 0.001)
+
+	value, err = dh.GetSLIValue(context.Background(), "rt_p90_deviation", start, end)
+	assert.NoError(t, err)
+	assert.InDelta(t, -5.0, value, 0.001)
+
+	// 5% failures measured vs. a baseline of 2%
+	value, err = dh.GetSLIValue(context.Background(), "failure_rate", start, end)
+	assert.NoError(t, err)
+	assert.InDelta(t, 2.5, value, 0.001)
+
+	// the deviation of the failure rate is in percentage points - not a ratio
+	value, err = dh.GetSLIValue(context.Background(), "failure_rate_pp", start, end)
+	assert.NoError(t, err)
+	assert.InDelta(t, 3.0, value, 0.001)
+
+	_, err = dh.GetSLIValue(context.Background(), "no_service", start, end)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "matches 0 services")
+	}
+
+	_, err = dh.GetSLIValue(context.Background(), "invalid_mode", start, end)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported baseline mode percent")
+	}
+}
+
+// Tests that a deep link into Dynatrace is generated for Metrics and USQL indicators
+func TestGetSLIValueGeneratesLinks(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/userSessionQueryLanguage") {
+			w.Write([]byte(`{"columnNames": ["count(*)"], "values": [[42]]}`))
+			return
+		}
+		w.Write([]byte(`{"totalCount": 1, "nextPageKey": null, "result": [{"metricId": "builtin:service.requestCount.total:merge(0):sum", "data": [{"dimensions": [], "timestamps": [1579097520000], "values": [100]}]}]}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	keptnEvent := &common.BaseKeptnEvent{}
+	keptnEvent.Project = "sockshop"
+	keptnEvent.Stage = "dev"
+	keptnEvent.Service = "carts"
+
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+	dh.CustomQueries = map[string]string{
+		"sessions":        "USQL;SINGLE_VALUE;;SELECT count(*) FROM usersession",
+		"secret_sessions": "USQL;SINGLE_VALUE;;SELECT count(*) FROM usersession WHERE userId='$ENV.SLI_TEST_USER'",
+	}
+	os.Setenv("SLI_TEST_USER", "my-secret-user")
+	defer os.Unsetenv("SLI_TEST_USER")
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649384, 0).UTC()
+
+	_, err := dh.GetSLIValue(context.Background(), Throughput, start, end)
+	assert.NoError(t, err)
+	_, err = dh.GetSLIValue(context.Background(), "sessions", start, end)
+	assert.NoError(t, err)
+	_, err = dh.GetSLIValue(context.Background(), "secret_sessions", start, end)
+	assert.NoError(t, err)
+
+	links := dh.GetSLILinks()
+	assert.Equal(t, "http://dynatrace/ui/data-explorer?entitySelector=type%28SERVICE%29%2Ctag%28keptn_project%3Asockshop%29%2Ctag%28keptn_stage%3Adev%29%2Ctag%28keptn_service%3Acarts%29%2Ctag%28keptn_deployment%3A%29&gtf=c_1571649084000_1571649384000&metricSelector=builtin%3Aservice.requestCount.total%3Amerge%280%29%3Asum", links[Throughput])
+	assert.Equal(t, "http://dynatrace/ui/user-sessions/query?gtf=c_1571649084000_1571649384000&query=SELECT+count%28%2A%29+FROM+usersession", links["sessions"])
+	// the link would contain the value of $ENV.SLI_TEST_USER
+	assert.NotContains(t, links, "secret_sessions")
+}
+
+// Tests that the time series of an indicator is fetched at the configured resolution if the export is enabled
+func TestGetSLIValueCollectsTimeseries(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("resolution") == "Inf" {
+			w.Write([]byte(`{"totalCount": 1, "nextPageKey": null, "result": [{"metricId": "builtin:service.response.time:merge(0):avg", "data": [{"dimensions": [], "timestamps": [1571649384000], "values": [3000]}]}]}`))
+			return
+		}
+		if r.URL.Query().Get("resolution") != "1m" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"totalCount": 1, "nextPageKey": null, "result": [{"metricId": "builtin:service.response.time:merge(0):avg", "data": [{"dimensions": [], "timestamps": [1571649144000, 1571649204000], "values": [2000, 4000]}]}]}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	keptnEvent := &common.BaseKeptnEvent{}
+	keptnEvent.Project = "sockshop"
+	keptnEvent.Stage = "dev"
+	keptnEvent.Service = "carts"
+
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+	dh.CustomQueries = map[string]string{
+		"rt": "metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)",
+	}
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649384, 0).UTC()
+
+	// disabled by default
+	_, err := dh.GetSLIValue(context.Background(), "rt", start, end)
+	assert.NoError(t, err)
+	assert.Empty(t, dh.GetTimeseries())
+
+	dh.Timeseries = &common.TimeseriesExport{Enabled: true}
+	value, err := dh.GetSLIValue(context.Background(), "rt", start, end)
+	assert.NoError(t, err)
+	assert.InDelta(t, 3.0, value, 0.001)
+
+	timeseries := dh.GetTimeseries()
+	if assert.Len(t, timeseries, 1) && assert.Len(t, timeseries[0].Series, 1) {
+		assert.Equal(t, "rt", timeseries[0].Indicator)
+		assert.Equal(t, "1m", timeseries[0].Resolution)
+		assert.Equal(t, []int64{1571649144000, 1571649204000}, timeseries[0].Series[0].Timestamps)
+		assert.Equal(t, []float64{2, 4}, timeseries[0].Series[0].Values)
+	}
+
+	content, err := MarshalTimeseries(timeseries, common.TimeseriesFormatCSV)
+	assert.NoError(t, err)
+	assert.Equal(t, "indicator,metricId,dimensions,timestamp,value\n"+
+		"rt,builtin:service.response.time:merge(0):avg,,1571649144000,2\n"+
+		"rt,builtin:service.response.time:merge(0):avg,,1571649204000,4\n", string(content))
+}
+
+// Tests that throttled calls are retried and that the evaluation report contains query, unit, values and retries of every indicator
+func TestGetEvaluationReport(t *testing.T) {
+	defer func(delay time.Duration) { dynatraceAPIRetryDelay = delay }(dynatraceAPIRetryDelay)
+	dynatraceAPIRetryDelay = time.Millisecond
+
+	throttled := false
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first call is throttled
+		if !throttled {
+			throttled = true
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"totalCount": 1, "nextPageKey": null, "result": [{"metricId": "builtin:service.response.time:merge(0):avg", "data": [{"dimensions": [], "timestamps": [1571649384000], "values": [3000]}]}]}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	keptnEvent := &common.BaseKeptnEvent{}
+	keptnEvent.Context = "my-context"
+	keptnEvent.Project = "sockshop"
+	keptnEvent.Stage = "dev"
+	keptnEvent.Service = "carts"
+
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+	dh.CustomQueries = map[string]string{
+		"rt": "MV2;MicroSecond;metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)",
+	}
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649384, 0).UTC()
+
+	sliResults := dh.GetSLIResults(context.Background(), "rt", start, end)
+	sliResults = append(sliResults, dh.GetSLIResults(context.Background(), "unknown", start, end)...)
+
+	report := dh.GetEvaluationReport(ReportSourceSLIFile, start, end, sliResults)
+	assert.Equal(t, "my-context", report.Context)
+	assert.Equal(t, ReportSourceSLIFile, report.Source)
+	assert.Equal(t, "2019-10-21T09:11:24Z", report.Start)
+	if assert.Len(t, report.Indicators, 2) {
+		assert.Equal(t, "rt", report.Indicators[0].Indicator)
+		assert.Equal(t, IndicatorTypeMetrics, report.Indicators[0].Type)
+		assert.Equal(t, "MicroSecond", report.Indicators[0].Unit)
+		assert.Contains(t, report.Indicators[0].Query, "metricSelector=builtin%3Aservice.response.time%3Amerge%280%29%3Aavg")
+		assert.Equal(t, 3000.0, report.Indicators[0].RawValue)
+		assert.Equal(t, 3.0, report.Indicators[0].Value)
+		assert.Equal(t, 1, report.Indicators[0].Retries)
+		assert.True(t, report.Indicators[0].Success)
+
+		// the unknown indicator was never queried
+		assert.Equal(t, "unknown", report.Indicators[1].Indicator)
+		assert.False(t, report.Indicators[1].Success)
+		assert.NotEmpty(t, report.Indicators[1].Error)
+	}
+}
+
+// Tests that only idempotent calls are retried - a retried POST could create a dashboard twice
+func TestExecuteDynatraceRESTRetriesIdempotentCallsOnly(t *testing.T) {
+	defer func(delay time.Duration) { dynatraceAPIRetryDelay = delay }(dynatraceAPIRetryDelay)
+	dynatraceAPIRetryDelay = time.Millisecond
+
+	var mutex sync.Mutex
+	calls := map[string]int{}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls[r.Method]++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	tests := []struct {
+		method      string
+		wantCalls   int
+		wantRetries int
+	}{
+		{"GET", 3, 2},
+		{"PUT", 3, 2},
+		{"POST", 1, 0},
+	}
+	for _, tt := range tests {
+		dh := NewDynatraceHandler("http://dynatrace", &common.BaseKeptnEvent{}, nil, nil, "", "", nil)
+		dh.HTTPClient = httpClient
+		indicatorHandler := dh.forIndicator("indicator")
+
+		resp, _, err := indicatorHandler.executeDynatraceRESTWithBody(context.Background(), tt.method, dh.ApiURL+"/api/config/v1/dashboards", []byte("{}"), nil)
+		assert.NoError(t, err)
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		}
+		assert.Equal(t, tt.wantCalls, calls[tt.method], tt.method)
+		assert.Equal(t, tt.wantRetries, indicatorHandler.requestStats.getRetries(), tt.method)
+	}
+}
+
+// Tests that no more than defaults.parallelism indicators are queried at once and the results keep the order of the indicators
+func TestGetSLIResultsForIndicatorsInParallel(t *testing.T) {
+	var mutex sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		w.Write([]byte(`{"totalCount": 1, "nextPageKey": null, "result": [{"metricId": "builtin:service.requestCount.total:merge(0):sum", "data": [{"dimensions": [], "timestamps": [1571649384000], "values": [100]}]}]}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	dh := NewDynatraceHandler("http://dynatrace", &common.BaseKeptnEvent{}, nil, nil, "", "", &common.SLIDefaults{Parallelism: 2})
+	dh.HTTPClient = httpClient
+	indicators := []string{"rt1", "rt2", "rt3", "rt4"}
+	dh.CustomQueries = map[string]string{}
+	for _, indicator := range indicators {
+		dh.CustomQueries[indicator] = "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(" + indicator + ")"
+	}
+
+	sliResults := dh.GetSLIResultsForIndicators(context.Background(), indicators, time.Unix(1571649084, 0).UTC(), time.Unix(1571649384, 0).UTC())
+
+	assert.Equal(t, 2, maxInFlight)
+	if assert.Len(t, sliResults, len(indicators)) {
+		for i, indicator := range indicators {
+			assert.Equal(t, indicator, sliResults[i].Metric)
+			assert.True(t, sliResults[i].Success, sliResults[i].Message)
+		}
+	}
+}
+
+func TestGetSLIResultsExceedingEvaluationDeadline(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the tenant hangs until the client gives up
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	keptnEvent := &common.BaseKeptnEvent{}
+	keptnEvent.Project = "sockshop"
+	keptnEvent.Stage = "dev"
+	keptnEvent.Service = "carts"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+	dh.CustomQueries = map[string]string{
+		"rt": "MV2;MicroSecond;metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)",
+	}
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649384, 0).UTC()
+
+	queryStart := time.Now()
+	sliResults := dh.GetSLIResults(ctx, "rt", start, end)
+	assert.True(t, time.Since(queryStart) < 2*time.Second, "the query should have been canceled at the deadline")
+	if assert.Len(t, sliResults, 1) {
+		assert.False(t, sliResults[0].Success)
+		assert.True(t, strings.HasPrefix(sliResults[0].Message, TimedOutMessagePrefix), sliResults[0].Message)
+	}
+
+	report := dh.GetEvaluationReport(ReportSourceSLIFile, start, end, sliResults)
+	if assert.Len(t, report.Indicators, 1) {
+		assert.True(t, report.Indicators[0].TimedOut)
+	}
+}
+
+func TestUploadDashboard(t *testing.T) {
+	existingDashboards := `{"dashboards":[]}`
+	var method, path string
+	var payload map[string]interface{}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/api/config/v1/dashboards" {
+			w.Write([]byte(existingDashboards))
+			return
+		}
+		method, path = r.Method, r.URL.Path
+		payload = map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"new-dashboard-id","name":"KQG"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	keptnEvent := &common.BaseKeptnEvent{}
+	keptnEvent.Project = "sockshop"
+	keptnEvent.Stage = "dev"
+	keptnEvent.Service = "carts"
+
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+
+	dashboardJSON, _ := dh.GenerateDashboard(&SLI{Indicators: map[string]string{}}, nil)
+
+	// a new dashboard is created
+	dashboardID, err := dh.UploadDashboard(context.Background(), dashboardJSON)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "new-dashboard-id", dashboardID)
+	assert.EqualValues(t, "POST", method)
+	assert.NotContains(t, payload, "id")
+	assert.NotContains(t, payload, "metadata")
+
+	// the existing KQG dashboard of the service is replaced
+	existingDashboards = `{"dashboards":[{"id":"existing-id","name":"KQG;project=sockshop;service=carts;stage=dev"}]}`
+	dashboardID, err = dh.UploadDashboard(context.Background(), dashboardJSON)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "existing-id", dashboardID)
+	assert.EqualValues(t, "PUT", method)
+	assert.EqualValues(t, "/api/config/v1/dashboards/existing-id", path)
+	assert.EqualValues(t, "existing-id", payload["id"])
+}
+
+// Tests that a failed POST is not retried and a dashboard that was created anyway is found instead of created twice
+func TestUploadDashboardAfterFailedPost(t *testing.T) {
+	defer func(delay time.Duration) { dynatraceAPIRetryDelay = delay }(dynatraceAPIRetryDelay)
+	dynatraceAPIRetryDelay = time.Millisecond
+
+	var mutex sync.Mutex
+	posts := 0
+	createdAnyway := false
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Method == "GET" && r.URL.Path == "/api/config/v1/dashboards" {
+			if createdAnyway && posts > 0 {
+				w.Write([]byte(`{"dashboards":[{"id":"created-id","name":"KQG;project=sockshop;service=carts;stage=dev"}]}`))
+				return
+			}
+			w.Write([]byte(`{"dashboards":[]}`))
+			return
+		}
+		posts++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	keptnEvent := &common.BaseKeptnEvent{Project: "sockshop", Stage: "dev", Service: "carts"}
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+	dashboardJSON, _ := dh.GenerateDashboard(&SLI{Indicators: map[string]string{}}, nil)
+
+	// the dashboard was not created
+	dashboardID, err := dh.UploadDashboard(context.Background(), dashboardJSON)
+	assert.Error(t, err)
+	assert.Empty(t, dashboardID)
+	assert.Equal(t, 1, posts)
+
+	// the dashboard was created although the POST failed
+	posts = 0
+	createdAnyway = true
+	dashboardID, err = dh.UploadDashboard(context.Background(), dashboardJSON)
+	assert.NoError(t, err)
+	assert.Equal(t, "created-id", dashboardID)
+	assert.Equal(t, 1, posts)
+}
+
+// Tests that no dashboard is created if the existing dashboards can't be listed - it might exist already
+func TestUploadDashboardFailsIfLookupFails(t *testing.T) {
+	defer func(delay time.Duration) { dynatraceAPIRetryDelay = delay }(dynatraceAPIRetryDelay)
+	dynatraceAPIRetryDelay = time.Millisecond
+
+	var mutex sync.Mutex
+	posts := 0
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Method == "GET" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		posts++
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"new-dashboard-id"}`))
+	})
+
+	httpClient, teardown := testingHTTPClient(h)
+	defer teardown()
+
+	keptnEvent := &common.BaseKeptnEvent{Project: "sockshop", Stage: "dev", Service: "carts"}
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+	dashboardJSON, _ := dh.GenerateDashboard(&SLI{Indicators: map[string]string{}}, nil)
+
+	dashboardID, err := dh.UploadDashboard(context.Background(), dashboardJSON)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "status code 500")
+	}
+	assert.Empty(t, dashboardID)
+	assert.Equal(t, 0, posts)
+}
+
+// tests a dashboard evaluation against the fake Dynatrace API - the first metrics query is throttled and retried
+func TestQueryDynatraceDashboardWithFakeDynatrace(t *testing.T) {
+	fake := fakedynatrace.New("../fakedynatrace/fixtures")
+	fake.AddFault(fakedynatrace.Fault{Path: fakedynatrace.MetricsQueryPath, StatusCode: http.StatusTooManyRequests, RetryAfter: "0", Count: 1})
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	keptnEvent := &common.BaseKeptnEvent{Project: "qualitygate", Stage: "qualitystage", Service: "evalservice"}
+	dh := NewDynatraceHandler(server.URL, keptnEvent, map[string]string{"Authorization": "Api-Token test"}, nil, "", "", nil)
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649085, 0).UTC()
+	_, _, dashboardSLI, _, sliResults, err := dh.QueryDynatraceDashboardForSLIs(context.Background(), keptnEvent, common.DynatraceConfigDashboardQUERY, start, end)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 12, len(dashboardSLI.Indicators))
+	assert.Equal(t, 12, len(sliResults))
+	for _, sliResult := range sliResults {
+		assert.True(t, sliResult.Success, "%s failed: %s", sliResult.Metric, sliResult.Message)
+	}
+
+	metricsQueries := 0
+	for _, request := range fake.Requests() {
+		if strings.HasPrefix(request.Path, fakedynatrace.MetricsQueryPath) {
+			metricsQueries++
+		}
+	}
+	assert.Equal(t, len(sliResults)+1, metricsQueries, "expected one additional metrics query for the retry")
+}
+
+// tests the built-in SLIs against the fake Dynatrace API
+func TestGetSLIValueWithFakeDynatrace(t *testing.T) {
+	server := httptest.NewServer(fakedynatrace.New("../fakedynatrace/fixtures"))
+	defer server.Close()
+
+	keptnEvent := &common.BaseKeptnEvent{Project: "sockshop", Stage: "dev", Service: "carts"}
+	dh := NewDynatraceHandler(server.URL, keptnEvent, nil, nil, "", "", nil)
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649085, 0).UTC()
+	for _, indicator := range []string{Throughput, ErrorRate, ResponseTimeP95} {
+		_, err := dh.GetSLIValue(context.Background(), indicator, start, end)
+		assert.NoError(t, err, indicator)
+	}
+}
+
+// tests that recorded Dynatrace API calls - including retries - are replayed without calling Dynatrace and that the API token is not recorded
+func TestRecordAndReplayWithFakeDynatrace(t *testing.T) {
+	fake := fakedynatrace.New("../fakedynatrace/fixtures")
+	fake.APIToken = "recorded-token"
+	fake.AddFault(fakedynatrace.Fault{Path: fakedynatrace.MetricsQueryPath, StatusCode: http.StatusServiceUnavailable, RetryAfter: "0", Count: 1})
+	server := httptest.NewServer(fake)
+
+	keptnEvent := &common.BaseKeptnEvent{Project: "qualitygate", Stage: "qualitystage", Service: "evalservice"}
+	dh := NewDynatraceHandler(server.URL, keptnEvent, map[string]string{"Authorization": "Api-Token recorded-token"}, nil, "", "", nil)
+	dh.Cassette = NewRecordingCassette(map[string]string{"project": keptnEvent.Project})
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649085, 0).UTC()
+	_, _, _, _, recordedResults, err := dh.QueryDynatraceDashboardForSLIs(context.Background(), keptnEvent, common.DynatraceConfigDashboardQUERY, start, end)
+	server.Close()
+	assert.NoError(t, err)
+
+	content, err := dh.Cassette.Marshal()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "recorded-token")
+	assert.Equal(t, len(fake.Requests()), len(dh.Cassette.Interactions))
+
+	cassette, err := ParseCassette(content)
+	assert.NoError(t, err)
+	replayHandler := NewDynatraceHandler("http://replay.invalid", keptnEvent, nil, nil, "", "", nil)
+	replayHandler.Cassette = cassette
+
+	// a different timeframe is replayed as well
+	_, _, _, _, replayedResults, err := replayHandler.QueryDynatraceDashboardForSLIs(context.Background(), keptnEvent, common.DynatraceConfigDashboardQUERY, start.Add(time.Hour), end.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, recordedResults, replayedResults)
+	for _, interaction := range cassette.Interactions {
+		assert.True(t, interaction.replayed, "%s %s was not replayed", interaction.Method, interaction.URI)
+	}
+}
+
+// tests replaying a cassette recorded with dtsli eval -record, e.g: attached to a bug report
+func TestReplayCassetteFile(t *testing.T) {
+	cassette, err := LoadCassette("./testfiles/test_cassette_dashboard.json")
+	assert.NoError(t, err)
+
+	keptnEvent := &common.BaseKeptnEvent{Project: "qualitygate", Stage: "qualitystage", Service: "evalservice"}
+	dh := NewDynatraceHandler("http://replay.invalid", keptnEvent, nil, nil, "", "", nil)
+	dh.Cassette = cassette
+
+	start, _ := time.Parse(time.RFC3339, cassette.Metadata["start"])
+	end, _ := time.Parse(time.RFC3339, cassette.Metadata["end"])
+	_, _, dashboardSLI, _, sliResults, err := dh.QueryDynatraceDashboardForSLIs(context.Background(), keptnEvent, common.DynatraceConfigDashboardQUERY, start, end)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 12, len(dashboardSLI.Indicators))
+	for _, sliResult := range sliResults {
+		assert.True(t, sliResult.Success, "%s failed: %s", sliResult.Metric, sliResult.Message)
+	}
+}
+
+// tests that calls which were not recorded fail instead of calling Dynatrace
+func TestReplayUnrecordedCall(t *testing.T) {
+	httpClient, teardown := testingHTTPClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected call to %s", r.URL.Path)
+	}))
+	defer teardown()
+
+	cassette, err := ParseCassette([]byte(`{"interactions": []}`))
+	assert.NoError(t, err)
+
+	dh := NewDynatraceHandler("http://dynatrace", &common.BaseKeptnEvent{}, nil, nil, "", "", nil)
+	dh.HTTPClient = httpClient
+	dh.Cassette = cassette
+
+	_, err = dh.GetSLIValue(context.Background(), Throughput, time.Unix(1571649084, 0).UTC(), time.Unix(1571649085, 0).UTC())
+	assert.Error(t, err)
+}
+
+// tests that an existing dynatrace/dashboard.json in the resource store makes an evaluation without dashboard setting query the dashboard
+func TestQueryDynatraceDashboardWithExistingDashboardResource(t *testing.T) {
+	server := httptest.NewServer(fakedynatrace.New("../fakedynatrace/fixtures"))
+	defer server.Close()
+
+	keptnEvent := &common.BaseKeptnEvent{Project: "qualitygate", Stage: "qualitystage", Service: "evalservice"}
+	dh := NewDynatraceHandler(server.URL, keptnEvent, nil, nil, "", "", nil)
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := time.Unix(1571649085, 0).UTC()
+
+	dh.ResourceStore = common.NewMemoryStore(nil)
+	_, dashboardJSON, _, _, _, err := dh.QueryDynatraceDashboardForSLIs(context.Background(), keptnEvent, "", start, end)
+	assert.NoError(t, err)
+	assert.Nil(t, dashboardJSON)
+
+	dh.ResourceStore = common.NewMemoryStore(map[string]string{common.DynatraceDashboardFilename: "{}"})
+	_, dashboardJSON, _, _, sliResults, err := dh.QueryDynatraceDashboardForSLIs(context.Background(), keptnEvent, "", start, end)
+	assert.NoError(t, err)
+	assert.NotNil(t, dashboardJSON)
+	assert.Equal(t, 12, len(sliResults))
+}
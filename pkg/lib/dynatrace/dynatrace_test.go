@@ -1,9 +1,11 @@
 package dynatrace
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -11,10 +13,14 @@ import (
 
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 
 	_ "github.com/keptn/go-utils/pkg/lib"
 	keptn "github.com/keptn/go-utils/pkg/lib"
@@ -142,7 +148,7 @@ func testingGetDynatraceHandler(keptnEvent *common.BaseKeptnEvent) (*Handler, *h
 
 	dh := NewDynatraceHandler(url, keptnEvent, map[string]string{
 		"Authorization": "Api-Token " + "test",
-	}, nil, "", "")
+	}, nil, "", "", nil)
 
 	dh.HTTPClient = httpClient
 
@@ -154,7 +160,7 @@ func TestExecuteDynatraceREST(t *testing.T) {
 	dh, _, url, teardown := testingGetDynatraceHandler(keptnEvent)
 	defer teardown()
 
-	resp, body, err := dh.executeDynatraceREST("GET", url+"/api/config/v1/dashboards", nil)
+	resp, body, err := dh.executeDynatraceREST(context.Background(), "GET", url+"/api/config/v1/dashboards", nil)
 
 	if resp == nil || resp.StatusCode != 200 {
 		t.Errorf("Dynatrace REST not returning http 200 status")
@@ -174,7 +180,7 @@ func TestExecuteDynatraceRESTBadRequest(t *testing.T) {
 	dh, _, url, teardown := testingGetDynatraceHandler(keptnEvent)
 	defer teardown()
 
-	resp, _, _ := dh.executeDynatraceREST("GET", url+"/BADAPI", nil)
+	resp, _, _ := dh.executeDynatraceREST(context.Background(), "GET", url+"/BADAPI", nil)
 
 	if resp == nil || resp.StatusCode != http.StatusBadRequest {
 		t.Errorf("Dynatrace REST not returning http 400")
@@ -186,7 +192,7 @@ func TestFindDynatraceDashboardSuccess(t *testing.T) {
 	dh, _, _, teardown := testingGetDynatraceHandler(keptnEvent)
 	defer teardown()
 
-	dashboardID, err := dh.findDynatraceDashboard(keptnEvent)
+	dashboardID, err := dh.findDynatraceDashboard(context.Background(), keptnEvent)
 
 	if err != nil {
 		t.Error(err)
@@ -202,7 +208,7 @@ func TestFindDynatraceDashboardNoneExistingDashboard(t *testing.T) {
 	dh, _, _, teardown := testingGetDynatraceHandler(keptnEvent)
 	defer teardown()
 
-	dashboardID, err := dh.findDynatraceDashboard(keptnEvent)
+	dashboardID, err := dh.findDynatraceDashboard(context.Background(), keptnEvent)
 
 	if err != nil {
 		t.Error(err)
@@ -219,7 +225,7 @@ func TestLoadDynatraceDashboardWithQUERY(t *testing.T) {
 	defer teardown()
 
 	// this should load the dashboard
-	dashboardJSON, dashboard, err := dh.loadDynatraceDashboard(keptnEvent, common.DynatraceConfigDashboardQUERY)
+	dashboardJSON, dashboard, err := dh.loadDynatraceDashboard(context.Background(), keptnEvent, common.DynatraceConfigDashboardQUERY)
 
 	if dashboardJSON == nil {
 		t.Errorf("Didnt query dashboard for quality gate project even though it shoudl exist: " + dashboard)
@@ -240,7 +246,7 @@ func TestLoadDynatraceDashboardWithID(t *testing.T) {
 	defer teardown()
 
 	// this should load the dashboard
-	dashboardJSON, dashboard, err := dh.loadDynatraceDashboard(keptnEvent, QUALITYGATE_DASHBOARD_ID)
+	dashboardJSON, dashboard, err := dh.loadDynatraceDashboard(context.Background(), keptnEvent, QUALITYGATE_DASHBOARD_ID)
 
 	if dashboardJSON == nil {
 		t.Errorf("Didnt query dashboard for quality gate project even though it should exist by ID")
@@ -261,7 +267,7 @@ func TestLoadDynatraceDashboardWithEmptyDashboard(t *testing.T) {
 	defer teardown()
 
 	// this should load the dashboard
-	dashboardJSON, dashboard, err := dh.loadDynatraceDashboard(keptnEvent, "")
+	dashboardJSON, dashboard, err := dh.loadDynatraceDashboard(context.Background(), keptnEvent, "")
 
 	if dashboardJSON != nil {
 		t.Errorf("No dashboard should be loaded if no dashboard is passed")
@@ -301,7 +307,7 @@ func TestQueryDynatraceDashboardForSLIs(t *testing.T) {
 
 	startTime := time.Unix(1571649084, 0).UTC()
 	endTime := time.Unix(1571649085, 0).UTC()
-	dashboardLinkAsLabel, dashboardJSON, dashboardSLI, dashboardSLO, sliResults, err := dh.QueryDynatraceDashboardForSLIs(keptnEvent, common.DynatraceConfigDashboardQUERY, startTime, endTime)
+	dashboardLinkAsLabel, dashboardJSON, dashboardSLI, dashboardSLO, sliResults, err := dh.QueryDynatraceDashboardForSLIs(context.Background(), keptnEvent, common.DynatraceConfigDashboardQUERY, startTime, endTime)
 
 	if dashboardLinkAsLabel == "" {
 		t.Errorf("No dashboard link label generated")
@@ -346,6 +352,471 @@ func TestQueryDynatraceDashboardForSLIs(t *testing.T) {
 	}
 }
 
+func TestConvertDashboardToSLIAndSLO(t *testing.T) {
+	keptnEvent := testingGetKeptnEvent(QUALITYGATE_PROJECT, QUALITYGATE_STAGE, QUALTIYGATE_SERVICE, "", "")
+	dh, _, _, teardown := testingGetDynatraceHandler(keptnEvent)
+	defer teardown()
+
+	dashboardJSON, err := dh.LoadDynatraceDashboard(context.Background(), keptnEvent, QUALITYGATE_DASHBOARD_ID)
+	if err != nil || dashboardJSON == nil {
+		t.Fatalf("Could not load dashboard: %v", err)
+	}
+
+	dashboardSLI, dashboardSLO, warnings, err := dh.ConvertDashboardToSLIAndSLO(context.Background(), dashboardJSON)
+	if err != nil {
+		t.Fatalf("Could not convert dashboard: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Expected no warnings. Got %v", warnings)
+	}
+
+	// 12 tiles define SLIs - an evaluation only generates the 9 the mocked metrics query returns data for
+	if len(dashboardSLI.Indicators) != 12 {
+		t.Errorf("Expected 12 SLIs. Got %d", len(dashboardSLI.Indicators))
+	}
+	if len(dashboardSLO.Objectives) != 12 {
+		t.Errorf("Expected 12 SLOs. Got %d", len(dashboardSLO.Objectives))
+	}
+
+	// the converted definitions have to be the same as those generated by an evaluation of the dashboard
+	startTime := time.Unix(1571649084, 0).UTC()
+	endTime := time.Unix(1571649085, 0).UTC()
+	_, _, queriedSLI, queriedSLO, _, err := dh.QueryDynatraceDashboardForSLIs(context.Background(), keptnEvent, QUALITYGATE_DASHBOARD_ID, startTime, endTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for indicator, query := range queriedSLI.Indicators {
+		if dashboardSLI.Indicators[indicator] != query {
+			t.Errorf("Indicator %s: expected %s. Got %s", indicator, query, dashboardSLI.Indicators[indicator])
+		}
+	}
+	for _, queriedObjective := range queriedSLO.Objectives {
+		found := false
+		for _, objective := range dashboardSLO.Objectives {
+			if objective.SLI == queriedObjective.SLI {
+				found = true
+				if !reflect.DeepEqual(objective, queriedObjective) {
+					t.Errorf("SLO %s: expected %+v. Got %+v", objective.SLI, queriedObjective, objective)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("SLO %s is missing", queriedObjective.SLI)
+		}
+	}
+	if dashboardSLO.TotalScore.Pass != "90%" || dashboardSLO.TotalScore.Warning != "70%" {
+		t.Errorf("Total Warning and Pass Scores not as expected. Got %s (pass) and %s (warning)", dashboardSLO.TotalScore.Pass, dashboardSLO.TotalScore.Warning)
+	}
+}
+
+func TestGenerateDashboard(t *testing.T) {
+	keptnEvent := testingGetKeptnEvent(QUALITYGATE_PROJECT, QUALITYGATE_STAGE, QUALTIYGATE_SERVICE, "", "")
+	dh, _, _, teardown := testingGetDynatraceHandler(keptnEvent)
+	defer teardown()
+
+	sli := &SLI{Indicators: map[string]string{
+		"svc_rt_p95": "MV2;MicroSecond;metricSelector=builtin:service.response.time:merge(0):percentile(95.000000):names&entitySelector=type(SERVICE),entityId(\"SERVICE-086C46F600BA1DC6\"),tag(\"keptn_deployment:primary\")",
+		"host_cpu":   "MV2;Percent;metricSelector=builtin:host.cpu.usage:merge(0):avg:names&entitySelector=type(HOST)",
+		"sessions":   "USQL;SINGLE_VALUE;;SELECT count(*) FROM usersession",
+		"throughput": "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(keptn_service:$SERVICE),mzId(1234)",
+		"legacy":     "builtin:service.response.time:merge(0):avg?scope=tag(keptn_project:$PROJECT)",
+		"browsers":   "USQL;PIE_CHART;Chrome;SELECT browserFamily, count(*) FROM usersession GROUP BY browserFamily",
+		"filtered":   "metricSelector=builtin:service.response.time:filter(eq(Service,carts)):avg&entitySelector=type(SERVICE)",
+	}}
+	slo := &keptn.ServiceLevelObjectives{
+		Objectives: []*keptn.SLO{
+			{SLI: "svc_rt_p95", Pass: []*keptn.SLOCriteria{{Criteria: []string{"<+10%", "<600"}}}, Warning: []*keptn.SLOCriteria{{Criteria: []string{"<800"}}}, Weight: 2, KeySLI: true},
+			{SLI: "host_cpu", Pass: []*keptn.SLOCriteria{{Criteria: []string{"<20"}}}, Weight: 1},
+			{SLI: "sessions", Weight: 1},
+			{SLI: "undefined", Weight: 1},
+		},
+		TotalScore: &keptn.SLOScore{Pass: "95%", Warning: "80%"},
+		Comparison: &keptn.SLOComparison{CompareWith: "several_results", IncludeResultWithScore: "pass_or_warn", NumberOfComparisonResults: 3, AggregateFunction: "p90"},
+	}
+
+	dashboardJSON, warnings := dh.GenerateDashboard(sli, slo)
+	if dashboardJSON.DashboardMetadata.Name != "KQG;project=qualitygate;service=evalservice;stage=qualitystage" {
+		t.Errorf("Unexpected dashboard name %s", dashboardJSON.DashboardMetadata.Name)
+	}
+	// undefined objective, legacy query, PIE_CHART and filter transformation
+	if len(warnings) != 4 {
+		t.Errorf("Expected 4 warnings. Got %v", warnings)
+	}
+	// markdown + 4 SLI tiles
+	if len(dashboardJSON.Tiles) != 5 {
+		t.Fatalf("Expected 5 tiles. Got %d", len(dashboardJSON.Tiles))
+	}
+	if title := dashboardJSON.Tiles[1].FilterConfig.CustomName; title != "svc_rt_p95;sli=svc_rt_p95;pass=<+10%,<600;warning=<800;weight=2;key=true" {
+		t.Errorf("Unexpected tile title %s", title)
+	}
+
+	throughputTile := dashboardJSON.Tiles[4]
+	if throughputTile.FilterConfig.ChartConfig.Series[0].Aggregation != "SUM" || throughputTile.TileFilter.ManagementZone == nil || throughputTile.TileFilter.ManagementZone.ID != "1234" {
+		t.Errorf("Unexpected throughput tile %+v", throughputTile)
+	}
+	if tags := throughputTile.FilterConfig.FiltersPerEntityType["SERVICE"]["AUTO_TAGS"]; len(tags) != 1 || tags[0] != "keptn_service:evalservice" {
+		t.Errorf("Expected the resolved tag keptn_service:evalservice. Got %v", tags)
+	}
+
+	// an evaluation of the generated dashboard has to use the same queries and objectives
+	convertedSLI, convertedSLO, warnings, err := dh.ConvertDashboardToSLIAndSLO(context.Background(), dashboardJSON)
+	if err != nil {
+		t.Fatalf("Could not convert the generated dashboard: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Expected no conversion warnings. Got %v", warnings)
+	}
+	for _, indicator := range []string{"svc_rt_p95", "host_cpu", "sessions"} {
+		if convertedSLI.Indicators[indicator] != sli.Indicators[indicator] {
+			t.Errorf("Indicator %s: expected %s. Got %s", indicator, sli.Indicators[indicator], convertedSLI.Indicators[indicator])
+		}
+	}
+	for i, objective := range slo.Objectives[:3] {
+		if !reflect.DeepEqual(objective, convertedSLO.Objectives[i]) {
+			t.Errorf("SLO %s: expected %+v. Got %+v", objective.SLI, objective, convertedSLO.Objectives[i])
+		}
+	}
+	if !reflect.DeepEqual(slo.TotalScore, convertedSLO.TotalScore) || !reflect.DeepEqual(slo.Comparison, convertedSLO.Comparison) {
+		t.Errorf("Unexpected total score %+v and comparison %+v", convertedSLO.TotalScore, convertedSLO.Comparison)
+	}
+}
+
+// Tests that queries resolved per evaluation are not charted - the dashboard would keep the values of the event it was generated for
+func TestGenerateDashboardSkipsEventPlaceholders(t *testing.T) {
+	os.Setenv("SLI_TEST_SECRET", "my-secret-value")
+	defer os.Unsetenv("SLI_TEST_SECRET")
+
+	keptnEvent := testingGetKeptnEvent("sockshop", "dev", "carts", "blue_green_service", "performance")
+	keptnEvent.Deployment = "canary"
+	keptnEvent.Labels = map[string]string{"owner": "team-a"}
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+
+	sli := &SLI{Indicators: map[string]string{
+		"service":    "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(keptn_service:$SERVICE)",
+		"label":      "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(owner:$LABEL.owner)",
+		"env":        "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag($ENV.SLI_TEST_SECRET)",
+		"deployment": "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(keptn_deployment:$DEPLOYMENT)",
+		"template":   "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag({{ .DeploymentStrategy }})",
+	}}
+
+	dashboardJSON, warnings := dh.GenerateDashboard(sli, nil)
+	if len(warnings) != 4 {
+		t.Errorf("Expected 4 warnings. Got %v", warnings)
+	}
+	// markdown + service tile
+	if len(dashboardJSON.Tiles) != 2 || dashboardJSON.Tiles[1].FilterConfig.FiltersPerEntityType["SERVICE"]["AUTO_TAGS"][0] != "keptn_service:carts" {
+		t.Errorf("Expected only the tile of the service indicator. Got %+v", dashboardJSON.Tiles)
+	}
+
+	content, _ := json.Marshal(dashboardJSON)
+	for _, value := range []string{"my-secret-value", "team-a", "canary", "blue_green_service", "performance"} {
+		if strings.Contains(string(content), value) {
+			t.Errorf("The generated dashboard contains the event value %s", value)
+		}
+	}
+}
+
+// Tests that a dashboard is converted without tenant - and that the conversion fails for custom charts as they need the metric definitions of the tenant
+func TestConvertDashboardToSLIAndSLOWithoutTenant(t *testing.T) {
+	keptnEvent := testingGetKeptnEvent(QUALITYGATE_PROJECT, QUALITYGATE_STAGE, QUALTIYGATE_SERVICE, "", "")
+	dh, _, _, teardown := testingGetDynatraceHandler(keptnEvent)
+	defer teardown()
+
+	sli := &SLI{Indicators: map[string]string{
+		"host_cpu": "MV2;Percent;metricSelector=builtin:host.cpu.usage:merge(0):avg:names&entitySelector=type(HOST)",
+		"sessions": "USQL;SINGLE_VALUE;;SELECT count(*) FROM usersession",
+	}}
+	slo := &keptn.ServiceLevelObjectives{
+		Objectives: []*keptn.SLO{
+			{SLI: "host_cpu", Pass: []*keptn.SLOCriteria{{Criteria: []string{"<20"}}}, Weight: 1},
+			{SLI: "sessions", Pass: []*keptn.SLOCriteria{{Criteria: []string{">100"}}}, Weight: 1},
+		},
+		TotalScore: &keptn.SLOScore{Pass: "90%", Warning: "75%"},
+	}
+	dashboardJSON, _ := dh.GenerateDashboard(sli, slo)
+
+	offlineHandler := NewDynatraceHandler("", keptnEvent, nil, nil, "", "", nil)
+	convertedSLI, convertedSLO, _, err := offlineHandler.ConvertDashboardToSLIAndSLO(context.Background(), dashboardJSON)
+	if err == nil || !strings.Contains(err.Error(), "host_cpu") {
+		t.Errorf("Expected an error naming the host_cpu custom chart. Got %v", err)
+	}
+	if convertedSLI != nil || convertedSLO != nil {
+		t.Errorf("Expected no sli.yaml and slo.yaml if custom charts were skipped. Got %v, %+v", convertedSLI, convertedSLO)
+	}
+
+	// without custom charts the dashboard is converted completely
+	delete(sli.Indicators, "host_cpu")
+	slo.Objectives = slo.Objectives[1:]
+	dashboardJSON, _ = dh.GenerateDashboard(sli, slo)
+	convertedSLI, convertedSLO, warnings, err := offlineHandler.ConvertDashboardToSLIAndSLO(context.Background(), dashboardJSON)
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("Expected no error and no warnings. Got %v, %v", err, warnings)
+	}
+	if len(convertedSLI.Indicators) != 1 || convertedSLI.Indicators["sessions"] != sli.Indicators["sessions"] {
+		t.Errorf("Expected only the sessions indicator. Got %v", convertedSLI.Indicators)
+	}
+	if len(convertedSLO.Objectives) != 1 || !reflect.DeepEqual(slo.Objectives[0], convertedSLO.Objectives[0]) {
+		t.Errorf("Expected only the sessions objective. Got %+v", convertedSLO.Objectives)
+	}
+	if convertedSLO.TotalScore.Pass != "90%" || convertedSLO.TotalScore.Warning != "75%" {
+		t.Errorf("Unexpected total score %+v", convertedSLO.TotalScore)
+	}
+}
+
+// lintFindingRules returns the rules of all findings of a location
+func lintFindingRules(findings []*LintFinding, location string) []string {
+	rules := []string{}
+	for _, finding := range findings {
+		if finding.Location == location {
+			rules = append(rules, finding.Rule)
+		}
+	}
+	return rules
+}
+
+func TestLintSLIAndSLO(t *testing.T) {
+	sli := &SLI{Indicators: map[string]string{
+		"valid":       "MV2;MicroSecond;metricSelector=builtin:service.response.time:merge(0):percentile(95)&entitySelector=type(SERVICE),tag(keptn_service:$SERVICE)",
+		"question":    "?metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)",
+		"scope":       "builtin:service.response.time:merge(0):avg?scope=tag(keptn_service:$SERVICE)",
+		"nomerge":     "metricSelector=builtin:host.cpu.usage:avg&entitySelector=type(HOST)",
+		"placeholder": "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(keptn_service:$SERVCE)",
+		"timeshift":   "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE)&timeshift=-1x",
+		"usql":        "USQL;MAP;;SELECT count(*) FROM usersession",
+		"baseline":    "BASELINE;RESPONSE_TIME_P99;ratio",
+	}}
+	slo := &keptn.ServiceLevelObjectives{
+		Objectives: []*keptn.SLO{
+			{SLI: "valid", Pass: []*keptn.SLOCriteria{{Criteria: []string{"<+10%", "<=600"}}}, Warning: []*keptn.SLOCriteria{{Criteria: []string{"<800ms"}}}},
+			{SLI: "response_time_p95"},
+			{SLI: "undefined", Weight: -1},
+		},
+		TotalScore: &keptn.SLOScore{Pass: "70%", Warning: "80%"},
+		Comparison: &keptn.SLOComparison{CompareWith: "single_result", IncludeResultWithScore: "pass", NumberOfComparisonResults: 1, AggregateFunction: "p99"},
+	}
+
+	linter := NewLinter(nil, GetBuiltInSLICatalog())
+	linter.LintSLI(context.Background(), "sli.yaml", sli)
+	linter.LintSLO("slo.yaml", slo, sli)
+	findings := linter.Findings()
+
+	expectedRules := map[string][]string{
+		"indicators.valid":              {},
+		"indicators.question":           {LintRuleOldQueryFormat},
+		"indicators.scope":              {LintRuleOldQueryFormat, LintRuleScopeParameter},
+		"indicators.nomerge":            {LintRuleMissingMerge},
+		"indicators.placeholder":        {LintRuleUnknownPlaceholder},
+		"indicators.timeshift":          {LintRuleInvalidQuery},
+		"indicators.usql":               {LintRuleInvalidQuery},
+		"indicators.baseline":           {LintRuleInvalidQuery},
+		"objectives[0].pass[0]":         {},
+		"objectives[0].warning[0]":      {LintRuleInvalidCriteria},
+		"objectives[1]":                 {},
+		"objectives[2]":                 {LintRuleUndefinedSLI},
+		"objectives[2].weight":          {LintRuleInvalidWeight},
+		"total_score":                   {LintRuleInvalidTotalScore},
+		"comparison.aggregate_function": {LintRuleInvalidComparison},
+	}
+	for location, expected := range expectedRules {
+		rules := lintFindingRules(findings, location)
+		sort.Strings(rules)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(rules, expected) {
+			t.Errorf("%s: expected findings %v. Got %v", location, expected, rules)
+		}
+	}
+	if !linter.HasErrors() {
+		t.Errorf("Expected errors")
+	}
+	if findings[0].Severity != LintSeverityError {
+		t.Errorf("Expected errors to be listed first")
+	}
+}
+
+func TestLintDashboard(t *testing.T) {
+	keptnEvent := testingGetKeptnEvent(QUALITYGATE_PROJECT, QUALITYGATE_STAGE, QUALTIYGATE_SERVICE, "", "")
+	dh, _, _, teardown := testingGetDynatraceHandler(keptnEvent)
+	defer teardown()
+
+	dashboardJSON, err := dh.LoadDynatraceDashboard(context.Background(), keptnEvent, QUALITYGATE_DASHBOARD_ID)
+	if err != nil || dashboardJSON == nil {
+		t.Fatalf("Could not load dashboard: %v", err)
+	}
+
+	// the test dashboard is valid - also when its metrics are validated against the tenant
+	linter := NewLinter(dh, nil)
+	linter.LintDashboard(context.Background(), "dashboard.json", dashboardJSON)
+	if findings := linter.Findings(); len(findings) != 0 {
+		t.Errorf("Expected no findings. Got %d, first: %+v", len(findings), findings[0])
+	}
+
+	markdownLocation := ""
+	var invalidTile Tile
+	for i, tile := range dashboardJSON.Tiles {
+		if tile.TileType == "MARKDOWN" && strings.Contains(tile.Markdown, "KQG.") {
+			dashboardJSON.Tiles[i].Markdown = "KQG.Total.Pass=90%;KQG.Total.Warning=95%;KQG.Compare.Function=p99;KQG.Total.Fail=10%"
+			markdownLocation = fmt.Sprintf("tiles[%d]", i)
+		}
+		if strings.Contains(tile.FilterConfig.CustomName, "sli=svc_rt_p95") {
+			invalidTile = tile
+		}
+	}
+	invalidTile.FilterConfig.CustomName = "Bad;sli=svc_rt_p95;pass=<600ms;weight=high;key=yes"
+	invalidTile.FilterConfig.ChartConfig.Series = []ChartSeries{{Metric: "builtin:does.not.exist"}}
+	dashboardJSON.Tiles = append(dashboardJSON.Tiles, invalidTile)
+	invalidLocation := fmt.Sprintf("tiles[%d]", len(dashboardJSON.Tiles)-1)
+
+	linter = NewLinter(dh, nil)
+	linter.LintDashboard(context.Background(), "dashboard.json", dashboardJSON)
+	findings := linter.Findings()
+
+	expectedRules := map[string][]string{
+		markdownLocation:               {LintRuleInvalidMarkdown, LintRuleInvalidMarkdown, LintRuleInvalidTotalScore},
+		invalidLocation:                {LintRuleDuplicateSLI, LintRuleInvalidTileTitle, LintRuleInvalidWeight},
+		invalidLocation + ".pass[0]":   {LintRuleInvalidCriteria},
+		invalidLocation + ".series[0]": {LintRuleUnknownMetric},
+	}
+	for location, expected := range expectedRules {
+		rules := lintFindingRules(findings, location)
+		sort.Strings(rules)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(rules, expected) {
+			t.Errorf("%s: expected findings %v. Got %v", location, expected, rules)
+		}
+	}
+}
+
+func TestMigrateLegacyQuery(t *testing.T) {
+	tests := []struct {
+		query        string
+		wantQuery    string
+		wantMigrated bool
+	}{
+		{
+			query:        "builtin:service.response.time:merge(0):avg?scope=tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE)",
+			wantQuery:    "metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),type(SERVICE)",
+			wantMigrated: true,
+		},
+		{
+			query:        "?metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)",
+			wantQuery:    "metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)",
+			wantMigrated: true,
+		},
+		{
+			query:        "metricSelector=builtin:service.requestCount.total:merge(0):sum&scope=type(SERVICE),tag(keptn_service:$SERVICE)",
+			wantQuery:    "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(keptn_service:$SERVICE)",
+			wantMigrated: true,
+		},
+		{
+			query:        "MV2;MicroSecond;builtin:service.cpu.perRequest:merge(0):avg?scope=$ENTITY_SELECTOR",
+			wantQuery:    "MV2;MicroSecond;metricSelector=builtin:service.cpu.perRequest:merge(0):avg&entitySelector=$ENTITY_SELECTOR",
+			wantMigrated: true,
+		},
+		{
+			query:     "metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)",
+			wantQuery: "metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)",
+		},
+		{
+			query:     "USQL;SINGLE_VALUE;;SELECT count(*) FROM usersession WHERE country = 'Austria'",
+			wantQuery: "USQL;SINGLE_VALUE;;SELECT count(*) FROM usersession WHERE country = 'Austria'",
+		},
+		{
+			query:     "BASELINE;RESPONSE_TIME_P90;ratio",
+			wantQuery: "BASELINE;RESPONSE_TIME_P90;ratio",
+		},
+	}
+	for _, tt := range tests {
+		gotQuery, gotMigrated := MigrateLegacyQuery(tt.query)
+		if gotQuery != tt.wantQuery || gotMigrated != tt.wantMigrated {
+			t.Errorf("MigrateLegacyQuery(%s) = %s, %t. Want %s, %t", tt.query, gotQuery, gotMigrated, tt.wantQuery, tt.wantMigrated)
+		}
+	}
+}
+
+func TestMigrateLegacyQueryMatchesRuntimeCompatibility(t *testing.T) {
+	keptnEvent := testingGetKeptnEvent("sockshop", "dev", "carts", "", "")
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+	start := time.Unix(1571649084, 0).UTC()
+	end := start.Add(5 * time.Minute)
+
+	for _, legacyQuery := range []string{
+		"builtin:service.response.time:merge(0):avg?scope=tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE)",
+		"?metricSelector=builtin:service.requestCount.total:merge(0):sum&scope=type(SERVICE),tag(keptn_service:$SERVICE)",
+	} {
+		migratedQuery, _ := MigrateLegacyQuery(legacyQuery)
+
+		legacyURL, _, err := dh.BuildDynatraceMetricsQuery(legacyQuery, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		migratedURL, _, err := dh.BuildDynatraceMetricsQuery(migratedQuery, start, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		legacyParams := testingGetQueryParams(t, legacyURL)
+		migratedParams := testingGetQueryParams(t, migratedURL)
+		for _, param := range []string{"metricSelector", "entitySelector"} {
+			if !reflect.DeepEqual(legacyParams[param], migratedParams[param]) {
+				t.Errorf("%s: %s of the migrated query is %v, the runtime compatibility uses %v", legacyQuery, param, migratedParams[param], legacyParams[param])
+			}
+		}
+	}
+}
+
+func testingGetQueryParams(t *testing.T, queryURL string) url.Values {
+	u, err := url.Parse(queryURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
+
+func TestMigrateSLIFile(t *testing.T) {
+	content := `---
+spec_version: "1.0"
+indicators:
+  # response time of the service
+  response_time: "builtin:service.response.time:merge(0):avg?scope=tag(keptn_service:$SERVICE)"
+  throughput: "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE)"
+`
+	migratedContent, migrations, err := MigrateSLIFile([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedContent := strings.Replace(content,
+		"builtin:service.response.time:merge(0):avg?scope=tag(keptn_service:$SERVICE)",
+		"metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=tag(keptn_service:$SERVICE),type(SERVICE)", 1)
+	if string(migratedContent) != expectedContent {
+		t.Errorf("MigrateSLIFile() returned\n%s\nExpected the query to be replaced in place:\n%s", migratedContent, expectedContent)
+	}
+	if len(migrations) != 1 || migrations[0].Indicator != "response_time" {
+		t.Fatalf("MigrateSLIFile() returned migrations %v, expected one for response_time", migrations)
+	}
+
+	// nothing left to migrate
+	_, migrations, err = MigrateSLIFile(migratedContent)
+	if err != nil || len(migrations) != 0 {
+		t.Errorf("MigrateSLIFile() of a migrated file returned %v, %v. Expected no migrations", migrations, err)
+	}
+
+	// the same query used twice can't be replaced in place
+	content = "indicators:\n  a: \"metric:avg?scope=type(HOST)\"\n  b: \"metric:avg?scope=type(HOST)\"\n"
+	migratedContent, migrations, err = MigrateSLIFile([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sli, err := ParseSLIFile(migratedContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedQuery := "metricSelector=metric:avg&entitySelector=type(HOST),type(SERVICE)"
+	if len(migrations) != 2 || sli.Indicators["a"] != expectedQuery || sli.Indicators["b"] != expectedQuery {
+		t.Errorf("MigrateSLIFile() returned %v and %v. Expected both indicators to be migrated to %s", migrations, sli.Indicators, expectedQuery)
+	}
+}
+
 func TestCreateNewDynatraceHandler(t *testing.T) {
 	keptnEvent := testingGetKeptnEvent("sockshop", "dev", "carts", "direct", "")
 	dh, _, url, teardown := testingGetDynatraceHandler(keptnEvent)
@@ -394,6 +865,257 @@ func TestGetTimeseriesUnsupportedSLI(t *testing.T) {
 	}
 }
 
+func TestGetTimeseriesConfigWithDefaults(t *testing.T) {
+	keptnEvent := testingGetKeptnEvent("sockshop", "dev", "carts", "", "")
+
+	tests := []struct {
+		name     string
+		defaults *common.SLIDefaults
+		want     string
+	}{
+		{
+			name:     "built-in defaults",
+			defaults: nil,
+			want:     "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),tag(keptn_service:$SERVICE),tag(keptn_deployment:$DEPLOYMENT)",
+		},
+		{
+			name: "custom tags without deployment tag and management zone",
+			defaults: &common.SLIDefaults{
+				Tags:           &common.EntityTags{Project: "app", Stage: "env", Service: "svc"},
+				ManagementZone: "my zone",
+			},
+			want: "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),tag(app:$PROJECT),tag(env:$STAGE),tag(svc:$SERVICE),mzName(\"my zone\")",
+		},
+		{
+			name: "tags on process group",
+			defaults: &common.SLIDefaults{
+				EntityType:     common.SLIEntityTypeProcessGroup,
+				ManagementZone: "1234",
+			},
+			want: "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=type(SERVICE),fromRelationships.runsOn(type(PROCESS_GROUP),tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),tag(keptn_service:$SERVICE),tag(keptn_deployment:$DEPLOYMENT)),mzId(1234)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", tt.defaults)
+			got, err := dh.getTimeseriesConfig(Throughput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("getTimeseriesConfig() returned %s, expected %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSLICatalogWithOverrideFile(t *testing.T) {
+	catalogFile, err := ioutil.TempFile("", "sli-catalog-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(catalogFile.Name())
+	catalogFile.WriteString("indicators:\n  throughput: \"metricSelector=my.throughput:merge(0):sum&entitySelector=type(SERVICE),$TAG_FILTER\"\n  my_sli: \"metricSelector=my.metric\"\n")
+	catalogFile.Close()
+
+	os.Setenv(sliCatalogFileEnv, catalogFile.Name())
+	defer os.Unsetenv(sliCatalogFileEnv)
+
+	keptnEvent := testingGetKeptnEvent("sockshop", "dev", "carts", "", "")
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", &common.SLIDefaults{TagScheme: "tag(app:$SERVICE)"})
+	dh.DefaultQueries, err = LoadSLICatalog(dh.Logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		Throughput:      "metricSelector=my.throughput:merge(0):sum&entitySelector=type(SERVICE),tag(app:$SERVICE)",
+		"my_sli":        "metricSelector=my.metric",
+		ResponseTimeP99: "metricSelector=builtin:service.response.time:merge(0):percentile(99)&entitySelector=type(SERVICE),tag(app:$SERVICE)",
+		DBTime:          "MV2;MicroSecond;metricSelector=builtin:service.dbChildCallTime:merge(0):avg&entitySelector=type(SERVICE),tag(app:$SERVICE)",
+	}
+	for metric, want := range expected {
+		got, err := dh.getTimeseriesConfig(metric)
+		if err != nil {
+			t.Errorf("getTimeseriesConfig(%s) returned error %v", metric, err)
+		}
+		if got != want {
+			t.Errorf("getTimeseriesConfig(%s) returned %s, expected %s", metric, got, want)
+		}
+	}
+}
+
+func TestReplaceQueryParametersWithTemplate(t *testing.T) {
+	keptnEvent := testingGetKeptnEvent("sockshop", "dev", "carts", "blue_green_service", "")
+	keptnEvent.Labels = map[string]string{"owner": "team a"}
+	customFilters := []*keptn.SLIFilter{{Key: "handler", Value: "ItemsController"}}
+	os.Setenv("SLI_TEST_SECRET", "my-secret-value")
+	defer os.Unsetenv("SLI_TEST_SECRET")
+
+	tests := []struct {
+		name    string
+		query   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "legacy placeholders",
+			query: "entitySelector=tag(keptn_project:$PROJECT),tag(owner:$LABEL.owner)",
+			want:  "entitySelector=tag(keptn_project:sockshop),tag(owner:team+a)",
+		},
+		{
+			name:  "default for missing label",
+			query: "entitySelector=tag({{ label \"dttag\" | default \"prod\" }}),tag(owner:{{ label \"owner\" | urlencode }})",
+			want:  "entitySelector=tag(prod),tag(owner:team+a)",
+		},
+		{
+			name:  "conditional on deployment strategy",
+			query: "entitySelector=type(SERVICE){{ if eq .DeploymentStrategy \"blue_green_service\" }},tag(primary){{ end }},tag({{ filter \"handler\" | lower }})",
+			want:  "entitySelector=type(SERVICE),tag(primary),tag(itemscontroller)",
+		},
+		{
+			name:    "unresolved legacy placeholders",
+			query:   "entitySelector=tag($LABEL.dttag),tag($ENV.SLI_TEST_UNKNOWN)",
+			wantErr: "unresolved placeholders: $ENV.SLI_TEST_UNKNOWN, $LABEL.dttag",
+		},
+		{
+			name:    "unresolved template placeholder",
+			query:   "entitySelector=tag({{ label \"dttag\" }})",
+			wantErr: "unresolved placeholders: $LABEL.dttag",
+		},
+		{
+			name:    "resolved values are not part of the error",
+			query:   "entitySelector=tag($ENV.SLI_TEST_SECRET),tag($LABEL.dttag)",
+			wantErr: "unresolved placeholders: $LABEL.dttag",
+		},
+		{
+			name:    "invalid template",
+			query:   "entitySelector=tag({{ label }})",
+			wantErr: "could not execute query template",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, customFilters, "", "", nil)
+			got, err := dh.replaceQueryParameters(tt.query)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || strings.Contains(err.Error(), "my-secret-value") {
+					t.Errorf("replaceQueryParameters() returned error %v, expected %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("replaceQueryParameters() returned %s, expected %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyQueryTimeframe(t *testing.T) {
+	start := time.Unix(1571649084, 0).UTC()
+	end := start.Add(5 * time.Minute)
+
+	tests := []struct {
+		name      string
+		query     string
+		wantQuery string
+		wantStart time.Time
+		wantEnd   time.Time
+		wantErr   string
+	}{
+		{
+			name:      "time placeholders",
+			query:     "metricSelector=my.metric&entitySelector=type(SERVICE)&window=$START-$END&duration=$DURATION",
+			wantQuery: "metricSelector=my.metric&entitySelector=type(SERVICE)&window=1571649084000-1571649384000&duration=300",
+			wantStart: start,
+			wantEnd:   end,
+		},
+		{
+			name:      "timeshift by one day",
+			query:     "metricSelector=my.metric&timeshift=-1d&entitySelector=type(SERVICE)",
+			wantQuery: "metricSelector=my.metric&entitySelector=type(SERVICE)",
+			wantStart: start.Add(-24 * time.Hour),
+			wantEnd:   end.Add(-24 * time.Hour),
+		},
+		{
+			name:      "exclude warm-up",
+			query:     "metricSelector=my.metric&from=$START+1m&to=$END-30s&duration=$DURATION",
+			wantQuery: "metricSelector=my.metric&duration=210",
+			wantStart: start.Add(time.Minute),
+			wantEnd:   end.Add(-30 * time.Second),
+		},
+		{
+			name:      "fixed range shifted by a week",
+			query:     "SELECT count(*) FROM usersession WHERE startTime > $START&from=1570000000000&to=1570000600000&timeshift=-1w",
+			wantQuery: "SELECT count(*) FROM usersession WHERE startTime > 1569395200000",
+			wantStart: time.Unix(1570000000, 0).Add(-7 * 24 * time.Hour),
+			wantEnd:   time.Unix(1570000600, 0).Add(-7 * 24 * time.Hour),
+		},
+		{
+			name:    "invalid timeshift",
+			query:   "metricSelector=my.metric&timeshift=yesterday",
+			wantErr: "invalid timeshift",
+		},
+		{
+			name:    "from after to",
+			query:   "metricSelector=my.metric&from=$END+1m",
+			wantErr: "is not before its end",
+		},
+		{
+			name:    "timeframe in the future",
+			query:   "metricSelector=my.metric&timeshift=+1d&from=" + common.TimestampToString(time.Now().Add(-time.Hour)) + "&to=" + common.TimestampToString(time.Now()),
+			wantErr: "is in the future",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, queryStart, queryEnd, err := applyQueryTimeframe(tt.query, start, end)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("applyQueryTimeframe() returned error %v, expected %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.wantQuery {
+				t.Errorf("applyQueryTimeframe() returned query %s, expected %s", query, tt.wantQuery)
+			}
+			if !queryStart.Equal(tt.wantStart) || !queryEnd.Equal(tt.wantEnd) {
+				t.Errorf("applyQueryTimeframe() returned %v - %v, expected %v - %v", queryStart, queryEnd, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestBuildDynatraceMetricsQueryWithTimeshift(t *testing.T) {
+	keptnEvent := testingGetKeptnEvent("sockshop", "dev", "carts", "", "")
+	dh := NewDynatraceHandler("http://dynatrace", keptnEvent, nil, nil, "", "", nil)
+
+	start := time.Unix(1571649084, 0).UTC()
+	end := start.Add(5 * time.Minute)
+	query, metricID, err := dh.BuildDynatraceMetricsQuery("metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=tag(keptn_project:$PROJECT)&timeshift=-1d", start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metricID != "builtin:service.response.time:merge(0):avg" {
+		t.Errorf("BuildDynatraceMetricsQuery() returned metricID %s", metricID)
+	}
+	for _, expected := range []string{"from=1571562684000", "to=1571562984000", "entitySelector=tag%28keptn_project%3Asockshop%29"} {
+		if !strings.Contains(query, expected) {
+			t.Errorf("BuildDynatraceMetricsQuery() returned %s, expected it to contain %s", query, expected)
+		}
+	}
+	if strings.Contains(query, "timeshift") {
+		t.Errorf("BuildDynatraceMetricsQuery() returned %s, expected timeshift to be removed", query)
+	}
+}
+
 func TestTimestampToString(t *testing.T) {
 	dt := time.Now()
 
@@ -610,3 +1332,95 @@ func TestParseMarkdownConfiguration(t *testing.T) {
 	}
 
 }
+
+func TestNewHTTPTransportWithCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// without the CA of the tenant the call fails
+	transport, err := NewHTTPTransport(&common.DTCredentials{})
+	if err != nil {
+		t.Fatalf("NewHTTPTransport() returned error %v", err)
+	}
+	if _, err := (&http.Client{Transport: transport}).Get(server.URL); err == nil {
+		t.Errorf("expected the call to fail with an unknown CA")
+	}
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	transport, err = NewHTTPTransport(&common.DTCredentials{CACert: string(caCert)})
+	if err != nil {
+		t.Fatalf("NewHTTPTransport() returned error %v", err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the call to succeed with DT_CA_CERT: %v", err)
+	}
+	resp.Body.Close()
+
+	if _, err := NewHTTPTransport(&common.DTCredentials{CACert: "no certificate"}); err == nil {
+		t.Errorf("expected an error for an invalid DT_CA_CERT")
+	}
+}
+
+func TestNewHTTPTransportWithProxy(t *testing.T) {
+	transport, err := NewHTTPTransport(&common.DTCredentials{Proxy: "http://proxy.example.com:3128", NoProxy: "internal.example.com"})
+	if err != nil {
+		t.Fatalf("NewHTTPTransport() returned error %v", err)
+	}
+
+	tests := []struct {
+		url       string
+		wantProxy string
+	}{
+		{url: "https://abc12345.live.dynatrace.com/api/v2/metrics", wantProxy: "http://proxy.example.com:3128"},
+		{url: "https://managed.internal.example.com/e/abc/api/v2/metrics", wantProxy: ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		proxyURL, err := transport.Proxy(req)
+		if err != nil {
+			t.Fatalf("Proxy(%s) returned error %v", tt.url, err)
+		}
+		gotProxy := ""
+		if proxyURL != nil {
+			gotProxy = proxyURL.String()
+		}
+		if gotProxy != tt.wantProxy {
+			t.Errorf("Proxy(%s) = %s, want %s", tt.url, gotProxy, tt.wantProxy)
+		}
+	}
+}
+
+func TestNewHTTPTransportWithClientCert(t *testing.T) {
+	if _, err := NewHTTPTransport(&common.DTCredentials{ClientCert: "cert"}); err == nil {
+		t.Errorf("expected an error if DT_CLIENT_KEY is missing")
+	}
+	if _, err := NewHTTPTransport(&common.DTCredentials{ClientCert: "cert", ClientKey: "key"}); err == nil {
+		t.Errorf("expected an error for an invalid client certificate")
+	}
+}
+
+func TestGetCassetteURI(t *testing.T) {
+	logger := common.NewLogger("", "", common.ServiceName)
+	logger.RegisterSecret("my-secret-tag")
+	tests := []struct {
+		apiURL     string
+		requestURL string
+		want       string
+	}{
+		{"https://abc12345.live.dynatrace.com", "https://abc12345.live.dynatrace.com/api/v2/metrics/query?to=2&from=1&Api-Token=abc", "/api/v2/metrics/query?from=1&to=2"},
+		{"https://abc12345.live.dynatrace.com", "https://abc12345.live.dynatrace.com/api/v2/metrics/query?api-token=abc&from=1", "/api/v2/metrics/query?from=1"},
+		{"https://abc12345.live.dynatrace.com", "https://abc12345.live.dynatrace.com/api/v2/metrics/query?API-TOKEN=abc", "/api/v2/metrics/query"},
+		{"https://managed.example.com/e/env-id/", "https://managed.example.com/e/env-id/api/config/v1/dashboards", "/api/config/v1/dashboards"},
+		{"https://abc12345.live.dynatrace.com", "https://abc12345.live.dynatrace.com/api/v2/entities?entitySelector=tag(my-secret-tag)", "/api/v2/entities?entitySelector=tag%28***%29"},
+	}
+	for _, tt := range tests {
+		requestURL, _ := url.Parse(tt.requestURL)
+		dh := &Handler{ApiURL: tt.apiURL, Logger: logger}
+		if got := dh.getCassetteURI(requestURL); got != tt.want {
+			t.Errorf("getCassetteURI(%s) = %s, want %s", tt.requestURL, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package dynatrace
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// SLILinkLabelSuffix is appended to the indicator name to build the label that holds the deep link into Dynatrace, e.g: "throughput Link"
+const SLILinkLabelSuffix = " Link"
+
+// sliLinks collects the deep links of all indicators - indicators may be queried in parallel
+type sliLinks struct {
+	mutex sync.Mutex
+	links map[string]string
+}
+
+/**
+ * addSLILink remembers the deep link of an indicator
+ * Links are sent as labels to every consumer of the event - a link that contains a secret, e.g: a resolved $ENV placeholder, is dropped
+ */
+func (ph *Handler) addSLILink(indicator string, link string) {
+	if link == "" {
+		return
+	}
+	if ph.Logger.Redact(link) != link {
+		ph.Logger.Debugf("No link for %s as its query contains a secret", indicator)
+		return
+	}
+	ph.sliLinks.mutex.Lock()
+	defer ph.sliLinks.mutex.Unlock()
+	ph.sliLinks.links[indicator] = link
+}
+
+// GetSLILinks returns the deep links into Dynatrace of all indicators that were queried, keyed by indicator name
+func (ph *Handler) GetSLILinks() map[string]string {
+	ph.sliLinks.mutex.Lock()
+	defer ph.sliLinks.mutex.Unlock()
+
+	links := make(map[string]string, len(ph.sliLinks.links))
+	for indicator, link := range ph.sliLinks.links {
+		links[indicator] = link
+	}
+	return links
+}
+
+/**
+ * buildDataExplorerLink returns a link to the Data Explorer for a finalized Metrics API query (see BuildDynatraceMetricsQuery)
+ * The link contains the metricSelector, entitySelector and the timeframe of the query (gtf=c_START_END)
+ */
+func (ph *Handler) buildDataExplorerLink(metricsQuery string) string {
+	u, err := url.Parse(metricsQuery)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+
+	linkParams := url.Values{}
+	linkParams.Add("metricSelector", q.Get("metricSelector"))
+	if entitySelector := q.Get("entitySelector"); entitySelector != "" {
+		linkParams.Add("entitySelector", entitySelector)
+	}
+	linkParams.Add("gtf", fmt.Sprintf("c_%s_%s", q.Get("from"), q.Get("to")))
+
+	return fmt.Sprintf("%s/ui/data-explorer?%s", ph.ApiURL, linkParams.Encode())
+}
+
+/**
+ * buildUSQLLink returns a link to the User Sessions Query page for a finalized USQL query (see BuildDynatraceUSQLQuery)
+ * The link contains the query and the timeframe of the query (gtf=c_START_END)
+ */
+func (ph *Handler) buildUSQLLink(usql string) string {
+	u, err := url.Parse(usql)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+
+	linkParams := url.Values{}
+	linkParams.Add("query", q.Get("query"))
+	linkParams.Add("gtf", fmt.Sprintf("c_%s_%s", q.Get("startTimestamp"), q.Get("endTimestamp")))
+
+	return fmt.Sprintf("%s/ui/user-sessions/query?%s", ph.ApiURL, linkParams.Encode())
+}
@@ -0,0 +1,486 @@
+package dynatrace
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	keptnevents "github.com/keptn/go-utils/pkg/lib"
+)
+
+// Severities of lint findings - only errors fail an evaluation, warnings are patched at runtime or may return unexpected values
+const LintSeverityError = "error"
+const LintSeverityWarning = "warning"
+
+// Rules of lint findings
+const LintRuleInvalidFile = "invalid-file"
+const LintRuleInvalidQuery = "invalid-query"
+const LintRuleOldQueryFormat = "old-query-format"
+const LintRuleScopeParameter = "scope-parameter"
+const LintRuleMissingMerge = "missing-merge"
+const LintRuleUnknownPlaceholder = "unknown-placeholder"
+const LintRuleUnknownMetric = "unknown-metric"
+const LintRuleUndefinedSLI = "undefined-sli"
+const LintRuleDuplicateSLI = "duplicate-sli"
+const LintRuleInvalidCriteria = "invalid-criteria"
+const LintRuleInvalidWeight = "invalid-weight"
+const LintRuleInvalidTotalScore = "invalid-total-score"
+const LintRuleInvalidComparison = "invalid-comparison"
+const LintRuleInvalidTileTitle = "invalid-tile-title"
+const LintRuleInvalidMarkdown = "invalid-markdown"
+
+// sloCriteriaRegex matches a single criterion of an slo.yaml, e.g: <600, <=+10%, >-5%, =0
+var sloCriteriaRegex = regexp.MustCompile(`^(<=|>=|<|>|=)[+-]?\d+(\.\d+)?%?$`)
+
+// totalScoreRegex matches the total score of an slo.yaml, e.g: 90%
+var totalScoreRegex = regexp.MustCompile(`^\d+(\.\d+)?%?$`)
+
+// placeholderRegex matches $ placeholders in queries, e.g: $SERVICE or $LABEL.buildId
+var placeholderRegex = regexp.MustCompile(`\$[A-Z][A-Z_]*(\.[A-Za-z0-9_\-]+)?`)
+
+// knownPlaceholders are all placeholders of ReplaceKeptnPlaceholders and the timeframe placeholders - $LABEL., $ENV. and $FILTER. take a name
+var knownPlaceholders = map[string]bool{
+	"$CONTEXT": true, "$EVENT": true, "$SOURCE": true, "$PROJECT": true, "$STAGE": true, "$SERVICE": true,
+	"$DEPLOYMENT": true, "$TESTSTRATEGY": true, StartPlaceholder: true, EndPlaceholder: true, DurationPlaceholder: true,
+}
+var knownNamedPlaceholders = []string{"$LABEL.", "$ENV.", "$FILTER."}
+
+// lintStart and lintEnd are the evaluation timeframe queries are checked for, e.g: for invalid from and to parameters
+var lintStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+var lintEnd = lintStart.Add(5 * time.Minute)
+
+// LintFinding is a problem in an sli.yaml, slo.yaml or dashboard. Location is the path within the file, e.g: indicators.rt or tiles[3]
+type LintFinding struct {
+	File     string `json:"file"`
+	Location string `json:"location"`
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+/**
+ * Linter checks sli.yaml, slo.yaml and dashboards for problems that otherwise only show up during an evaluation
+ * Handler is optional - if set, the metric IDs of all queries are validated against /api/v2/metrics/{id}
+ * Catalog are the indicators an slo.yaml can reference without defining them in its sli.yaml, e.g: the built-in SLI catalog
+ */
+type Linter struct {
+	Handler  *Handler
+	Catalog  map[string]string
+	metrics  map[string]error
+	findings []*LintFinding
+}
+
+// NewLinter returns a linter that validates metric IDs against the tenant of the handler - pass nil for static checks only
+func NewLinter(handler *Handler, catalog map[string]string) *Linter {
+	return &Linter{Handler: handler, Catalog: catalog, metrics: map[string]error{}}
+}
+
+// Findings returns all findings so far - errors first, then ordered by file and location
+func (l *Linter) Findings() []*LintFinding {
+	findings := append([]*LintFinding{}, l.findings...)
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Severity != findings[j].Severity {
+			return findings[i].Severity == LintSeverityError
+		}
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		return findings[i].Location < findings[j].Location
+	})
+	return findings
+}
+
+// HasErrors returns whether any finding so far is an error
+func (l *Linter) HasErrors() bool {
+	for _, finding := range l.findings {
+		if finding.Severity == LintSeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// AddFinding adds a finding, e.g: for a file that could not be parsed
+func (l *Linter) AddFinding(file string, location string, rule string, severity string, format string, args ...interface{}) {
+	l.findings = append(l.findings, &LintFinding{File: file, Location: location, Rule: rule, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// LintSLI checks the queries of an sli.yaml
+func (l *Linter) LintSLI(ctx context.Context, file string, sli *SLI) {
+	indicators := []string{}
+	for indicator := range sli.Indicators {
+		indicators = append(indicators, indicator)
+	}
+	sort.Strings(indicators)
+
+	for _, indicator := range indicators {
+		l.lintQuery(ctx, file, "indicators."+indicator, sli.Indicators[indicator])
+	}
+}
+
+/**
+ * lintQuery checks a single query of an sli.yaml:
+ * -- USQL;<type>;<dimension>;<query> and BASELINE;<metric>;<mode>[;<entitySelector>] have to be complete
+ * -- metric queries should use the format metricSelector=..&entitySelector=.. and merge dimensions
+ * -- placeholders, templates and timeframe parameters have to be valid
+ */
+func (l *Linter) lintQuery(ctx context.Context, file string, location string, query string) {
+	if strings.TrimSpace(query) == "" {
+		l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "the query is empty")
+		return
+	}
+
+	for _, placeholder := range placeholderRegex.FindAllString(query, -1) {
+		if !isKnownPlaceholder(placeholder) {
+			l.AddFinding(file, location, LintRuleUnknownPlaceholder, LintSeverityWarning, "%s is not a known placeholder - it is only replaced if a custom filter of that name is passed", placeholder)
+		}
+	}
+
+	if common.IsQueryTemplate(query) {
+		expandedQuery, err := common.ExpandQueryTemplate(query, &common.BaseKeptnEvent{}, nil, nil)
+		if err != nil {
+			l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "the query template is invalid: %v", err)
+			return
+		}
+		query = expandedQuery
+	}
+
+	switch {
+	case strings.HasPrefix(query, "USQL;"):
+		querySplits := strings.Split(query, ";")
+		if len(querySplits) != 4 {
+			l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "USQL queries have to be in the format USQL;<type>;<dimension>;<query>")
+			return
+		}
+		if !isSupportedUSQLType(querySplits[1]) {
+			l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "the USQL type %s is not supported, expected SINGLE_VALUE, PIE_CHART, COLUMN_CHART or TABLE", querySplits[1])
+		}
+		return
+	case strings.HasPrefix(query, AutoBaselinePrefix):
+		querySplits := strings.SplitN(strings.TrimPrefix(query, AutoBaselinePrefix), ";", 3)
+		if len(querySplits) < 2 {
+			l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "BASELINE queries have to be in the format BASELINE;<metric>;<mode>[;<entitySelector>]")
+			return
+		}
+		if _, ok := autoBaselineMetricSelectors[querySplits[0]]; !ok {
+			l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "unsupported baseline metric %s, expected %s, %s or %s", querySplits[0], AutoBaselineResponseTimeP50, AutoBaselineResponseTimeP90, AutoBaselineFailureRate)
+		}
+		if querySplits[1] != AutoBaselineModeRatio && querySplits[1] != AutoBaselineModeDeviation {
+			l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "unsupported baseline mode %s, expected %s or %s", querySplits[1], AutoBaselineModeRatio, AutoBaselineModeDeviation)
+		}
+		return
+	case strings.HasPrefix(query, "MV2;"):
+		querySplits := strings.SplitN(query, ";", 3)
+		if len(querySplits) != 3 || querySplits[1] == "" {
+			l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "MV2 queries have to be in the format MV2;<unit>;<query>")
+			return
+		}
+		query = querySplits[2]
+	}
+
+	if strings.HasPrefix(query, "?metricSelector=") {
+		l.AddFinding(file, location, LintRuleOldQueryFormat, LintSeverityWarning, "remove the leading ? - see %s", MetricsAPIOldFormatNewFormatDoc)
+		query = strings.TrimPrefix(query, "?")
+	} else if querySplit := strings.SplitN(query, "?", 2); len(querySplit) == 2 {
+		l.AddFinding(file, location, LintRuleOldQueryFormat, LintSeverityWarning, "use metricSelector=%s&%s instead of the old format - see %s", querySplit[0], querySplit[1], MetricsAPIOldFormatNewFormatDoc)
+		query = fmt.Sprintf("metricSelector=%s&%s", querySplit[0], querySplit[1])
+	}
+
+	if _, _, _, err := applyQueryTimeframe(query, lintStart, lintEnd); err != nil {
+		l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "the timeframe of the query is invalid: %v", err)
+	}
+
+	parameters := map[string]string{}
+	for _, parameter := range strings.Split(query, "&") {
+		if nameValue := strings.SplitN(parameter, "=", 2); len(nameValue) == 2 {
+			parameters[nameValue[0]] = nameValue[1]
+		}
+	}
+	if _, ok := parameters["scope"]; ok {
+		l.AddFinding(file, location, LintRuleScopeParameter, LintSeverityWarning, "use entitySelector=... instead of scope=... - see %s", MetricsAPIOldFormatNewFormatDoc)
+	}
+	metricSelector, ok := parameters["metricSelector"]
+	if !ok || metricSelector == "" {
+		l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "the query has no metricSelector")
+		return
+	}
+	if !strings.Contains(metricSelector, ":merge(") && !strings.Contains(metricSelector, ":splitBy(") {
+		l.AddFinding(file, location, LintRuleMissingMerge, LintSeverityWarning, "the metricSelector %s doesn't merge dimensions - the evaluation fails if more than one series is returned, e.g: add :merge(0)", metricSelector)
+	}
+
+	l.lintMetricID(ctx, file, location, getMetricIDFromSelector(metricSelector))
+}
+
+// lintMetricID validates that the metric exists on the tenant - each metric is only described once
+func (l *Linter) lintMetricID(ctx context.Context, file string, location string, metricID string) {
+	if l.Handler == nil || metricID == "" {
+		return
+	}
+	err, described := l.metrics[metricID]
+	if !described {
+		_, err = l.Handler.ExecuteMetricAPIDescribe(ctx, metricID)
+		l.metrics[metricID] = err
+	}
+	if err != nil {
+		l.AddFinding(file, location, LintRuleUnknownMetric, LintSeverityError, "the metric %s could not be found: %v", metricID, err)
+	}
+}
+
+// getMetricIDFromSelector returns the metric ID of a metricSelector, e.g: builtin:service.response.time for builtin:service.response.time:merge(0):avg
+func getMetricIDFromSelector(metricSelector string) string {
+	if strings.HasPrefix(metricSelector, "(") {
+		// metric expressions are not validated
+		return ""
+	}
+	metricIDParts := []string{}
+	for _, part := range splitOutsideParentheses(metricSelector, ':') {
+		if strings.Contains(part, "(") || part == "names" || part == "median" || metricSelectorAggregations[part] != "" {
+			break
+		}
+		metricIDParts = append(metricIDParts, part)
+	}
+	return strings.Join(metricIDParts, ":")
+}
+
+func isKnownPlaceholder(placeholder string) bool {
+	if knownPlaceholders[placeholder] {
+		return true
+	}
+	for _, prefix := range knownNamedPlaceholders {
+		if strings.HasPrefix(placeholder, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSupportedUSQLType(usqlType string) bool {
+	return usqlType == "SINGLE_VALUE" || usqlType == "PIE_CHART" || usqlType == "COLUMN_CHART" || usqlType == "TABLE"
+}
+
+/**
+ * LintSLO checks an slo.yaml - sli is the sli.yaml next to it and may be nil
+ * Objectives have to reference an indicator of the sli.yaml or the catalog of the linter
+ */
+func (l *Linter) LintSLO(file string, slo *keptnevents.ServiceLevelObjectives, sli *SLI) {
+	objectives := map[string]bool{}
+	for i, objective := range slo.Objectives {
+		location := fmt.Sprintf("objectives[%d]", i)
+		if objective.SLI == "" {
+			l.AddFinding(file, location, LintRuleUndefinedSLI, LintSeverityError, "the objective has no sli")
+			continue
+		}
+		if objectives[objective.SLI] {
+			l.AddFinding(file, location, LintRuleDuplicateSLI, LintSeverityWarning, "the sli %s has more than one objective", objective.SLI)
+		}
+		objectives[objective.SLI] = true
+
+		_, inSLIFile := sli.getIndicator(objective.SLI)
+		if _, inCatalog := l.Catalog[objective.SLI]; !inSLIFile && !inCatalog {
+			l.AddFinding(file, location, LintRuleUndefinedSLI, LintSeverityError, "the sli %s is neither defined in the sli.yaml nor in the SLI catalog", objective.SLI)
+		}
+
+		if objective.Weight < 0 {
+			l.AddFinding(file, location+".weight", LintRuleInvalidWeight, LintSeverityError, "the weight %d is negative", objective.Weight)
+		}
+		l.lintCriteria(file, location+".pass", objective.Pass)
+		l.lintCriteria(file, location+".warning", objective.Warning)
+	}
+
+	if slo.TotalScore != nil {
+		l.lintTotalScore(file, "total_score", slo.TotalScore.Pass, slo.TotalScore.Warning)
+	}
+	if slo.Comparison != nil {
+		l.lintComparison(file, "comparison", slo.Comparison.CompareWith, slo.Comparison.IncludeResultWithScore, slo.Comparison.NumberOfComparisonResults, slo.Comparison.AggregateFunction)
+	}
+}
+
+func (sli *SLI) getIndicator(indicator string) (string, bool) {
+	if sli == nil {
+		return "", false
+	}
+	query, ok := sli.Indicators[indicator]
+	return query, ok
+}
+
+func (l *Linter) lintCriteria(file string, location string, criteria []*keptnevents.SLOCriteria) {
+	for i, sloCriteria := range criteria {
+		if sloCriteria == nil || len(sloCriteria.Criteria) == 0 {
+			l.AddFinding(file, fmt.Sprintf("%s[%d]", location, i), LintRuleInvalidCriteria, LintSeverityError, "the criteria are empty")
+			continue
+		}
+		for _, criterion := range sloCriteria.Criteria {
+			if !sloCriteriaRegex.MatchString(strings.TrimSpace(criterion)) {
+				l.AddFinding(file, fmt.Sprintf("%s[%d]", location, i), LintRuleInvalidCriteria, LintSeverityError, "the criterion %s is invalid, expected an operator followed by a number, e.g: <600, <=+10%% or >-5%%", criterion)
+			}
+		}
+	}
+}
+
+func (l *Linter) lintTotalScore(file string, location string, pass string, warning string) {
+	passScore, passErr := parseTotalScore(pass)
+	if passErr != nil {
+		l.AddFinding(file, location+".pass", LintRuleInvalidTotalScore, LintSeverityError, "%v", passErr)
+	}
+	warningScore, warningErr := parseTotalScore(warning)
+	if warningErr != nil {
+		l.AddFinding(file, location+".warning", LintRuleInvalidTotalScore, LintSeverityError, "%v", warningErr)
+	}
+	if passErr == nil && warningErr == nil && warningScore > passScore {
+		l.AddFinding(file, location, LintRuleInvalidTotalScore, LintSeverityError, "the warning score %s is above the pass score %s", warning, pass)
+	}
+}
+
+func parseTotalScore(score string) (float64, error) {
+	if !totalScoreRegex.MatchString(score) {
+		return 0, fmt.Errorf("the score %s is invalid, expected a percentage, e.g: 90%%", score)
+	}
+	return strconv.ParseFloat(strings.TrimSuffix(score, "%"), 64)
+}
+
+func (l *Linter) lintComparison(file string, location string, compareWith string, includeResultWithScore string, numberOfComparisonResults int, aggregateFunction string) {
+	if compareWith != "" && compareWith != "single_result" && compareWith != "several_results" {
+		l.AddFinding(file, location+".compare_with", LintRuleInvalidComparison, LintSeverityError, "%s is invalid, expected single_result or several_results", compareWith)
+	}
+	if includeResultWithScore != "" && includeResultWithScore != "pass" && includeResultWithScore != "pass_or_warn" && includeResultWithScore != "all" {
+		l.AddFinding(file, location+".include_result_with_score", LintRuleInvalidComparison, LintSeverityError, "%s is invalid, expected pass, pass_or_warn or all", includeResultWithScore)
+	}
+	if numberOfComparisonResults < 0 {
+		l.AddFinding(file, location+".number_of_comparison_results", LintRuleInvalidComparison, LintSeverityError, "%d is negative", numberOfComparisonResults)
+	}
+	if aggregateFunction != "" && aggregateFunction != "avg" && aggregateFunction != "p50" && aggregateFunction != "p90" && aggregateFunction != "p95" {
+		l.AddFinding(file, location+".aggregate_function", LintRuleInvalidComparison, LintSeverityError, "%s is invalid, expected avg, p50, p90 or p95", aggregateFunction)
+	}
+}
+
+/**
+ * LintDashboard checks the SLI tiles and the KQG markdown of a dashboard:
+ * -- titles of custom chart and USQL tiles: sli name, pass and warning criteria, weight and key
+ * -- the sli names have to be unique - charts split by a dimension generate one indicator per dimension value
+ * -- KQG.* settings of markdown tiles
+ */
+func (l *Linter) LintDashboard(ctx context.Context, file string, dashboardJSON *DynatraceDashboard) {
+	indicators := map[string]string{}
+	kqgMarkdowns := 0
+
+	for i, tile := range dashboardJSON.Tiles {
+		location := fmt.Sprintf("tiles[%d]", i)
+		switch tile.TileType {
+		case "MARKDOWN":
+			if strings.Contains(tile.Markdown, "KQG.") {
+				kqgMarkdowns++
+				if kqgMarkdowns > 1 {
+					l.AddFinding(file, location, LintRuleInvalidMarkdown, LintSeverityWarning, "more than one markdown tile defines KQG settings - the last one wins")
+				}
+				l.lintKQGMarkdown(file, location, tile.Markdown)
+			}
+			continue
+		case "CUSTOM_CHARTING":
+			l.lintTileTitle(file, location, tile.FilterConfig.CustomName, indicators)
+			if strings.Contains(tile.FilterConfig.CustomName, "sli=") {
+				for j, series := range tile.FilterConfig.ChartConfig.Series {
+					l.lintMetricID(ctx, file, fmt.Sprintf("%s.series[%d]", location, j), series.Metric)
+				}
+			}
+		case "DTAQL":
+			l.lintTileTitle(file, location, tile.CustomName, indicators)
+			if strings.Contains(tile.CustomName, "sli=") && !isSupportedUSQLType(tile.Type) {
+				l.AddFinding(file, location, LintRuleInvalidQuery, LintSeverityError, "the USQL type %s is not supported, expected SINGLE_VALUE, PIE_CHART, COLUMN_CHART or TABLE", tile.Type)
+			}
+		}
+	}
+}
+
+// lintTileTitle checks a title such as Response time (P95);sli=svc_rt_p95;pass=<+10%,<600;warning=<800;weight=2;key=true
+func (l *Linter) lintTileTitle(file string, location string, title string, indicators map[string]string) {
+	sliName := ""
+	hasCriteria := false
+	for _, nameValue := range strings.Split(title, ";") {
+		dividerIndex := strings.Index(nameValue, "=")
+		if dividerIndex < 0 {
+			continue
+		}
+		name := nameValue[:dividerIndex]
+		value := nameValue[dividerIndex+1:]
+		switch name {
+		case "sli":
+			sliName = value
+		case "pass", "warning":
+			hasCriteria = true
+			l.lintCriteria(file, location+"."+name, []*keptnevents.SLOCriteria{{Criteria: strings.Split(value, ",")}})
+		case "weight":
+			if weight, err := strconv.Atoi(value); err != nil || weight < 0 {
+				l.AddFinding(file, location, LintRuleInvalidWeight, LintSeverityError, "the weight %s is not a positive number", value)
+			}
+		case "key":
+			if _, err := strconv.ParseBool(value); err != nil {
+				l.AddFinding(file, location, LintRuleInvalidTileTitle, LintSeverityError, "key=%s is invalid, expected true or false", value)
+			}
+		default:
+			l.AddFinding(file, location, LintRuleInvalidTileTitle, LintSeverityWarning, "%s= is ignored, expected sli, pass, warning, weight or key", name)
+		}
+	}
+
+	if sliName == "" {
+		if hasCriteria {
+			l.AddFinding(file, location, LintRuleInvalidTileTitle, LintSeverityError, "the tile defines criteria but no sli= - it is ignored")
+		}
+		return
+	}
+	if cleanIndicatorName(sliName) != sliName {
+		l.AddFinding(file, location, LintRuleInvalidTileTitle, LintSeverityWarning, "the sli %s is renamed to %s - blanks, / and %% are replaced", sliName, cleanIndicatorName(sliName))
+	}
+	if otherLocation, ok := indicators[sliName]; ok {
+		l.AddFinding(file, location, LintRuleDuplicateSLI, LintSeverityError, "the sli %s is already defined by %s", sliName, otherLocation)
+		return
+	}
+	indicators[sliName] = location
+}
+
+// lintKQGMarkdown checks the settings ParseMarkdownConfiguration supports, e.g: KQG.Total.Pass=90%;KQG.Compare.Results=1
+func (l *Linter) lintKQGMarkdown(file string, location string, markdown string) {
+	pass, warning := "", ""
+	for _, setting := range strings.Split(markdown, ";") {
+		nameValue := strings.Split(strings.TrimSpace(setting), "=")
+		if len(nameValue) != 2 || !strings.HasPrefix(strings.ToLower(nameValue[0]), "kqg.") {
+			continue
+		}
+		value := nameValue[1]
+		switch strings.ToLower(nameValue[0]) {
+		case "kqg.total.pass":
+			pass = value
+		case "kqg.total.warning":
+			warning = value
+		case "kqg.compare.withscore":
+			if value != "pass" && value != "pass_or_warn" && value != "all" {
+				l.AddFinding(file, location, LintRuleInvalidMarkdown, LintSeverityWarning, "KQG.Compare.WithScore=%s is replaced by pass, expected pass, pass_or_warn or all", value)
+			}
+		case "kqg.compare.results":
+			if results, err := strconv.Atoi(value); err != nil || results < 1 {
+				l.AddFinding(file, location, LintRuleInvalidMarkdown, LintSeverityWarning, "KQG.Compare.Results=%s is replaced by 1, expected a number of at least 1", value)
+			}
+		case "kqg.compare.function":
+			if value != "avg" && value != "p50" && value != "p90" && value != "p95" {
+				l.AddFinding(file, location, LintRuleInvalidMarkdown, LintSeverityWarning, "KQG.Compare.Function=%s is replaced by avg, expected avg, p50, p90 or p95", value)
+			}
+		default:
+			l.AddFinding(file, location, LintRuleInvalidMarkdown, LintSeverityWarning, "%s is ignored, expected KQG.Total.Pass, KQG.Total.Warning, KQG.Compare.WithScore, KQG.Compare.Results or KQG.Compare.Function", nameValue[0])
+		}
+	}
+
+	if pass != "" || warning != "" {
+		_, defaultSLO := newDashboardSLIAndSLO()
+		if pass == "" {
+			pass = defaultSLO.TotalScore.Pass
+		}
+		if warning == "" {
+			warning = defaultSLO.TotalScore.Warning
+		}
+		l.lintTotalScore(file, location, pass, warning)
+	}
+}
@@ -0,0 +1,159 @@
+package dynatrace
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	"gopkg.in/yaml.v2"
+)
+
+// SLIMigrationLabel is the label that summarizes the migration of legacy queries in dynatrace/sli.yaml
+const SLIMigrationLabel = "SLI Migration"
+
+// SLIMigrationLabelSuffix is appended to the indicator name to build the label that holds its migrated query, e.g: "throughput Migration"
+const SLIMigrationLabelSuffix = " Migration"
+
+// SLIBackupFilename is the name of the backup of a migrated sli.yaml, e.g: dynatrace/results/<shkeptncontext>/sli.backup.yaml
+const SLIBackupFilename = "sli.backup.yaml"
+
+// QueryMigration describes how the query of an indicator was migrated to the new Metrics API format
+type QueryMigration struct {
+	Indicator string `json:"indicator"`
+	OldQuery  string `json:"oldQuery"`
+	NewQuery  string `json:"newQuery"`
+}
+
+// String returns the migration as <old query> => <new query>
+func (m *QueryMigration) String() string {
+	return m.OldQuery + " => " + m.NewQuery
+}
+
+/**
+ * MigrateLegacyQuery converts a query in one of the legacy formats that BuildDynatraceMetricsQuery patches at runtime
+ * into the metricSelector=...&entitySelector=... format (see MetricsAPIOldFormatNewFormatDoc):
+ *   ?metricSelector=metric&...    -> metricSelector=metric&...
+ *   metric?scope=...              -> metricSelector=metric&entitySelector=...,type(SERVICE)
+ * The MV2;<unit>; prefix is kept. USQL, BASELINE and template queries are not touched
+ * Returns the query and whether it was migrated
+ */
+func MigrateLegacyQuery(query string) (string, bool) {
+	if common.IsQueryTemplate(query) || strings.HasPrefix(query, "USQL;") || strings.HasPrefix(query, AutoBaselinePrefix) {
+		return query, false
+	}
+
+	prefix := ""
+	metricsQuery := query
+	if strings.HasPrefix(query, "MV2;") {
+		querySplits := strings.SplitN(query, ";", 3)
+		if len(querySplits) != 3 {
+			return query, false
+		}
+		prefix = querySplits[0] + ";" + querySplits[1] + ";"
+		metricsQuery = querySplits[2]
+	}
+
+	migratedQuery := metricsQuery
+	if strings.HasPrefix(migratedQuery, "?metricSelector=") {
+		migratedQuery = strings.TrimPrefix(migratedQuery, "?")
+	} else if querySplit := strings.SplitN(migratedQuery, "?", 2); len(querySplit) == 2 {
+		migratedQuery = fmt.Sprintf("metricSelector=%s&%s", querySplit[0], querySplit[1])
+	}
+	migratedQuery = migrateScopeParameter(migratedQuery)
+
+	if migratedQuery == metricsQuery {
+		return query, false
+	}
+	return prefix + migratedQuery, true
+}
+
+/**
+ * migrateScopeParameter replaces scope=... with entitySelector=... and adds type(SERVICE) just like BuildDynatraceMetricsQuery does at runtime
+ * Queries that already have an entitySelector are returned as they are
+ */
+func migrateScopeParameter(query string) string {
+	parameters := strings.Split(query, "&")
+	for _, parameter := range parameters {
+		if strings.HasPrefix(parameter, "entitySelector=") {
+			return query
+		}
+	}
+
+	for i, parameter := range parameters {
+		if !strings.HasPrefix(parameter, "scope=") {
+			continue
+		}
+		scope := strings.TrimPrefix(parameter, "scope=")
+		// $ENTITY_SELECTOR already resolves to an entitySelector with type(SERVICE)
+		if !strings.Contains(scope, "type(SERVICE)") && !strings.Contains(scope, EntitySelectorPlaceholder) {
+			scope = scope + ",type(SERVICE)"
+		}
+		parameters[i] = "entitySelector=" + scope
+	}
+	return strings.Join(parameters, "&")
+}
+
+/**
+ * MigrateSLIFile migrates all legacy queries of an sli.yaml (see MigrateLegacyQuery)
+ * The queries are replaced in place to keep comments and formatting of the file. If that is not possible, e.g: because
+ * two indicators share the same query, the migrated file is serialized from scratch
+ * Returns the migrated content and the migrations sorted by indicator - the content is returned unchanged if there was nothing to migrate
+ */
+func MigrateSLIFile(content []byte) ([]byte, []*QueryMigration, error) {
+	sli, err := ParseSLIFile(content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indicators := make([]string, 0, len(sli.Indicators))
+	for indicator := range sli.Indicators {
+		indicators = append(indicators, indicator)
+	}
+	sort.Strings(indicators)
+
+	var migrations []*QueryMigration
+	for _, indicator := range indicators {
+		query := sli.Indicators[indicator]
+		migratedQuery, migrated := MigrateLegacyQuery(query)
+		if !migrated {
+			continue
+		}
+		migrations = append(migrations, &QueryMigration{Indicator: indicator, OldQuery: query, NewQuery: migratedQuery})
+		sli.Indicators[indicator] = migratedQuery
+	}
+
+	if len(migrations) == 0 {
+		return content, nil, nil
+	}
+
+	if migratedContent, ok := replaceMigratedQueries(string(content), migrations, sli); ok {
+		return []byte(migratedContent), migrations, nil
+	}
+
+	migratedContent, err := yaml.Marshal(sli)
+	if err != nil {
+		return nil, nil, err
+	}
+	return migratedContent, migrations, nil
+}
+
+/**
+ * replaceMigratedQueries replaces every old query in the content with the new one
+ * Only succeeds if every old query occurs exactly once and the result parses to the migrated indicators
+ */
+func replaceMigratedQueries(content string, migrations []*QueryMigration, migratedSLI *SLI) (string, bool) {
+	for _, migration := range migrations {
+		if strings.Count(content, migration.OldQuery) != 1 {
+			return "", false
+		}
+		content = strings.Replace(content, migration.OldQuery, migration.NewQuery, 1)
+	}
+
+	parsedSLI, err := ParseSLIFile([]byte(content))
+	if err != nil || parsedSLI.SpecVersion != migratedSLI.SpecVersion || !reflect.DeepEqual(parsedSLI.Indicators, migratedSLI.Indicators) {
+		return "", false
+	}
+	return content, true
+}
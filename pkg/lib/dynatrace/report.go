@@ -0,0 +1,185 @@
+package dynatrace
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+	keptnevents "github.com/keptn/go-utils/pkg/lib"
+)
+
+// EvaluationReportFilename is the name of the uploaded evaluation report, e.g: dynatrace/results/<shkeptncontext>/report.json
+const EvaluationReportFilename = "report.json"
+
+// EvaluationReportLabel is the label that holds the resource URI of the uploaded evaluation report
+const EvaluationReportLabel = "Evaluation Report"
+
+// Sources of the indicators of an evaluation
+const ReportSourceDashboard = "dashboard"
+const ReportSourceSLIFile = "sli.yaml"
+
+// Data source types of an indicator
+const IndicatorTypeMetrics = "METRICS"
+const IndicatorTypeUSQL = "USQL"
+const IndicatorTypeBaseline = "BASELINE"
+const IndicatorTypeChange = "CHANGE"
+
+// EvaluationReport documents how the SLIs of one evaluation were retrieved
+type EvaluationReport struct {
+	Context    string             `json:"shkeptncontext"`
+	Project    string             `json:"project"`
+	Stage      string             `json:"stage"`
+	Service    string             `json:"service"`
+	Start      string             `json:"start"`
+	End        string             `json:"end"`
+	Source     string             `json:"source"`
+	Indicators []*IndicatorReport `json:"indicators"`
+}
+
+// IndicatorReport documents the query and result of a single indicator. RawValue is the value before scaling, e.g: in microseconds
+type IndicatorReport struct {
+	Indicator  string  `json:"indicator"`
+	Type       string  `json:"type"`
+	Query      string  `json:"query,omitempty"`
+	Unit       string  `json:"unit,omitempty"`
+	RawValue   float64 `json:"rawValue"`
+	Value      float64 `json:"value"`
+	DurationMs int64   `json:"durationMs"`
+	Retries    int     `json:"retries"`
+	Success    bool    `json:"success"`
+	TimedOut   bool    `json:"timedOut,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// reportCollector collects the reports of all indicators - indicators may be queried in parallel
+type reportCollector struct {
+	mutex      sync.Mutex
+	indicators map[string]*IndicatorReport
+}
+
+// requestStats counts the retries of the Dynatrace API calls of a single indicator
+type requestStats struct {
+	retries int32
+}
+
+func (stats *requestStats) addRetry() {
+	if stats != nil {
+		atomic.AddInt32(&stats.retries, 1)
+	}
+}
+
+func (stats *requestStats) getRetries() int {
+	if stats == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&stats.retries))
+}
+
+/**
+ * forIndicator returns a copy of the handler that counts the retries of its Dynatrace API calls and logs the indicator name
+ * If the handler already belongs to an indicator, e.g: for nested queries of an indicator, the handler itself is returned
+ */
+func (ph *Handler) forIndicator(indicator string) *Handler {
+	if ph.requestStats != nil {
+		return ph
+	}
+	handler := *ph
+	handler.requestStats = &requestStats{}
+	handler.Logger = ph.Logger.WithIndicator(indicator)
+	return &handler
+}
+
+// getIndicatorType returns the data source type of an indicator query from sli.yaml
+func getIndicatorType(query string) string {
+	if strings.HasPrefix(query, "USQL;") {
+		return IndicatorTypeUSQL
+	}
+	if strings.HasPrefix(query, AutoBaselinePrefix) {
+		return IndicatorTypeBaseline
+	}
+	return IndicatorTypeMetrics
+}
+
+/**
+ * querySLIValue executes the passed Metric or USQL query for the timeframe and returns its single value
+ * Duration, retries and result of the query are added to the evaluation report
+ */
+func (ph *Handler) querySLIValue(ctx context.Context, metric string, metricsQuery string, startUnix time.Time, endUnix time.Time) (float64, error) {
+	report := &IndicatorReport{Indicator: metric, Type: getIndicatorType(metricsQuery)}
+	ctx, span := common.StartSpan(ctx, "querySLIValue", common.SpanAttributeIndicator.String(metric))
+	handler := ph.forIndicator(metric)
+
+	queryStart := time.Now()
+	value, err := handler.executeSLIQuery(ctx, metric, metricsQuery, startUnix, endUnix, report)
+	common.EndSpan(span, err)
+	report.DurationMs = time.Since(queryStart).Milliseconds()
+	report.Retries = handler.requestStats.getRetries()
+	report.setResult(value, err)
+
+	ph.addIndicatorReport(report)
+	return value, err
+}
+
+// setResult sets the value respectively the error of the indicator
+func (report *IndicatorReport) setResult(value float64, err error) {
+	report.Value = value
+	report.Success = err == nil
+	report.Error = ""
+	if err != nil {
+		report.Error = err.Error()
+	}
+}
+
+/**
+ * addIndicatorReport remembers the report of an indicator - a later report of the same indicator replaces the earlier one
+ * Query and error are redacted as the report is stored in the configuration repo, e.g: resolved $ENV placeholders
+ */
+func (ph *Handler) addIndicatorReport(report *IndicatorReport) {
+	report.Query = ph.Logger.Redact(report.Query)
+	report.Error = ph.Logger.Redact(report.Error)
+
+	ph.reports.mutex.Lock()
+	defer ph.reports.mutex.Unlock()
+	ph.reports.indicators[report.Indicator] = report
+}
+
+/**
+ * GetEvaluationReport returns the evaluation report for the passed SLIResults
+ * Indicators appear in the order of the SLIResults. Value, success and error are always taken from the SLIResult -
+ * indicators that failed before they were queried, e.g: because of a missing SLI definition, are reported without query details
+ */
+func (ph *Handler) GetEvaluationReport(source string, startUnix time.Time, endUnix time.Time, sliResults []*keptnevents.SLIResult) *EvaluationReport {
+	ph.reports.mutex.Lock()
+	defer ph.reports.mutex.Unlock()
+
+	report := &EvaluationReport{
+		Start:      startUnix.UTC().Format(time.RFC3339),
+		End:        endUnix.UTC().Format(time.RFC3339),
+		Source:     source,
+		Indicators: make([]*IndicatorReport, 0, len(sliResults)),
+	}
+	if ph.KeptnEvent != nil {
+		report.Context = ph.KeptnEvent.Context
+		report.Project = ph.KeptnEvent.Project
+		report.Stage = ph.KeptnEvent.Stage
+		report.Service = ph.KeptnEvent.Service
+	}
+
+	for _, sliResult := range sliResults {
+		indicatorReport := &IndicatorReport{Indicator: sliResult.Metric}
+		if collected, ok := ph.reports.indicators[sliResult.Metric]; ok {
+			copied := *collected
+			indicatorReport = &copied
+		}
+		indicatorReport.Value = sliResult.Value
+		indicatorReport.Success = sliResult.Success
+		indicatorReport.Error = ph.Logger.Redact(sliResult.Message)
+		indicatorReport.TimedOut = !sliResult.Success && strings.HasPrefix(sliResult.Message, TimedOutMessagePrefix)
+		report.Indicators = append(report.Indicators, indicatorReport)
+	}
+
+	return report
+}
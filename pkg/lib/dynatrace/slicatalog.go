@@ -0,0 +1,167 @@
+package dynatrace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+)
+
+const ResponseTimeP99 = "response_time_p99"
+const ErrorCount = "error_count"
+const Apdex = "apdex"
+const CPUPerRequest = "cpu_per_request"
+const GCTime = "gc_time"
+const DBTime = "db_time"
+
+/**
+ * Environment variables that allow to override the default SLI catalog
+ * SLI_CATALOG_FILE: path to a yaml file, e.g: a mounted ConfigMap
+ * SLI_CATALOG_CONFIGMAP: name of a ConfigMap in the Keptn namespace that holds the catalog in the key sli-catalog.yaml
+ */
+const sliCatalogFileEnv = "SLI_CATALOG_FILE"
+const sliCatalogConfigMapEnv = "SLI_CATALOG_CONFIGMAP"
+const sliCatalogConfigMapKey = "sli-catalog.yaml"
+
+// EntitySelectorPlaceholder is replaced with the entitySelector built from the defaults in dynatrace.conf.yaml
+const EntitySelectorPlaceholder = "$ENTITY_SELECTOR"
+
+// TagFilterPlaceholder is replaced with the tag filters built from the defaults in dynatrace.conf.yaml, e.g: tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE)
+const TagFilterPlaceholder = "$TAG_FILTER"
+
+/**
+ * defaultSLICatalog holds the SLIs that are available without any sli.yaml or dashboard
+ * Metrics that are not in milliseconds or a plain number are prefixed with MV2;<unit>; so they get scaled correctly
+ */
+const defaultSLICatalog = `---
+spec_version: "1.0"
+indicators:
+  throughput: "metricSelector=builtin:service.requestCount.total:merge(0):sum&entitySelector=$ENTITY_SELECTOR"
+  error_rate: "metricSelector=builtin:service.errors.total.count:merge(0):avg&entitySelector=$ENTITY_SELECTOR"
+  error_count: "metricSelector=builtin:service.errors.total.count:merge(0):sum&entitySelector=$ENTITY_SELECTOR"
+  response_time_p50: "metricSelector=builtin:service.response.time:merge(0):percentile(50)&entitySelector=$ENTITY_SELECTOR"
+  response_time_p90: "metricSelector=builtin:service.response.time:merge(0):percentile(90)&entitySelector=$ENTITY_SELECTOR"
+  response_time_p95: "metricSelector=builtin:service.response.time:merge(0):percentile(95)&entitySelector=$ENTITY_SELECTOR"
+  response_time_p99: "metricSelector=builtin:service.response.time:merge(0):percentile(99)&entitySelector=$ENTITY_SELECTOR"
+  apdex: "metricSelector=builtin:apps.web.apdex.userType:merge(1):merge(0):avg&entitySelector=type(APPLICATION),fromRelationships.calls($ENTITY_SELECTOR)"
+  cpu_per_request: "MV2;MicroSecond;metricSelector=builtin:service.cpu.perRequest:merge(0):avg&entitySelector=$ENTITY_SELECTOR"
+  gc_time: "MV2;MilliSecond;metricSelector=builtin:tech.jvm.memory.gc.collectionTime:merge(1):merge(0):avg&entitySelector=type(PROCESS_GROUP_INSTANCE),toRelationships.runsOnProcessGroupInstance($ENTITY_SELECTOR)"
+  db_time: "MV2;MicroSecond;metricSelector=builtin:service.dbChildCallTime:merge(0):avg&entitySelector=$ENTITY_SELECTOR"
+`
+
+// ParseSLIFile parses the content of an sli.yaml, e.g: of a local file
+func ParseSLIFile(input []byte) (*SLI, error) {
+	return parseSLICatalog(input)
+}
+
+/**
+ * parseSLICatalog parses an SLI catalog in the same format as an sli.yaml
+ */
+func parseSLICatalog(input []byte) (*SLI, error) {
+	catalog := &SLI{}
+	err := yaml.Unmarshal(input, catalog)
+	if err != nil {
+		return nil, err
+	}
+	if catalog.Indicators == nil {
+		catalog.Indicators = map[string]string{}
+	}
+	return catalog, nil
+}
+
+// GetBuiltInSLICatalog returns the SLI catalog that is compiled into the dynatrace-sli-service
+func GetBuiltInSLICatalog() map[string]string {
+	catalog, err := parseSLICatalog([]byte(defaultSLICatalog))
+	if err != nil {
+		// the built-in catalog is a constant - so this can only be a programming error
+		panic(fmt.Sprintf("could not parse built-in SLI catalog: %v", err))
+	}
+	return catalog.Indicators
+}
+
+/**
+ * LoadSLICatalog returns the built-in SLI catalog merged with the overrides from SLI_CATALOG_FILE and SLI_CATALOG_CONFIGMAP
+ * Indicators in an override replace the built-in indicator with the same name
+ */
+func LoadSLICatalog(logger *common.Logger) (map[string]string, error) {
+	indicators := GetBuiltInSLICatalog()
+
+	if catalogFile := os.Getenv(sliCatalogFileEnv); catalogFile != "" {
+		content, err := ioutil.ReadFile(catalogFile)
+		if err != nil {
+			return indicators, fmt.Errorf("could not read SLI catalog file %s: %v", catalogFile, err)
+		}
+		err = mergeSLICatalog(indicators, content)
+		if err != nil {
+			return indicators, fmt.Errorf("could not parse SLI catalog file %s: %v", catalogFile, err)
+		}
+		logger.Debug("Loaded SLI catalog overrides from " + catalogFile)
+	}
+
+	if configMapName := os.Getenv(sliCatalogConfigMapEnv); configMapName != "" {
+		content, err := common.GetConfigMapValue(configMapName, sliCatalogConfigMapKey)
+		if err != nil {
+			return indicators, fmt.Errorf("could not read SLI catalog from ConfigMap %s: %v", configMapName, err)
+		}
+		err = mergeSLICatalog(indicators, []byte(content))
+		if err != nil {
+			return indicators, fmt.Errorf("could not parse SLI catalog from ConfigMap %s: %v", configMapName, err)
+		}
+		logger.Debug("Loaded SLI catalog overrides from ConfigMap " + configMapName)
+	}
+
+	return indicators, nil
+}
+
+func mergeSLICatalog(indicators map[string]string, content []byte) error {
+	catalog, err := parseSLICatalog(content)
+	if err != nil {
+		return err
+	}
+	for name, query := range catalog.Indicators {
+		indicators[name] = query
+	}
+	return nil
+}
+
+/**
+ * getTagFilter returns the tag filters for the built-in SLIs, e.g: tag(keptn_project:$PROJECT),tag(keptn_stage:$STAGE),...
+ * If defaults.tagScheme is set in dynatrace.conf.yaml it is used as is
+ */
+func (ph *Handler) getTagFilter() string {
+	defaults := common.ResolveSLIDefaults(ph.Defaults)
+	if defaults.TagScheme != "" {
+		return defaults.TagScheme
+	}
+
+	tagFilters := []string{}
+	tagNamesAndPlaceholders := []struct {
+		tagName     string
+		placeholder string
+	}{
+		{defaults.Tags.Project, "$PROJECT"},
+		{defaults.Tags.Stage, "$STAGE"},
+		{defaults.Tags.Service, "$SERVICE"},
+		{defaults.Tags.Deployment, "$DEPLOYMENT"},
+	}
+	for _, tag := range tagNamesAndPlaceholders {
+		if tag.tagName != "" {
+			tagFilters = append(tagFilters, fmt.Sprintf("tag(%s:%s)", tag.tagName, tag.placeholder))
+		}
+	}
+
+	return strings.Join(tagFilters, ",")
+}
+
+/**
+ * resolveCatalogQuery replaces $ENTITY_SELECTOR and $TAG_FILTER in a query of the SLI catalog
+ */
+func (ph *Handler) resolveCatalogQuery(query string) string {
+	query = strings.Replace(query, EntitySelectorPlaceholder, ph.getDefaultEntitySelector(), -1)
+	query = strings.Replace(query, TagFilterPlaceholder, ph.getTagFilter(), -1)
+	return query
+}
@@ -0,0 +1,180 @@
+package dynatrace
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+)
+
+/**
+ * Placeholders that reference the evaluation timeframe within an SLI query
+ * $START and $END are unix timestamps in milliseconds, $DURATION is the length of the timeframe in seconds
+ */
+const StartPlaceholder = "$START"
+const EndPlaceholder = "$END"
+const DurationPlaceholder = "$DURATION"
+
+/**
+ * Parameters that change the timeframe of a single SLI query, e.g:
+ *   metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)&timeshift=-1d
+ *   metricSelector=builtin:service.response.time:merge(0):avg&entitySelector=type(SERVICE)&from=$START+5m
+ * timeshift moves the whole timeframe, from and to replace the start and end of the evaluation
+ */
+const timeshiftParameter = "timeshift"
+const fromParameter = "from"
+const toParameter = "to"
+
+var relativeDurationRegex = regexp.MustCompile(`^([+-]?)(\d+)([dw])$`)
+var timeExpressionRegex = regexp.MustCompile(`^(\$START|\$END|\d+)\s*(?:([+-])\s*(\S+))?$`)
+
+/**
+ * ParseRelativeDuration parses a duration such as -1d, 2w, +90m or -1h30m
+ * Besides the units of time.ParseDuration it supports d (days) and w (weeks)
+ */
+func ParseRelativeDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if matches := relativeDurationRegex.FindStringSubmatch(value); matches != nil {
+		amount, err := strconv.Atoi(matches[2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %s: %v", value, err)
+		}
+
+		unit := 24 * time.Hour
+		if matches[3] == "w" {
+			unit = 7 * 24 * time.Hour
+		}
+
+		duration := time.Duration(amount) * unit
+		if matches[1] == "-" {
+			duration = -duration
+		}
+		return duration, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %s, expected e.g: -1d, 2w or -30m", value)
+	}
+	return duration, nil
+}
+
+/**
+ * parseTimeExpression parses the value of a from or to parameter, e.g: $START+5m, $END-1h or 1592210000000 (unix timestamp in ms)
+ */
+func parseTimeExpression(expression string, startUnix time.Time, endUnix time.Time) (time.Time, error) {
+	matches := timeExpressionRegex.FindStringSubmatch(strings.TrimSpace(expression))
+	if matches == nil {
+		return time.Time{}, fmt.Errorf("invalid time expression %s, expected e.g: $START+5m, $END-1h or a unix timestamp in ms", expression)
+	}
+
+	var result time.Time
+	switch matches[1] {
+	case StartPlaceholder:
+		result = startUnix
+	case EndPlaceholder:
+		result = endUnix
+	default:
+		timestampMs, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time expression %s: %v", expression, err)
+		}
+		result = time.Unix(timestampMs/1000, (timestampMs%1000)*int64(time.Millisecond))
+	}
+
+	if matches[2] != "" {
+		offset, err := ParseRelativeDuration(matches[3])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time expression %s: %v", expression, err)
+		}
+		if matches[2] == "-" {
+			offset = -offset
+		}
+		result = result.Add(offset)
+	}
+
+	return result, nil
+}
+
+/**
+ * extractQueryParameters removes the passed parameters from a query and returns them separately
+ * Only parameters that are appended with & are considered, the first part of the query always stays untouched
+ */
+func extractQueryParameters(query string, names ...string) (string, map[string]string) {
+	parameters := map[string]string{}
+	extract := map[string]bool{}
+	for _, name := range names {
+		extract[name] = true
+	}
+
+	parts := strings.Split(query, "&")
+	remainingParts := []string{parts[0]}
+	for _, part := range parts[1:] {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) == 2 && extract[keyValue[0]] {
+			parameters[keyValue[0]] = keyValue[1]
+			continue
+		}
+		remainingParts = append(remainingParts, part)
+	}
+
+	return strings.Join(remainingParts, "&"), parameters
+}
+
+/**
+ * applyQueryTimeframe resolves the timeframe of a single SLI query
+ * Returns:
+ *  #1: the query without timeshift, from and to parameters and with $START, $END and $DURATION replaced
+ *  #2: start of the timeframe to query
+ *  #3: end of the timeframe to query
+ *  #4: error
+ */
+func applyQueryTimeframe(query string, startUnix time.Time, endUnix time.Time) (string, time.Time, time.Time, error) {
+	query, parameters := extractQueryParameters(query, timeshiftParameter, fromParameter, toParameter)
+	if len(parameters) == 0 {
+		return replaceTimeframePlaceholders(query, startUnix, endUnix), startUnix, endUnix, nil
+	}
+
+	queryStart, queryEnd := startUnix, endUnix
+	var err error
+	if from, ok := parameters[fromParameter]; ok {
+		queryStart, err = parseTimeExpression(from, startUnix, endUnix)
+		if err != nil {
+			return "", startUnix, endUnix, err
+		}
+	}
+	if to, ok := parameters[toParameter]; ok {
+		queryEnd, err = parseTimeExpression(to, startUnix, endUnix)
+		if err != nil {
+			return "", startUnix, endUnix, err
+		}
+	}
+	if timeshift, ok := parameters[timeshiftParameter]; ok {
+		shift, err := ParseRelativeDuration(timeshift)
+		if err != nil {
+			return "", startUnix, endUnix, fmt.Errorf("invalid timeshift: %v", err)
+		}
+		queryStart = queryStart.Add(shift)
+		queryEnd = queryEnd.Add(shift)
+	}
+
+	if !queryStart.Before(queryEnd) {
+		return "", startUnix, endUnix, fmt.Errorf("start of the query timeframe %s is not before its end %s", queryStart.UTC().Format(time.RFC3339), queryEnd.UTC().Format(time.RFC3339))
+	}
+	if queryEnd.After(time.Now()) {
+		return "", startUnix, endUnix, fmt.Errorf("end of the query timeframe %s is in the future", queryEnd.UTC().Format(time.RFC3339))
+	}
+
+	return replaceTimeframePlaceholders(query, queryStart, queryEnd), queryStart, queryEnd, nil
+}
+
+// replaceTimeframePlaceholders replaces $START, $END and $DURATION with the passed timeframe
+func replaceTimeframePlaceholders(query string, startUnix time.Time, endUnix time.Time) string {
+	query = strings.Replace(query, StartPlaceholder, common.TimestampToString(startUnix), -1)
+	query = strings.Replace(query, EndPlaceholder, common.TimestampToString(endUnix), -1)
+	query = strings.Replace(query, DurationPlaceholder, strconv.FormatInt(int64(endUnix.Sub(startUnix).Seconds()), 10), -1)
+	return query
+}
@@ -0,0 +1,140 @@
+package dynatrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keptn-contrib/dynatrace-sli-service/pkg/common"
+)
+
+// TimeseriesFilename is the name of the uploaded time series artifact without extension, e.g: dynatrace/results/<shkeptncontext>/timeseries.json
+const TimeseriesFilename = "timeseries"
+
+// TimeseriesLabel is the label that holds the resource URI of the uploaded time series
+const TimeseriesLabel = "Timeseries"
+
+// IndicatorTimeseries holds the data points of a Metrics indicator for the evaluation timeframe
+type IndicatorTimeseries struct {
+	Indicator  string             `json:"indicator"`
+	MetricID   string             `json:"metricId"`
+	Resolution string             `json:"resolution"`
+	Series     []TimeseriesSeries `json:"series,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// TimeseriesSeries holds the scaled data points of one dimension of an indicator. Timestamps are unix timestamps in ms
+type TimeseriesSeries struct {
+	Dimensions []string  `json:"dimensions"`
+	Timestamps []int64   `json:"timestamps"`
+	Values     []float64 `json:"values"`
+}
+
+// timeseriesCollector collects the time series of all indicators - indicators may be queried in parallel
+type timeseriesCollector struct {
+	mutex      sync.Mutex
+	timeseries map[string]*IndicatorTimeseries
+}
+
+/**
+ * collectTimeseries fetches the data points of a Metrics query at the resolution configured in dynatrace.conf.yaml
+ * Does nothing if the time series export is not enabled. Errors are stored with the time series and do not fail the indicator
+ */
+func (ph *Handler) collectTimeseries(ctx context.Context, indicator string, metricsQuery string, unit string, startUnix time.Time, endUnix time.Time) {
+	if ph.Timeseries == nil || !ph.Timeseries.Enabled {
+		return
+	}
+	export := common.ResolveTimeseriesExport(ph.Timeseries)
+
+	timeseries := &IndicatorTimeseries{
+		Indicator:  indicator,
+		Resolution: export.Resolution,
+	}
+
+	fullMetricsQuery, metricID, err := ph.BuildDynatraceMetricsQueryWithResolution(metricsQuery, startUnix, endUnix, export.Resolution)
+	timeseries.MetricID = metricID
+
+	var result *DynatraceResult
+	if err == nil {
+		result, err = ph.ExecuteMetricsAPIQuery(ctx, fullMetricsQuery)
+	}
+
+	if err != nil {
+		ph.Logger.Errorf("Could not fetch time series of %s: %v", indicator, err)
+		timeseries.Error = err.Error()
+	} else {
+		for _, singleResult := range result.Result {
+			if !ph.isMatchingMetricID(singleResult.MetricID, metricID) {
+				continue
+			}
+			for _, data := range singleResult.Data {
+				series := TimeseriesSeries{
+					Dimensions: data.Dimensions,
+					Timestamps: data.Timestamps,
+					Values:     make([]float64, len(data.Values)),
+				}
+				for i, value := range data.Values {
+					series.Values[i] = scaleData(metricID, unit, value)
+				}
+				timeseries.Series = append(timeseries.Series, series)
+			}
+		}
+	}
+
+	ph.timeseries.mutex.Lock()
+	defer ph.timeseries.mutex.Unlock()
+	ph.timeseries.timeseries[indicator] = timeseries
+}
+
+// GetTimeseries returns the time series of all indicators that were collected, sorted by indicator name
+func (ph *Handler) GetTimeseries() []*IndicatorTimeseries {
+	ph.timeseries.mutex.Lock()
+	defer ph.timeseries.mutex.Unlock()
+
+	timeseries := make([]*IndicatorTimeseries, 0, len(ph.timeseries.timeseries))
+	for _, indicatorTimeseries := range ph.timeseries.timeseries {
+		timeseries = append(timeseries, indicatorTimeseries)
+	}
+	sort.Slice(timeseries, func(i, j int) bool { return timeseries[i].Indicator < timeseries[j].Indicator })
+	return timeseries
+}
+
+/**
+ * MarshalTimeseries returns the passed time series either as json or as csv
+ * The csv contains one row per data point: indicator,metricId,dimensions,timestamp,value - dimensions are separated by |
+ */
+func MarshalTimeseries(timeseries []*IndicatorTimeseries, format string) ([]byte, error) {
+	if format != common.TimeseriesFormatCSV {
+		return json.MarshalIndent(timeseries, "", "  ")
+	}
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	writer.Write([]string{"indicator", "metricId", "dimensions", "timestamp", "value"})
+	for _, indicatorTimeseries := range timeseries {
+		for _, series := range indicatorTimeseries.Series {
+			dimensions := strings.Join(series.Dimensions, "|")
+			for i, timestamp := range series.Timestamps {
+				if i >= len(series.Values) {
+					break
+				}
+				writer.Write([]string{
+					indicatorTimeseries.Indicator,
+					indicatorTimeseries.MetricID,
+					dimensions,
+					strconv.FormatInt(timestamp, 10),
+					strconv.FormatFloat(series.Values[i], 'f', -1, 64),
+				})
+			}
+		}
+	}
+	writer.Flush()
+
+	return buffer.Bytes(), writer.Error()
+}
@@ -0,0 +1,146 @@
+package fakedynatrace
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+)
+
+// dashboardList is the content of dashboards.json
+type dashboardList struct {
+	Dashboards []*dashboardStub `json:"dashboards"`
+}
+
+type dashboardStub struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+}
+
+/**
+ * serveDashboards serves the dashboards of the fixture directory
+ * Dashboards that are created, updated or deleted are kept in memory - the fixture directory is never modified
+ */
+func (s *Server) serveDashboards(w http.ResponseWriter, r *http.Request, dashboardID string, body []byte) {
+	switch {
+	case dashboardID == "" && r.Method == http.MethodGet:
+		s.serveDashboardList(w)
+	case dashboardID == "" && r.Method == http.MethodPost:
+		s.mutex.Lock()
+		s.created++
+		dashboardID = fmt.Sprintf("00000000-0000-4000-8000-%012d", s.created)
+		s.mutex.Unlock()
+		dashboard, err := s.storeDashboard(dashboardID, body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		response, _ := json.Marshal(dashboard)
+		writeJSON(w, http.StatusCreated, response)
+	case dashboardID == "":
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not supported for %s", r.Method, DashboardsPath))
+	case r.Method == http.MethodGet:
+		content, found, err := s.getDashboard(dashboardID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+		} else if !found {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Dashboard %s not found", dashboardID))
+		} else {
+			writeJSON(w, http.StatusOK, content)
+		}
+	case r.Method == http.MethodPut:
+		if _, err := s.storeDashboard(dashboardID, body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		s.mutex.Lock()
+		delete(s.dashboards, dashboardID)
+		s.deleted[dashboardID] = true
+		s.mutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not supported for dashboards", r.Method))
+	}
+}
+
+// serveDashboardList responds with the dashboards of dashboards.json merged with the dashboards kept in memory
+func (s *Server) serveDashboardList(w http.ResponseWriter) {
+	list := &dashboardList{}
+	content, err := s.readFixture("dashboards.json")
+	if err == nil && content != nil {
+		err = json.Unmarshal(content, list)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("invalid fixture dashboards.json: %v", err))
+		return
+	}
+
+	s.mutex.Lock()
+	dashboards := []*dashboardStub{}
+	for _, dashboard := range list.Dashboards {
+		if _, stored := s.dashboards[dashboard.ID]; !stored && !s.deleted[dashboard.ID] {
+			dashboards = append(dashboards, dashboard)
+		}
+	}
+	storedIDs := make([]string, 0, len(s.dashboards))
+	for id := range s.dashboards {
+		storedIDs = append(storedIDs, id)
+	}
+	sort.Strings(storedIDs)
+	for _, id := range storedIDs {
+		dashboards = append(dashboards, newDashboardStub(id, s.dashboards[id]))
+	}
+	s.mutex.Unlock()
+
+	response, _ := json.Marshal(&dashboardList{Dashboards: dashboards})
+	writeJSON(w, http.StatusOK, response)
+}
+
+// getDashboard returns a dashboard kept in memory or from the fixture directory
+func (s *Server) getDashboard(dashboardID string) ([]byte, bool, error) {
+	s.mutex.Lock()
+	content, stored := s.dashboards[dashboardID]
+	deleted := s.deleted[dashboardID]
+	s.mutex.Unlock()
+	if stored {
+		return content, true, nil
+	}
+	if deleted {
+		return nil, false, nil
+	}
+
+	content, err := s.readFixture(filepath.Join("dashboards", filepath.Base(dashboardID)+".json"))
+	return content, content != nil, err
+}
+
+// storeDashboard keeps the dashboard in memory with the passed ID and returns its stub
+func (s *Server) storeDashboard(dashboardID string, body []byte) (*dashboardStub, error) {
+	dashboard := map[string]interface{}{}
+	if err := json.Unmarshal(body, &dashboard); err != nil {
+		return nil, fmt.Errorf("invalid dashboard: %v", err)
+	}
+	dashboard["id"] = dashboardID
+	content, _ := json.Marshal(dashboard)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.dashboards[dashboardID] = content
+	delete(s.deleted, dashboardID)
+	return newDashboardStub(dashboardID, content), nil
+}
+
+// newDashboardStub returns the list entry of a dashboard
+func newDashboardStub(dashboardID string, content []byte) *dashboardStub {
+	dashboard := struct {
+		DashboardMetadata struct {
+			Name  string `json:"name"`
+			Owner string `json:"owner"`
+		} `json:"dashboardMetadata"`
+	}{}
+	json.Unmarshal(content, &dashboard)
+	return &dashboardStub{ID: dashboardID, Name: dashboard.DashboardMetadata.Name, Owner: dashboard.DashboardMetadata.Owner}
+}
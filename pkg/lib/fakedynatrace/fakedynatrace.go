@@ -0,0 +1,295 @@
+package fakedynatrace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * fakedynatrace is an in-process fake of the Dynatrace API for tests and offline runs (ENV=local)
+ * Responses are served from a fixture directory:
+ *
+ *   dashboards.json         GET /api/config/v1/dashboards
+ *   dashboards/<id>.json    GET /api/config/v1/dashboards/<id> - POST, PUT and DELETE are kept in memory
+ *   metrics.json            GET /api/v2/metrics and /api/v2/metrics/<metricId> (Metrics API format)
+ *   metrics_query.json      GET /api/v2/metrics/query - a Metrics API response, see findSeries for how the series are selected
+ *   usql.json               GET /api/v1/userSessionQueryLanguage/table - [{"query": "...", "result": {...}}]
+ *   entities.json           GET /api/v2/entities
+ *   baselines/<id>.json     GET /api/v1/entity/services/<id>/baseline
+ *   slo.json                GET /api/v2/slo and /api/v2/slo/<id>
+ *   problems.json           GET /api/v2/problems and /api/v2/problems/<problemId>
+ *
+ * Missing fixture files result in a 404 just like unknown endpoints
+ */
+
+// Paths of the Dynatrace API endpoints served by the fake
+const DashboardsPath = "/api/config/v1/dashboards"
+const MetricsPath = "/api/v2/metrics"
+const MetricsQueryPath = "/api/v2/metrics/query"
+const USQLPath = "/api/v1/userSessionQueryLanguage/table"
+const EntitiesPath = "/api/v2/entities"
+const ServicesPath = "/api/v1/entity/services"
+const SLOPath = "/api/v2/slo"
+const ProblemsPath = "/api/v2/problems"
+
+// Fault is injected into the responses of the fake, e.g: to test timeouts or retries
+type Fault struct {
+	// Path limits the fault to requests whose path starts with it, e.g: /api/v2/metrics/query - empty for all requests
+	Path string
+	// Latency delays the response
+	Latency time.Duration
+	// StatusCode is returned instead of the fixture, e.g: 429 or 503 - 0 only adds the latency
+	StatusCode int
+	// RetryAfter is sent as Retry-After header, e.g: 1 for a 429
+	RetryAfter string
+	// Count is the number of requests the fault applies to - 0 for all requests
+	Count int
+}
+
+/**
+ * ParseFault parses a fault from a comma separated list of key=value pairs, e.g: path=/api/v2/metrics/query,status=429,retryAfter=1,count=2
+ * Supported keys: path, latency (a duration, e.g: 500ms), status, retryAfter and count
+ */
+func ParseFault(spec string) (Fault, error) {
+	fault := Fault{}
+	for _, pair := range strings.Split(spec, ",") {
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 {
+			return fault, fmt.Errorf("%s is not in the format key=value", pair)
+		}
+		var err error
+		switch keyValue[0] {
+		case "path":
+			fault.Path = keyValue[1]
+		case "latency":
+			fault.Latency, err = time.ParseDuration(keyValue[1])
+		case "status":
+			fault.StatusCode, err = strconv.Atoi(keyValue[1])
+		case "retryAfter":
+			fault.RetryAfter = keyValue[1]
+		case "count":
+			fault.Count, err = strconv.Atoi(keyValue[1])
+		default:
+			err = fmt.Errorf("unknown key %s, expected path, latency, status, retryAfter or count", keyValue[0])
+		}
+		if err != nil {
+			return fault, fmt.Errorf("invalid fault %s: %v", spec, err)
+		}
+	}
+	return fault, nil
+}
+
+// Request is a request received by the fake
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	Body   string
+}
+
+// Server serves the fixtures of a directory as Dynatrace API - use it with httptest.NewServer or http.ListenAndServe
+type Server struct {
+	// FixtureDir is the directory the responses are loaded from
+	FixtureDir string
+	// APIToken is required as "Authorization: Api-Token <token>" if set
+	APIToken string
+
+	mutex      sync.Mutex
+	faults     []*Fault
+	requests   []*Request
+	dashboards map[string][]byte
+	deleted    map[string]bool
+	created    int
+}
+
+// New returns a fake Dynatrace API that serves the fixtures of the passed directory
+func New(fixtureDir string) *Server {
+	return &Server{
+		FixtureDir: fixtureDir,
+		dashboards: map[string][]byte{},
+		deleted:    map[string]bool{},
+	}
+}
+
+// AddFault injects a fault into the following responses - faults are applied in the order they were added
+func (s *Server) AddFault(fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes all faults
+func (s *Server) ClearFaults() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = nil
+}
+
+// Requests returns all requests received so far
+func (s *Server) Requests() []*Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	requests := make([]*Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	fault := s.recordRequest(r, body)
+
+	if s.APIToken != "" && r.Header.Get("Authorization") != "Api-Token "+s.APIToken {
+		writeError(w, http.StatusUnauthorized, "Token Authentication failed")
+		return
+	}
+
+	if fault != nil {
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.StatusCode != 0 {
+			if fault.RetryAfter != "" {
+				w.Header().Set("Retry-After", fault.RetryAfter)
+			}
+			writeError(w, fault.StatusCode, fmt.Sprintf("injected fault for %s", r.URL.Path))
+			return
+		}
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == MetricsQueryPath:
+		s.serveMetricsQuery(w, r)
+	case strings.HasPrefix(path, DashboardsPath):
+		s.serveDashboards(w, r, strings.TrimPrefix(strings.TrimPrefix(path, DashboardsPath), "/"), body)
+	case r.Method != http.MethodGet:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not supported for %s", r.Method, path))
+	case path == MetricsPath:
+		s.serveFixture(w, "metrics.json")
+	case strings.HasPrefix(path, MetricsPath+"/"):
+		s.serveListItem(w, "metrics.json", "metrics", "metricId", strings.TrimPrefix(path, MetricsPath+"/"))
+	case path == USQLPath:
+		s.serveUSQL(w, r)
+	case path == EntitiesPath:
+		s.serveFixture(w, "entities.json")
+	case strings.HasPrefix(path, ServicesPath+"/") && strings.HasSuffix(path, "/baseline"):
+		serviceID := strings.TrimSuffix(strings.TrimPrefix(path, ServicesPath+"/"), "/baseline")
+		s.serveFixture(w, filepath.Join("baselines", filepath.Base(serviceID)+".json"))
+	case path == SLOPath:
+		s.serveFixture(w, "slo.json")
+	case strings.HasPrefix(path, SLOPath+"/"):
+		s.serveListItem(w, "slo.json", "slo", "id", strings.TrimPrefix(path, SLOPath+"/"))
+	case path == ProblemsPath:
+		s.serveFixture(w, "problems.json")
+	case strings.HasPrefix(path, ProblemsPath+"/"):
+		s.serveListItem(w, "problems.json", "problems", "problemId", strings.TrimPrefix(path, ProblemsPath+"/"))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%s is not supported by the fake Dynatrace API", path))
+	}
+}
+
+// recordRequest stores the request and returns the fault that applies to it - nil if there is none
+func (s *Server) recordRequest(r *http.Request, body []byte) *Fault {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests = append(s.requests, &Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, Body: string(body)})
+
+	for i, fault := range s.faults {
+		if !strings.HasPrefix(r.URL.Path, fault.Path) {
+			continue
+		}
+		if fault.Count > 0 {
+			fault.Count--
+			if fault.Count == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+// readFixture returns the content of a file in the fixture directory - nil if it doesn't exist
+func (s *Server) readFixture(name string) ([]byte, error) {
+	content, err := ioutil.ReadFile(filepath.Join(s.FixtureDir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// serveFixture responds with the content of a file in the fixture directory
+func (s *Server) serveFixture(w http.ResponseWriter, name string) {
+	content, err := s.readFixture(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if content == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no fixture %s", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, content)
+}
+
+// serveListItem responds with the item of a list fixture, e.g: {"metrics": [...]}, whose key has the passed value
+func (s *Server) serveListItem(w http.ResponseWriter, name string, listKey string, itemKey string, value string) {
+	content, err := s.readFixture(name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	fixture := map[string]json.RawMessage{}
+	items := []map[string]interface{}{}
+	if content != nil {
+		err = json.Unmarshal(content, &fixture)
+		if err == nil && fixture[listKey] != nil {
+			err = json.Unmarshal(fixture[listKey], &items)
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("invalid fixture %s: %v", name, err))
+			return
+		}
+	}
+	for _, item := range items {
+		if fmt.Sprintf("%v", item[itemKey]) == value {
+			itemContent, _ := json.Marshal(item)
+			writeJSON(w, http.StatusOK, itemContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Sprintf("%s %s not found", itemKey, value))
+}
+
+// writeJSON writes a json response
+func writeJSON(w http.ResponseWriter, statusCode int, content []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(content)
+}
+
+// writeError writes an error in the format of the Dynatrace API
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	content, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    statusCode,
+			"message": message,
+		},
+	})
+	writeJSON(w, statusCode, content)
+}
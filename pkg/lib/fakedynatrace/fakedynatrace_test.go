@@ -0,0 +1,194 @@
+package fakedynatrace
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testingGet calls the fake and returns status code and body
+func testingGet(t *testing.T, server *httptest.Server, path string) (int, string) {
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestMetricsQuerySelectsSeries(t *testing.T) {
+	fixtureDir, err := ioutil.TempDir("", "fakedynatrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(fixtureDir)
+	fixture := `{"result": [
+		{"metricId": "builtin:service.response.time", "data": [{"dimensions": [], "timestamps": [1], "values": [1]}]},
+		{"metricId": "builtin:service.response.time", "entitySelector": "type(SERVICE),tag(canary)", "data": [{"dimensions": [], "timestamps": [1], "values": [2]}]},
+		{"metricId": "builtin:service.response.time:merge(0):percentile(95)", "data": [{"dimensions": [], "timestamps": [1], "values": [3]}]}
+	]}`
+	if err := ioutil.WriteFile(fixtureDir+"/metrics_query.json", []byte(fixture), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(New(fixtureDir))
+	defer server.Close()
+
+	tests := []struct {
+		metricSelector string
+		entitySelector string
+		wantValues     string
+	}{
+		{"builtin:service.response.time:merge(0):percentile(95)", "type(SERVICE)", "[3]"},
+		{"builtin:service.response.time:merge(0):percentile(50)", "type(SERVICE)", "[1]"},
+		{"builtin:service.response.time:merge(0):percentile(50)", "type(SERVICE),tag(canary)", "[2]"},
+		{"builtin:service.requestCount.total:merge(0):sum", "type(SERVICE)", ""},
+	}
+	for _, tt := range tests {
+		query := url.Values{"metricSelector": {tt.metricSelector}, "entitySelector": {tt.entitySelector}}
+		statusCode, body := testingGet(t, server, MetricsQueryPath+"/?"+query.Encode())
+		if statusCode != http.StatusOK {
+			t.Fatalf("%s returned status code %d: %s", tt.metricSelector, statusCode, body)
+		}
+
+		result := struct {
+			Result []struct {
+				MetricID string `json:"metricId"`
+				Data     []struct {
+					Values json.RawMessage `json:"values"`
+				} `json:"data"`
+			} `json:"result"`
+		}{}
+		if err := json.Unmarshal([]byte(body), &result); err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Result) != 1 || result.Result[0].MetricID != tt.metricSelector {
+			t.Fatalf("%s returned %s, expected one result with the metricSelector as metricId", tt.metricSelector, body)
+		}
+		values := ""
+		if len(result.Result[0].Data) > 0 {
+			values = string(result.Result[0].Data[0].Values)
+		}
+		if values != tt.wantValues {
+			t.Errorf("%s with %s returned values %s, want %s", tt.metricSelector, tt.entitySelector, values, tt.wantValues)
+		}
+	}
+}
+
+func TestDashboardsAreKeptInMemory(t *testing.T) {
+	fake := New("./fixtures")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	statusCode, _ := testingGet(t, server, DashboardsPath+"/12345678-1111-4444-8888-123456789012")
+	if statusCode != http.StatusOK {
+		t.Fatalf("GET of the fixture dashboard returned status code %d", statusCode)
+	}
+
+	resp, err := http.Post(server.URL+DashboardsPath, "application/json", strings.NewReader(`{"dashboardMetadata": {"name": "KQG;project=sockshop;service=carts;stage=staging", "owner": "dtsli"}, "tiles": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := dashboardStub{}
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || created.ID == "" {
+		t.Fatalf("POST returned status code %d and id %s", resp.StatusCode, created.ID)
+	}
+
+	request, _ := http.NewRequest(http.MethodDelete, server.URL+DashboardsPath+"/12345678-1111-4444-8888-123456789012", nil)
+	resp, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	_, body := testingGet(t, server, DashboardsPath)
+	list := dashboardList{}
+	if err := json.Unmarshal([]byte(body), &list); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, dashboard := range list.Dashboards {
+		names = append(names, dashboard.Name)
+	}
+	if strings.Join(names, "|") != "some other dashboard|KQG;project=sockshop;service=carts;stage=staging" {
+		t.Errorf("dashboard list returned %v, expected the deleted dashboard to be gone and the created one to be added", names)
+	}
+
+	statusCode, _ = testingGet(t, server, DashboardsPath+"/12345678-1111-4444-8888-123456789012")
+	if statusCode != http.StatusNotFound {
+		t.Errorf("GET of the deleted dashboard returned status code %d, want 404", statusCode)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	fake := New("./fixtures")
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	fault, err := ParseFault("path=/api/v2/slo,status=429,retryAfter=2,count=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.AddFault(fault)
+	fake.AddFault(Fault{Path: ProblemsPath, Latency: 50 * time.Millisecond})
+
+	resp, err := http.Get(server.URL + SLOPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("first call returned status code %d with Retry-After %s, want 429 with 2", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	if statusCode, body := testingGet(t, server, SLOPath+"/6b8a2e4c-8f0e-3d5a-9c71-2f1e8d0b4a11"); statusCode != http.StatusOK || !strings.Contains(body, "carts availability") {
+		t.Errorf("second call returned status code %d: %s, expected the fault to be used up", statusCode, body)
+	}
+
+	start := time.Now()
+	if statusCode, _ := testingGet(t, server, ProblemsPath); statusCode != http.StatusOK {
+		t.Errorf("problems returned status code %d", statusCode)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected the latency to be added")
+	}
+
+	if len(fake.Requests()) != 3 {
+		t.Errorf("expected 3 recorded requests, got %d", len(fake.Requests()))
+	}
+
+	if _, err := ParseFault("status=abc"); err == nil {
+		t.Errorf("expected an error for an invalid status")
+	}
+}
+
+func TestAPIToken(t *testing.T) {
+	fake := New("./fixtures")
+	fake.APIToken = "secret"
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	if statusCode, _ := testingGet(t, server, EntitiesPath); statusCode != http.StatusUnauthorized {
+		t.Errorf("call without token returned status code %d, want 401", statusCode)
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+EntitiesPath, nil)
+	request.Header.Set("Authorization", "Api-Token secret")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("call with token returned status code %d, want 200", resp.StatusCode)
+	}
+}
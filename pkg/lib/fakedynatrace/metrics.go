@@ -0,0 +1,156 @@
+package fakedynatrace
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// metricsQueryFixture is the content of metrics_query.json - a response of the Metrics API where every result may be limited to an entitySelector
+type metricsQueryFixture struct {
+	Result []*cannedSeries `json:"result"`
+}
+
+type cannedSeries struct {
+	MetricID       string          `json:"metricId"`
+	EntitySelector string          `json:"entitySelector,omitempty"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// usqlFixture is an entry of usql.json - an entry without query is returned for all queries without an exact match
+type usqlFixture struct {
+	Query  string          `json:"query"`
+	Result json.RawMessage `json:"result"`
+}
+
+// aggregations and transformations without parameters that end the metric key of a metricSelector
+var metricSelectorKeywords = map[string]bool{
+	"auto": true, "avg": true, "count": true, "max": true, "median": true, "min": true, "sum": true, "value": true, "names": true, "last": true,
+}
+
+/**
+ * serveMetricsQuery responds with the canned result of metrics_query.json that matches metricSelector and entitySelector
+ * The metricId of the result is always the requested metricSelector. Without a matching result the series has no data points
+ */
+func (s *Server) serveMetricsQuery(w http.ResponseWriter, r *http.Request) {
+	metricSelector := r.URL.Query().Get("metricSelector")
+	if metricSelector == "" {
+		writeError(w, http.StatusBadRequest, "Constraints violated: metricSelector must not be empty")
+		return
+	}
+
+	fixture := &metricsQueryFixture{}
+	content, err := s.readFixture("metrics_query.json")
+	if err == nil && content != nil {
+		err = json.Unmarshal(content, fixture)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("invalid fixture metrics_query.json: %v", err))
+		return
+	}
+
+	data := json.RawMessage("[]")
+	if series := findSeries(fixture.Result, metricSelector, r.URL.Query().Get("entitySelector")); series != nil && len(series.Data) > 0 {
+		data = series.Data
+	}
+
+	response, _ := json.Marshal(map[string]interface{}{
+		"totalCount":  1,
+		"nextPageKey": nil,
+		"result": []map[string]interface{}{
+			{"metricId": metricSelector, "data": data},
+		},
+	})
+	writeJSON(w, http.StatusOK, response)
+}
+
+/**
+ * findSeries selects the canned result for a metricSelector and entitySelector:
+ *   - a result whose metricId is the metricSelector wins over a result of the same metric key,
+ *     e.g: builtin:service.response.time also matches builtin:service.response.time:merge(0):percentile(95)
+ *   - a result with the requested entitySelector wins over a result without entitySelector - a different entitySelector never matches
+ * Returns nil if no result matches
+ */
+func findSeries(results []*cannedSeries, metricSelector string, entitySelector string) *cannedSeries {
+	var bestMatch *cannedSeries
+	bestScore := 0
+	for _, series := range results {
+		if series.EntitySelector != "" && series.EntitySelector != entitySelector {
+			continue
+		}
+
+		score := 0
+		if series.MetricID == metricSelector {
+			score = 4
+		} else if metricKey(series.MetricID) == metricKey(metricSelector) {
+			score = 2
+		} else {
+			continue
+		}
+		if series.EntitySelector != "" {
+			score++
+		}
+
+		if score > bestScore {
+			bestMatch = series
+			bestScore = score
+		}
+	}
+	return bestMatch
+}
+
+// metricKey returns the metric of a metricSelector without transformations and aggregation, e.g: builtin:service.response.time
+func metricKey(metricSelector string) string {
+	keyParts := []string{}
+	depth := 0
+	part := ""
+	for _, c := range metricSelector + ":" {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ':' && depth == 0:
+			if strings.Contains(part, "(") || metricSelectorKeywords[part] {
+				return strings.Join(keyParts, ":")
+			}
+			keyParts = append(keyParts, part)
+			part = ""
+			continue
+		}
+		part += string(c)
+	}
+	return strings.Join(keyParts, ":")
+}
+
+// serveUSQL responds with the result of usql.json for the query - an entry without query is the fallback for all other queries
+func (s *Server) serveUSQL(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("query")
+
+	var fixtures []*usqlFixture
+	content, err := s.readFixture("usql.json")
+	if err == nil && content != nil {
+		err = json.Unmarshal(content, &fixtures)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("invalid fixture usql.json: %v", err))
+		return
+	}
+
+	var fallback *usqlFixture
+	for _, fixture := range fixtures {
+		if fixture.Query == query {
+			writeJSON(w, http.StatusOK, fixture.Result)
+			return
+		}
+		if fixture.Query == "" && fallback == nil {
+			fallback = fixture
+		}
+	}
+	if fallback != nil {
+		writeJSON(w, http.StatusOK, fallback.Result)
+		return
+	}
+	writeError(w, http.StatusBadRequest, fmt.Sprintf("no result for the USQL query %s", query))
+}